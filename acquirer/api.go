@@ -3,9 +3,14 @@ package acquirer
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/middleware"
 	"github.com/go-chi/chi/v5"
 	"golang.org/x/exp/slog"
 )
@@ -24,10 +29,45 @@ func NewAPI(logger *slog.Logger, acquirer *Service) *API {
 
 func (a *API) AppendRoutes(r chi.Router) {
 	r.Route("/merchants", func(r chi.Router) {
-		r.Post("/", a.createMerchant)
+		// the admin manages the merchants, the merchants call only their own
+		// routes with the keys issued to them
+		r.Use(middleware.Authenticate(a.acquirer))
+		r.With(middleware.RequireAdmin).Get("/", a.listMerchants)
+		r.With(middleware.RequireAdmin).Post("/", a.createMerchant)
 		r.Route("/{merchantID}", func(r chi.Router) {
+			r.Use(middleware.RequireMerchant("merchantID"))
+			r.Get("/", a.getMerchant)
+			r.With(middleware.RequireAdmin).Put("/", a.updateMerchant)
+			r.With(middleware.RequireAdmin).Delete("/", a.closeMerchant)
+			r.With(middleware.RequireAdmin).Put("/status", a.updateMerchantStatus)
+			r.Get("/api-keys", a.listAPIKeys)
+			r.Post("/api-keys", a.issueAPIKey)
+			r.Delete("/api-keys/{apiKeyID}", a.revokeAPIKey)
+			r.Post("/api-keys/{apiKeyID}/rotate", a.rotateAPIKey)
+			r.Get("/terminals", a.listTerminals)
+			r.Post("/terminals", a.createTerminal)
+			r.Delete("/terminals/{terminalID}", a.deactivateTerminal)
+			r.Post("/balance-inquiries", a.inquireBalance)
+			r.Get("/payment-methods", a.listPaymentMethods)
+			r.Post("/payment-methods", a.createPaymentMethod)
+			r.Get("/payment-methods/{paymentMethodID}", a.getPaymentMethod)
+			r.Delete("/payment-methods/{paymentMethodID}", a.deletePaymentMethod)
+			r.Get("/plans", a.listPlans)
+			r.Post("/plans", a.createPlan)
+			r.Get("/plans/{planID}", a.getPlan)
+			r.Get("/subscriptions", a.listSubscriptions)
+			r.Post("/subscriptions", a.createSubscription)
+			r.Get("/subscriptions/{subscriptionID}", a.getSubscription)
+			r.Post("/subscriptions/{subscriptionID}/cancel", a.cancelSubscription)
+			r.Get("/payments", a.listPayments)
 			r.Post("/payments", a.createPayment)
-			r.Get("/payments/{paymentID}", a.getPayment)
+			r.Route("/payments/{paymentID}", func(r chi.Router) {
+				r.Get("/", a.getPayment)
+				r.Post("/increment", a.incrementPayment)
+				r.Post("/dispute/representment", a.submitRepresentment)
+				r.Post("/dispute/accept", a.acceptDispute)
+				r.Post("/dispute/reject", a.rejectPreArbitration)
+			})
 		})
 	})
 }
@@ -40,15 +80,157 @@ func (a *API) createMerchant(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	account, err := a.acquirer.CreateMerchant(create)
+	merchant, err := a.acquirer.CreateMerchant(create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (a *API) listMerchants(w http.ResponseWriter, r *http.Request) {
+	status := models.MerchantStatus(r.URL.Query().Get("status"))
+
+	merchants, err := a.acquirer.ListMerchants(status)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchants)
+}
+
+func (a *API) getMerchant(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	merchant, err := a.acquirer.GetMerchant(merchantID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (a *API) updateMerchant(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	update := models.UpdateMerchant{}
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	merchant, err := a.acquirer.UpdateMerchant(merchantID, update)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (a *API) updateMerchantStatus(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	update := models.UpdateMerchantStatus{}
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	merchant, err := a.acquirer.UpdateMerchantStatus(merchantID, update)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+// closeMerchant deactivates the merchant. Merchants are never deleted as
+// their payments have to be kept.
+func (a *API) closeMerchant(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	merchant, err := a.acquirer.UpdateMerchantStatus(merchantID, models.UpdateMerchantStatus{
+		Status: models.MerchantStatusClosed,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(merchant)
+}
+
+func (a *API) createTerminal(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	create := models.CreateTerminal{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	terminal, err := a.acquirer.CreateTerminal(merchantID, create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(account)
+	json.NewEncoder(w).Encode(terminal)
+}
+
+func (a *API) listTerminals(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	terminals, err := a.acquirer.ListTerminals(merchantID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(terminals)
+}
+
+func (a *API) deactivateTerminal(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	terminalID := chi.URLParam(r, "terminalID")
+
+	terminal, err := a.acquirer.DeactivateTerminal(merchantID, terminalID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(terminal)
 }
 
 func (a *API) createPayment(w http.ResponseWriter, r *http.Request) {
@@ -64,7 +246,7 @@ func (a *API) createPayment(w http.ResponseWriter, r *http.Request) {
 	payment, err := a.acquirer.CreatePayment(merchantID, create)
 	if err != nil {
 		a.logger.Error("failed to create payment", "err", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -73,6 +255,304 @@ func (a *API) createPayment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(payment)
 }
 
+func (a *API) createPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	create := models.CreatePaymentMethod{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	method, err := a.acquirer.CreatePaymentMethod(merchantID, create)
+	if err != nil {
+		a.logger.Error("failed to create payment method", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(method)
+}
+
+func (a *API) listPaymentMethods(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	methods, err := a.acquirer.ListPaymentMethods(merchantID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(methods)
+}
+
+func (a *API) getPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentMethodID := chi.URLParam(r, "paymentMethodID")
+
+	method, err := a.acquirer.GetPaymentMethod(merchantID, paymentMethodID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(method)
+}
+
+func (a *API) deletePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentMethodID := chi.URLParam(r, "paymentMethodID")
+
+	err := a.acquirer.DeletePaymentMethod(merchantID, paymentMethodID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) createPlan(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	create := models.CreatePlan{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := a.acquirer.CreatePlan(merchantID, create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(plan)
+}
+
+func (a *API) listPlans(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	plans, err := a.acquirer.ListPlans(merchantID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(plans)
+}
+
+func (a *API) getPlan(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	planID := chi.URLParam(r, "planID")
+
+	plan, err := a.acquirer.GetPlan(merchantID, planID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(plan)
+}
+
+func (a *API) createSubscription(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	create := models.CreateSubscription{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := a.acquirer.CreateSubscription(merchantID, create)
+	if err != nil {
+		a.logger.Error("failed to create subscription", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func (a *API) listSubscriptions(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	status := models.SubscriptionStatus(r.URL.Query().Get("status"))
+
+	subscriptions, err := a.acquirer.ListSubscriptions(merchantID, status)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscriptions)
+}
+
+func (a *API) getSubscription(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	subscriptionID := chi.URLParam(r, "subscriptionID")
+
+	subscription, err := a.acquirer.GetSubscription(merchantID, subscriptionID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func (a *API) cancelSubscription(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	subscriptionID := chi.URLParam(r, "subscriptionID")
+
+	subscription, err := a.acquirer.CancelSubscription(merchantID, subscriptionID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(subscription)
+}
+
+func (a *API) incrementPayment(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentID := chi.URLParam(r, "paymentID")
+
+	increment := models.IncrementPayment{}
+	err := json.NewDecoder(r.Body).Decode(&increment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := a.acquirer.IncrementPayment(merchantID, paymentID, increment)
+	if err != nil {
+		a.logger.Error("failed to increment payment", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payment)
+}
+
+func (a *API) inquireBalance(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	create := models.CreateBalanceInquiry{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	inquiry, err := a.acquirer.InquireBalance(merchantID, create)
+	if err != nil {
+		a.logger.Error("failed to inquire balance", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(inquiry)
+}
+
+func (a *API) listPayments(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	filter, err := parsePaymentFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payments, err := a.acquirer.ListPayments(merchantID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payments)
+}
+
+// parsePaymentFilter parses the payment filter from the query parameters.
+// Dates are expected in RFC 3339 format.
+func parsePaymentFilter(query url.Values) (models.PaymentFilter, error) {
+	filter := models.PaymentFilter{
+		Status:            models.PaymentStatus(query.Get("status")),
+		CardFirst6:        query.Get("card_first6"),
+		CardLast4:         query.Get("card_last4"),
+		Currency:          query.Get("currency"),
+		AuthorizationCode: query.Get("authorization_code"),
+		Cursor:            query.Get("cursor"),
+	}
+
+	var err error
+
+	for param, value := range map[string]*time.Time{
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+	} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %w", param, err)
+		}
+	}
+
+	for param, value := range map[string]*int64{
+		"amount_min": &filter.AmountMin,
+		"amount_max": &filter.AmountMax,
+	} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = strconv.ParseInt(query.Get(param), 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %w", param, err)
+		}
+	}
+
+	if query.Get("limit") != "" {
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			return filter, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
 func (a *API) getPayment(w http.ResponseWriter, r *http.Request) {
 	merchantID := chi.URLParam(r, "merchantID")
 	paymentID := chi.URLParam(r, "paymentID")
@@ -91,3 +571,138 @@ func (a *API) getPayment(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(payment)
 }
+
+func (a *API) submitRepresentment(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentID := chi.URLParam(r, "paymentID")
+
+	create := models.CreateRepresentment{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := a.acquirer.SubmitRepresentment(merchantID, paymentID, create)
+	if err != nil {
+		a.logger.Error("failed to submit representment", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payment)
+}
+
+func (a *API) acceptDispute(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentID := chi.URLParam(r, "paymentID")
+
+	payment, err := a.acquirer.AcceptDispute(merchantID, paymentID)
+	if err != nil {
+		a.logger.Error("failed to accept dispute", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payment)
+}
+
+func (a *API) rejectPreArbitration(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	paymentID := chi.URLParam(r, "paymentID")
+
+	payment, err := a.acquirer.RejectPreArbitration(merchantID, paymentID)
+	if err != nil {
+		a.logger.Error("failed to reject pre-arbitration", "err", err)
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(payment)
+}
+
+// errorStatus returns the HTTP status code for the service error.
+func (a *API) issueAPIKey(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	apiKey, err := a.acquirer.IssueAPIKey(merchantID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+func (a *API) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+
+	apiKeys, err := a.acquirer.ListAPIKeys(merchantID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiKeys)
+}
+
+func (a *API) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	apiKeyID := chi.URLParam(r, "apiKeyID")
+
+	apiKey, err := a.acquirer.RevokeAPIKey(merchantID, apiKeyID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+// rotateAPIKey issues the new API key and revokes the rotated one
+func (a *API) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	merchantID := chi.URLParam(r, "merchantID")
+	apiKeyID := chi.URLParam(r, "apiKeyID")
+
+	apiKey, err := a.acquirer.RotateAPIKey(merchantID, apiKeyID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrInvalidDisputeStatus),
+		errors.Is(err, models.ErrInvalidMerchantStatus),
+		errors.Is(err, models.ErrInvalidAPIKeyStatus),
+		errors.Is(err, models.ErrPaymentNotIncrementable),
+		errors.Is(err, models.ErrInvalidSubscriptionStatus):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrMerchantNotActive),
+		errors.Is(err, models.ErrTerminalNotActive):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
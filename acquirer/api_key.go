@@ -0,0 +1,184 @@
+package acquirer
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/middleware"
+	"github.com/google/uuid"
+)
+
+// apiKeyPrefix tells the API keys of the acquirer apart from other secrets,
+// e.g. in the leaked logs
+const apiKeyPrefix = "ak_"
+
+// SetAdminAPIKey sets the key of the admin allowed to call all routes,
+// including the management ones. Without it only the merchants are
+// authenticated.
+func (a *Service) SetAdminAPIKey(key string) {
+	a.adminAPIKeyHash = hashAPIKey(key)
+}
+
+// IssueAPIKey issues the new API key of the merchant. The key is returned
+// only once, only its hash is stored.
+func (a *Service) IssueAPIKey(merchantID string) (*models.APIKey, error) {
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status == models.MerchantStatusClosed {
+		return nil, fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+	}
+
+	return a.issueAPIKey(merchantID)
+}
+
+func (a *Service) issueAPIKey(merchantID string) (*models.APIKey, error) {
+	key, err := generateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("generating API key: %w", err)
+	}
+
+	apiKey := &models.APIKey{
+		ID:         uuid.New().String(),
+		MerchantID: merchantID,
+		Last4:      key[len(key)-4:],
+		Status:     models.APIKeyStatusActive,
+		CreatedAt:  time.Now(),
+	}
+
+	err = a.repo.CreateAPIKey(apiKey, hashAPIKey(key))
+	if err != nil {
+		return nil, fmt.Errorf("creating API key: %w", err)
+	}
+
+	issued := *apiKey
+	issued.Key = key
+
+	return &issued, nil
+}
+
+func (a *Service) ListAPIKeys(merchantID string) ([]*models.APIKey, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	apiKeys, err := a.repo.ListAPIKeys(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("listing API keys: %w", err)
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes the API key of the merchant, the requests with it
+// are rejected from now on.
+func (a *Service) RevokeAPIKey(merchantID, apiKeyID string) (*models.APIKey, error) {
+	apiKey, err := a.repo.GetAPIKey(merchantID, apiKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("getting API key: %w", err)
+	}
+
+	if apiKey.Status == models.APIKeyStatusRevoked {
+		return nil, fmt.Errorf("%w: API key is already revoked", models.ErrInvalidAPIKeyStatus)
+	}
+
+	err = a.repo.RevokeAPIKey(apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("revoking API key: %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// RotateAPIKey issues the new API key of the merchant and revokes the
+// rotated one. To rotate the key without downtime, issue the new key with
+// IssueAPIKey and revoke the old one when it's no longer used instead.
+func (a *Service) RotateAPIKey(merchantID, apiKeyID string) (*models.APIKey, error) {
+	apiKey, err := a.repo.GetAPIKey(merchantID, apiKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("getting API key: %w", err)
+	}
+
+	if apiKey.Status == models.APIKeyStatusRevoked {
+		return nil, fmt.Errorf("%w: API key is already revoked", models.ErrInvalidAPIKeyStatus)
+	}
+
+	issued, err := a.IssueAPIKey(merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.repo.RevokeAPIKey(apiKey)
+	if err != nil {
+		return nil, fmt.Errorf("revoking API key: %w", err)
+	}
+
+	return issued, nil
+}
+
+// AuthenticateAPIKey returns the admin or the merchant the API key belongs
+// to. It returns middleware.ErrInvalidAPIKey if the key is unknown or
+// revoked or its merchant is closed.
+func (a *Service) AuthenticateAPIKey(key string) (middleware.Principal, error) {
+	keyHash := hashAPIKey(key)
+
+	if a.adminAPIKeyHash != "" && subtle.ConstantTimeCompare([]byte(keyHash), []byte(a.adminAPIKeyHash)) == 1 {
+		return middleware.Principal{Admin: true}, nil
+	}
+
+	// the key is found by its hash, so the lookup time doesn't depend on
+	// how much of the key matches
+	apiKey, err := a.repo.FindAPIKeyByHash(keyHash)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return middleware.Principal{}, middleware.ErrInvalidAPIKey
+		}
+
+		return middleware.Principal{}, fmt.Errorf("finding API key: %w", err)
+	}
+
+	if apiKey.Status != models.APIKeyStatusActive {
+		return middleware.Principal{}, middleware.ErrInvalidAPIKey
+	}
+
+	// the keys of the closed merchant are revoked right after it's closed,
+	// the status is checked too so they are not accepted in between
+	merchantStatus, err := a.repo.GetMerchantStatus(apiKey.MerchantID)
+	if err != nil {
+		return middleware.Principal{}, fmt.Errorf("getting merchant status: %w", err)
+	}
+
+	if merchantStatus == models.MerchantStatusClosed {
+		return middleware.Principal{}, middleware.ErrInvalidAPIKey
+	}
+
+	return middleware.Principal{MerchantID: apiKey.MerchantID}, nil
+}
+
+// generateAPIKey returns the new random API key.
+func generateAPIKey() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+
+	return apiKeyPrefix + hex.EncodeToString(secret), nil
+}
+
+// hashAPIKey returns the SHA-256 hash of the key. The keys are random, so
+// the fast hash is enough to protect the stored ones.
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,279 @@
+package acquirer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/acquirer"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// iso8583Client approves all payments without sending them to the issuer
+type iso8583Client struct{}
+
+func (c *iso8583Client) AuthorizePayment(payment *models.Payment, card models.Card, merchant models.Merchant) (models.AuthorizationResponse, error) {
+	return models.AuthorizationResponse{
+		ApprovalCode:      "00",
+		AuthorizationCode: "123456",
+	}, nil
+}
+
+func (c *iso8583Client) IncrementAuthorization(payment *models.Payment, increment *models.PaymentIncrement, merchant models.Merchant) (models.AuthorizationResponse, error) {
+	return models.AuthorizationResponse{
+		ApprovalCode:      "00",
+		AuthorizationCode: payment.AuthorizationCode,
+	}, nil
+}
+
+func (c *iso8583Client) InquireBalance(card models.Card, terminalID string, merchant models.Merchant) (models.BalanceInquiry, error) {
+	return models.BalanceInquiry{
+		ApprovalCode: "00",
+	}, nil
+}
+
+func (c *iso8583Client) NotifyDispute(notification models.DisputeNotification) error {
+	return nil
+}
+
+func TestMerchantAPI(t *testing.T) {
+	router := chi.NewRouter()
+
+	const adminAPIKey = "ak_admin_test"
+
+	service := acquirer.NewService(acquirer.NewRepository(), &iso8583Client{})
+	service.SetAdminAPIKey(adminAPIKey)
+
+	api := acquirer.NewAPI(log.New(), service)
+	api.AppendRoutes(router)
+
+	sendWithKey := func(apiKey, method, path string, req any) *httptest.ResponseRecorder {
+		jsonReq, _ := json.Marshal(req)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(method, path, bytes.NewBuffer(jsonReq))
+		if apiKey != "" {
+			r.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	send := func(method, path string, req any) *httptest.ResponseRecorder {
+		return sendWithKey(adminAPIKey, method, path, req)
+	}
+
+	validMerchant := models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+		WebSite:    "https://demo.merchant.com",
+	}
+
+	t.Run("create merchant with invalid details", func(t *testing.T) {
+		tests := map[string]func(m *models.CreateMerchant){
+			"missing name":     func(m *models.CreateMerchant) { m.Name = "" },
+			"MCC not 4 digits": func(m *models.CreateMerchant) { m.MCC = "541" },
+			"unknown MCC":      func(m *models.CreateMerchant) { m.MCC = "0001" },
+			"bad postal code":  func(m *models.CreateMerchant) { m.PostalCode = "1" },
+			"bad web site":     func(m *models.CreateMerchant) { m.WebSite = "demo.merchant.com" },
+		}
+
+		for name, modify := range tests {
+			t.Run(name, func(t *testing.T) {
+				create := validMerchant
+				modify(&create)
+
+				w := send(http.MethodPost, "/merchants", create)
+				require.Equal(t, http.StatusBadRequest, w.Code)
+			})
+		}
+	})
+
+	t.Run("merchant lifecycle", func(t *testing.T) {
+		w := send(http.MethodPost, "/merchants", validMerchant)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		merchant := models.Merchant{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merchant))
+		require.Equal(t, models.MerchantStatusActive, merchant.Status)
+
+		// update merchant details
+		update := models.UpdateMerchant(validMerchant)
+		update.MCC = "3501" // hotel chain
+		w = send(http.MethodPut, "/merchants/"+merchant.ID, update)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merchant))
+		require.Equal(t, "3501", merchant.MCC)
+
+		// merchant is listed
+		w = send(http.MethodGet, "/merchants?status=active", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var merchants []models.Merchant
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merchants))
+		require.Len(t, merchants, 1)
+
+		// add a terminal
+		w = send(http.MethodPost, "/merchants/"+merchant.ID+"/terminals", models.CreateTerminal{Description: "front desk"})
+		require.Equal(t, http.StatusCreated, w.Code)
+		terminal := models.Terminal{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &terminal))
+		require.Len(t, terminal.ID, 8)
+
+		payment := models.CreatePayment{
+			Amount:     10_00,
+			Currency:   "USD",
+			TerminalID: terminal.ID,
+			Card: models.Card{
+				Number:                "9000000000000001",
+				ExpirationDate:        "0130",
+				CardVerificationValue: "1234",
+			},
+		}
+
+		w = send(http.MethodPost, "/merchants/"+merchant.ID+"/payments", payment)
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		// suspended merchant can't accept payments
+		w = send(http.MethodPut, "/merchants/"+merchant.ID+"/status", models.UpdateMerchantStatus{Status: models.MerchantStatusSuspended})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodPost, "/merchants/"+merchant.ID+"/payments", payment)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		// reactivated merchant can't use deactivated terminal
+		w = send(http.MethodPut, "/merchants/"+merchant.ID+"/status", models.UpdateMerchantStatus{Status: models.MerchantStatusActive})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodDelete, "/merchants/"+merchant.ID+"/terminals/"+terminal.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodPost, "/merchants/"+merchant.ID+"/payments", payment)
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		// closed merchant can't be reactivated
+		w = send(http.MethodDelete, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodPut, "/merchants/"+merchant.ID+"/status", models.UpdateMerchantStatus{Status: models.MerchantStatusActive})
+		require.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("payment for unknown merchant", func(t *testing.T) {
+		w := send(http.MethodPost, "/merchants/unknown/payments", models.CreatePayment{
+			Amount:   10_00,
+			Currency: "USD",
+			Card: models.Card{
+				Number:                "9000000000000001",
+				ExpirationDate:        "0130",
+				CardVerificationValue: "1234",
+			},
+		})
+		require.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("API keys", func(t *testing.T) {
+		// requests without the valid key are not authenticated
+		w := sendWithKey("", http.MethodGet, "/merchants", nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = sendWithKey("ak_unknown", http.MethodGet, "/merchants", nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		// the key of the merchant is issued when it's created
+		w = send(http.MethodPost, "/merchants", validMerchant)
+		require.Equal(t, http.StatusCreated, w.Code)
+		merchant := models.Merchant{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &merchant))
+		require.NotEmpty(t, merchant.APIKey)
+
+		w = send(http.MethodPost, "/merchants", validMerchant)
+		require.Equal(t, http.StatusCreated, w.Code)
+		otherMerchant := models.Merchant{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &otherMerchant))
+
+		// the key is not shown again
+		w = sendWithKey(merchant.APIKey, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NotContains(t, w.Body.String(), merchant.APIKey)
+
+		// the merchant can't call the routes of other merchants and the
+		// management routes
+		w = sendWithKey(merchant.APIKey, http.MethodGet, "/merchants/"+otherMerchant.ID, nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendWithKey(merchant.APIKey, http.MethodGet, "/merchants", nil)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendWithKey(merchant.APIKey, http.MethodPost, "/merchants", validMerchant)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		w = sendWithKey(merchant.APIKey, http.MethodPut, "/merchants/"+merchant.ID+"/status", models.UpdateMerchantStatus{Status: models.MerchantStatusActive})
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		// the MCC defines the hold expiry, so the merchant can't change it
+		update := models.UpdateMerchant(validMerchant)
+		update.MCC = "7011"
+		w = sendWithKey(merchant.APIKey, http.MethodPut, "/merchants/"+merchant.ID, update)
+		require.Equal(t, http.StatusForbidden, w.Code)
+
+		// the rotated key is revoked
+		w = sendWithKey(merchant.APIKey, http.MethodGet, "/merchants/"+merchant.ID+"/api-keys", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		var apiKeys []models.APIKey
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiKeys))
+		require.Len(t, apiKeys, 1)
+		require.Empty(t, apiKeys[0].Key)
+		require.Equal(t, merchant.APIKey[len(merchant.APIKey)-4:], apiKeys[0].Last4)
+
+		w = sendWithKey(merchant.APIKey, http.MethodPost, "/merchants/"+merchant.ID+"/api-keys/"+apiKeys[0].ID+"/rotate", nil)
+		require.Equal(t, http.StatusCreated, w.Code)
+		rotated := models.APIKey{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rotated))
+		require.NotEmpty(t, rotated.Key)
+
+		w = sendWithKey(merchant.APIKey, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = sendWithKey(rotated.Key, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		// the revoked key can't be used and revoked again
+		w = sendWithKey(rotated.Key, http.MethodDelete, "/merchants/"+merchant.ID+"/api-keys/"+rotated.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = sendWithKey(rotated.Key, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = send(http.MethodDelete, "/merchants/"+merchant.ID+"/api-keys/"+rotated.ID, nil)
+		require.Equal(t, http.StatusConflict, w.Code)
+
+		// the keys of the closed merchant are revoked
+		w = send(http.MethodPost, "/merchants/"+merchant.ID+"/api-keys", nil)
+		require.Equal(t, http.StatusCreated, w.Code)
+		issued := models.APIKey{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &issued))
+
+		w = sendWithKey(issued.Key, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = send(http.MethodDelete, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		w = sendWithKey(issued.Key, http.MethodGet, "/merchants/"+merchant.ID, nil)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		w = send(http.MethodGet, "/merchants/"+merchant.ID+"/api-keys", nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &apiKeys))
+		for _, apiKey := range apiKeys {
+			require.Equal(t, models.APIKeyStatusRevoked, apiKey.Status)
+		}
+	})
+}
@@ -2,12 +2,16 @@ package acquirer
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/iso8583"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/hsm"
 	"github.com/alovak/cardflow-playground/internal/middleware"
 	"github.com/go-chi/chi/v5"
 	"golang.org/x/exp/slog"
@@ -22,6 +26,7 @@ type App struct {
 	ISO8583ServerAddr string
 	logger            *slog.Logger
 	config            *Config
+	done              chan struct{}
 }
 
 func NewApp(logger *slog.Logger, config *Config) *App {
@@ -35,6 +40,7 @@ func NewApp(logger *slog.Logger, config *Config) *App {
 		logger: logger,
 		wg:     &sync.WaitGroup{},
 		config: config,
+		done:   make(chan struct{}),
 	}
 }
 
@@ -54,12 +60,89 @@ func (a *App) Start() error {
 		return fmt.Errorf("creating iso8583 client: %w", err)
 	}
 
+	if a.config.ISO8583TLS != nil {
+		err = iso8583Client.SetTLS(*a.config.ISO8583TLS)
+		if err != nil {
+			return fmt.Errorf("configuring iso8583 client TLS: %w", err)
+		}
+	}
+
+	paymentHSM, err := hsm.Open(a.config.HSMMasterKeyFile, a.config.HSMKeyStoreFile)
+	if err != nil {
+		return fmt.Errorf("opening HSM: %w", err)
+	}
+
+	err = paymentHSM.ImportOrGenerateKey(iso8583.ZonePINKeyName, hsm.KeyTypeZPK, a.config.ZonePINKey)
+	if err != nil {
+		return fmt.Errorf("loading zone PIN key: %w", err)
+	}
+
+	iso8583Client.SetPINEncryption(paymentHSM, a.config.PINBlockFormat)
+
+	if a.config.MACKey != "" {
+		err = paymentHSM.ImportOrGenerateKey(iso8583.MACKeyName, hsm.KeyTypeZAK, a.config.MACKey)
+		if err != nil {
+			return fmt.Errorf("loading MAC key: %w", err)
+		}
+
+		algorithm := a.config.MACAlgorithm
+		if algorithm == "" {
+			algorithm = hsm.MACAlgorithmX919
+		}
+
+		iso8583Client.SetMAC(paymentHSM, algorithm)
+	}
+
+	if a.config.ZoneMasterKey != "" {
+		err = paymentHSM.ImportOrGenerateKey(iso8583.ZoneMasterKeyName, hsm.KeyTypeZMK, a.config.ZoneMasterKey)
+		if err != nil {
+			return fmt.Errorf("loading zone master key: %w", err)
+		}
+
+		iso8583Client.SetKeyExchange(paymentHSM)
+	}
+
+	acq := NewService(repository, iso8583Client)
+
+	if a.config.CardVaultKey != "" {
+		key, err := hex.DecodeString(a.config.CardVaultKey)
+		if err != nil {
+			return fmt.Errorf("decoding card vault key: %w", err)
+		}
+
+		vault, err := NewCardVault(key)
+		if err != nil {
+			return fmt.Errorf("creating card vault: %w", err)
+		}
+
+		acq.SetCardVault(vault)
+	}
+
+	if a.config.DunningPolicy != (models.DunningPolicy{}) {
+		acq.SetDunningPolicy(a.config.DunningPolicy)
+	}
+
+	adminAPIKey := a.config.AdminAPIKey
+	if adminAPIKey == "" {
+		adminAPIKey, err = generateAPIKey()
+		if err != nil {
+			return fmt.Errorf("generating admin API key: %w", err)
+		}
+
+		// the generated key is logged only once, it's not known otherwise
+		a.logger.Warn("admin API key is not set, generated the random one", slog.String("admin_api_key", adminAPIKey))
+	}
+
+	acq.SetAdminAPIKey(adminAPIKey)
+
+	// disputes are received from the issuer over the ISO 8583 connection
+	iso8583Client.SetDisputeHandler(acq)
+
 	// connect to iso8583 server
 	if err := iso8583Client.Connect(); err != nil {
 		return fmt.Errorf("connecting to iso8583 server: %w", err)
 	}
 
-	acq := NewService(repository, iso8583Client)
 	api := NewAPI(a.logger, acq)
 	api.AppendRoutes(router)
 
@@ -74,6 +157,8 @@ func (a *App) Start() error {
 		Handler: router,
 	}
 
+	a.startSubscriptionScheduler(acq)
+
 	a.wg.Add(1)
 	go func() {
 		a.logger.Info("http server started", slog.String("addr", a.Addr))
@@ -92,9 +177,44 @@ func (a *App) Start() error {
 	return nil
 }
 
+// startSubscriptionScheduler periodically charges the due subscriptions
+// until the app is shut down.
+func (a *App) startSubscriptionScheduler(acq *Service) {
+	interval := a.config.SubscriptionInterval
+	if interval == 0 {
+		interval = defaultSubscriptionInterval
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case now := <-ticker.C:
+				charged, err := acq.ChargeDueSubscriptions(now)
+				if err != nil {
+					a.logger.Error("charging subscriptions", "err", err)
+				}
+
+				if charged > 0 {
+					a.logger.Info("charged subscriptions", slog.Int("count", charged))
+				}
+			}
+		}
+	}()
+}
+
 func (a *App) Shutdown() {
 	a.logger.Info("shutting down app...")
 
+	close(a.done)
+
 	a.srv.Shutdown(context.Background())
 
 	a.wg.Wait()
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/models"
@@ -13,9 +16,12 @@ import (
 type client struct {
 	httpClient *http.Client
 	baseURL    string
+	apiKey     string
 }
 
-func New(baseURL string) *client {
+// New returns the client of the acquirer API authenticated with the API key,
+// the admin key or the key of the merchant whose routes are called.
+func New(baseURL, apiKey string) *client {
 	httpClient := &http.Client{
 		Transport: &http.Transport{
 			IdleConnTimeout: 5 * time.Second,
@@ -24,27 +30,71 @@ func New(baseURL string) *client {
 
 	return &client{
 		baseURL:    baseURL,
+		apiKey:     apiKey,
 		httpClient: httpClient,
 	}
 }
 
 func (c *client) CreateMerchant(req models.CreateMerchant) (models.Merchant, error) {
-	reqJSON, err := json.Marshal(req)
+	var merchant models.Merchant
+	err := c.do(http.MethodPost, "/merchants", req, http.StatusCreated, &merchant)
 	if err != nil {
 		return models.Merchant{}, err
 	}
 
-	res, err := c.httpClient.Post(c.baseURL+"/merchants", "application/json", bytes.NewReader(reqJSON))
+	return merchant, nil
+}
+
+func (c *client) CreatePayment(merchantID string, req models.CreatePayment) (models.Payment, error) {
+	var payment models.Payment
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/payments", req, http.StatusCreated, &payment)
+	if err != nil {
+		return models.Payment{}, err
+	}
+
+	return payment, nil
+}
+
+func (c *client) GetPayment(merchantID, paymentID string) (models.Payment, error) {
+	var payment models.Payment
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/payments/"+paymentID, nil, http.StatusOK, &payment)
+	if err != nil {
+		return models.Payment{}, err
+	}
+
+	return payment, nil
+}
+
+func (c *client) GetMerchant(merchantID string) (models.Merchant, error) {
+	var merchant models.Merchant
+	err := c.do(http.MethodGet, "/merchants/"+merchantID, nil, http.StatusOK, &merchant)
 	if err != nil {
 		return models.Merchant{}, err
 	}
 
-	if res.StatusCode != http.StatusCreated {
-		return models.Merchant{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	return merchant, nil
+}
+
+// ListMerchants returns merchants with the given status or all merchants if
+// status is empty.
+func (c *client) ListMerchants(status models.MerchantStatus) ([]models.Merchant, error) {
+	path := "/merchants"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(string(status))
+	}
+
+	var merchants []models.Merchant
+	err := c.do(http.MethodGet, path, nil, http.StatusOK, &merchants)
+	if err != nil {
+		return nil, err
 	}
 
+	return merchants, nil
+}
+
+func (c *client) UpdateMerchant(merchantID string, req models.UpdateMerchant) (models.Merchant, error) {
 	var merchant models.Merchant
-	err = json.NewDecoder(res.Body).Decode(&merchant)
+	err := c.do(http.MethodPut, "/merchants/"+merchantID, req, http.StatusOK, &merchant)
 	if err != nil {
 		return models.Merchant{}, err
 	}
@@ -52,23 +102,186 @@ func (c *client) CreateMerchant(req models.CreateMerchant) (models.Merchant, err
 	return merchant, nil
 }
 
-func (c *client) CreatePayment(merchantID string, req models.CreatePayment) (models.Payment, error) {
-	reqJSON, err := json.Marshal(req)
+func (c *client) UpdateMerchantStatus(merchantID string, status models.MerchantStatus) (models.Merchant, error) {
+	req := models.UpdateMerchantStatus{
+		Status: status,
+	}
+
+	var merchant models.Merchant
+	err := c.do(http.MethodPut, "/merchants/"+merchantID+"/status", req, http.StatusOK, &merchant)
 	if err != nil {
-		return models.Payment{}, err
+		return models.Merchant{}, err
 	}
 
-	res, err := c.httpClient.Post(c.baseURL+"/merchants/"+merchantID+"/payments", "application/json", bytes.NewReader(reqJSON))
+	return merchant, nil
+}
+
+// CloseMerchant closes the merchant. Closed merchant can't be reopened.
+func (c *client) CloseMerchant(merchantID string) (models.Merchant, error) {
+	var merchant models.Merchant
+	err := c.do(http.MethodDelete, "/merchants/"+merchantID, nil, http.StatusOK, &merchant)
 	if err != nil {
-		return models.Payment{}, err
+		return models.Merchant{}, err
+	}
+
+	return merchant, nil
+}
+
+func (c *client) CreateTerminal(merchantID string, req models.CreateTerminal) (models.Terminal, error) {
+	var terminal models.Terminal
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/terminals", req, http.StatusCreated, &terminal)
+	if err != nil {
+		return models.Terminal{}, err
+	}
+
+	return terminal, nil
+}
+
+func (c *client) ListTerminals(merchantID string) ([]models.Terminal, error) {
+	var terminals []models.Terminal
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/terminals", nil, http.StatusOK, &terminals)
+	if err != nil {
+		return nil, err
+	}
+
+	return terminals, nil
+}
+
+func (c *client) DeactivateTerminal(merchantID, terminalID string) (models.Terminal, error) {
+	var terminal models.Terminal
+	err := c.do(http.MethodDelete, "/merchants/"+merchantID+"/terminals/"+terminalID, nil, http.StatusOK, &terminal)
+	if err != nil {
+		return models.Terminal{}, err
+	}
+
+	return terminal, nil
+}
+
+// CreatePaymentMethod stores the card in the merchant's vault, the returned
+// payment method ID can be used for payments instead of the card.
+func (c *client) CreatePaymentMethod(merchantID string, req models.CreatePaymentMethod) (models.PaymentMethod, error) {
+	var method models.PaymentMethod
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/payment-methods", req, http.StatusCreated, &method)
+	if err != nil {
+		return models.PaymentMethod{}, err
 	}
 
-	if res.StatusCode != http.StatusCreated {
-		return models.Payment{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	return method, nil
+}
+
+func (c *client) ListPaymentMethods(merchantID string) ([]models.PaymentMethod, error) {
+	var methods []models.PaymentMethod
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/payment-methods", nil, http.StatusOK, &methods)
+	if err != nil {
+		return nil, err
 	}
 
+	return methods, nil
+}
+
+func (c *client) DeletePaymentMethod(merchantID, paymentMethodID string) error {
+	return c.do(http.MethodDelete, "/merchants/"+merchantID+"/payment-methods/"+paymentMethodID, nil, http.StatusNoContent, nil)
+}
+
+func (c *client) CreatePlan(merchantID string, req models.CreatePlan) (models.Plan, error) {
+	var plan models.Plan
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/plans", req, http.StatusCreated, &plan)
+	if err != nil {
+		return models.Plan{}, err
+	}
+
+	return plan, nil
+}
+
+// CreateSubscription subscribes the stored card to the plan, the card is
+// charged by the acquirer's scheduler.
+func (c *client) CreateSubscription(merchantID string, req models.CreateSubscription) (models.Subscription, error) {
+	var subscription models.Subscription
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/subscriptions", req, http.StatusCreated, &subscription)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (c *client) GetSubscription(merchantID, subscriptionID string) (models.Subscription, error) {
+	var subscription models.Subscription
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/subscriptions/"+subscriptionID, nil, http.StatusOK, &subscription)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	return subscription, nil
+}
+
+func (c *client) CancelSubscription(merchantID, subscriptionID string) (models.Subscription, error) {
+	var subscription models.Subscription
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/subscriptions/"+subscriptionID+"/cancel", nil, http.StatusOK, &subscription)
+	if err != nil {
+		return models.Subscription{}, err
+	}
+
+	return subscription, nil
+}
+
+// ListPayments returns a page of merchant's payments matching the filter,
+// newest first. To get the next page, set filter's Cursor to NextCursor of
+// the returned list.
+func (c *client) ListPayments(merchantID string, filter models.PaymentFilter) (models.PaymentList, error) {
+	query := url.Values{}
+
+	for param, value := range map[string]string{
+		"status":             string(filter.Status),
+		"card_first6":        filter.CardFirst6,
+		"card_last4":         filter.CardLast4,
+		"currency":           filter.Currency,
+		"authorization_code": filter.AuthorizationCode,
+		"cursor":             filter.Cursor,
+	} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+
+	if !filter.CreatedFrom.IsZero() {
+		query.Set("created_from", filter.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if !filter.CreatedTo.IsZero() {
+		query.Set("created_to", filter.CreatedTo.Format(time.RFC3339Nano))
+	}
+	if filter.AmountMin != 0 {
+		query.Set("amount_min", strconv.FormatInt(filter.AmountMin, 10))
+	}
+	if filter.AmountMax != 0 {
+		query.Set("amount_max", strconv.FormatInt(filter.AmountMax, 10))
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var list models.PaymentList
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/payments?"+query.Encode(), nil, http.StatusOK, &list)
+	if err != nil {
+		return models.PaymentList{}, err
+	}
+
+	return list, nil
+}
+
+func (c *client) InquireBalance(merchantID string, req models.CreateBalanceInquiry) (models.BalanceInquiry, error) {
+	var inquiry models.BalanceInquiry
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/balance-inquiries", req, http.StatusOK, &inquiry)
+	if err != nil {
+		return models.BalanceInquiry{}, err
+	}
+
+	return inquiry, nil
+}
+
+func (c *client) IncrementPayment(merchantID, paymentID string, req models.IncrementPayment) (models.Payment, error) {
 	var payment models.Payment
-	err = json.NewDecoder(res.Body).Decode(&payment)
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/payments/"+paymentID+"/increment", req, http.StatusOK, &payment)
 	if err != nil {
 		return models.Payment{}, err
 	}
@@ -76,21 +289,105 @@ func (c *client) CreatePayment(merchantID string, req models.CreatePayment) (mod
 	return payment, nil
 }
 
-func (c *client) GetPayment(merchantID, paymentID string) (models.Payment, error) {
-	res, err := c.httpClient.Get(c.baseURL + "/merchants/" + merchantID + "/payments/" + paymentID)
+func (c *client) SubmitRepresentment(merchantID, paymentID string, req models.CreateRepresentment) (models.Payment, error) {
+	return c.postDisputeAction(merchantID, paymentID, "representment", req)
+}
+
+func (c *client) AcceptDispute(merchantID, paymentID string) (models.Payment, error) {
+	return c.postDisputeAction(merchantID, paymentID, "accept", nil)
+}
+
+func (c *client) RejectPreArbitration(merchantID, paymentID string) (models.Payment, error) {
+	return c.postDisputeAction(merchantID, paymentID, "reject", nil)
+}
+
+func (c *client) postDisputeAction(merchantID, paymentID, action string, req any) (models.Payment, error) {
+	var payment models.Payment
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/payments/"+paymentID+"/dispute/"+action, req, http.StatusOK, &payment)
 	if err != nil {
 		return models.Payment{}, err
 	}
 
-	if res.StatusCode != http.StatusOK {
-		return models.Payment{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	return payment, nil
+}
+
+func (c *client) IssueAPIKey(merchantID string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/api-keys", nil, http.StatusCreated, &apiKey)
+	if err != nil {
+		return models.APIKey{}, err
 	}
 
-	var payment models.Payment
-	err = json.NewDecoder(res.Body).Decode(&payment)
+	return apiKey, nil
+}
+
+func (c *client) ListAPIKeys(merchantID string) ([]models.APIKey, error) {
+	var apiKeys []models.APIKey
+	err := c.do(http.MethodGet, "/merchants/"+merchantID+"/api-keys", nil, http.StatusOK, &apiKeys)
 	if err != nil {
-		return models.Payment{}, err
+		return nil, err
 	}
 
-	return payment, nil
+	return apiKeys, nil
+}
+
+func (c *client) RevokeAPIKey(merchantID, apiKeyID string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	err := c.do(http.MethodDelete, "/merchants/"+merchantID+"/api-keys/"+apiKeyID, nil, http.StatusOK, &apiKey)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+// RotateAPIKey returns the new API key, the rotated one is revoked.
+func (c *client) RotateAPIKey(merchantID, apiKeyID string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	err := c.do(http.MethodPost, "/merchants/"+merchantID+"/api-keys/"+apiKeyID+"/rotate", nil, http.StatusCreated, &apiKey)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+// do sends the request with req encoded as JSON body (if not nil) and decodes
+// the response into res (if not nil) if the response status matches the
+// expected one.
+func (c *client) do(method, path string, req any, expectedStatus int, res any) error {
+	var body io.Reader
+	if req != nil {
+		reqJSON, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(reqJSON)
+	}
+
+	httpReq, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != expectedStatus {
+		return fmt.Errorf("unexpected status code: %d; expected: %d", httpRes.StatusCode, expectedStatus)
+	}
+
+	// responses without body, e.g. 204 No Content, are not decoded
+	if res == nil {
+		return nil
+	}
+
+	return json.NewDecoder(httpRes.Body).Decode(res)
 }
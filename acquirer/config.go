@@ -1,13 +1,77 @@
 package acquirer
 
+import (
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer/iso8583"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/hsm"
+)
+
 type Config struct {
 	HTTPAddr    string
 	ISO8583Addr string
+	// ISO8583TLS configures TLS of the connection to the issuer and the
+	// client certificate of the acquirer. Plain TCP is used when it's nil.
+	ISO8583TLS *iso8583.TLSConfig
+	// CardVaultKey is the hex encoded AES-256 key the cards of payment
+	// methods are encrypted with. A random key is used when it's empty.
+	CardVaultKey string
+	// DunningPolicy defines how the declined payments of subscriptions are
+	// retried. models.DefaultDunningPolicy is used if it's not set.
+	DunningPolicy models.DunningPolicy
+	// SubscriptionInterval is how often the due subscriptions are charged.
+	SubscriptionInterval time.Duration
+	// HSMMasterKeyFile is the file with the hex encoded local master key
+	// the HSM keys are encrypted under. It's created with the random key
+	// if it doesn't exist. The HSM keys are kept in memory only when it's
+	// empty.
+	HSMMasterKeyFile string
+	// HSMKeyStoreFile is the file the encrypted HSM keys are stored in
+	HSMKeyStoreFile string
+	// ZonePINKey is the hex encoded key PIN blocks are encrypted under, it's
+	// shared with the issuer
+	ZonePINKey string
+	// PINBlockFormat is the ISO 9564-1 format of PIN blocks, 0 or 4
+	PINBlockFormat hsm.PINBlockFormat
+	// MACKey is the hex encoded zone authentication key the MACs of ISO
+	// 8583 requests are generated with, it's shared with the issuer. The
+	// requests are not MACed when it's empty.
+	MACKey string
+	// MACAlgorithm is the algorithm of the MACs, hsm.MACAlgorithmX919
+	// (double length key) or hsm.MACAlgorithmAESCMAC
+	MACAlgorithm hsm.MACAlgorithm
+	// ZoneMasterKey is the hex encoded key the working keys (the zone PIN
+	// key and the zone authentication key) are exchanged under, it's
+	// shared with the issuer. The new working keys are requested from the
+	// issuer when the acquirer signs on. The keys are not exchanged when
+	// it's empty.
+	ZoneMasterKey string
+	// AdminAPIKey is the API key of the admin allowed to manage the
+	// merchants and call the routes of all of them. A random key is
+	// generated and logged once at the start when it's empty.
+	AdminAPIKey string
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		HTTPAddr:    "127.0.0.1:8080",
-		ISO8583Addr: "127.0.0.1:8583",
+		HTTPAddr:             "127.0.0.1:8080",
+		ISO8583Addr:          "127.0.0.1:8583",
+		DunningPolicy:        models.DefaultDunningPolicy(),
+		SubscriptionInterval: defaultSubscriptionInterval,
+		ZonePINKey:           developmentZonePINKey,
+		PINBlockFormat:       hsm.PINBlockFormat0,
+		MACAlgorithm:         hsm.MACAlgorithmX919,
+		ZoneMasterKey:        developmentZoneMasterKey,
 	}
 }
+
+const defaultSubscriptionInterval = time.Minute
+
+// developmentZonePINKey is the zone PIN key of the default configs of the
+// acquirer and the issuer, it must not be used outside of the playground
+const developmentZonePINKey = "0123456789ABCDEFFEDCBA9876543210"
+
+// developmentZoneMasterKey is the zone master key of the default configs of
+// the acquirer and the issuer, it must not be used outside of the playground
+const developmentZoneMasterKey = "FEDCBA98765432100123456789ABCDEF"
@@ -0,0 +1,83 @@
+package iso8583
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ProcessingCodeBalanceInquiry is the processing code of the 0100 balance
+// inquiry request. Authorization requests are sent without the processing
+// code.
+const ProcessingCodeBalanceInquiry = "310000"
+
+type BalanceInquiryRequest struct {
+	MTI                      string               `index:"0"`
+	PrimaryAccountNumber     string               `index:"2"`
+	TransmissionDateTime     string               `index:"4"`
+	CardVerificationValue    string               `index:"8"`
+	ExpirationDate           string               `index:"9"`
+	AcceptorInformation      *AcceptorInformation `index:"10"`
+	STAN                     string               `index:"11"`
+	ProcessingCode           string               `index:"12"`
+	RetrievalReferenceNumber string               `index:"37"`
+	TerminalID               string               `index:"41"`
+}
+
+type BalanceInquiryResponse struct {
+	MTI               string `index:"0"`
+	ApprovalCode      string `index:"5"`
+	STAN              string `index:"11"`
+	ProcessingCode    string `index:"12"`
+	AdditionalAmounts string `index:"54"`
+}
+
+// Amount types of the additional amounts
+const (
+	AmountTypeLedgerBalance    = "01"
+	AmountTypeAvailableBalance = "02"
+)
+
+// AdditionalAmount is a single amount of the Additional Amounts field. Each
+// amount is encoded as 20 characters: account type (2), amount type (2),
+// currency (3), sign C or D (1) and amount (12).
+type AdditionalAmount struct {
+	AccountType string
+	AmountType  string
+	Currency    string
+	Amount      int64
+}
+
+const additionalAmountLength = 20
+
+// decodeAdditionalAmounts decodes amounts from the Additional Amounts field
+// value.
+func decodeAdditionalAmounts(value string) ([]AdditionalAmount, error) {
+	if len(value)%additionalAmountLength != 0 {
+		return nil, fmt.Errorf("invalid additional amounts length: %d", len(value))
+	}
+
+	var amounts []AdditionalAmount
+	for ; len(value) > 0; value = value[additionalAmountLength:] {
+		amount, err := strconv.ParseInt(value[8:20], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing additional amount: %w", err)
+		}
+
+		switch value[7] {
+		case 'C':
+		case 'D':
+			amount = -amount
+		default:
+			return nil, fmt.Errorf("invalid additional amount sign: %c", value[7])
+		}
+
+		amounts = append(amounts, AdditionalAmount{
+			AccountType: value[0:2],
+			AmountType:  value[2:4],
+			Currency:    value[4:7],
+			Amount:      amount,
+		})
+	}
+
+	return amounts, nil
+}
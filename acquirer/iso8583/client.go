@@ -1,10 +1,13 @@
 package iso8583
 
 import (
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/alovak/cardflow-playground/internal/iso8583mac"
 	"github.com/moov-io/iso8583"
 	iso8583Connection "github.com/moov-io/iso8583-connection"
 	"golang.org/x/exp/slog"
@@ -14,31 +17,87 @@ type Client struct {
 	iso8583Connection *iso8583Connection.Connection
 	logger            *slog.Logger
 	stanGenerator     STANGenerator
+	disputeHandler    DisputeHandler
+
+	// hsm encrypts PIN blocks under the zone PIN key
+	hsm            *hsm.HSM
+	pinBlockFormat hsm.PINBlockFormat
+
+	// macHSM generates MACs of the requests with the zone authentication
+	// key, the requests are not MACed when it's not set
+	macHSM       *hsm.HSM
+	macAlgorithm hsm.MACAlgorithm
+
+	// keyHSM exchanges the working keys with the issuer under the zone
+	// master key
+	keyHSM *hsm.HSM
 }
 
+// ZonePINKeyName is the name of the HSM key PIN blocks are encrypted under,
+// the key is shared with the issuer.
+const ZonePINKeyName = "zpk"
+
+// MACKeyName is the name of the HSM key the MACs of the requests are
+// generated with, the key is shared with the issuer.
+const MACKeyName = "zak"
+
 type STANGenerator interface {
 	Next() string
 }
 
+// DisputeHandler is an interface that defines the logic of handling dispute
+// notifications received from the issuer.
+type DisputeHandler interface {
+	HandleDisputeNotification(notification models.DisputeNotification) error
+}
+
 func NewClient(logger *slog.Logger, iso8583ServerAddr string, stanGenerator STANGenerator) (*Client, error) {
 	logger = logger.With(slog.String("type", "iso8583-client"), slog.String("addr", iso8583ServerAddr))
 
+	c := &Client{
+		logger:        logger,
+		stanGenerator: stanGenerator,
+	}
+
 	conn, err := iso8583Connection.New(
 		iso8583ServerAddr,
 		spec,
 		readMessageLength,
 		writeMessageLength,
 		iso8583Connection.SendTimeout(5*time.Second),
+		// sign on when connection is established
+		iso8583Connection.OnConnect(c.signOn),
+		// handle messages initiated by the issuer
+		iso8583Connection.InboundMessageHandler(c.handleInboundMessage),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("creating iso8583 connection: %w", err)
 	}
 
-	return &Client{
-		iso8583Connection: conn,
-		logger:            logger,
-		stanGenerator:     stanGenerator,
-	}, nil
+	c.iso8583Connection = conn
+
+	return c, nil
+}
+
+// SetDisputeHandler sets the handler for dispute notifications received from
+// the issuer. It should be set before connecting to the server.
+func (c *Client) SetDisputeHandler(handler DisputeHandler) {
+	c.disputeHandler = handler
+}
+
+// SetPINEncryption sets the HSM with the zone PIN key named ZonePINKeyName
+// and the format of the PIN blocks. Payments with the PIN can't be
+// authorized until it's set.
+func (c *Client) SetPINEncryption(h *hsm.HSM, format hsm.PINBlockFormat) {
+	c.hsm = h
+	c.pinBlockFormat = format
+}
+
+// SetMAC sets the HSM with the zone authentication key named MACKeyName and
+// the algorithm the MACs of the requests are generated with.
+func (c *Client) SetMAC(h *hsm.HSM, algorithm hsm.MACAlgorithm) {
+	c.macHSM = h
+	c.macAlgorithm = algorithm
 }
 
 func (c *Client) Connect() error {
@@ -55,22 +114,110 @@ func (c *Client) Connect() error {
 func (c *Client) AuthorizePayment(payment *models.Payment, card models.Card, merchant models.Merchant) (models.AuthorizationResponse, error) {
 	c.logger.Info("authorizing payment", slog.String("payment_id", payment.ID))
 
+	stan := c.stanGenerator.Next()
+
 	requestMessage := iso8583.NewMessage(spec)
 	requestData := &AuthorizationRequest{
-		MTI:                   "0100",
-		PrimaryAccountNumber:  card.Number,
-		Amount:                payment.Amount,
-		Currency:              payment.Currency,
-		TransmissionDateTime:  payment.CreatedAt.UTC().Format(time.RFC3339),
-		STAN:                  c.stanGenerator.Next(),
-		CardVerificationValue: card.CardVerificationValue,
-		ExpirationDate:        card.ExpirationDate,
+		MTI:                      "0100",
+		PrimaryAccountNumber:     card.Number,
+		Amount:                   payment.Amount,
+		Currency:                 payment.Currency,
+		TransmissionDateTime:     payment.CreatedAt.UTC().Format(time.RFC3339),
+		STAN:                     stan,
+		RetrievalReferenceNumber: retrievalReferenceNumber(payment.CreatedAt, stan),
+		TerminalID:               payment.TerminalID,
+		CardVerificationValue:    card.CardVerificationValue,
+		ExpirationDate:           card.ExpirationDate,
+		AcceptorInformation: &AcceptorInformation{
+			Name:       merchant.Name,
+			MCC:        merchant.MCC,
+			PostalCode: merchant.PostalCode,
+			WebSite:    merchant.WebSite,
+		},
+	}
+
+	if payment.PartialApprovalSupported || payment.TokenRequestorID != "" || payment.StoredCredential != "" {
+		requestData.AdditionalData = &AdditionalData{
+			TokenRequestorID:          payment.TokenRequestorID,
+			StoredCredentialIndicator: string(payment.StoredCredential),
+		}
+
+		if payment.PartialApprovalSupported {
+			requestData.AdditionalData.PartialApprovalIndicator = PartialApprovalSupported
+		}
+	}
+
+	// the PIN is sent only as the PIN block encrypted under the zone PIN key
+	if card.PIN != "" {
+		if c.hsm == nil {
+			return models.AuthorizationResponse{}, fmt.Errorf("PIN encryption is not configured")
+		}
+
+		pinBlock, err := c.hsm.EncryptPINBlock(ZonePINKeyName, c.pinBlockFormat, card.PIN, card.Number)
+		if err != nil {
+			return models.AuthorizationResponse{}, fmt.Errorf("encrypting PIN block: %w", err)
+		}
+
+		requestData.PINData = hex.EncodeToString(pinBlock)
+		requestData.PINBlockFormat = fmt.Sprintf("%02d", c.pinBlockFormat)
+	}
+
+	err := requestMessage.Marshal(requestData)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	if err := c.generateMAC(requestMessage); err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	responseMessage, err := sendMessage(c.logger, c.iso8583Connection, requestMessage)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("sending ISO 8583 message to server: %w", err)
+	}
+
+	responseData := &AuthorizationResponse{}
+	err = responseMessage.Unmarshal(responseData)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	return models.AuthorizationResponse{
+		ApprovalCode:             responseData.ApprovalCode,
+		AuthorizationCode:        responseData.AuthorizationCode,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
+		ApprovedAmount:           responseData.ApprovedAmount,
+	}, nil
+}
+
+// IncrementAuthorization sends the incremental authorization of the payment
+// to the issuer. The request references the original authorization by its
+// retrieval reference number, the card details are not sent as the acquirer
+// doesn't keep them.
+func (c *Client) IncrementAuthorization(payment *models.Payment, increment *models.PaymentIncrement, merchant models.Merchant) (models.AuthorizationResponse, error) {
+	c.logger.Info("incrementing authorization", slog.String("payment_id", payment.ID))
+
+	stan := c.stanGenerator.Next()
+
+	requestMessage := iso8583.NewMessage(spec)
+	requestData := &AuthorizationRequest{
+		MTI:                      "0100",
+		Amount:                   increment.Amount,
+		Currency:                 payment.Currency,
+		TransmissionDateTime:     increment.CreatedAt.UTC().Format(time.RFC3339),
+		STAN:                     stan,
+		RetrievalReferenceNumber: retrievalReferenceNumber(increment.CreatedAt, stan),
+		TerminalID:               payment.TerminalID,
 		AcceptorInformation: &AcceptorInformation{
 			Name:       merchant.Name,
 			MCC:        merchant.MCC,
 			PostalCode: merchant.PostalCode,
 			WebSite:    merchant.WebSite,
 		},
+		OriginalData: &OriginalData{
+			RetrievalReferenceNumber: payment.RetrievalReferenceNumber,
+			AuthorizationCode:        payment.AuthorizationCode,
+		},
 	}
 
 	err := requestMessage.Marshal(requestData)
@@ -78,7 +225,11 @@ func (c *Client) AuthorizePayment(payment *models.Payment, card models.Card, mer
 		return models.AuthorizationResponse{}, fmt.Errorf("marshaling request data: %w", err)
 	}
 
-	responseMessage, err := c.iso8583Connection.Send(requestMessage)
+	if err := c.generateMAC(requestMessage); err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	responseMessage, err := sendMessage(c.logger, c.iso8583Connection, requestMessage)
 	if err != nil {
 		return models.AuthorizationResponse{}, fmt.Errorf("sending ISO 8583 message to server: %w", err)
 	}
@@ -90,7 +241,265 @@ func (c *Client) AuthorizePayment(payment *models.Payment, card models.Card, mer
 	}
 
 	return models.AuthorizationResponse{
-		ApprovalCode:      responseData.ApprovalCode,
-		AuthorizationCode: responseData.AuthorizationCode,
+		ApprovalCode:             responseData.ApprovalCode,
+		AuthorizationCode:        responseData.AuthorizationCode,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
 	}, nil
 }
+
+// InquireBalance sends the balance inquiry for the card to the issuer.
+func (c *Client) InquireBalance(card models.Card, terminalID string, merchant models.Merchant) (models.BalanceInquiry, error) {
+	c.logger.Info("inquiring balance")
+
+	stan := c.stanGenerator.Next()
+	now := time.Now()
+
+	requestMessage := iso8583.NewMessage(spec)
+	requestData := &BalanceInquiryRequest{
+		MTI:                      "0100",
+		PrimaryAccountNumber:     card.Number,
+		TransmissionDateTime:     now.UTC().Format(time.RFC3339),
+		STAN:                     stan,
+		ProcessingCode:           ProcessingCodeBalanceInquiry,
+		RetrievalReferenceNumber: retrievalReferenceNumber(now, stan),
+		TerminalID:               terminalID,
+		CardVerificationValue:    card.CardVerificationValue,
+		ExpirationDate:           card.ExpirationDate,
+		AcceptorInformation: &AcceptorInformation{
+			Name:       merchant.Name,
+			MCC:        merchant.MCC,
+			PostalCode: merchant.PostalCode,
+			WebSite:    merchant.WebSite,
+		},
+	}
+
+	err := requestMessage.Marshal(requestData)
+	if err != nil {
+		return models.BalanceInquiry{}, fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	if err := c.generateMAC(requestMessage); err != nil {
+		return models.BalanceInquiry{}, err
+	}
+
+	responseMessage, err := sendMessage(c.logger, c.iso8583Connection, requestMessage)
+	if err != nil {
+		return models.BalanceInquiry{}, fmt.Errorf("sending ISO 8583 message to server: %w", err)
+	}
+
+	responseData := &BalanceInquiryResponse{}
+	err = responseMessage.Unmarshal(responseData)
+	if err != nil {
+		return models.BalanceInquiry{}, fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	amounts, err := decodeAdditionalAmounts(responseData.AdditionalAmounts)
+	if err != nil {
+		return models.BalanceInquiry{}, fmt.Errorf("decoding additional amounts: %w", err)
+	}
+
+	inquiry := models.BalanceInquiry{
+		ApprovalCode:             responseData.ApprovalCode,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
+	}
+
+	for _, amount := range amounts {
+		inquiry.Currency = amount.Currency
+
+		switch amount.AmountType {
+		case AmountTypeAvailableBalance:
+			inquiry.AvailableBalance = amount.Amount
+		case AmountTypeLedgerBalance:
+			inquiry.LedgerBalance = amount.Amount
+		}
+	}
+
+	return inquiry, nil
+}
+
+// NotifyDispute sends the dispute notification to the issuer.
+func (c *Client) NotifyDispute(notification models.DisputeNotification) error {
+	c.logger.Info("sending dispute advice", slog.String("dispute_id", notification.DisputeID), slog.String("stage", string(notification.Stage)))
+
+	requestMessage := iso8583.NewMessage(spec)
+	requestData := &DisputeAdvice{
+		MTI:                      "0422",
+		Amount:                   notification.Amount,
+		Currency:                 notification.Currency,
+		TransmissionDateTime:     time.Now().UTC().Format(time.RFC3339),
+		STAN:                     c.stanGenerator.Next(),
+		RetrievalReferenceNumber: notification.RetrievalReferenceNumber,
+		DisputeInformation: &DisputeInformation{
+			DisputeID:  notification.DisputeID,
+			ReasonCode: notification.ReasonCode,
+			Stage:      string(notification.Stage),
+			Message:    notification.Message,
+		},
+	}
+
+	err := requestMessage.Marshal(requestData)
+	if err != nil {
+		return fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	if err := c.generateMAC(requestMessage); err != nil {
+		return err
+	}
+
+	responseMessage, err := sendMessage(c.logger, c.iso8583Connection, requestMessage)
+	if err != nil {
+		return fmt.Errorf("sending ISO 8583 message to server: %w", err)
+	}
+
+	responseData := &DisputeAdviceResponse{}
+	err = responseMessage.Unmarshal(responseData)
+	if err != nil {
+		return fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	if responseData.ApprovalCode != "00" {
+		return fmt.Errorf("dispute advice declined by issuer with code: %s", responseData.ApprovalCode)
+	}
+
+	return nil
+}
+
+// signOn sends the sign on network management request to the issuer.
+func (c *Client) signOn(conn *iso8583Connection.Connection) error {
+	c.logger.Info("signing on...")
+
+	requestMessage := iso8583.NewMessage(spec)
+	requestData := &NetworkManagementRequest{
+		MTI:                   "0800",
+		TransmissionDateTime:  time.Now().UTC().Format(time.RFC3339),
+		STAN:                  c.stanGenerator.Next(),
+		NetworkManagementCode: NetworkManagementCodeSignOn,
+	}
+
+	err := requestMessage.Marshal(requestData)
+	if err != nil {
+		return fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	if err := c.generateMAC(requestMessage); err != nil {
+		return err
+	}
+
+	responseMessage, err := sendMessage(c.logger, conn, requestMessage)
+	if err != nil {
+		return fmt.Errorf("sending sign on request: %w", err)
+	}
+
+	responseData := &NetworkManagementResponse{}
+	err = responseMessage.Unmarshal(responseData)
+	if err != nil {
+		return fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	if responseData.ApprovalCode != "00" {
+		return fmt.Errorf("sign on declined with code: %s", responseData.ApprovalCode)
+	}
+
+	c.logger.Info("signed on")
+
+	if c.keyHSM != nil {
+		if err := c.requestKeys(conn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleInboundMessage is called when a message initiated by the issuer is
+// received.
+func (c *Client) handleInboundMessage(conn *iso8583Connection.Connection, message *iso8583.Message) {
+	mti, err := message.GetMTI()
+	if err != nil {
+		c.logger.Error("failed to get MTI from message", "err", err)
+	}
+
+	logger := c.logger.With(slog.String("mti", mti))
+
+	dumpMessage(logger, "received", message)
+
+	switch mti {
+	case "0422":
+		err = c.handleDisputeAdvice(conn, message)
+	case "0800":
+		err = c.handleNetworkManagementRequest(conn, message)
+	default:
+		err = fmt.Errorf("unknown MTI: %s", mti)
+	}
+
+	if err != nil {
+		logger.Error("failed to handle inbound message", "err", err)
+	}
+}
+
+// handleDisputeAdvice handles dispute notifications sent by the issuer.
+func (c *Client) handleDisputeAdvice(conn *iso8583Connection.Connection, message *iso8583.Message) error {
+	requestData := &DisputeAdvice{}
+	if err := message.Unmarshal(requestData); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+
+	if requestData.DisputeInformation == nil {
+		requestData.DisputeInformation = &DisputeInformation{}
+	}
+
+	c.logger.With(
+		slog.String("stan", requestData.STAN),
+		slog.String("dispute_id", requestData.DisputeInformation.DisputeID),
+		slog.String("stage", requestData.DisputeInformation.Stage),
+	).Info("handling dispute advice")
+
+	responseData := &DisputeAdviceResponse{
+		MTI:          "0432",
+		STAN:         requestData.STAN,
+		ApprovalCode: "00",
+	}
+
+	err := c.disputeHandler.HandleDisputeNotification(models.DisputeNotification{
+		DisputeID:                requestData.DisputeInformation.DisputeID,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
+		Stage:                    models.DisputeStage(requestData.DisputeInformation.Stage),
+		ReasonCode:               requestData.DisputeInformation.ReasonCode,
+		Amount:                   requestData.Amount,
+		Currency:                 requestData.Currency,
+		Message:                  requestData.DisputeInformation.Message,
+	})
+	if err != nil {
+		c.logger.Error("failed to handle dispute advice", "err", err)
+		responseData.ApprovalCode = "05"
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+
+	if err := replyMessage(c.logger, conn, responseMessage); err != nil {
+		return fmt.Errorf("sending response: %w", err)
+	}
+
+	return nil
+}
+
+// generateMAC generates the MAC of the request message if MACs are
+// configured.
+func (c *Client) generateMAC(message *iso8583.Message) error {
+	if c.macHSM == nil {
+		return nil
+	}
+
+	return iso8583mac.Generate(c.macHSM, MACKeyName, c.macAlgorithm, message)
+}
+
+// retrievalReferenceNumber builds the RRN in the YDDDhhnnnnnn format: last
+// digit of the year, day of the year, hour and STAN.
+func retrievalReferenceNumber(t time.Time, stan string) string {
+	t = t.UTC()
+
+	return fmt.Sprintf("%d%03d%02d%s", t.Year()%10, t.YearDay(), t.Hour(), stan)
+}
@@ -0,0 +1,27 @@
+package iso8583
+
+// DisputeAdvice is exchanged in both directions during the dispute lifecycle:
+// the issuer sends chargebacks and pre-arbitrations, the acquirer sends
+// representments and responses to pre-arbitrations.
+type DisputeAdvice struct {
+	MTI                      string              `index:"0"`
+	Amount                   int64               `index:"3"`
+	TransmissionDateTime     string              `index:"4"`
+	Currency                 string              `index:"7"`
+	STAN                     string              `index:"11"`
+	RetrievalReferenceNumber string              `index:"37"`
+	DisputeInformation       *DisputeInformation `index:"48"`
+}
+
+type DisputeAdviceResponse struct {
+	MTI          string `index:"0"`
+	ApprovalCode string `index:"5"`
+	STAN         string `index:"11"`
+}
+
+type DisputeInformation struct {
+	DisputeID  string `index:"01"`
+	ReasonCode string `index:"02"`
+	Stage      string `index:"03"`
+	Message    string `index:"04"`
+}
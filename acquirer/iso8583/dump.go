@@ -0,0 +1,35 @@
+package iso8583
+
+import (
+	"github.com/alovak/cardflow-playground/log"
+	"github.com/moov-io/iso8583"
+	iso8583Connection "github.com/moov-io/iso8583-connection"
+	"golang.org/x/exp/slog"
+)
+
+// dumpMessage logs the redacted dump of the sent or received message at the
+// debug level.
+func dumpMessage(logger *slog.Logger, direction string, message *iso8583.Message) {
+	logger.Debug(direction+" message", log.ISO8583Message("message", message))
+}
+
+// sendMessage sends the request and dumps it with its response.
+func sendMessage(logger *slog.Logger, conn *iso8583Connection.Connection, message *iso8583.Message) (*iso8583.Message, error) {
+	dumpMessage(logger, "sending", message)
+
+	response, err := conn.Send(message)
+	if err != nil {
+		return nil, err
+	}
+
+	dumpMessage(logger, "received", response)
+
+	return response, nil
+}
+
+// replyMessage dumps the response and sends it.
+func replyMessage(logger *slog.Logger, conn *iso8583Connection.Connection, message *iso8583.Message) error {
+	dumpMessage(logger, "sending", message)
+
+	return conn.Reply(message)
+}
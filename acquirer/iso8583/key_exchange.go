@@ -0,0 +1,153 @@
+package iso8583
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/moov-io/iso8583"
+	iso8583Connection "github.com/moov-io/iso8583-connection"
+	"golang.org/x/exp/slog"
+)
+
+// ZoneMasterKeyName is the name of the HSM key the working keys are
+// exchanged under, the key is shared with the issuer.
+const ZoneMasterKeyName = "zmk"
+
+// SetKeyExchange sets the HSM with the zone master key named
+// ZoneMasterKeyName the working keys are exchanged under. Once it's set, the
+// client requests the new working keys when it signs on and accepts the
+// keys changed by the issuer. It should be set before connecting to the
+// server.
+func (c *Client) SetKeyExchange(h *hsm.HSM) {
+	c.keyHSM = h
+}
+
+// requestKeys requests the new zone PIN key and, if MACs are configured, the
+// new zone authentication key from the issuer.
+func (c *Client) requestKeys(conn *iso8583Connection.Connection) error {
+	keyTypes := []hsm.KeyType{hsm.KeyTypeZPK}
+	if c.macHSM != nil {
+		keyTypes = append(keyTypes, hsm.KeyTypeZAK)
+	}
+
+	for _, keyType := range keyTypes {
+		if err := c.requestKey(conn, keyType); err != nil {
+			return fmt.Errorf("requesting %s: %w", keyType, err)
+		}
+	}
+
+	return nil
+}
+
+// requestKey requests the new working key of the type from the issuer and
+// imports it.
+func (c *Client) requestKey(conn *iso8583Connection.Connection, keyType hsm.KeyType) error {
+	requestMessage := iso8583.NewMessage(spec)
+	requestData := &NetworkManagementRequest{
+		MTI:                   "0800",
+		TransmissionDateTime:  time.Now().UTC().Format(time.RFC3339),
+		STAN:                  c.stanGenerator.Next(),
+		NetworkManagementCode: NetworkManagementCodeKeyRequest,
+		KeyExchangeData: &KeyExchangeData{
+			KeyType: string(keyType),
+		},
+	}
+
+	err := requestMessage.Marshal(requestData)
+	if err != nil {
+		return fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	if err := c.generateMAC(requestMessage); err != nil {
+		return err
+	}
+
+	responseMessage, err := sendMessage(c.logger, conn, requestMessage)
+	if err != nil {
+		return fmt.Errorf("sending key request: %w", err)
+	}
+
+	responseData := &NetworkManagementResponse{}
+	err = responseMessage.Unmarshal(responseData)
+	if err != nil {
+		return fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	if responseData.ApprovalCode != "00" {
+		return fmt.Errorf("key request declined with code: %s", responseData.ApprovalCode)
+	}
+
+	return c.importKey(responseData.KeyExchangeData)
+}
+
+// importKey imports the working key encrypted under the zone master key as
+// the new version of the key.
+func (c *Client) importKey(keyData *KeyExchangeData) error {
+	if keyData == nil {
+		return errors.New("key exchange data is not set")
+	}
+
+	var name string
+	switch keyType := hsm.KeyType(keyData.KeyType); keyType {
+	case hsm.KeyTypeZPK:
+		name = ZonePINKeyName
+	case hsm.KeyTypeZAK:
+		name = MACKeyName
+	default:
+		return fmt.Errorf("unsupported key type %q", keyType)
+	}
+
+	encrypted, err := hex.DecodeString(keyData.EncryptedKey)
+	if err != nil {
+		return fmt.Errorf("decoding encrypted key: %w", err)
+	}
+
+	info, err := c.keyHSM.ImportEncryptedKey(name, hsm.KeyType(keyData.KeyType), ZoneMasterKeyName, encrypted, keyData.CheckValue)
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", name, err)
+	}
+
+	c.logger.With(
+		slog.String("key_type", keyData.KeyType),
+		slog.Int("version", info.Version),
+		slog.String("check_value", info.CheckValue),
+	).Info("working key changed")
+
+	return nil
+}
+
+// handleNetworkManagementRequest handles the key changes sent by the issuer.
+func (c *Client) handleNetworkManagementRequest(conn *iso8583Connection.Connection, message *iso8583.Message) error {
+	requestData := &NetworkManagementRequest{}
+	if err := message.Unmarshal(requestData); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+
+	responseData := &NetworkManagementResponse{
+		MTI:                   "0810",
+		STAN:                  requestData.STAN,
+		NetworkManagementCode: requestData.NetworkManagementCode,
+		ApprovalCode:          "00",
+	}
+
+	if requestData.NetworkManagementCode != NetworkManagementCodeKeyChange || c.keyHSM == nil {
+		responseData.ApprovalCode = "12"
+	} else if err := c.importKey(requestData.KeyExchangeData); err != nil {
+		c.logger.Error("failed to change key", "err", err)
+		responseData.ApprovalCode = "05"
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+
+	if err := replyMessage(c.logger, conn, responseMessage); err != nil {
+		return fmt.Errorf("sending response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package iso8583
+
+// NetworkManagementCodeSignOn is sent by the acquirer when it connects to the
+// issuer, so the issuer knows which connection to use for the messages it
+// initiates (e.g. dispute notifications).
+const NetworkManagementCodeSignOn = "001"
+
+const (
+	// NetworkManagementCodeKeyChange is sent by the issuer with the new
+	// working key in the key exchange data
+	NetworkManagementCodeKeyChange = "101"
+	// NetworkManagementCodeKeyRequest is sent by the acquirer to request
+	// the new working key of the type in the key exchange data, the key is
+	// returned in the response
+	NetworkManagementCodeKeyRequest = "161"
+)
+
+type NetworkManagementRequest struct {
+	MTI                   string           `index:"0"`
+	TransmissionDateTime  string           `index:"4"`
+	STAN                  string           `index:"11"`
+	NetworkManagementCode string           `index:"70"`
+	KeyExchangeData       *KeyExchangeData `index:"96"`
+}
+
+type NetworkManagementResponse struct {
+	MTI                   string           `index:"0"`
+	ApprovalCode          string           `index:"5"`
+	STAN                  string           `index:"11"`
+	NetworkManagementCode string           `index:"70"`
+	KeyExchangeData       *KeyExchangeData `index:"96"`
+}
+
+// KeyExchangeData carries the working key, e.g. the zone PIN key or the
+// zone authentication key, encrypted under the zone master key shared by
+// the issuer and the acquirer.
+type KeyExchangeData struct {
+	KeyType string `index:"01"`
+	// EncryptedKey is the hex encoded key encrypted under the zone master
+	// key
+	EncryptedKey string `index:"02"`
+	// CheckValue is the key check value (KCV) of the clear key
+	CheckValue string `index:"03"`
+}
@@ -0,0 +1,49 @@
+package iso8583
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	iso8583Connection "github.com/moov-io/iso8583-connection"
+)
+
+// TLSConfig configures TLS of the connection to the issuer.
+type TLSConfig struct {
+	// CAFile is the PEM file with the CA certificates the certificate of
+	// the issuer is verified with. The system CA pool is used if it's
+	// empty.
+	CAFile string
+	// CertFile and KeyFile are the PEM files with the client certificate
+	// the acquirer authenticates with (mutual TLS) and its key
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13. TLS 1.2
+	// is used if it's not set.
+	MinVersion uint16
+}
+
+// SetTLS configures the client to connect to the issuer over TLS. It should
+// be set before connecting to the server.
+func (c *Client) SetTLS(config TLSConfig) error {
+	options := []iso8583Connection.Option{
+		iso8583Connection.SetTLSConfig(func(tlsConfig *tls.Config) {
+			if config.MinVersion != 0 {
+				tlsConfig.MinVersion = config.MinVersion
+			}
+		}),
+	}
+
+	if config.CAFile != "" {
+		options = append(options, iso8583Connection.RootCAs(config.CAFile))
+	}
+
+	if config.CertFile != "" {
+		options = append(options, iso8583Connection.ClientCert(config.CertFile, config.KeyFile))
+	}
+
+	if err := c.iso8583Connection.SetOptions(options...); err != nil {
+		return fmt.Errorf("setting TLS options: %w", err)
+	}
+
+	return nil
+}
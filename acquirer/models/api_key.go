@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type APIKeyStatus string
+
+const (
+	// active key authenticates the requests of the merchant
+	APIKeyStatusActive APIKeyStatus = "active"
+	// revoked key is rejected, it can't be activated again
+	APIKeyStatusRevoked APIKeyStatus = "revoked"
+)
+
+// APIKey authenticates the requests of the merchant to the routes of the
+// merchant. Only the hash of the key is stored.
+type APIKey struct {
+	ID         string
+	MerchantID string
+	// Key is shown only in the response of the key issuing
+	Key string
+	// Last4 are the last characters of the key to tell the keys apart
+	Last4     string
+	Status    APIKeyStatus
+	CreatedAt time.Time
+	RevokedAt time.Time
+}
@@ -1,6 +1,10 @@
 package models
 
 type AuthorizationResponse struct {
-	ApprovalCode      string
-	AuthorizationCode string
+	ApprovalCode             string
+	AuthorizationCode        string
+	RetrievalReferenceNumber string
+	// ApprovedAmount is less than the requested amount when the payment is
+	// partially approved
+	ApprovedAmount int64
 }
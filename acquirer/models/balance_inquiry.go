@@ -0,0 +1,18 @@
+package models
+
+type CreateBalanceInquiry struct {
+	Card Card
+	// TerminalID is optional, it identifies the merchant's terminal (e.g.
+	// ATM) the inquiry is made with
+	TerminalID string
+}
+
+// BalanceInquiry is the result of the balance inquiry. Balances are returned
+// only when the inquiry is approved.
+type BalanceInquiry struct {
+	ApprovalCode             string
+	AvailableBalance         int64
+	LedgerBalance            int64
+	Currency                 string
+	RetrievalReferenceNumber string
+}
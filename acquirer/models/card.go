@@ -4,6 +4,9 @@ type Card struct {
 	Number                string
 	ExpirationDate        string
 	CardVerificationValue string
+	// PIN entered by the cardholder, it's never stored and is sent to the
+	// issuer encrypted in the PIN block
+	PIN string
 }
 
 type SafeCard struct {
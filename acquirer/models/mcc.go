@@ -0,0 +1,71 @@
+package models
+
+import "strconv"
+
+// MerchantCategoryCodes lists the merchant category codes (MCC) known to the
+// acquirer. Codes assigned to specific airlines, car rental companies and
+// hotel chains are not listed, they are recognized by their ranges.
+var MerchantCategoryCodes = map[string]string{
+	"4111": "Commuter transport",
+	"4121": "Taxicabs and limousines",
+	"4511": "Airlines",
+	"4722": "Travel agencies",
+	"4814": "Telecommunication services",
+	"4900": "Utilities",
+	"5045": "Computers and peripherals",
+	"5311": "Department stores",
+	"5411": "Grocery stores and supermarkets",
+	"5499": "Miscellaneous food stores",
+	"5541": "Service stations",
+	"5542": "Automated fuel dispensers",
+	"5651": "Family clothing stores",
+	"5732": "Electronics stores",
+	"5734": "Computer software stores",
+	"5812": "Eating places and restaurants",
+	"5813": "Bars and taverns",
+	"5814": "Fast food restaurants",
+	"5817": "Digital goods - applications",
+	"5912": "Drug stores and pharmacies",
+	"5942": "Book stores",
+	"5945": "Toy and game shops",
+	"5968": "Direct marketing - continuity and subscription",
+	"5999": "Miscellaneous retail",
+	"6011": "Automated cash disbursements",
+	"7011": "Hotels and motels",
+	"7512": "Car rental agencies",
+	"7523": "Parking lots and garages",
+	"7832": "Motion picture theaters",
+	"7997": "Clubs and memberships",
+	"8011": "Doctors",
+	"8062": "Hospitals",
+	"8999": "Professional services",
+}
+
+// mccRanges lists the ranges of codes assigned to specific airlines, car
+// rental companies and hotel chains.
+var mccRanges = [][2]int{
+	{3000, 3299}, // airlines
+	{3351, 3441}, // car rental agencies
+	{3501, 3999}, // hotels and motels
+}
+
+// IsKnownMCC returns true if the merchant category code is known to the
+// acquirer.
+func IsKnownMCC(mcc string) bool {
+	if _, ok := MerchantCategoryCodes[mcc]; ok {
+		return true
+	}
+
+	code, err := strconv.Atoi(mcc)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range mccRanges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+
+	return false
+}
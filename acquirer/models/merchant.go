@@ -1,5 +1,30 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrValidation            = errors.New("validation failed")
+	ErrInvalidMerchantStatus = errors.New("invalid merchant status")
+	ErrMerchantNotActive     = errors.New("merchant is not active")
+	ErrInvalidAPIKeyStatus   = errors.New("invalid API key status")
+)
+
+const (
+	maxMerchantNameLength    = 99
+	maxMerchantWebSiteLength = 299
+)
+
+var (
+	mccPattern        = regexp.MustCompile(`^[0-9]{4}$`)
+	postalCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,8}[A-Za-z0-9]$`)
+)
+
 type CreateMerchant struct {
 	Name       string
 	MCC        string // Merchant Category Code
@@ -7,10 +32,98 @@ type CreateMerchant struct {
 	WebSite    string
 }
 
+// Validate returns an error wrapping ErrValidation if the merchant details
+// are not valid.
+func (c CreateMerchant) Validate() error {
+	if c.Name == "" || len(c.Name) > maxMerchantNameLength {
+		return fmt.Errorf("%w: name is required and must be at most %d characters", ErrValidation, maxMerchantNameLength)
+	}
+
+	if !mccPattern.MatchString(c.MCC) {
+		return fmt.Errorf("%w: MCC must be 4 digits", ErrValidation)
+	}
+
+	if !IsKnownMCC(c.MCC) {
+		return fmt.Errorf("%w: unknown MCC %s", ErrValidation, c.MCC)
+	}
+
+	if !postalCodePattern.MatchString(c.PostalCode) {
+		return fmt.Errorf("%w: postal code must be 3 to 10 letters, digits, spaces or hyphens", ErrValidation)
+	}
+
+	// web site is optional for merchants with physical stores only
+	if c.WebSite != "" {
+		if len(c.WebSite) > maxMerchantWebSiteLength {
+			return fmt.Errorf("%w: web site must be at most %d characters", ErrValidation, maxMerchantWebSiteLength)
+		}
+
+		u, err := url.ParseRequestURI(c.WebSite)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: web site must be a valid http(s) URL", ErrValidation)
+		}
+	}
+
+	return nil
+}
+
+// UpdateMerchant replaces the merchant details. It's validated the same way
+// as CreateMerchant.
+type UpdateMerchant CreateMerchant
+
+type UpdateMerchantStatus struct {
+	Status MerchantStatus
+}
+
+type MerchantStatus string
+
+const (
+	// merchant can accept payments
+	MerchantStatusActive MerchantStatus = "active"
+	// merchant temporarily can't accept payments
+	MerchantStatusSuspended MerchantStatus = "suspended"
+	// merchant relationship is terminated, it can't be reopened
+	MerchantStatusClosed MerchantStatus = "closed"
+)
+
+// merchantTransitions defines which statuses the merchant can move to from
+// its current status.
+var merchantTransitions = map[MerchantStatus][]MerchantStatus{
+	MerchantStatusActive:    {MerchantStatusSuspended, MerchantStatusClosed},
+	MerchantStatusSuspended: {MerchantStatusActive, MerchantStatusClosed},
+}
+
 type Merchant struct {
 	ID         string
 	Name       string
 	MCC        string // Merchant Category Code
 	PostalCode string
 	WebSite    string
+	Status     MerchantStatus
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+
+	// APIKey is the first API key of the merchant, it's shown only in the
+	// response of the merchant creation
+	APIKey string
+
+	// DisputedAmount is withheld from the merchant while the disputes are
+	// open
+	DisputedAmount int64
+	// ChargedBackAmount is the total amount of disputes lost by the merchant
+	ChargedBackAmount int64
+}
+
+// Transition moves the merchant to the given status if it's allowed from the
+// current one.
+func (m *Merchant) Transition(status MerchantStatus) error {
+	for _, allowed := range merchantTransitions[m.Status] {
+		if allowed == status {
+			m.Status = status
+			m.UpdatedAt = time.Now()
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: can't move merchant from %s to %s", ErrInvalidMerchantStatus, m.Status, status)
 }
@@ -1,11 +1,70 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrPaymentNotIncrementable = errors.New("payment can't be incremented")
 
 type CreatePayment struct {
 	Amount   int64
 	Currency string
-	Card     Card
+	// Card or PaymentMethodID of the card stored in the merchant's vault
+	// is set. The CVV of the stored card is entered by the customer for
+	// the customer initiated payments.
+	Card            Card
+	PaymentMethodID string
+	// StoredCredential tells who initiated the payment with the stored
+	// card, customer initiated payment is assumed when it's empty
+	StoredCredential StoredCredential
+	// TerminalID is optional, it identifies the merchant's terminal the
+	// payment is made with
+	TerminalID string
+	// PartialApprovalSupported is set when the merchant accepts approval of
+	// the amount less than requested, e.g. the rest is paid with another
+	// card
+	PartialApprovalSupported bool
+	// TokenRequestorID is set when the card number is the token provisioned
+	// for the wallet or the merchant
+	TokenRequestorID string
+}
+
+// Validate returns an error wrapping ErrValidation if neither or both the
+// card and the payment method are set.
+func (c CreatePayment) Validate() error {
+	if c.PaymentMethodID == "" {
+		if !cardNumberPattern.MatchString(c.Card.Number) {
+			return fmt.Errorf("%w: card number must be 12 to 19 digits", ErrValidation)
+		}
+
+		if c.StoredCredential != "" {
+			return fmt.Errorf("%w: stored credential is set for payments with payment method only", ErrValidation)
+		}
+
+		if c.Card.PIN != "" && !pinPattern.MatchString(c.Card.PIN) {
+			return fmt.Errorf("%w: PIN must be 4 to 12 digits", ErrValidation)
+		}
+
+		return nil
+	}
+
+	if c.Card.Number != "" || c.Card.ExpirationDate != "" || c.Card.PIN != "" {
+		return fmt.Errorf("%w: card and payment method can't be used together", ErrValidation)
+	}
+
+	if c.StoredCredential != "" && !c.StoredCredential.IsValid() {
+		return fmt.Errorf("%w: unknown stored credential %q", ErrValidation, c.StoredCredential)
+	}
+
+	return nil
+}
+
+// IncrementPayment is an incremental authorization of the authorized payment,
+// e.g. when the hotel guest extends the stay.
+type IncrementPayment struct {
+	Amount int64
 }
 
 type PaymentStatus string
@@ -18,12 +77,86 @@ const (
 )
 
 type Payment struct {
-	ID                string
-	MerchantID        string
-	Amount            int64
+	ID         string
+	MerchantID string
+	TerminalID string
+	// Amount is the approved amount, it's less than the RequestedAmount
+	// when the payment was partially approved
+	Amount                   int64
+	RequestedAmount          int64
+	PartialApprovalSupported bool
+	TokenRequestorID         string
+	PaymentMethodID          string
+	StoredCredential         StoredCredential
+	Currency                 string
+	Card                     SafeCard
+	Status                   PaymentStatus
+	CreatedAt                time.Time
+	AuthorizationCode        string
+	// ApprovalCode is the issuer's response code, e.g. "51" when the
+	// payment was declined for insufficient funds
+	ApprovalCode             string
+	RetrievalReferenceNumber string
+	Dispute                  *Dispute
+	// Increments are incremental authorizations of the payment. Amount of
+	// the payment includes the authorized increments.
+	Increments []PaymentIncrement
+}
+
+type PaymentIncrement struct {
+	Amount                   int64
+	Status                   PaymentStatus
+	ApprovalCode             string
+	RetrievalReferenceNumber string
+	CreatedAt                time.Time
+}
+
+// PaymentFilter defines the criteria for listing merchant's payments. Zero
+// values are ignored.
+type PaymentFilter struct {
+	Status PaymentStatus
+	// CreatedFrom is inclusive, CreatedTo is exclusive
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// AmountMin and AmountMax are inclusive
+	AmountMin         int64
+	AmountMax         int64
+	CardFirst6        string
+	CardLast4         string
 	Currency          string
-	Card              SafeCard
-	Status            PaymentStatus
-	CreatedAt         time.Time
 	AuthorizationCode string
+
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+}
+
+// Match returns true if the payment matches the filter. Date range and cursor
+// are not checked here as they are handled by the repository index.
+func (f PaymentFilter) Match(payment *Payment) bool {
+	switch {
+	case f.Status != "" && payment.Status != f.Status:
+		return false
+	case f.AmountMin != 0 && payment.Amount < f.AmountMin:
+		return false
+	case f.AmountMax != 0 && payment.Amount > f.AmountMax:
+		return false
+	case f.CardFirst6 != "" && payment.Card.First6 != f.CardFirst6:
+		return false
+	case f.CardLast4 != "" && payment.Card.Last4 != f.CardLast4:
+		return false
+	case f.Currency != "" && payment.Currency != f.Currency:
+		return false
+	case f.AuthorizationCode != "" && payment.AuthorizationCode != f.AuthorizationCode:
+		return false
+	}
+
+	return true
+}
+
+// PaymentList is a page of payments sorted by creation time, newest first.
+// NextCursor is empty when there are no more payments.
+type PaymentList struct {
+	Payments   []*Payment
+	NextCursor string
 }
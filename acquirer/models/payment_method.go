@@ -0,0 +1,68 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	cardNumberPattern     = regexp.MustCompile(`^[0-9]{12,19}$`)
+	expirationDatePattern = regexp.MustCompile(`^(0[1-9]|1[0-2])[0-9]{2}$`)
+	pinPattern            = regexp.MustCompile(`^[0-9]{4,12}$`)
+)
+
+// CreatePaymentMethod stores the card of the merchant's customer for
+// card-on-file and recurring payments. The CVV is never stored.
+type CreatePaymentMethod struct {
+	Card Card
+}
+
+// Validate returns an error wrapping ErrValidation if the card can't be
+// stored.
+func (c CreatePaymentMethod) Validate() error {
+	if !cardNumberPattern.MatchString(c.Card.Number) {
+		return fmt.Errorf("%w: card number must be 12 to 19 digits", ErrValidation)
+	}
+
+	if !expirationDatePattern.MatchString(c.Card.ExpirationDate) {
+		return fmt.Errorf("%w: expiration date must be in MMYY format", ErrValidation)
+	}
+
+	return nil
+}
+
+// PaymentMethod is the card stored in the vault of the merchant. The card
+// number is kept encrypted, only the safe card details are exposed.
+type PaymentMethod struct {
+	ID         string
+	MerchantID string
+	Card       SafeCard
+	CreatedAt  time.Time
+}
+
+// StoredCredential indicates that the payment is made with the stored
+// card and who initiated it.
+type StoredCredential string
+
+const (
+	// customer made the payment with the card stored earlier
+	StoredCredentialCustomerInitiated StoredCredential = "C"
+	// merchant made the payment without the customer, e.g. an unscheduled
+	// top-up
+	StoredCredentialMerchantInitiated StoredCredential = "M"
+	// merchant made the payment of the recurring schedule
+	StoredCredentialRecurring StoredCredential = "R"
+)
+
+// IsValid returns true if the indicator is one of the known ones.
+func (s StoredCredential) IsValid() bool {
+	switch s {
+	case StoredCredentialCustomerInitiated,
+		StoredCredentialMerchantInitiated,
+		StoredCredentialRecurring:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrInvalidSubscriptionStatus = errors.New("invalid subscription status")
+
+// PlanInterval is how often subscriptions of the plan are charged.
+type PlanInterval string
+
+const (
+	PlanIntervalDay   PlanInterval = "day"
+	PlanIntervalWeek  PlanInterval = "week"
+	PlanIntervalMonth PlanInterval = "month"
+	PlanIntervalYear  PlanInterval = "year"
+)
+
+// Next returns the start of the billing period following the one started
+// at the given time.
+func (i PlanInterval) Next(t time.Time) time.Time {
+	switch i {
+	case PlanIntervalDay:
+		return t.AddDate(0, 0, 1)
+	case PlanIntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case PlanIntervalMonth:
+		return t.AddDate(0, 1, 0)
+	case PlanIntervalYear:
+		return t.AddDate(1, 0, 0)
+	}
+
+	panic(fmt.Sprintf("unknown plan interval %q", i))
+}
+
+type CreatePlan struct {
+	Name     string
+	Amount   int64
+	Currency string
+	Interval PlanInterval
+}
+
+// Validate returns an error wrapping ErrValidation if the plan details are
+// not valid.
+func (c CreatePlan) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrValidation)
+	}
+
+	if c.Amount <= 0 {
+		return fmt.Errorf("%w: amount must be positive", ErrValidation)
+	}
+
+	if len(c.Currency) != 3 {
+		return fmt.Errorf("%w: currency must be 3 letters", ErrValidation)
+	}
+
+	switch c.Interval {
+	case PlanIntervalDay, PlanIntervalWeek, PlanIntervalMonth, PlanIntervalYear:
+	default:
+		return fmt.Errorf("%w: unknown interval %q", ErrValidation, c.Interval)
+	}
+
+	return nil
+}
+
+// Plan defines the amount the merchant charges its subscribers every
+// interval.
+type Plan struct {
+	ID         string
+	MerchantID string
+	Name       string
+	Amount     int64
+	Currency   string
+	Interval   PlanInterval
+	CreatedAt  time.Time
+}
+
+type CreateSubscription struct {
+	PlanID          string
+	PaymentMethodID string
+	// StartAt is when the first payment is made, it's made right away
+	// if it's not set
+	StartAt time.Time
+}
+
+type SubscriptionStatus string
+
+const (
+	// subscription is paid up
+	SubscriptionStatusActive SubscriptionStatus = "active"
+	// payment of the subscription was declined and is retried
+	SubscriptionStatusPastDue SubscriptionStatus = "past_due"
+	// subscription was canceled by the merchant
+	SubscriptionStatusCanceled SubscriptionStatus = "canceled"
+	// payments were stopped as the card can't be charged or the retries
+	// are exhausted
+	SubscriptionStatusFailed SubscriptionStatus = "failed"
+)
+
+// Subscription charges the stored card of the customer according to the
+// plan.
+type Subscription struct {
+	ID              string
+	MerchantID      string
+	PlanID          string
+	PaymentMethodID string
+	Status          SubscriptionStatus
+	// NextBillingAt is the start of the billing period to be paid next
+	NextBillingAt time.Time
+	// NextAttemptAt is when the next payment is made. It's NextBillingAt
+	// unless the payment of the period is retried.
+	NextAttemptAt time.Time
+	// Retries is the number of retried payments of the current period
+	Retries int
+	// PaymentIDs are IDs of the subscription's payments including the
+	// declined ones
+	PaymentIDs []string
+	// LastApprovalCode is the approval code of the last payment
+	LastApprovalCode string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// IsBillable returns true if the subscription is charged by the scheduler.
+func (s *Subscription) IsBillable() bool {
+	return s.Status == SubscriptionStatusActive || s.Status == SubscriptionStatusPastDue
+}
+
+// DunningPolicy defines how the declined payments of subscriptions are
+// retried.
+type DunningPolicy struct {
+	// RetryInterval is the time between retries
+	RetryInterval time.Duration
+	// MaxRetries is the number of retries after which the subscription
+	// fails
+	MaxRetries int
+}
+
+// DefaultDunningPolicy retries the declined payment every day, three times.
+func DefaultDunningPolicy() DunningPolicy {
+	return DunningPolicy{
+		RetryInterval: 24 * time.Hour,
+		MaxRetries:    3,
+	}
+}
+
+// IsRetryableDecline returns true if the declined payment may be approved
+// later, e.g. when the customer tops up the account. Declines of invalid,
+// expired or restricted cards are not retried.
+func IsRetryableDecline(approvalCode string) bool {
+	switch approvalCode {
+	case "14", "54", "57", "62":
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrTerminalNotActive = errors.New("terminal is not active")
+
+type CreateTerminal struct {
+	// Description helps the merchant to identify the terminal, e.g. its
+	// location
+	Description string
+}
+
+type TerminalStatus string
+
+const (
+	TerminalStatusActive   TerminalStatus = "active"
+	TerminalStatusInactive TerminalStatus = "inactive"
+)
+
+// Terminal is a point of sale (physical or virtual) of the merchant. Its ID is
+// the 8 characters Terminal ID (TID) sent to the issuer with the payment.
+type Terminal struct {
+	ID          string
+	MerchantID  string
+	Description string
+	Status      TerminalStatus
+	CreatedAt   time.Time
+}
@@ -0,0 +1,110 @@
+package acquirer
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/google/uuid"
+)
+
+// CreatePaymentMethod stores the card in the merchant's vault. The card
+// number is encrypted, the CVV is not stored, so payments with the payment
+// method are sent without it.
+func (a *Service) CreatePaymentMethod(merchantID string, create models.CreatePaymentMethod) (*models.PaymentMethod, error) {
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status == models.MerchantStatusClosed {
+		return nil, fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+	}
+
+	method := &models.PaymentMethod{
+		ID:         uuid.New().String(),
+		MerchantID: merchantID,
+		Card:       safeCard(create.Card),
+		CreatedAt:  time.Now(),
+	}
+
+	encryptedCard, err := a.cardVault.Encrypt(method.ID, models.Card{
+		Number:         create.Card.Number,
+		ExpirationDate: create.Card.ExpirationDate,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encrypting card: %w", err)
+	}
+
+	err = a.repo.CreatePaymentMethod(method, encryptedCard)
+	if err != nil {
+		return nil, fmt.Errorf("creating payment method: %w", err)
+	}
+
+	return method, nil
+}
+
+func (a *Service) GetPaymentMethod(merchantID, paymentMethodID string) (*models.PaymentMethod, error) {
+	method, _, err := a.repo.GetPaymentMethod(merchantID, paymentMethodID)
+	if err != nil {
+		return nil, fmt.Errorf("getting payment method: %w", err)
+	}
+
+	return method, nil
+}
+
+func (a *Service) ListPaymentMethods(merchantID string) ([]*models.PaymentMethod, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	methods, err := a.repo.ListPaymentMethods(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("listing payment methods: %w", err)
+	}
+
+	return methods, nil
+}
+
+// DeletePaymentMethod deletes the payment method with its encrypted card,
+// e.g. when the customer removes the card from the account.
+func (a *Service) DeletePaymentMethod(merchantID, paymentMethodID string) error {
+	err := a.repo.DeletePaymentMethod(merchantID, paymentMethodID)
+	if err != nil {
+		return fmt.Errorf("deleting payment method: %w", err)
+	}
+
+	return nil
+}
+
+// paymentMethodCard returns the decrypted card of the merchant's payment
+// method.
+func (a *Service) paymentMethodCard(merchantID, paymentMethodID string) (models.Card, error) {
+	_, encryptedCard, err := a.repo.GetPaymentMethod(merchantID, paymentMethodID)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("getting payment method: %w", err)
+	}
+
+	card, err := a.cardVault.Decrypt(paymentMethodID, encryptedCard)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("decrypting card: %w", err)
+	}
+
+	return card, nil
+}
+
+// safeCard returns the card details that can be stored and shown in plain
+// text.
+func safeCard(card models.Card) models.SafeCard {
+	return models.SafeCard{
+		First6:         card.Number[:6],
+		Last4:          card.Number[len(card.Number)-4:],
+		ExpirationDate: card.ExpirationDate,
+	}
+}
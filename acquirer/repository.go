@@ -1,25 +1,61 @@
 package acquirer
 
 import (
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/models"
 )
 
-var ErrNotFound = fmt.Errorf("not found")
+var (
+	ErrNotFound      = fmt.Errorf("not found")
+	ErrAlreadyExists = fmt.Errorf("already exists")
+)
 
 type Repository struct {
 	mu sync.RWMutex
 
 	merchants map[string]*models.Merchant
+	terminals map[string]*models.Terminal
 	payments  map[string]*models.Payment
+
+	// paymentMethods are stored with their cards encrypted by the vault
+	paymentMethods map[string]*models.PaymentMethod
+	encryptedCards map[string][]byte
+
+	plans         map[string]*models.Plan
+	subscriptions map[string]*models.Subscription
+
+	// apiKeys are found by the hashes of the keys, the keys themselves are
+	// not stored
+	apiKeys       map[string]*models.APIKey
+	apiKeysByHash map[string]*models.APIKey
+
+	// indexes of payments
+	merchantPayments   map[string][]*models.Payment // sorted by CreatedAt and ID
+	paymentsByRRN      map[string]*models.Payment
+	paymentsByDisputes map[string]*models.Payment
 }
 
 func NewRepository() *Repository {
 	return &Repository{
-		merchants: make(map[string]*models.Merchant),
-		payments:  make(map[string]*models.Payment),
+		merchants:          make(map[string]*models.Merchant),
+		terminals:          make(map[string]*models.Terminal),
+		payments:           make(map[string]*models.Payment),
+		paymentMethods:     make(map[string]*models.PaymentMethod),
+		encryptedCards:     make(map[string][]byte),
+		plans:              make(map[string]*models.Plan),
+		subscriptions:      make(map[string]*models.Subscription),
+		apiKeys:            make(map[string]*models.APIKey),
+		apiKeysByHash:      make(map[string]*models.APIKey),
+		merchantPayments:   make(map[string][]*models.Payment),
+		paymentsByRRN:      make(map[string]*models.Payment),
+		paymentsByDisputes: make(map[string]*models.Payment),
 	}
 }
 
@@ -32,6 +68,8 @@ func (r *Repository) CreateMerchant(merchant *models.Merchant) error {
 	return nil
 }
 
+// GetMerchant returns the copy of the merchant made under the lock, so it
+// can be read while the merchant is updated with UpdateMerchant.
 func (r *Repository) GetMerchant(merchantID string) (*models.Merchant, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -41,7 +79,279 @@ func (r *Repository) GetMerchant(merchantID string) (*models.Merchant, error) {
 		return nil, ErrNotFound
 	}
 
-	return merchant, nil
+	copied := *merchant
+
+	return &copied, nil
+}
+
+// GetMerchantStatus returns the current status of the merchant, it's read
+// under the lock as the merchant may be updated concurrently.
+func (r *Repository) GetMerchantStatus(merchantID string) (models.MerchantStatus, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	merchant, ok := r.merchants[merchantID]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return merchant.Status, nil
+}
+
+// ListMerchants returns merchants with the given status (all merchants if
+// status is empty) sorted by creation time.
+func (r *Repository) ListMerchants(status models.MerchantStatus) ([]*models.Merchant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	merchants := make([]*models.Merchant, 0)
+
+	for _, merchant := range r.merchants {
+		if status == "" || merchant.Status == status {
+			copied := *merchant
+			merchants = append(merchants, &copied)
+		}
+	}
+
+	sort.Slice(merchants, func(i, j int) bool {
+		return merchants[i].CreatedAt.Before(merchants[j].CreatedAt)
+	})
+
+	return merchants, nil
+}
+
+// CreateTerminal stores the terminal. It returns ErrAlreadyExists if the
+// terminal with the same ID exists.
+func (r *Repository) CreateTerminal(terminal *models.Terminal) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.terminals[terminal.ID]; ok {
+		return ErrAlreadyExists
+	}
+
+	r.terminals[terminal.ID] = terminal
+
+	return nil
+}
+
+// GetTerminal returns the copy of the merchant's terminal made under the
+// lock, so it can be read while the terminal is updated with UpdateTerminal.
+func (r *Repository) GetTerminal(merchantID, terminalID string) (*models.Terminal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terminal, ok := r.terminals[terminalID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if terminal.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	copied := *terminal
+
+	return &copied, nil
+}
+
+// UpdateTerminal updates the merchant's terminal with the update function
+// under the lock and returns the copy of the updated terminal.
+func (r *Repository) UpdateTerminal(merchantID, terminalID string, update func(terminal *models.Terminal) error) (*models.Terminal, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	terminal, ok := r.terminals[terminalID]
+	if !ok || terminal.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	if err := update(terminal); err != nil {
+		return nil, err
+	}
+
+	copied := *terminal
+
+	return &copied, nil
+}
+
+// ListTerminals returns the merchant's terminals sorted by creation time.
+func (r *Repository) ListTerminals(merchantID string) ([]*models.Terminal, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terminals := make([]*models.Terminal, 0)
+
+	for _, terminal := range r.terminals {
+		if terminal.MerchantID == merchantID {
+			copied := *terminal
+			terminals = append(terminals, &copied)
+		}
+	}
+
+	sort.Slice(terminals, func(i, j int) bool {
+		return terminals[i].CreatedAt.Before(terminals[j].CreatedAt)
+	})
+
+	return terminals, nil
+}
+
+// CreateAPIKey stores the API key with the hash of the key.
+func (r *Repository) CreateAPIKey(apiKey *models.APIKey, keyHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.apiKeysByHash[keyHash]; ok {
+		return ErrAlreadyExists
+	}
+
+	r.apiKeys[apiKey.ID] = apiKey
+	r.apiKeysByHash[keyHash] = apiKey
+
+	return nil
+}
+
+// FindAPIKeyByHash returns the copy of the API key with the hash of the
+// key. The copy is returned as the key may be revoked while the request
+// authenticated with it checks its status.
+func (r *Repository) FindAPIKeyByHash(keyHash string) (models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	apiKey, ok := r.apiKeysByHash[keyHash]
+	if !ok {
+		return models.APIKey{}, ErrNotFound
+	}
+
+	return *apiKey, nil
+}
+
+// GetAPIKey returns the API key of the merchant.
+func (r *Repository) GetAPIKey(merchantID, apiKeyID string) (*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	apiKey, ok := r.apiKeys[apiKeyID]
+	if !ok || apiKey.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	return apiKey, nil
+}
+
+// ListAPIKeys returns the merchant's API keys sorted by creation time.
+func (r *Repository) ListAPIKeys(merchantID string) ([]*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	apiKeys := make([]*models.APIKey, 0)
+
+	for _, apiKey := range r.apiKeys {
+		if apiKey.MerchantID == merchantID {
+			apiKeys = append(apiKeys, apiKey)
+		}
+	}
+
+	sort.Slice(apiKeys, func(i, j int) bool {
+		return apiKeys[i].CreatedAt.Before(apiKeys[j].CreatedAt)
+	})
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes the API key.
+func (r *Repository) RevokeAPIKey(apiKey *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	apiKey.Status = models.APIKeyStatusRevoked
+	apiKey.RevokedAt = time.Now()
+
+	return nil
+}
+
+// RevokeMerchantAPIKeys revokes all active API keys of the merchant.
+func (r *Repository) RevokeMerchantAPIKeys(merchantID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	for _, apiKey := range r.apiKeys {
+		if apiKey.MerchantID == merchantID && apiKey.Status == models.APIKeyStatusActive {
+			apiKey.Status = models.APIKeyStatusRevoked
+			apiKey.RevokedAt = now
+		}
+	}
+
+	return nil
+}
+
+// CreatePaymentMethod stores the payment method with its encrypted card.
+func (r *Repository) CreatePaymentMethod(method *models.PaymentMethod, encryptedCard []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.paymentMethods[method.ID]; ok {
+		return ErrAlreadyExists
+	}
+
+	r.paymentMethods[method.ID] = method
+	r.encryptedCards[method.ID] = encryptedCard
+
+	return nil
+}
+
+// GetPaymentMethod returns the payment method of the merchant with its
+// encrypted card.
+func (r *Repository) GetPaymentMethod(merchantID, paymentMethodID string) (*models.PaymentMethod, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	method, ok := r.paymentMethods[paymentMethodID]
+	if !ok || method.MerchantID != merchantID {
+		return nil, nil, ErrNotFound
+	}
+
+	return method, r.encryptedCards[paymentMethodID], nil
+}
+
+// ListPaymentMethods returns the merchant's payment methods sorted by
+// creation time.
+func (r *Repository) ListPaymentMethods(merchantID string) ([]*models.PaymentMethod, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	methods := make([]*models.PaymentMethod, 0)
+
+	for _, method := range r.paymentMethods {
+		if method.MerchantID == merchantID {
+			methods = append(methods, method)
+		}
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].CreatedAt.Before(methods[j].CreatedAt)
+	})
+
+	return methods, nil
+}
+
+// DeletePaymentMethod deletes the payment method of the merchant and its
+// encrypted card.
+func (r *Repository) DeletePaymentMethod(merchantID, paymentMethodID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	method, ok := r.paymentMethods[paymentMethodID]
+	if !ok || method.MerchantID != merchantID {
+		return ErrNotFound
+	}
+
+	delete(r.paymentMethods, paymentMethodID)
+	delete(r.encryptedCards, paymentMethodID)
+
+	return nil
 }
 
 func (r *Repository) CreatePayment(payment *models.Payment) error {
@@ -50,9 +360,112 @@ func (r *Repository) CreatePayment(payment *models.Payment) error {
 
 	r.payments[payment.ID] = payment
 
+	// payments are created in order most of the time, so we are usually
+	// appending to the end of the slice
+	payments := r.merchantPayments[payment.MerchantID]
+	i := sort.Search(len(payments), func(i int) bool {
+		return paymentLess(payment, payments[i])
+	})
+	payments = append(payments, nil)
+	copy(payments[i+1:], payments[i:])
+	payments[i] = payment
+	r.merchantPayments[payment.MerchantID] = payments
+
+	r.indexPayment(payment)
+
 	return nil
 }
 
+// UpdatePayment applies the update to the merchant's payment under the
+// repository lock, updates indexes of the payment and returns the copy of
+// the updated payment.
+func (r *Repository) UpdatePayment(merchantID, paymentID string, update func(payment *models.Payment) error) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, ok := r.payments[paymentID]
+	if !ok || payment.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	if err := update(payment); err != nil {
+		return nil, err
+	}
+
+	r.indexPayment(payment)
+
+	return copyPayment(payment), nil
+}
+
+func (r *Repository) indexPayment(payment *models.Payment) {
+	if payment.RetrievalReferenceNumber != "" {
+		r.paymentsByRRN[payment.RetrievalReferenceNumber] = payment
+	}
+
+	if payment.Dispute != nil {
+		r.paymentsByDisputes[payment.Dispute.ID] = payment
+	}
+}
+
+// ListPayments returns a page of merchant's payments matching the filter,
+// newest first. Payments of the merchant are kept sorted by creation time, so
+// the date range and the cursor are found with binary search and only the
+// payments within the range are checked against the rest of the filter.
+func (r *Repository) ListPayments(merchantID string, filter models.PaymentFilter) (*models.PaymentList, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payments := r.merchantPayments[merchantID]
+
+	start := 0
+	if !filter.CreatedFrom.IsZero() {
+		start = sort.Search(len(payments), func(i int) bool {
+			return !payments[i].CreatedAt.Before(filter.CreatedFrom)
+		})
+	}
+
+	end := len(payments)
+	if !filter.CreatedTo.IsZero() {
+		end = sort.Search(len(payments), func(i int) bool {
+			return !payments[i].CreatedAt.Before(filter.CreatedTo)
+		})
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodePaymentCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		// only payments created before the cursor are returned
+		cursorEnd := sort.Search(len(payments), func(i int) bool {
+			return !paymentLess(payments[i], cursor)
+		})
+		if cursorEnd < end {
+			end = cursorEnd
+		}
+	}
+
+	list := &models.PaymentList{
+		Payments: make([]*models.Payment, 0, filter.Limit),
+	}
+
+	for i := end - 1; i >= start; i-- {
+		if !filter.Match(payments[i]) {
+			continue
+		}
+
+		if len(list.Payments) == filter.Limit {
+			list.NextCursor = encodePaymentCursor(list.Payments[len(list.Payments)-1])
+			break
+		}
+
+		list.Payments = append(list.Payments, copyPayment(payments[i]))
+	}
+
+	return list, nil
+}
+
 func (r *Repository) GetPayment(merchantID, paymentID string) (*models.Payment, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -66,5 +479,237 @@ func (r *Repository) GetPayment(merchantID, paymentID string) (*models.Payment,
 		return nil, ErrNotFound
 	}
 
-	return payment, nil
+	return copyPayment(payment), nil
+}
+
+// UpdateMerchant calls update with the merchant while holding the repository
+// lock, so concurrent updates of the merchant don't interfere, and returns
+// the copy of the updated merchant. The error returned by update is returned
+// as is.
+func (r *Repository) UpdateMerchant(merchantID string, update func(merchant *models.Merchant) error) (*models.Merchant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	merchant, ok := r.merchants[merchantID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if err := update(merchant); err != nil {
+		return nil, err
+	}
+
+	copied := *merchant
+
+	return &copied, nil
+}
+
+// FindPaymentByRRN returns the payment with the given retrieval reference
+// number.
+func (r *Repository) FindPaymentByRRN(rrn string) (*models.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payment, ok := r.paymentsByRRN[rrn]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyPayment(payment), nil
+}
+
+// FindPaymentForDispute returns the payment disputed with the given dispute
+// ID.
+func (r *Repository) FindPaymentForDispute(disputeID string) (*models.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	payment, ok := r.paymentsByDisputes[disputeID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyPayment(payment), nil
+}
+
+// copyPayment returns the copy of the payment with its own dispute and
+// increments, so the copy can be read while the payment is updated with
+// UpdatePayment.
+func copyPayment(payment *models.Payment) *models.Payment {
+	copied := *payment
+
+	if payment.Dispute != nil {
+		dispute := *payment.Dispute
+		copied.Dispute = &dispute
+	}
+
+	copied.Increments = append([]models.PaymentIncrement(nil), payment.Increments...)
+
+	return &copied
+}
+
+// paymentLess defines the order of payments in the index: by creation time
+// and by ID for payments created at the same time.
+func paymentLess(a, b *models.Payment) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+
+	return a.ID < b.ID
+}
+
+// encodePaymentCursor encodes the position of the payment in the index as an
+// opaque string.
+func encodePaymentCursor(payment *models.Payment) string {
+	cursor := fmt.Sprintf("%d|%s", payment.CreatedAt.UnixNano(), payment.ID)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodePaymentCursor returns a payment with the creation time and ID
+// encoded in the cursor.
+func decodePaymentCursor(cursor string) (*models.Payment, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	nanos, id, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	return &models.Payment{
+		ID:        id,
+		CreatedAt: time.Unix(0, n),
+	}, nil
+}
+
+func (r *Repository) CreatePlan(plan *models.Plan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.plans[plan.ID] = plan
+
+	return nil
+}
+
+// GetPlan returns the plan of the merchant.
+func (r *Repository) GetPlan(merchantID, planID string) (*models.Plan, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	plan, ok := r.plans[planID]
+	if !ok || plan.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	return plan, nil
+}
+
+// ListPlans returns the merchant's plans sorted by creation time.
+func (r *Repository) ListPlans(merchantID string) ([]*models.Plan, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	plans := make([]*models.Plan, 0)
+
+	for _, plan := range r.plans {
+		if plan.MerchantID == merchantID {
+			plans = append(plans, plan)
+		}
+	}
+
+	sort.Slice(plans, func(i, j int) bool {
+		return plans[i].CreatedAt.Before(plans[j].CreatedAt)
+	})
+
+	return plans, nil
+}
+
+func (r *Repository) CreateSubscription(subscription *models.Subscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.subscriptions[subscription.ID] = subscription
+
+	return nil
+}
+
+// GetSubscription returns the subscription of the merchant.
+func (r *Repository) GetSubscription(merchantID, subscriptionID string) (*models.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscription, ok := r.subscriptions[subscriptionID]
+	if !ok || subscription.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	return subscription, nil
+}
+
+// ListSubscriptions returns the merchant's subscriptions with the given
+// status (all subscriptions if status is empty) sorted by creation time.
+func (r *Repository) ListSubscriptions(merchantID string, status models.SubscriptionStatus) ([]*models.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscriptions := make([]*models.Subscription, 0)
+
+	for _, subscription := range r.subscriptions {
+		if subscription.MerchantID == merchantID && (status == "" || subscription.Status == status) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+
+	return subscriptions, nil
+}
+
+// ListDueSubscriptions returns billable subscriptions which next payment
+// attempt is due at the given time, the longest overdue first.
+func (r *Repository) ListDueSubscriptions(now time.Time) ([]*models.Subscription, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	subscriptions := make([]*models.Subscription, 0)
+
+	for _, subscription := range r.subscriptions {
+		if subscription.IsBillable() && !subscription.NextAttemptAt.After(now) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].NextAttemptAt.Before(subscriptions[j].NextAttemptAt)
+	})
+
+	return subscriptions, nil
+}
+
+// UpdateSubscription applies the update to the merchant's subscription
+// under the repository lock.
+func (r *Repository) UpdateSubscription(merchantID, subscriptionID string, update func(subscription *models.Subscription) error) (*models.Subscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscription, ok := r.subscriptions[subscriptionID]
+	if !ok || subscription.MerchantID != merchantID {
+		return nil, ErrNotFound
+	}
+
+	if err := update(subscription); err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
 }
@@ -0,0 +1,169 @@
+package acquirer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/stretchr/testify/require"
+)
+
+// createPayments creates n payments for the merchant, one per minute starting
+// from the given time. Every second payment is declined.
+func createPayments(t testing.TB, repo *acquirer.Repository, merchantID string, start time.Time, n int) {
+	for i := 0; i < n; i++ {
+		status := models.PaymentStatusAuthorized
+		if i%2 == 1 {
+			status = models.PaymentStatusDeclined
+		}
+
+		err := repo.CreatePayment(&models.Payment{
+			ID:         fmt.Sprintf("%s-%06d", merchantID, i),
+			MerchantID: merchantID,
+			Amount:     int64(i+1) * 100,
+			Currency:   "USD",
+			Status:     status,
+			Card: models.SafeCard{
+				First6: "900000",
+				Last4:  fmt.Sprintf("%04d", i%10),
+			},
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestRepositoryListPayments(t *testing.T) {
+	repo := acquirer.NewRepository()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	createPayments(t, repo, "merchant-1", start, 50)
+	createPayments(t, repo, "merchant-2", start, 10)
+
+	t.Run("pages are sorted newest first", func(t *testing.T) {
+		var ids []string
+		filter := models.PaymentFilter{Limit: 20}
+
+		for {
+			list, err := repo.ListPayments("merchant-1", filter)
+			require.NoError(t, err)
+
+			for _, payment := range list.Payments {
+				ids = append(ids, payment.ID)
+			}
+
+			if list.NextCursor == "" {
+				break
+			}
+
+			filter.Cursor = list.NextCursor
+		}
+
+		require.Len(t, ids, 50)
+		require.Equal(t, "merchant-1-000049", ids[0])
+		require.Equal(t, "merchant-1-000000", ids[49])
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		list, err := repo.ListPayments("merchant-1", models.PaymentFilter{
+			Status:      models.PaymentStatusAuthorized,
+			CreatedFrom: start.Add(10 * time.Minute),
+			CreatedTo:   start.Add(20 * time.Minute),
+			Limit:       100,
+		})
+		require.NoError(t, err)
+		require.Len(t, list.Payments, 5) // 10, 12, 14, 16, 18
+		require.Equal(t, "merchant-1-000018", list.Payments[0].ID)
+		require.Empty(t, list.NextCursor)
+
+		list, err = repo.ListPayments("merchant-1", models.PaymentFilter{
+			AmountMin: 1000,
+			AmountMax: 2000,
+			CardLast4: "0005",
+			Limit:     100,
+		})
+		require.NoError(t, err)
+		require.Len(t, list.Payments, 1) // 15
+		require.Equal(t, int64(1600), list.Payments[0].Amount)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := repo.ListPayments("merchant-1", models.PaymentFilter{
+			Cursor: "invalid",
+			Limit:  10,
+		})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+}
+
+func BenchmarkRepositoryListPayments(b *testing.B) {
+	repo := acquirer.NewRepository()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	createPayments(b, repo, "merchant-1", start, 100_000)
+
+	filter := models.PaymentFilter{
+		Status:      models.PaymentStatusAuthorized,
+		CreatedFrom: start.Add(50_000 * time.Minute),
+		CreatedTo:   start.Add(60_000 * time.Minute),
+		Limit:       100,
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := repo.ListPayments("merchant-1", filter)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRepositoryPaymentCopies(t *testing.T) {
+	repo := acquirer.NewRepository()
+
+	err := repo.CreatePayment(&models.Payment{
+		ID:                       "payment-1",
+		MerchantID:               "merchant-1",
+		Amount:                   10_00,
+		Currency:                 "USD",
+		Status:                   models.PaymentStatusAuthorized,
+		RetrievalReferenceNumber: "123456789012",
+		CreatedAt:                time.Now(),
+	})
+	require.NoError(t, err)
+
+	_, err = repo.UpdatePayment("merchant-1", "payment-1", func(payment *models.Payment) error {
+		payment.Dispute = &models.Dispute{
+			ID:     "dispute-1",
+			Amount: 10_00,
+			Status: models.DisputeStatusChargeback,
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	payment, err := repo.GetPayment("merchant-1", "payment-1")
+	require.NoError(t, err)
+
+	byRRN, err := repo.FindPaymentByRRN("123456789012")
+	require.NoError(t, err)
+
+	byDispute, err := repo.FindPaymentForDispute("dispute-1")
+	require.NoError(t, err)
+
+	updated, err := repo.UpdatePayment("merchant-1", "payment-1", func(payment *models.Payment) error {
+		return payment.Dispute.Transition(models.DisputeStatusRepresentment)
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.DisputeStatusRepresentment, updated.Dispute.Status)
+
+	// the disputes of the payments returned before the update are not
+	// changed
+	require.Equal(t, models.DisputeStatusChargeback, payment.Dispute.Status)
+	require.Equal(t, models.DisputeStatusChargeback, byRRN.Dispute.Status)
+	require.Equal(t, models.DisputeStatusChargeback, byDispute.Dispute.Status)
+}
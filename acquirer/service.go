@@ -1,7 +1,10 @@
 package acquirer
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer/models"
@@ -11,79 +14,414 @@ import (
 type Service struct {
 	repo          *Repository
 	iso8583Client ISO8583Client
+	cardVault     *CardVault
+	dunningPolicy models.DunningPolicy
+
+	// adminAPIKeyHash is the hash of the admin key, the admin is not
+	// authenticated when it's empty
+	adminAPIKeyHash string
+
+	// subscriptionsMu serializes the runs of the subscription scheduler,
+	// so the subscription is never charged twice for the same period
+	subscriptionsMu sync.Mutex
+
+	// disputesMu guards disputesInProgress, the disputes with the
+	// merchant's action being sent to the issuer
+	disputesMu         sync.Mutex
+	disputesInProgress map[string]bool
 }
 
 type ISO8583Client interface {
 	AuthorizePayment(payment *models.Payment, card models.Card, merchant models.Merchant) (models.AuthorizationResponse, error)
+	IncrementAuthorization(payment *models.Payment, increment *models.PaymentIncrement, merchant models.Merchant) (models.AuthorizationResponse, error)
+	InquireBalance(card models.Card, terminalID string, merchant models.Merchant) (models.BalanceInquiry, error)
+	NotifyDispute(notification models.DisputeNotification) error
 }
 
 func NewService(repo *Repository, iso8583Client ISO8583Client) *Service {
 	return &Service{
 		repo:          repo,
 		iso8583Client: iso8583Client,
+		cardVault:     newRandomCardVault(),
+		dunningPolicy: models.DefaultDunningPolicy(),
+
+		disputesInProgress: make(map[string]bool),
 	}
 }
 
+// SetCardVault sets the vault encrypting cards of payment methods. By
+// default, the vault with a random key is used.
+func (a *Service) SetCardVault(vault *CardVault) {
+	a.cardVault = vault
+}
+
 func (a *Service) CreateMerchant(create models.CreateMerchant) (*models.Merchant, error) {
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	merchant := &models.Merchant{
 		ID:         uuid.New().String(),
 		Name:       create.Name,
 		MCC:        create.MCC,
 		PostalCode: create.PostalCode,
 		WebSite:    create.WebSite,
+		Status:     models.MerchantStatusActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
 	}
 
-	err := a.repo.CreateMerchant(merchant)
+	err = a.repo.CreateMerchant(merchant)
 	if err != nil {
 		return nil, fmt.Errorf("creating merchant: %w", err)
 	}
 
+	apiKey, err := a.issueAPIKey(merchant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the only time the key is shown with the merchant
+	created := *merchant
+	created.APIKey = apiKey.Key
+
+	return &created, nil
+}
+
+func (a *Service) GetMerchant(merchantID string) (*models.Merchant, error) {
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	return merchant, nil
+}
+
+// ListMerchants returns merchants with the given status or all merchants if
+// status is empty.
+func (a *Service) ListMerchants(status models.MerchantStatus) ([]*models.Merchant, error) {
+	merchants, err := a.repo.ListMerchants(status)
+	if err != nil {
+		return nil, fmt.Errorf("listing merchants: %w", err)
+	}
+
+	return merchants, nil
+}
+
+// UpdateMerchant replaces the merchant details. Closed merchants can't be
+// updated.
+func (a *Service) UpdateMerchant(merchantID string, update models.UpdateMerchant) (*models.Merchant, error) {
+	err := models.CreateMerchant(update).Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	merchant, err := a.repo.UpdateMerchant(merchantID, func(merchant *models.Merchant) error {
+		if merchant.Status == models.MerchantStatusClosed {
+			return fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+		}
+
+		merchant.Name = update.Name
+		merchant.MCC = update.MCC
+		merchant.PostalCode = update.PostalCode
+		merchant.WebSite = update.WebSite
+		merchant.UpdatedAt = time.Now()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating merchant: %w", err)
+	}
+
 	return merchant, nil
 }
 
+// UpdateMerchantStatus suspends, reactivates or closes the merchant.
+func (a *Service) UpdateMerchantStatus(merchantID string, update models.UpdateMerchantStatus) (*models.Merchant, error) {
+	merchant, err := a.repo.UpdateMerchant(merchantID, func(merchant *models.Merchant) error {
+		return merchant.Transition(update.Status)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating merchant status: %w", err)
+	}
+
+	// the closed merchant can't be reactivated, so its keys are no longer
+	// needed
+	if update.Status == models.MerchantStatusClosed {
+		err = a.repo.RevokeMerchantAPIKeys(merchantID)
+		if err != nil {
+			return nil, fmt.Errorf("revoking API keys: %w", err)
+		}
+	}
+
+	return merchant, nil
+}
+
+// CreateTerminal creates a terminal for the merchant with a newly assigned
+// Terminal ID (TID).
+func (a *Service) CreateTerminal(merchantID string, create models.CreateTerminal) (*models.Terminal, error) {
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status == models.MerchantStatusClosed {
+		return nil, fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+	}
+
+	terminal := &models.Terminal{
+		MerchantID:  merchantID,
+		Description: create.Description,
+		Status:      models.TerminalStatusActive,
+		CreatedAt:   time.Now(),
+	}
+
+	// TIDs are random, so we retry in the unlikely case of a collision
+	for attempt := 0; attempt < 10; attempt++ {
+		terminal.ID = generateTerminalID()
+
+		err = a.repo.CreateTerminal(terminal)
+		if !errors.Is(err, ErrAlreadyExists) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating terminal: %w", err)
+	}
+
+	return terminal, nil
+}
+
+func (a *Service) ListTerminals(merchantID string) ([]*models.Terminal, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	terminals, err := a.repo.ListTerminals(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("listing terminals: %w", err)
+	}
+
+	return terminals, nil
+}
+
+// DeactivateTerminal deactivates the terminal, so it can't be used for
+// payments anymore.
+func (a *Service) DeactivateTerminal(merchantID, terminalID string) (*models.Terminal, error) {
+	terminal, err := a.repo.UpdateTerminal(merchantID, terminalID, func(terminal *models.Terminal) error {
+		terminal.Status = models.TerminalStatusInactive
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating terminal: %w", err)
+	}
+
+	return terminal, nil
+}
+
+// CreatePayment authorizes the payment with the card or with the payment
+// method stored in the merchant's vault. Payments with the payment method
+// are sent to the issuer with the stored credential indicator.
 func (a *Service) CreatePayment(merchantID string, create models.CreatePayment) (*models.Payment, error) {
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status != models.MerchantStatusActive {
+		return nil, fmt.Errorf("%w: merchant is %s", models.ErrMerchantNotActive, merchant.Status)
+	}
+
+	if create.TerminalID != "" {
+		terminal, err := a.repo.GetTerminal(merchantID, create.TerminalID)
+		if err != nil {
+			return nil, fmt.Errorf("getting terminal: %w", err)
+		}
+
+		if terminal.Status != models.TerminalStatusActive {
+			return nil, models.ErrTerminalNotActive
+		}
+	}
+
+	card := create.Card
+	if create.PaymentMethodID != "" {
+		card, err = a.paymentMethodCard(merchantID, create.PaymentMethodID)
+		if err != nil {
+			return nil, err
+		}
+
+		// the CVV is not stored, the customer enters it
+		card.CardVerificationValue = create.Card.CardVerificationValue
+
+		if create.StoredCredential == "" {
+			create.StoredCredential = models.StoredCredentialCustomerInitiated
+		}
+	}
+
 	payment := &models.Payment{
 		ID:         uuid.New().String(),
 		MerchantID: merchantID,
+		TerminalID: create.TerminalID,
 		Amount:     create.Amount,
 		Currency:   create.Currency,
-		Card: models.SafeCard{
-			First6:         create.Card.Number[:6],
-			Last4:          create.Card.Number[len(create.Card.Number)-4:],
-			ExpirationDate: create.Card.ExpirationDate,
-		},
+
+		RequestedAmount:          create.Amount,
+		PartialApprovalSupported: create.PartialApprovalSupported,
+		TokenRequestorID:         create.TokenRequestorID,
+		PaymentMethodID:          create.PaymentMethodID,
+		StoredCredential:         create.StoredCredential,
+
+		Card:      safeCard(card),
 		Status:    models.PaymentStatusPending,
 		CreatedAt: time.Now(),
 	}
 
-	err := a.repo.CreatePayment(payment)
+	err = a.repo.CreatePayment(payment)
 	if err != nil {
 		return nil, fmt.Errorf("creating payment: %w", err)
 	}
 
+	response, err := a.iso8583Client.AuthorizePayment(payment, card, *merchant)
+	if err != nil {
+		// the payment is kept with the error status, the authorization
+		// error is returned anyway
+		a.repo.UpdatePayment(merchantID, payment.ID, func(payment *models.Payment) error {
+			payment.Status = models.PaymentStatusError
+
+			return nil
+		})
+
+		return nil, fmt.Errorf("authorizing payment: %w", err)
+	}
+
+	payment, err = a.repo.UpdatePayment(merchantID, payment.ID, func(payment *models.Payment) error {
+		payment.AuthorizationCode = response.AuthorizationCode
+		payment.ApprovalCode = response.ApprovalCode
+		payment.RetrievalReferenceNumber = response.RetrievalReferenceNumber
+
+		switch response.ApprovalCode {
+		case "00":
+			payment.Status = models.PaymentStatusAuthorized
+		case "10":
+			// partially approved, only the approved amount can be captured
+			payment.Status = models.PaymentStatusAuthorized
+			payment.Amount = response.ApprovedAmount
+		default:
+			payment.Status = models.PaymentStatusDeclined
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+// IncrementPayment sends the incremental authorization of the authorized
+// payment to the issuer. If it's approved, the amount is added to the
+// payment amount.
+func (a *Service) IncrementPayment(merchantID, paymentID string, increment models.IncrementPayment) (*models.Payment, error) {
+	if increment.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", models.ErrValidation)
+	}
+
 	merchant, err := a.repo.GetMerchant(merchantID)
 	if err != nil {
 		return nil, fmt.Errorf("getting merchant: %w", err)
 	}
 
-	response, err := a.iso8583Client.AuthorizePayment(payment, create.Card, *merchant)
+	if merchant.Status != models.MerchantStatusActive {
+		return nil, fmt.Errorf("%w: merchant is %s", models.ErrMerchantNotActive, merchant.Status)
+	}
+
+	payment, err := a.repo.GetPayment(merchantID, paymentID)
 	if err != nil {
-		payment.Status = models.PaymentStatusError
-		// update payment details
-		return nil, fmt.Errorf("authorizing payment: %w", err)
+		return nil, fmt.Errorf("getting payment: %w", err)
 	}
 
-	payment.AuthorizationCode = response.AuthorizationCode
+	if payment.Status != models.PaymentStatusAuthorized {
+		return nil, fmt.Errorf("%w: payment is %s", models.ErrPaymentNotIncrementable, payment.Status)
+	}
 
-	if response.ApprovalCode == "00" {
-		payment.Status = models.PaymentStatusAuthorized
-	} else {
-		payment.Status = models.PaymentStatusDeclined
+	if payment.Dispute != nil {
+		return nil, fmt.Errorf("%w: payment is disputed", models.ErrPaymentNotIncrementable)
+	}
+
+	paymentIncrement := &models.PaymentIncrement{
+		Amount:    increment.Amount,
+		CreatedAt: time.Now(),
+	}
+
+	response, err := a.iso8583Client.IncrementAuthorization(payment, paymentIncrement, *merchant)
+	if err != nil {
+		return nil, fmt.Errorf("incrementing authorization: %w", err)
+	}
+
+	paymentIncrement.ApprovalCode = response.ApprovalCode
+	paymentIncrement.RetrievalReferenceNumber = response.RetrievalReferenceNumber
+
+	payment, err = a.repo.UpdatePayment(merchantID, paymentID, func(payment *models.Payment) error {
+		if response.ApprovalCode == "00" {
+			paymentIncrement.Status = models.PaymentStatusAuthorized
+			payment.Amount += paymentIncrement.Amount
+			payment.RequestedAmount += paymentIncrement.Amount
+		} else {
+			paymentIncrement.Status = models.PaymentStatusDeclined
+		}
+
+		payment.Increments = append(payment.Increments, *paymentIncrement)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("updating payment: %w", err)
 	}
 
 	return payment, nil
 }
 
+// InquireBalance sends the balance inquiry for the card to the issuer. No
+// payment is created and no funds are held.
+func (a *Service) InquireBalance(merchantID string, create models.CreateBalanceInquiry) (*models.BalanceInquiry, error) {
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status != models.MerchantStatusActive {
+		return nil, fmt.Errorf("%w: merchant is %s", models.ErrMerchantNotActive, merchant.Status)
+	}
+
+	if create.TerminalID != "" {
+		terminal, err := a.repo.GetTerminal(merchantID, create.TerminalID)
+		if err != nil {
+			return nil, fmt.Errorf("getting terminal: %w", err)
+		}
+
+		if terminal.Status != models.TerminalStatusActive {
+			return nil, models.ErrTerminalNotActive
+		}
+	}
+
+	inquiry, err := a.iso8583Client.InquireBalance(create.Card, create.TerminalID, *merchant)
+	if err != nil {
+		return nil, fmt.Errorf("inquiring balance: %w", err)
+	}
+
+	return &inquiry, nil
+}
+
 func (a *Service) GetPayment(merchantID, paymentID string) (*models.Payment, error) {
 	payment, err := a.repo.GetPayment(merchantID, paymentID)
 	if err != nil {
@@ -92,3 +430,318 @@ func (a *Service) GetPayment(merchantID, paymentID string) (*models.Payment, err
 
 	return payment, nil
 }
+
+const (
+	defaultPaymentsLimit = 20
+	maxPaymentsLimit     = 100
+)
+
+// ListPayments returns a page of merchant's payments matching the filter,
+// newest first.
+func (a *Service) ListPayments(merchantID string, filter models.PaymentFilter) (*models.PaymentList, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = defaultPaymentsLimit
+	case filter.Limit < 0 || filter.Limit > maxPaymentsLimit:
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", models.ErrValidation, maxPaymentsLimit)
+	}
+
+	if filter.AmountMax != 0 && filter.AmountMin > filter.AmountMax {
+		return nil, fmt.Errorf("%w: minimum amount is greater than maximum", models.ErrValidation)
+	}
+
+	if !filter.CreatedTo.IsZero() && filter.CreatedFrom.After(filter.CreatedTo) {
+		return nil, fmt.Errorf("%w: created from is after created to", models.ErrValidation)
+	}
+
+	payments, err := a.repo.ListPayments(merchantID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("listing payments: %w", err)
+	}
+
+	return payments, nil
+}
+
+// HandleDisputeNotification handles dispute notifications received from the
+// issuer.
+func (a *Service) HandleDisputeNotification(notification models.DisputeNotification) error {
+	if notification.Stage == models.DisputeStageChargeback {
+		return a.receiveChargeback(notification)
+	}
+
+	payment, err := a.repo.FindPaymentForDispute(notification.DisputeID)
+	if err != nil {
+		return fmt.Errorf("finding disputed payment: %w", err)
+	}
+
+	switch notification.Stage {
+	case models.DisputeStagePreArbitration:
+		_, err = a.repo.UpdatePayment(payment.MerchantID, payment.ID, func(payment *models.Payment) error {
+			err := payment.Dispute.Transition(models.DisputeStatusPreArbitration)
+			if err != nil {
+				return err
+			}
+
+			payment.Dispute.Message = notification.Message
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	case models.DisputeStageAccepted:
+		// issuer accepted the representment
+		payment, err = a.repo.UpdatePayment(payment.MerchantID, payment.ID, func(payment *models.Payment) error {
+			return payment.Dispute.Transition(models.DisputeStatusWon)
+		})
+		if err != nil {
+			return err
+		}
+
+		return a.releaseDisputedAmount(payment.MerchantID, payment.Dispute, false)
+	default:
+		return fmt.Errorf("unexpected dispute stage: %s", notification.Stage)
+	}
+
+	return nil
+}
+
+// receiveChargeback opens a dispute for the payment and withholds the
+// disputed amount from the merchant. The payment is checked and the dispute
+// is opened under the repository lock, so the chargeback repeated for the
+// same payment is rejected.
+func (a *Service) receiveChargeback(notification models.DisputeNotification) error {
+	payment, err := a.repo.FindPaymentByRRN(notification.RetrievalReferenceNumber)
+	if err != nil {
+		return fmt.Errorf("finding payment: %w", err)
+	}
+
+	now := time.Now()
+	payment, err = a.repo.UpdatePayment(payment.MerchantID, payment.ID, func(payment *models.Payment) error {
+		switch {
+		case payment.Dispute != nil:
+			return fmt.Errorf("%w: payment %s is already disputed", models.ErrPaymentNotDisputable, payment.ID)
+		case payment.Status != models.PaymentStatusAuthorized:
+			return fmt.Errorf("%w: payment status is %s", models.ErrPaymentNotDisputable, payment.Status)
+		case notification.Currency != payment.Currency:
+			return fmt.Errorf("%w: dispute currency %s doesn't match payment currency %s", models.ErrPaymentNotDisputable, notification.Currency, payment.Currency)
+		case notification.Amount <= 0 || notification.Amount > payment.Amount:
+			return fmt.Errorf("%w: disputed amount must be between 1 and %d", models.ErrPaymentNotDisputable, payment.Amount)
+		}
+
+		payment.Dispute = &models.Dispute{
+			ID:         notification.DisputeID,
+			ReasonCode: notification.ReasonCode,
+			Amount:     notification.Amount,
+			Currency:   notification.Currency,
+			Status:     models.DisputeStatusChargeback,
+			Message:    notification.Message,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("updating payment: %w", err)
+	}
+
+	_, err = a.repo.UpdateMerchant(payment.MerchantID, func(merchant *models.Merchant) error {
+		merchant.DisputedAmount += payment.Dispute.Amount
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("updating merchant: %w", err)
+	}
+
+	return nil
+}
+
+// SubmitRepresentment challenges the chargeback with the evidence provided by
+// the merchant.
+func (a *Service) SubmitRepresentment(merchantID, paymentID string, create models.CreateRepresentment) (*models.Payment, error) {
+	payment, release, err := a.reserveDispute(merchantID, paymentID, func(dispute *models.Dispute) error {
+		return dispute.CanTransition(models.DisputeStatusRepresentment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = a.notifyDispute(payment.Dispute, models.DisputeStageRepresentment, create.Evidence)
+	if err != nil {
+		return nil, fmt.Errorf("sending representment: %w", err)
+	}
+
+	payment, err = a.repo.UpdatePayment(merchantID, paymentID, func(payment *models.Payment) error {
+		err := payment.Dispute.Transition(models.DisputeStatusRepresentment)
+		if err != nil {
+			return err
+		}
+
+		payment.Dispute.Evidence = create.Evidence
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// AcceptDispute accepts the chargeback or pre-arbitration. The dispute is
+// resolved in favor of the cardholder and the disputed amount is charged back
+// from the merchant.
+func (a *Service) AcceptDispute(merchantID, paymentID string) (*models.Payment, error) {
+	payment, release, err := a.reserveDispute(merchantID, paymentID, func(dispute *models.Dispute) error {
+		return dispute.CanTransition(models.DisputeStatusLost)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = a.notifyDispute(payment.Dispute, models.DisputeStageAccepted, "")
+	if err != nil {
+		return nil, fmt.Errorf("sending dispute acceptance: %w", err)
+	}
+
+	payment, err = a.repo.UpdatePayment(merchantID, paymentID, func(payment *models.Payment) error {
+		return payment.Dispute.Transition(models.DisputeStatusLost)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.releaseDisputedAmount(merchantID, payment.Dispute, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// RejectPreArbitration rejects the pre-arbitration. The dispute is resolved
+// in favor of the merchant.
+func (a *Service) RejectPreArbitration(merchantID, paymentID string) (*models.Payment, error) {
+	payment, release, err := a.reserveDispute(merchantID, paymentID, checkPreArbitration)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = a.notifyDispute(payment.Dispute, models.DisputeStageRejected, "")
+	if err != nil {
+		return nil, fmt.Errorf("sending pre-arbitration rejection: %w", err)
+	}
+
+	payment, err = a.repo.UpdatePayment(merchantID, paymentID, func(payment *models.Payment) error {
+		err := checkPreArbitration(payment.Dispute)
+		if err != nil {
+			return err
+		}
+
+		return payment.Dispute.Transition(models.DisputeStatusWon)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.releaseDisputedAmount(merchantID, payment.Dispute, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+func checkPreArbitration(dispute *models.Dispute) error {
+	if dispute.Status != models.DisputeStatusPreArbitration {
+		return fmt.Errorf("%w: dispute status is %s", models.ErrInvalidDisputeStatus, dispute.Status)
+	}
+
+	return nil
+}
+
+// reserveDispute checks the dispute of the merchant's payment and reserves
+// it for the merchant's action until release is called. The action is
+// sent to the issuer before the dispute is updated, so another action on
+// the dispute is rejected while it's reserved. The dispute is checked again
+// when it's updated as the issuer may move it in the meantime.
+func (a *Service) reserveDispute(merchantID, paymentID string, check func(dispute *models.Dispute) error) (*models.Payment, func(), error) {
+	payment, err := a.repo.GetPayment(merchantID, paymentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting payment: %w", err)
+	}
+
+	if payment.Dispute == nil {
+		return nil, nil, fmt.Errorf("payment is not disputed: %w", ErrNotFound)
+	}
+
+	err = check(payment.Dispute)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	disputeID := payment.Dispute.ID
+
+	a.disputesMu.Lock()
+	defer a.disputesMu.Unlock()
+
+	if a.disputesInProgress[disputeID] {
+		return nil, nil, fmt.Errorf("%w: another action on the dispute is in progress", models.ErrInvalidDisputeStatus)
+	}
+
+	a.disputesInProgress[disputeID] = true
+
+	release := func() {
+		a.disputesMu.Lock()
+		defer a.disputesMu.Unlock()
+
+		delete(a.disputesInProgress, disputeID)
+	}
+
+	return payment, release, nil
+}
+
+// releaseDisputedAmount releases the amount withheld from the merchant when
+// the dispute is resolved. If the merchant lost the dispute the amount is
+// charged back.
+func (a *Service) releaseDisputedAmount(merchantID string, dispute *models.Dispute, chargedBack bool) error {
+	_, err := a.repo.UpdateMerchant(merchantID, func(merchant *models.Merchant) error {
+		merchant.DisputedAmount -= dispute.Amount
+		if chargedBack {
+			merchant.ChargedBackAmount += dispute.Amount
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("updating merchant: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Service) notifyDispute(dispute *models.Dispute, stage models.DisputeStage, message string) error {
+	return a.iso8583Client.NotifyDispute(models.DisputeNotification{
+		DisputeID:  dispute.ID,
+		Stage:      stage,
+		ReasonCode: dispute.ReasonCode,
+		Amount:     dispute.Amount,
+		Currency:   dispute.Currency,
+		Message:    message,
+	})
+}
+
+// generateTerminalID generates a random 8 digits Terminal ID (TID).
+func generateTerminalID() string {
+	return fmt.Sprintf("%08d", rand.Intn(100_000_000))
+}
@@ -0,0 +1,93 @@
+package acquirer_test
+
+import (
+	"testing"
+
+	"github.com/alovak/cardflow-playground/acquirer"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/stretchr/testify/require"
+)
+
+// rrnISO8583Client approves all payments with the same retrieval reference
+// number
+type rrnISO8583Client struct {
+	iso8583Client
+}
+
+func (c *rrnISO8583Client) AuthorizePayment(payment *models.Payment, card models.Card, merchant models.Merchant) (models.AuthorizationResponse, error) {
+	return models.AuthorizationResponse{
+		ApprovalCode:             "00",
+		AuthorizationCode:        "123456",
+		RetrievalReferenceNumber: "301412000001",
+	}, nil
+}
+
+func TestServiceChargeback(t *testing.T) {
+	service := acquirer.NewService(acquirer.NewRepository(), &rrnISO8583Client{})
+
+	merchant, err := service.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+	})
+	require.NoError(t, err)
+
+	payment, err := service.CreatePayment(merchant.ID, models.CreatePayment{
+		Amount:   10_00,
+		Currency: "USD",
+		Card: models.Card{
+			Number:                "9000000000000001",
+			ExpirationDate:        "1230",
+			CardVerificationValue: "123",
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	chargeback := models.DisputeNotification{
+		DisputeID:                "dispute-1",
+		RetrievalReferenceNumber: "301412000001",
+		Stage:                    models.DisputeStageChargeback,
+		ReasonCode:               "4855",
+		Amount:                   10_00,
+		Currency:                 "USD",
+	}
+
+	t.Run("chargeback not matching the payment is rejected", func(t *testing.T) {
+		tests := map[string]func(n *models.DisputeNotification){
+			"amount above payment": func(n *models.DisputeNotification) { n.Amount = 10_01 },
+			"zero amount":          func(n *models.DisputeNotification) { n.Amount = 0 },
+			"other currency":       func(n *models.DisputeNotification) { n.Currency = "EUR" },
+		}
+
+		for name, modify := range tests {
+			t.Run(name, func(t *testing.T) {
+				notification := chargeback
+				modify(&notification)
+
+				err := service.HandleDisputeNotification(notification)
+				require.ErrorIs(t, err, models.ErrPaymentNotDisputable)
+			})
+		}
+
+		m, err := service.GetMerchant(merchant.ID)
+		require.NoError(t, err)
+		require.Zero(t, m.DisputedAmount)
+	})
+
+	t.Run("repeated chargeback is rejected", func(t *testing.T) {
+		err := service.HandleDisputeNotification(chargeback)
+		require.NoError(t, err)
+
+		repeated := chargeback
+		repeated.DisputeID = "dispute-2"
+
+		err = service.HandleDisputeNotification(repeated)
+		require.ErrorIs(t, err, models.ErrPaymentNotDisputable)
+
+		// the amount is withheld once
+		m, err := service.GetMerchant(merchant.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(10_00), m.DisputedAmount)
+	})
+}
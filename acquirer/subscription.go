@@ -0,0 +1,263 @@
+package acquirer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/google/uuid"
+)
+
+// SetDunningPolicy sets how the declined payments of subscriptions are
+// retried. By default, models.DefaultDunningPolicy is used.
+func (a *Service) SetDunningPolicy(policy models.DunningPolicy) {
+	a.dunningPolicy = policy
+}
+
+func (a *Service) CreatePlan(merchantID string, create models.CreatePlan) (*models.Plan, error) {
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status == models.MerchantStatusClosed {
+		return nil, fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+	}
+
+	plan := &models.Plan{
+		ID:         uuid.New().String(),
+		MerchantID: merchantID,
+		Name:       create.Name,
+		Amount:     create.Amount,
+		Currency:   create.Currency,
+		Interval:   create.Interval,
+		CreatedAt:  time.Now(),
+	}
+
+	err = a.repo.CreatePlan(plan)
+	if err != nil {
+		return nil, fmt.Errorf("creating plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+func (a *Service) GetPlan(merchantID, planID string) (*models.Plan, error) {
+	plan, err := a.repo.GetPlan(merchantID, planID)
+	if err != nil {
+		return nil, fmt.Errorf("getting plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+func (a *Service) ListPlans(merchantID string) ([]*models.Plan, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	plans, err := a.repo.ListPlans(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("listing plans: %w", err)
+	}
+
+	return plans, nil
+}
+
+// CreateSubscription subscribes the stored card to the merchant's plan. The
+// card is charged by the scheduler, see ChargeDueSubscriptions.
+func (a *Service) CreateSubscription(merchantID string, create models.CreateSubscription) (*models.Subscription, error) {
+	merchant, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	if merchant.Status == models.MerchantStatusClosed {
+		return nil, fmt.Errorf("%w: merchant is closed", models.ErrInvalidMerchantStatus)
+	}
+
+	_, err = a.repo.GetPlan(merchantID, create.PlanID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: plan not found", models.ErrValidation)
+		}
+
+		return nil, fmt.Errorf("getting plan: %w", err)
+	}
+
+	_, _, err = a.repo.GetPaymentMethod(merchantID, create.PaymentMethodID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: payment method not found", models.ErrValidation)
+		}
+
+		return nil, fmt.Errorf("getting payment method: %w", err)
+	}
+
+	now := time.Now()
+	startAt := create.StartAt
+	if startAt.IsZero() {
+		startAt = now
+	}
+
+	subscription := &models.Subscription{
+		ID:              uuid.New().String(),
+		MerchantID:      merchantID,
+		PlanID:          create.PlanID,
+		PaymentMethodID: create.PaymentMethodID,
+		Status:          models.SubscriptionStatusActive,
+		NextBillingAt:   startAt,
+		NextAttemptAt:   startAt,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+
+	err = a.repo.CreateSubscription(subscription)
+	if err != nil {
+		return nil, fmt.Errorf("creating subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+func (a *Service) GetSubscription(merchantID, subscriptionID string) (*models.Subscription, error) {
+	subscription, err := a.repo.GetSubscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("getting subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// ListSubscriptions returns the merchant's subscriptions with the given
+// status or all of them if status is empty.
+func (a *Service) ListSubscriptions(merchantID string, status models.SubscriptionStatus) ([]*models.Subscription, error) {
+	_, err := a.repo.GetMerchant(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("getting merchant: %w", err)
+	}
+
+	subscriptions, err := a.repo.ListSubscriptions(merchantID, status)
+	if err != nil {
+		return nil, fmt.Errorf("listing subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+// CancelSubscription stops the payments of the active or past due
+// subscription.
+func (a *Service) CancelSubscription(merchantID, subscriptionID string) (*models.Subscription, error) {
+	subscription, err := a.repo.UpdateSubscription(merchantID, subscriptionID, func(subscription *models.Subscription) error {
+		if !subscription.IsBillable() {
+			return fmt.Errorf("%w: subscription is %s", models.ErrInvalidSubscriptionStatus, subscription.Status)
+		}
+
+		subscription.Status = models.SubscriptionStatusCanceled
+		subscription.UpdatedAt = time.Now()
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("canceling subscription: %w", err)
+	}
+
+	return subscription, nil
+}
+
+// ChargeDueSubscriptions makes the payments of subscriptions which are due
+// at the given time. Payments are made with the stored card and marked as
+// recurring. Declined payments are retried according to the dunning policy
+// unless the card can't be charged anymore. The subscription which can't be
+// charged doesn't stop the others from being charged, the errors of all
+// such subscriptions are returned together. It returns the number of
+// payment attempts.
+func (a *Service) ChargeDueSubscriptions(now time.Time) (int, error) {
+	a.subscriptionsMu.Lock()
+	defer a.subscriptionsMu.Unlock()
+
+	subscriptions, err := a.repo.ListDueSubscriptions(now)
+	if err != nil {
+		return 0, fmt.Errorf("listing due subscriptions: %w", err)
+	}
+
+	var charged int
+	var errs []error
+	for _, subscription := range subscriptions {
+		err = a.chargeSubscription(subscription, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("charging subscription %s: %w", subscription.ID, err))
+
+			continue
+		}
+
+		charged++
+	}
+
+	return charged, errors.Join(errs...)
+}
+
+// chargeSubscription makes the payment of the subscription for its current
+// billing period and schedules the next attempt.
+func (a *Service) chargeSubscription(subscription *models.Subscription, now time.Time) error {
+	plan, err := a.repo.GetPlan(subscription.MerchantID, subscription.PlanID)
+	if err != nil {
+		return fmt.Errorf("getting plan: %w", err)
+	}
+
+	payment, paymentErr := a.CreatePayment(subscription.MerchantID, models.CreatePayment{
+		Amount:           plan.Amount,
+		Currency:         plan.Currency,
+		PaymentMethodID:  subscription.PaymentMethodID,
+		StoredCredential: models.StoredCredentialRecurring,
+	})
+
+	_, err = a.repo.UpdateSubscription(subscription.MerchantID, subscription.ID, func(subscription *models.Subscription) error {
+		subscription.UpdatedAt = now
+
+		if payment != nil {
+			subscription.PaymentIDs = append(subscription.PaymentIDs, payment.ID)
+			subscription.LastApprovalCode = payment.ApprovalCode
+		}
+
+		// the subscription may have been canceled while the payment was
+		// made
+		if !subscription.IsBillable() {
+			return nil
+		}
+
+		switch {
+		case payment != nil && payment.Status == models.PaymentStatusAuthorized:
+			subscription.Status = models.SubscriptionStatusActive
+			subscription.Retries = 0
+			subscription.NextBillingAt = plan.Interval.Next(subscription.NextBillingAt)
+			subscription.NextAttemptAt = subscription.NextBillingAt
+		case errors.Is(paymentErr, ErrNotFound),
+			payment != nil && !models.IsRetryableDecline(payment.ApprovalCode),
+			subscription.Retries >= a.dunningPolicy.MaxRetries:
+			// the payment method was deleted, the card can't be charged
+			// or the retries are exhausted
+			subscription.Status = models.SubscriptionStatusFailed
+		default:
+			// the payment was declined, e.g. for insufficient funds, or
+			// the issuer wasn't reachable
+			subscription.Status = models.SubscriptionStatusPastDue
+			subscription.Retries++
+			subscription.NextAttemptAt = now.Add(a.dunningPolicy.RetryInterval)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("updating subscription: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package acquirer_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alovak/cardflow-playground/acquirer"
+	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/stretchr/testify/require"
+)
+
+// declinedISO8583Client responds to authorizations with the approval codes
+// in order and records the stored credential indicators of the payments
+type declinedISO8583Client struct {
+	iso8583Client
+
+	approvalCodes     []string
+	storedCredentials []models.StoredCredential
+}
+
+func (c *declinedISO8583Client) AuthorizePayment(payment *models.Payment, card models.Card, merchant models.Merchant) (models.AuthorizationResponse, error) {
+	c.storedCredentials = append(c.storedCredentials, payment.StoredCredential)
+
+	approvalCode := c.approvalCodes[0]
+	c.approvalCodes = c.approvalCodes[1:]
+
+	return models.AuthorizationResponse{
+		ApprovalCode:      approvalCode,
+		AuthorizationCode: "123456",
+	}, nil
+}
+
+func TestServiceSubscriptions(t *testing.T) {
+	isoClient := &declinedISO8583Client{}
+	service := acquirer.NewService(acquirer.NewRepository(), isoClient)
+	service.SetDunningPolicy(models.DunningPolicy{
+		RetryInterval: time.Hour,
+		MaxRetries:    2,
+	})
+
+	merchant, err := service.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+	})
+	require.NoError(t, err)
+
+	plan, err := service.CreatePlan(merchant.ID, models.CreatePlan{
+		Name:     "Monthly",
+		Amount:   9_99,
+		Currency: "USD",
+		Interval: models.PlanIntervalMonth,
+	})
+	require.NoError(t, err)
+
+	method, err := service.CreatePaymentMethod(merchant.ID, models.CreatePaymentMethod{
+		Card: models.Card{
+			Number:         "9000000000000001",
+			ExpirationDate: "1230",
+		},
+	})
+	require.NoError(t, err)
+
+	start := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	subscribe := func() *models.Subscription {
+		subscription, err := service.CreateSubscription(merchant.ID, models.CreateSubscription{
+			PlanID:          plan.ID,
+			PaymentMethodID: method.ID,
+			StartAt:         start,
+		})
+		require.NoError(t, err)
+
+		return subscription
+	}
+
+	t.Run("plan and payment method of another merchant", func(t *testing.T) {
+		_, err := service.CreateSubscription(merchant.ID, models.CreateSubscription{
+			PlanID:          "unknown",
+			PaymentMethodID: method.ID,
+		})
+		require.ErrorIs(t, err, models.ErrValidation)
+
+		_, err = service.CreateSubscription(merchant.ID, models.CreateSubscription{
+			PlanID:          plan.ID,
+			PaymentMethodID: "unknown",
+		})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+
+	t.Run("subscription is charged every period", func(t *testing.T) {
+		subscription := subscribe()
+		isoClient.approvalCodes = []string{"00", "00"}
+		isoClient.storedCredentials = nil
+
+		// nothing is due before the start
+		charged, err := service.ChargeDueSubscriptions(start.Add(-time.Minute))
+		require.NoError(t, err)
+		require.Zero(t, charged)
+
+		charged, err = service.ChargeDueSubscriptions(start)
+		require.NoError(t, err)
+		require.Equal(t, 1, charged)
+		require.Equal(t, models.SubscriptionStatusActive, subscription.Status)
+		require.Equal(t, start.AddDate(0, 1, 0), subscription.NextBillingAt)
+		require.Len(t, subscription.PaymentIDs, 1)
+
+		payment, err := service.GetPayment(merchant.ID, subscription.PaymentIDs[0])
+		require.NoError(t, err)
+		require.Equal(t, plan.Amount, payment.Amount)
+		require.Equal(t, models.StoredCredentialRecurring, payment.StoredCredential)
+
+		// the period is paid only once
+		charged, err = service.ChargeDueSubscriptions(start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Zero(t, charged)
+
+		_, err = service.ChargeDueSubscriptions(start.AddDate(0, 1, 0))
+		require.NoError(t, err)
+		require.Len(t, subscription.PaymentIDs, 2)
+		require.Equal(t, []models.StoredCredential{models.StoredCredentialRecurring, models.StoredCredentialRecurring}, isoClient.storedCredentials)
+
+		_, err = service.CancelSubscription(merchant.ID, subscription.ID)
+		require.NoError(t, err)
+
+		_, err = service.CancelSubscription(merchant.ID, subscription.ID)
+		require.ErrorIs(t, err, models.ErrInvalidSubscriptionStatus)
+	})
+
+	t.Run("insufficient funds are retried", func(t *testing.T) {
+		subscription := subscribe()
+		isoClient.approvalCodes = []string{"51", "51", "00"}
+
+		_, err := service.ChargeDueSubscriptions(start)
+		require.NoError(t, err)
+		require.Equal(t, models.SubscriptionStatusPastDue, subscription.Status)
+		require.Equal(t, "51", subscription.LastApprovalCode)
+		require.Equal(t, start.Add(time.Hour), subscription.NextAttemptAt)
+
+		_, err = service.ChargeDueSubscriptions(start.Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, models.SubscriptionStatusPastDue, subscription.Status)
+		require.Equal(t, 2, subscription.Retries)
+
+		// the period keeps its billing date when it's paid late
+		_, err = service.ChargeDueSubscriptions(start.Add(2 * time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, models.SubscriptionStatusActive, subscription.Status)
+		require.Zero(t, subscription.Retries)
+		require.Equal(t, start.AddDate(0, 1, 0), subscription.NextAttemptAt)
+
+		_, err = service.CancelSubscription(merchant.ID, subscription.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("subscription fails when retries are exhausted", func(t *testing.T) {
+		subscription := subscribe()
+		isoClient.approvalCodes = []string{"51", "51", "51"}
+
+		for i := 0; i < 3; i++ {
+			_, err := service.ChargeDueSubscriptions(start.Add(time.Duration(i) * time.Hour))
+			require.NoError(t, err)
+		}
+
+		require.Equal(t, models.SubscriptionStatusFailed, subscription.Status)
+		require.Len(t, subscription.PaymentIDs, 3)
+	})
+
+	t.Run("invalid card is not retried", func(t *testing.T) {
+		subscription := subscribe()
+		isoClient.approvalCodes = []string{"14"}
+
+		_, err := service.ChargeDueSubscriptions(start)
+		require.NoError(t, err)
+		require.Equal(t, models.SubscriptionStatusFailed, subscription.Status)
+		require.Equal(t, "14", subscription.LastApprovalCode)
+
+		// failed subscriptions are not charged anymore
+		charged, err := service.ChargeDueSubscriptions(start.AddDate(1, 0, 0))
+		require.NoError(t, err)
+		require.Zero(t, charged)
+	})
+}
@@ -0,0 +1,92 @@
+package acquirer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/alovak/cardflow-playground/acquirer/models"
+)
+
+// CardVault encrypts cards of payment methods with AES-GCM, so card numbers
+// are never stored in plain text.
+type CardVault struct {
+	aead cipher.AEAD
+}
+
+// NewCardVault creates the vault with the 32 bytes AES-256 key.
+func NewCardVault(key []byte) (*CardVault, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	return &CardVault{aead: aead}, nil
+}
+
+// newRandomCardVault creates the vault with a random key. Cards encrypted
+// by it can't be decrypted after the restart, which is fine for the in
+// memory repository.
+func newRandomCardVault() *CardVault {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		panic(fmt.Sprintf("generating card vault key: %v", err))
+	}
+
+	vault, err := NewCardVault(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return vault
+}
+
+// Encrypt encrypts the card, the ID of the payment method is authenticated
+// with it, so the encrypted card can't be used for another payment method.
+func (v *CardVault) Encrypt(paymentMethodID string, card models.Card) ([]byte, error) {
+	plaintext, err := json.Marshal(card)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling card: %w", err)
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	return v.aead.Seal(nonce, nonce, plaintext, []byte(paymentMethodID)), nil
+}
+
+// Decrypt decrypts the card of the payment method.
+func (v *CardVault) Decrypt(paymentMethodID string, ciphertext []byte) (models.Card, error) {
+	if len(ciphertext) < v.aead.NonceSize() {
+		return models.Card{}, fmt.Errorf("ciphertext is too short")
+	}
+
+	nonce, ciphertext := ciphertext[:v.aead.NonceSize()], ciphertext[v.aead.NonceSize():]
+
+	plaintext, err := v.aead.Open(nil, nonce, ciphertext, []byte(paymentMethodID))
+	if err != nil {
+		return models.Card{}, fmt.Errorf("decrypting card: %w", err)
+	}
+
+	var card models.Card
+	err = json.Unmarshal(plaintext, &card)
+	if err != nil {
+		return models.Card{}, fmt.Errorf("unmarshaling card: %w", err)
+	}
+
+	return card, nil
+}
@@ -1,17 +1,39 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/alovak/cardflow-playground/acquirer"
+	"github.com/alovak/cardflow-playground/acquirer/iso8583"
 	"github.com/alovak/cardflow-playground/log"
+	"golang.org/x/exp/slog"
 )
 
 func main() {
+	config := acquirer.DefaultConfig()
+	flag.StringVar(&config.HSMMasterKeyFile, "hsm-master-key", "", "file with the local master key the HSM keys are encrypted under")
+	flag.StringVar(&config.HSMKeyStoreFile, "hsm-key-store", "", "file the encrypted HSM keys are stored in")
+	flag.StringVar(&config.AdminAPIKey, "admin-api-key", "", "API key of the admin allowed to manage the merchants, the random key is generated and logged when it's not set")
+	tlsConfig := iso8583.TLSConfig{}
+	tls := flag.Bool("tls", false, "connect to the issuer over TLS")
+	flag.StringVar(&tlsConfig.CAFile, "tls-ca", "", "PEM file with the CA certificates the issuer certificate is verified with")
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "PEM file with the client certificate of the acquirer")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "PEM file with the key of the client certificate")
+	debug := flag.Bool("debug", false, "log the redacted dumps of the ISO 8583 messages")
+	flag.Parse()
+
+	if *tls || tlsConfig.CAFile != "" || tlsConfig.CertFile != "" {
+		config.ISO8583TLS = &tlsConfig
+	}
+
 	logger := log.New()
-	app := acquirer.NewApp(logger, acquirer.DefaultConfig())
+	if *debug {
+		logger = log.NewWithLevel(slog.LevelDebug)
+	}
+	app := acquirer.NewApp(logger, config)
 
 	err := app.Start()
 	if err != nil {
@@ -1,17 +1,44 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/alovak/cardflow-playground/issuer"
+	issuer8583 "github.com/alovak/cardflow-playground/issuer/iso8583"
 	"github.com/alovak/cardflow-playground/log"
+	"golang.org/x/exp/slog"
 )
 
 func main() {
+	config := issuer.DefaultConfig()
+	flag.StringVar(&config.HSMMasterKeyFile, "hsm-master-key", "", "file with the local master key the HSM keys are encrypted under")
+	flag.StringVar(&config.HSMKeyStoreFile, "hsm-key-store", "", "file the encrypted HSM keys are stored in")
+	flag.StringVar(&config.CardKeyFile, "card-key", "", "file with the key encryption key the card numbers are encrypted with")
+	tlsConfig := issuer8583.TLSConfig{}
+	flag.StringVar(&tlsConfig.CertFile, "tls-cert", "", "PEM file with the certificate of the ISO 8583 server, TLS is enabled when it's set")
+	flag.StringVar(&tlsConfig.KeyFile, "tls-key", "", "PEM file with the key of the ISO 8583 server certificate")
+	flag.StringVar(&tlsConfig.ClientCAFile, "tls-client-ca", "", "PEM file with the CA certificates the client certificates of the acquirers are verified with")
+	knownAcquirers := flag.String("known-acquirers", "", "comma separated common names of the client certificates of the known acquirers")
+	debug := flag.Bool("debug", false, "log the redacted dumps of the ISO 8583 messages")
+	flag.Parse()
+
+	if tlsConfig.CertFile != "" {
+		if *knownAcquirers != "" {
+			tlsConfig.KnownAcquirers = strings.Split(*knownAcquirers, ",")
+		}
+
+		config.ISO8583TLS = &tlsConfig
+	}
+
 	logger := log.New()
-	app := issuer.NewApp(logger, issuer.DefaultConfig())
+	if *debug {
+		logger = log.NewWithLevel(slog.LevelDebug)
+	}
+	app := issuer.NewApp(logger, config)
 
 	err := app.Start()
 	if err != nil {
@@ -2,13 +2,18 @@ package main_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/alovak/cardflow-playground/acquirer"
 	acquirerClient "github.com/alovak/cardflow-playground/acquirer/client"
+	acquirerISO8583 "github.com/alovak/cardflow-playground/acquirer/iso8583"
 	"github.com/alovak/cardflow-playground/acquirer/models"
+	"github.com/alovak/cardflow-playground/internal/testcerts"
 	"github.com/alovak/cardflow-playground/issuer"
 	issuerClient "github.com/alovak/cardflow-playground/issuer/client"
+	issuer8583 "github.com/alovak/cardflow-playground/issuer/iso8583"
 	issuerModels "github.com/alovak/cardflow-playground/issuer/models"
 	"github.com/alovak/cardflow-playground/log"
 	"github.com/stretchr/testify/require"
@@ -21,18 +26,23 @@ func TestEndToEndTransaction(t *testing.T) {
 
 	// configure the issuer client
 	issuerClient := issuerClient.New(issuerBasePath)
-	acquirerClient := acquirerClient.New(acquirerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	// Given: Create a verified customer with an account with $100 balance
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
 
-	// Given: Create an account with $100 balance
 	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
-		Balance:  100_00, // $100
-		Currency: "USD",
+		CustomerID: customer.ID,
+		Balance:    100_00, // $100
+		Currency:   "USD",
 	})
 	require.NoError(t, err)
 
-	// Issue a card for the account
+	// Issue a card for the account with the customer's name embossed on it
 	card, err := issuerClient.IssueCard(accountID)
 	require.NoError(t, err)
+	require.Equal(t, "JOHN DOE", card.CardholderName)
 
 	// Given: Create a new merchant for the acquirer
 	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
@@ -43,6 +53,12 @@ func TestEndToEndTransaction(t *testing.T) {
 	})
 	require.NoError(t, err)
 
+	// Add a terminal the merchant accepts payments with
+	terminal, err := acquirerClient.CreateTerminal(merchant.ID, models.CreateTerminal{
+		Description: "Checkout #1",
+	})
+	require.NoError(t, err)
+
 	// When: Acquirer receives the payment request for the merchant with the issued card
 	payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
 		Card: models.Card{
@@ -50,8 +66,9 @@ func TestEndToEndTransaction(t *testing.T) {
 			CardVerificationValue: card.CardVerificationValue,
 			ExpirationDate:        card.ExpirationDate,
 		},
-		Amount:   10_00, // $10
-		Currency: "USD",
+		Amount:     10_00, // $10
+		Currency:   "USD",
+		TerminalID: terminal.ID,
 	})
 	require.NoError(t, err)
 
@@ -60,6 +77,15 @@ func TestEndToEndTransaction(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
 
+	// and the payment can be found by the card's last 4 digits
+	payments, err := acquirerClient.ListPayments(merchant.ID, models.PaymentFilter{
+		CardLast4: card.Number[len(card.Number)-4:],
+		Status:    models.PaymentStatusAuthorized,
+	})
+	require.NoError(t, err)
+	require.Len(t, payments.Payments, 1)
+	require.Equal(t, payment.ID, payments.Payments[0].ID)
+
 	// In the issuer, there should be an authorized transaction for the card
 	transactions, err := issuerClient.GetTransactions(accountID)
 	require.NoError(t, err)
@@ -78,6 +104,19 @@ func TestEndToEndTransaction(t *testing.T) {
 	require.Equal(t, merchant.MCC, transactions[0].Merchant.MCC)
 	require.Equal(t, merchant.PostalCode, transactions[0].Merchant.PostalCode)
 	require.Equal(t, merchant.WebSite, transactions[0].Merchant.WebSite)
+	require.Equal(t, terminal.ID, transactions[0].Merchant.TerminalID)
+
+	// the transaction can be found by the card and the merchant name
+	cardTransactions, err := issuerClient.ListCardTransactions(card.ID, issuerModels.TransactionFilter{
+		MerchantName: strings.ToLower(merchant.Name),
+	})
+	require.NoError(t, err)
+	require.Len(t, cardTransactions.Transactions, 1)
+	require.Equal(t, transactions[0].ID, cardTransactions.Transactions[0].ID)
+
+	transaction, err := issuerClient.GetTransaction(transactions[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, payment.RetrievalReferenceNumber, transaction.RetrievalReferenceNumber)
 
 	// Account's available balance should be less by the transaction amount
 	account, err := issuerClient.GetAccount(accountID)
@@ -87,10 +126,41 @@ func TestEndToEndTransaction(t *testing.T) {
 	require.Equal(t, int64(10_00), account.HoldBalance)
 }
 
+// verifiedCustomer passes the KYC verification of the issuer
+var verifiedCustomer = issuerModels.CreateCustomer{
+	FirstName:   "John",
+	LastName:    "Doe",
+	DateOfBirth: "1990-01-01",
+	Address: issuerModels.Address{
+		Line1:      "1 Main St",
+		City:       "Springfield",
+		PostalCode: "12345",
+		Country:    "US",
+	},
+}
+
+// testZonePINKey is shared by the issuer and the acquirer, PIN blocks are
+// encrypted under it
+const testZonePINKey = "89ABCDEF0123456776543210FEDCBA98"
+
+// testMACKey is shared by the issuer and the acquirer, ISO 8583 requests
+// are MACed with it
+const testMACKey = "FEDCBA98765432100123456789ABCDEF"
+
+// testZoneMasterKey is shared by the issuer and the acquirer, the working
+// keys are exchanged under it
+const testZoneMasterKey = "76543210FEDCBA9889ABCDEF01234567"
+
+// testAdminAPIKey is the key the acquirer API is called with by the admin
+const testAdminAPIKey = "ak_admin_test"
+
 func setupIssuer(t *testing.T) (string, string) {
 	app := issuer.NewApp(log.New(), &issuer.Config{
-		HTTPAddr:    "127.0.0.1:0", // use random port
-		ISO8583Addr: "127.0.0.1:0", // use random port
+		HTTPAddr:      "127.0.0.1:0", // use random port
+		ISO8583Addr:   "127.0.0.1:0", // use random port
+		ZonePINKey:    testZonePINKey,
+		MACKey:        testMACKey,
+		ZoneMasterKey: testZoneMasterKey,
 	})
 	err := app.Start()
 	require.NoError(t, err)
@@ -103,8 +173,12 @@ func setupIssuer(t *testing.T) (string, string) {
 
 func setupAcquirer(t *testing.T, iso8583ServerAddr string) string {
 	app := acquirer.NewApp(log.New(), &acquirer.Config{
-		HTTPAddr:    "127.0.0.1:0", // use random port
-		ISO8583Addr: iso8583ServerAddr,
+		HTTPAddr:      "127.0.0.1:0", // use random port
+		ISO8583Addr:   iso8583ServerAddr,
+		ZonePINKey:    testZonePINKey,
+		MACKey:        testMACKey,
+		ZoneMasterKey: testZoneMasterKey,
+		AdminAPIKey:   testAdminAPIKey,
 	})
 	err := app.Start()
 	require.NoError(t, err)
@@ -114,3 +188,875 @@ func setupAcquirer(t *testing.T, iso8583ServerAddr string) string {
 
 	return fmt.Sprintf("http://%s", app.Addr)
 }
+
+func TestDisputeLifecycle(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+		WebSite:    "https://demo.merchant.com",
+	})
+	require.NoError(t, err)
+
+	// authorizePayment creates an account with $100 balance, issues a card
+	// and makes a $10 payment with it
+	authorizePayment := func(t *testing.T) (string, models.Payment, issuerModels.Transaction) {
+		customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+		require.NoError(t, err)
+
+		accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+			CustomerID: customer.ID,
+			Balance:    100_00,
+			Currency:   "USD",
+		})
+		require.NoError(t, err)
+
+		card, err := issuerClient.IssueCard(accountID)
+		require.NoError(t, err)
+
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:                card.Number,
+				CardVerificationValue: card.CardVerificationValue,
+				ExpirationDate:        card.ExpirationDate,
+			},
+			Amount:   10_00,
+			Currency: "USD",
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+		require.NotEmpty(t, payment.RetrievalReferenceNumber)
+
+		transactions, err := issuerClient.GetTransactions(accountID)
+		require.NoError(t, err)
+		require.Len(t, transactions, 1)
+		require.Equal(t, payment.RetrievalReferenceNumber, transactions[0].RetrievalReferenceNumber)
+
+		return accountID, payment, transactions[0]
+	}
+
+	t.Run("cardholder wins in pre-arbitration", func(t *testing.T) {
+		accountID, payment, transaction := authorizePayment(t)
+
+		// When: cardholder disputes the transaction
+		dispute, err := issuerClient.OpenDispute(transaction.ID, issuerModels.CreateDispute{
+			ReasonCode: "4855",
+			Message:    "goods were not delivered",
+		})
+		require.NoError(t, err)
+		require.Equal(t, issuerModels.DisputeStatusChargeback, dispute.Status)
+
+		// Then: cardholder gets provisional credit
+		account, err := issuerClient.GetAccount(accountID)
+		require.NoError(t, err)
+		require.Equal(t, int64(100_00), account.AvailableBalance)
+		require.Equal(t, int64(0), account.HoldBalance)
+
+		// and acquirer withholds the disputed amount from the merchant
+		payment, err = acquirerClient.GetPayment(merchant.ID, payment.ID)
+		require.NoError(t, err)
+		require.NotNil(t, payment.Dispute)
+		require.Equal(t, dispute.ID, payment.Dispute.ID)
+		require.Equal(t, models.DisputeStatusChargeback, payment.Dispute.Status)
+		require.Equal(t, "4855", payment.Dispute.ReasonCode)
+		require.Equal(t, int64(10_00), payment.Dispute.Amount)
+
+		m, err := acquirerClient.GetMerchant(merchant.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(10_00), m.DisputedAmount)
+
+		// When: merchant challenges the chargeback
+		payment, err = acquirerClient.SubmitRepresentment(merchant.ID, payment.ID, models.CreateRepresentment{
+			Evidence: "tracking number 1Z999",
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.DisputeStatusRepresentment, payment.Dispute.Status)
+
+		dispute, err = issuerClient.GetDispute(dispute.ID)
+		require.NoError(t, err)
+		require.Equal(t, issuerModels.DisputeStatusRepresentment, dispute.Status)
+		require.Equal(t, "tracking number 1Z999", dispute.Evidence)
+
+		// When: issuer escalates the dispute
+		dispute, err = issuerClient.StartPreArbitration(dispute.ID, issuerModels.EscalateDispute{
+			Message: "package was delivered to a wrong address",
+		})
+		require.NoError(t, err)
+		require.Equal(t, issuerModels.DisputeStatusPreArbitration, dispute.Status)
+
+		payment, err = acquirerClient.GetPayment(merchant.ID, payment.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.DisputeStatusPreArbitration, payment.Dispute.Status)
+
+		// When: merchant accepts the pre-arbitration
+		payment, err = acquirerClient.AcceptDispute(merchant.ID, payment.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.DisputeStatusLost, payment.Dispute.Status)
+
+		// Then: dispute is resolved in favor of the cardholder
+		dispute, err = issuerClient.GetDispute(dispute.ID)
+		require.NoError(t, err)
+		require.Equal(t, issuerModels.DisputeStatusWon, dispute.Status)
+
+		account, err = issuerClient.GetAccount(accountID)
+		require.NoError(t, err)
+		require.Equal(t, int64(100_00), account.AvailableBalance)
+		require.Equal(t, int64(0), account.HoldBalance)
+
+		m, err = acquirerClient.GetMerchant(merchant.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), m.DisputedAmount)
+		require.Equal(t, int64(10_00), m.ChargedBackAmount)
+	})
+
+	t.Run("merchant wins with representment", func(t *testing.T) {
+		accountID, payment, transaction := authorizePayment(t)
+
+		dispute, err := issuerClient.OpenDispute(transaction.ID, issuerModels.CreateDispute{
+			ReasonCode: "4837",
+		})
+		require.NoError(t, err)
+
+		// the same transaction can't be disputed twice
+		_, err = issuerClient.OpenDispute(transaction.ID, issuerModels.CreateDispute{
+			ReasonCode: "4837",
+		})
+		require.Error(t, err)
+
+		_, err = acquirerClient.SubmitRepresentment(merchant.ID, payment.ID, models.CreateRepresentment{
+			Evidence: "signed receipt",
+		})
+		require.NoError(t, err)
+
+		// When: issuer accepts the representment
+		dispute, err = issuerClient.AcceptRepresentment(dispute.ID)
+		require.NoError(t, err)
+		require.Equal(t, issuerModels.DisputeStatusLost, dispute.Status)
+
+		// Then: funds are held again
+		account, err := issuerClient.GetAccount(accountID)
+		require.NoError(t, err)
+		require.Equal(t, int64(100_00-10_00), account.AvailableBalance)
+		require.Equal(t, int64(10_00), account.HoldBalance)
+
+		payment, err = acquirerClient.GetPayment(merchant.ID, payment.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.DisputeStatusWon, payment.Dispute.Status)
+
+		m, err := acquirerClient.GetMerchant(merchant.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), m.DisputedAmount)
+		// charged back amount is left from the previous dispute
+		require.Equal(t, int64(10_00), m.ChargedBackAmount)
+	})
+}
+
+func TestIncrementalAuthorization(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Hotel",
+		MCC:        "7011",
+		PostalCode: "12345",
+		WebSite:    "https://demo.hotel.com",
+	})
+	require.NoError(t, err)
+
+	// the hotel authorizes the first night
+	payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+		Card: models.Card{
+			Number:                card.Number,
+			CardVerificationValue: card.CardVerificationValue,
+			ExpirationDate:        card.ExpirationDate,
+		},
+		Amount:   40_00,
+		Currency: "USD",
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	// the guest extends the stay
+	payment, err = acquirerClient.IncrementPayment(merchant.ID, payment.ID, models.IncrementPayment{
+		Amount: 40_00,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(80_00), payment.Amount)
+	require.Len(t, payment.Increments, 1)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Increments[0].Status)
+
+	// the next increment exceeds the available balance
+	payment, err = acquirerClient.IncrementPayment(merchant.ID, payment.ID, models.IncrementPayment{
+		Amount: 40_00,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(80_00), payment.Amount)
+	require.Len(t, payment.Increments, 2)
+	require.Equal(t, models.PaymentStatusDeclined, payment.Increments[1].Status)
+	require.Equal(t, "51", payment.Increments[1].ApprovalCode)
+
+	// the issuer extended the hold of the same transaction
+	transactions, err := issuerClient.GetTransactions(accountID)
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+	require.Equal(t, int64(80_00), transactions[0].Amount)
+	require.Len(t, transactions[0].Increments, 2)
+	require.Equal(t, issuerModels.DeclineReasonInsufficientFunds, transactions[0].Increments[1].DeclineReason)
+
+	account, err := issuerClient.GetAccount(accountID)
+	require.NoError(t, err)
+	require.Equal(t, int64(20_00), account.AvailableBalance)
+	require.Equal(t, int64(80_00), account.HoldBalance)
+}
+
+func TestPartialApproval(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	// a prepaid account with $30 left
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    30_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+		WebSite:    "https://demo.merchant.com",
+	})
+	require.NoError(t, err)
+
+	createPayment := func(partialApprovalSupported bool) models.Payment {
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:                card.Number,
+				CardVerificationValue: card.CardVerificationValue,
+				ExpirationDate:        card.ExpirationDate,
+			},
+			Amount:                   50_00,
+			Currency:                 "USD",
+			PartialApprovalSupported: partialApprovalSupported,
+		})
+		require.NoError(t, err)
+
+		return payment
+	}
+
+	// without partial approval support the payment is declined
+	payment := createPayment(false)
+	require.Equal(t, models.PaymentStatusDeclined, payment.Status)
+
+	// with partial approval support the available balance is approved
+	payment = createPayment(true)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+	require.Equal(t, int64(50_00), payment.RequestedAmount)
+	require.Equal(t, int64(30_00), payment.Amount)
+
+	list, err := issuerClient.ListTransactions(accountID, issuerModels.TransactionFilter{
+		Status: issuerModels.TransactionStatusAuthorized,
+	})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 1)
+	require.Equal(t, issuerModels.ApprovalCodePartiallyApproved, list.Transactions[0].ApprovalCode)
+	require.Equal(t, int64(50_00), list.Transactions[0].RequestedAmount)
+	require.Equal(t, int64(30_00), list.Transactions[0].Amount)
+
+	account, err := issuerClient.GetAccount(accountID)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), account.AvailableBalance)
+	require.Equal(t, int64(30_00), account.HoldBalance)
+}
+
+func TestBalanceInquiry(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo ATM",
+		MCC:        "6011",
+		PostalCode: "12345",
+	})
+	require.NoError(t, err)
+
+	acquirerCard := models.Card{
+		Number:                card.Number,
+		CardVerificationValue: card.CardVerificationValue,
+		ExpirationDate:        card.ExpirationDate,
+	}
+
+	// put $10 on hold
+	payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+		Card:     acquirerCard,
+		Amount:   10_00,
+		Currency: "USD",
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	inquiry, err := acquirerClient.InquireBalance(merchant.ID, models.CreateBalanceInquiry{
+		Card: acquirerCard,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "00", inquiry.ApprovalCode)
+	require.Equal(t, "USD", inquiry.Currency)
+	require.Equal(t, int64(90_00), inquiry.AvailableBalance)
+	require.Equal(t, int64(100_00), inquiry.LedgerBalance)
+
+	// the inquiry doesn't hold funds or create transactions
+	account, err := issuerClient.GetAccount(accountID)
+	require.NoError(t, err)
+	require.Equal(t, int64(90_00), account.AvailableBalance)
+	require.Equal(t, int64(10_00), account.HoldBalance)
+
+	transactions, err := issuerClient.GetTransactions(accountID)
+	require.NoError(t, err)
+	require.Len(t, transactions, 1)
+
+	// balances are not returned for invalid card details
+	acquirerCard.CardVerificationValue = "0000"
+	inquiry, err = acquirerClient.InquireBalance(merchant.ID, models.CreateBalanceInquiry{
+		Card: acquirerCard,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "14", inquiry.ApprovalCode)
+	require.Zero(t, inquiry.AvailableBalance)
+}
+
+func TestTokenizedPayment(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	// the token is provisioned for the wallet
+	token, err := issuerClient.ProvisionToken(card.ID, issuerModels.CreateToken{
+		Domain: issuerModels.TokenDomain{TokenRequestorID: "40010030273"},
+	})
+	require.NoError(t, err)
+	require.True(t, issuerModels.IsTokenNumber(token.Number))
+	require.NotEqual(t, card.Number, token.Number)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+		WebSite:    "https://demo.merchant.com",
+	})
+	require.NoError(t, err)
+
+	createPayment := func(tokenRequestorID string) models.Payment {
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:         token.Number,
+				ExpirationDate: token.ExpirationDate,
+			},
+			Amount:           10_00,
+			Currency:         "USD",
+			TokenRequestorID: tokenRequestorID,
+		})
+		require.NoError(t, err)
+
+		return payment
+	}
+
+	// the token is used outside of its domain
+	payment := createPayment("")
+	require.Equal(t, models.PaymentStatusDeclined, payment.Status)
+
+	payment = createPayment("40010030273")
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	list, err := issuerClient.ListCardTransactions(card.ID, issuerModels.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 1)
+	require.Equal(t, token.ID, list.Transactions[0].TokenID)
+
+	// the suspended token is declined until it's resumed
+	_, err = issuerClient.SuspendToken(token.ID)
+	require.NoError(t, err)
+
+	payment = createPayment("40010030273")
+	require.Equal(t, models.PaymentStatusDeclined, payment.Status)
+
+	_, err = issuerClient.ResumeToken(token.ID)
+	require.NoError(t, err)
+
+	payment = createPayment("40010030273")
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	// the deleted token can't be resumed
+	_, err = issuerClient.DeleteToken(token.ID)
+	require.NoError(t, err)
+
+	_, err = issuerClient.ResumeToken(token.ID)
+	require.Error(t, err)
+
+	payment = createPayment("40010030273")
+	require.Equal(t, models.PaymentStatusDeclined, payment.Status)
+}
+
+func TestStoredCardPayment(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	createMerchant := func() models.Merchant {
+		merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+			Name:       "Demo Merchant",
+			MCC:        "5411",
+			PostalCode: "12345",
+			WebSite:    "https://demo.merchant.com",
+		})
+		require.NoError(t, err)
+
+		return merchant
+	}
+
+	merchant := createMerchant()
+
+	// the customer saves the card, the CVV is not stored
+	method, err := acquirerClient.CreatePaymentMethod(merchant.ID, models.CreatePaymentMethod{
+		Card: models.Card{
+			Number:                card.Number,
+			ExpirationDate:        card.ExpirationDate,
+			CardVerificationValue: card.CardVerificationValue,
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, card.Number[:6], method.Card.First6)
+	require.Equal(t, card.Number[len(card.Number)-4:], method.Card.Last4)
+
+	methods, err := acquirerClient.ListPaymentMethods(merchant.ID)
+	require.NoError(t, err)
+	require.Len(t, methods, 1)
+
+	createPayment := func(merchantID string, storedCredential models.StoredCredential, cvv string) (models.Payment, error) {
+		return acquirerClient.CreatePayment(merchantID, models.CreatePayment{
+			PaymentMethodID:  method.ID,
+			StoredCredential: storedCredential,
+			Card:             models.Card{CardVerificationValue: cvv},
+			Amount:           10_00,
+			Currency:         "USD",
+		})
+	}
+
+	// the customer enters the CVV to pay with the saved card
+	payment, err := createPayment(merchant.ID, "", "")
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusDeclined, payment.Status)
+
+	payment, err = createPayment(merchant.ID, "", card.CardVerificationValue)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+	require.Equal(t, method.ID, payment.PaymentMethodID)
+	require.Equal(t, models.StoredCredentialCustomerInitiated, payment.StoredCredential)
+
+	// the merchant charges the saved card without the customer
+	payment, err = createPayment(merchant.ID, models.StoredCredentialMerchantInitiated, "")
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	list, err := issuerClient.ListCardTransactions(card.ID, issuerModels.TransactionFilter{
+		Status: issuerModels.TransactionStatusAuthorized,
+	})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 2)
+
+	storedCredentials := []issuerModels.StoredCredential{
+		list.Transactions[0].StoredCredential,
+		list.Transactions[1].StoredCredential,
+	}
+	require.ElementsMatch(t, []issuerModels.StoredCredential{
+		issuerModels.StoredCredentialCustomerInitiated,
+		issuerModels.StoredCredentialMerchantInitiated,
+	}, storedCredentials)
+
+	// payment methods are scoped to the merchant
+	_, err = createPayment(createMerchant().ID, "", card.CardVerificationValue)
+	require.Error(t, err)
+
+	// the deleted payment method can't be used
+	err = acquirerClient.DeletePaymentMethod(merchant.ID, method.ID)
+	require.NoError(t, err)
+
+	_, err = createPayment(merchant.ID, "", card.CardVerificationValue)
+	require.Error(t, err)
+}
+
+func TestPINPayment(t *testing.T) {
+	issuerBasePath, iso8583ServerAddr := setupIssuer(t)
+	acquirerBasePath := setupAcquirer(t, iso8583ServerAddr)
+
+	issuerClient := issuerClient.New(issuerBasePath)
+	acquirerClient := acquirerClient.New(acquirerBasePath, testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+	})
+	require.NoError(t, err)
+
+	createPayment := func(pin string) models.Payment {
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:         card.Number,
+				ExpirationDate: card.ExpirationDate,
+				PIN:            pin,
+			},
+			Amount:   10_00,
+			Currency: "USD",
+		})
+		require.NoError(t, err)
+
+		return payment
+	}
+
+	// the PIN is not set yet
+	payment := createPayment("1234")
+	require.Equal(t, "55", payment.ApprovalCode)
+
+	err = issuerClient.SetPIN(card.ID, "1234")
+	require.NoError(t, err)
+
+	payment = createPayment("1234")
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+	// the card is blocked after 3 wrong PINs in a row
+	payment = createPayment("0000")
+	require.Equal(t, "55", payment.ApprovalCode)
+
+	payment = createPayment("0000")
+	require.Equal(t, "55", payment.ApprovalCode)
+
+	payment = createPayment("0000")
+	require.Equal(t, "75", payment.ApprovalCode)
+
+	// the blocked card declines even the right PIN
+	payment = createPayment("1234")
+	require.Equal(t, "62", payment.ApprovalCode)
+
+	list, err := issuerClient.ListCardTransactions(card.ID, issuerModels.TransactionFilter{})
+	require.NoError(t, err)
+	require.Equal(t, issuerModels.DeclineReasonCardBlocked, list.Transactions[0].DeclineReason)
+
+	// the new PIN unblocks the card
+	err = issuerClient.SetPIN(card.ID, "4321")
+	require.NoError(t, err)
+
+	payment = createPayment("4321")
+	require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+}
+
+func TestRequestsWithInvalidMACAreRejected(t *testing.T) {
+	_, iso8583ServerAddr := setupIssuer(t)
+
+	for name, macKey := range map[string]string{
+		"without MAC":      "",
+		"with invalid MAC": "0123456789ABCDEFFEDCBA9876543210",
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := acquirer.NewApp(log.New(), &acquirer.Config{
+				HTTPAddr:    "127.0.0.1:0",
+				ISO8583Addr: iso8583ServerAddr,
+				ZonePINKey:  testZonePINKey,
+				MACKey:      macKey,
+			})
+
+			// the sign on request is rejected with the security violation
+			// response code
+			err := app.Start()
+			require.ErrorContains(t, err, "sign on declined with code: 63")
+		})
+	}
+}
+
+func TestKeyExchange(t *testing.T) {
+	// the issuer and the acquirer start with different zone PIN keys, the
+	// acquirer gets the key of the issuer when it signs on and then the
+	// keys are rotated by the issuer
+	issuerApp := issuer.NewApp(log.New(), &issuer.Config{
+		HTTPAddr:            "127.0.0.1:0",
+		ISO8583Addr:         "127.0.0.1:0",
+		ZonePINKey:          testZonePINKey,
+		MACKey:              testMACKey,
+		ZoneMasterKey:       testZoneMasterKey,
+		KeyRotationInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, issuerApp.Start())
+	t.Cleanup(issuerApp.Shutdown)
+
+	acquirerApp := acquirer.NewApp(log.New(), &acquirer.Config{
+		HTTPAddr:      "127.0.0.1:0",
+		ISO8583Addr:   issuerApp.ISO8583ServerAddr,
+		ZonePINKey:    "0123456789ABCDEFFEDCBA9876543210",
+		MACKey:        testMACKey,
+		ZoneMasterKey: testZoneMasterKey,
+		AdminAPIKey:   testAdminAPIKey,
+	})
+	require.NoError(t, acquirerApp.Start())
+	t.Cleanup(acquirerApp.Shutdown)
+
+	issuerClient := issuerClient.New(fmt.Sprintf("http://%s", issuerApp.Addr))
+	acquirerClient := acquirerClient.New(fmt.Sprintf("http://%s", acquirerApp.Addr), testAdminAPIKey)
+
+	customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+	require.NoError(t, err)
+
+	accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+		CustomerID: customer.ID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := issuerClient.IssueCard(accountID)
+	require.NoError(t, err)
+
+	err = issuerClient.SetPIN(card.ID, "1234")
+	require.NoError(t, err)
+
+	merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+		Name:       "Demo Merchant",
+		MCC:        "5411",
+		PostalCode: "12345",
+	})
+	require.NoError(t, err)
+
+	// the PIN blocks and the MACs of the payments made while the keys are
+	// rotated are verified with the current or the previous keys
+	for i := 0; i < 10; i++ {
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:         card.Number,
+				ExpirationDate: card.ExpirationDate,
+				PIN:            "1234",
+			},
+			Amount:   1_00,
+			Currency: "USD",
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, err := testcerts.NewCA(dir, "playground-ca")
+	require.NoError(t, err)
+
+	issuerCert, issuerKey, err := ca.Issue("issuer", "127.0.0.1")
+	require.NoError(t, err)
+
+	issuerApp := issuer.NewApp(log.New(), &issuer.Config{
+		HTTPAddr:    "127.0.0.1:0",
+		ISO8583Addr: "127.0.0.1:0",
+		ISO8583TLS: &issuer8583.TLSConfig{
+			CertFile:       issuerCert,
+			KeyFile:        issuerKey,
+			ClientCAFile:   ca.CertFile,
+			KnownAcquirers: []string{"acquirer"},
+		},
+		ZonePINKey:    testZonePINKey,
+		MACKey:        testMACKey,
+		ZoneMasterKey: testZoneMasterKey,
+	})
+	require.NoError(t, issuerApp.Start())
+	t.Cleanup(issuerApp.Shutdown)
+
+	startAcquirer := func(tlsConfig *acquirerISO8583.TLSConfig) (*acquirer.App, error) {
+		app := acquirer.NewApp(log.New(), &acquirer.Config{
+			HTTPAddr:      "127.0.0.1:0",
+			ISO8583Addr:   issuerApp.ISO8583ServerAddr,
+			ISO8583TLS:    tlsConfig,
+			ZonePINKey:    testZonePINKey,
+			MACKey:        testMACKey,
+			ZoneMasterKey: testZoneMasterKey,
+			AdminAPIKey:   testAdminAPIKey,
+		})
+
+		return app, app.Start()
+	}
+
+	t.Run("known acquirer", func(t *testing.T) {
+		certFile, keyFile, err := ca.Issue("acquirer")
+		require.NoError(t, err)
+
+		acquirerApp, err := startAcquirer(&acquirerISO8583.TLSConfig{
+			CAFile:   ca.CertFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+		require.NoError(t, err)
+		t.Cleanup(acquirerApp.Shutdown)
+
+		issuerClient := issuerClient.New(fmt.Sprintf("http://%s", issuerApp.Addr))
+		acquirerClient := acquirerClient.New(fmt.Sprintf("http://%s", acquirerApp.Addr), testAdminAPIKey)
+
+		customer, err := issuerClient.CreateCustomer(verifiedCustomer)
+		require.NoError(t, err)
+
+		accountID, err := issuerClient.CreateAccount(issuerModels.CreateAccount{
+			CustomerID: customer.ID,
+			Balance:    100_00,
+			Currency:   "USD",
+		})
+		require.NoError(t, err)
+
+		card, err := issuerClient.IssueCard(accountID)
+		require.NoError(t, err)
+
+		merchant, err := acquirerClient.CreateMerchant(models.CreateMerchant{
+			Name:       "Demo Merchant",
+			MCC:        "5411",
+			PostalCode: "12345",
+		})
+		require.NoError(t, err)
+
+		payment, err := acquirerClient.CreatePayment(merchant.ID, models.CreatePayment{
+			Card: models.Card{
+				Number:                card.Number,
+				CardVerificationValue: card.CardVerificationValue,
+				ExpirationDate:        card.ExpirationDate,
+			},
+			Amount:   10_00,
+			Currency: "USD",
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+	})
+
+	t.Run("unknown acquirer", func(t *testing.T) {
+		certFile, keyFile, err := ca.Issue("unknown-acquirer")
+		require.NoError(t, err)
+
+		_, err = startAcquirer(&acquirerISO8583.TLSConfig{
+			CAFile:   ca.CertFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("certificate of another CA", func(t *testing.T) {
+		otherCA, err := testcerts.NewCA(t.TempDir(), "other-ca")
+		require.NoError(t, err)
+
+		certFile, keyFile, err := otherCA.Issue("acquirer")
+		require.NoError(t, err)
+
+		_, err = startAcquirer(&acquirerISO8583.TLSConfig{
+			CAFile:   ca.CertFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("without client certificate", func(t *testing.T) {
+		_, err := startAcquirer(&acquirerISO8583.TLSConfig{
+			CAFile: ca.CertFile,
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("without TLS", func(t *testing.T) {
+		_, err := startAcquirer(nil)
+		require.Error(t, err)
+	})
+}
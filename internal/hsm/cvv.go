@@ -0,0 +1,81 @@
+package hsm
+
+import (
+	"crypto/des"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	expiryPattern      = regexp.MustCompile(`^[0-9]{4}$`)
+	serviceCodePattern = regexp.MustCompile(`^[0-9]{3}$`)
+	cvvPattern         = regexp.MustCompile(`^[0-9]{3}$`)
+)
+
+// GenerateCVV generates the Visa card verification value of the card with
+// the double length card verification key. The expiry is YYMM as encoded on
+// the magnetic stripe. CVV2 printed on the card is generated with the
+// service code 000.
+func (h *HSM) GenerateCVV(cvkName, pan, expiry, serviceCode string) (string, error) {
+	if !panPattern.MatchString(pan) {
+		return "", fmt.Errorf("PAN must be 13 to 19 digits")
+	}
+
+	if !expiryPattern.MatchString(expiry) {
+		return "", fmt.Errorf("expiry must be YYMM")
+	}
+
+	if !serviceCodePattern.MatchString(serviceCode) {
+		return "", fmt.Errorf("service code must be 3 digits")
+	}
+
+	cvk, err := h.key(cvkName, KeyTypeCVK)
+	if err != nil {
+		return "", err
+	}
+
+	keyA, err := des.NewCipher(cvk[:8])
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	keyAB, err := tdesCipher(cvk)
+	if err != nil {
+		return "", err
+	}
+
+	// the PAN, the expiry and the service code padded with zeros are split
+	// into two blocks: the first is encrypted with the key A, XORed with
+	// the second and encrypted with the key A|B
+	data := pan + expiry + serviceCode
+	data += strings.Repeat("0", 32-len(data))
+
+	blocks, err := hex.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]byte, des.BlockSize)
+	keyA.Encrypt(result, blocks[:8])
+	result = xor(result, blocks[8:])
+	keyAB.Encrypt(result, result)
+
+	return decimalize(strings.ToUpper(hex.EncodeToString(result)), 3), nil
+}
+
+// VerifyCVV verifies the CVV of the card generated by GenerateCVV.
+func (h *HSM) VerifyCVV(cvkName, pan, expiry, serviceCode, cvv string) (bool, error) {
+	if !cvvPattern.MatchString(cvv) {
+		return false, nil
+	}
+
+	expected, err := h.GenerateCVV(cvkName, pan, expiry, serviceCode)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(cvv)) == 1, nil
+}
@@ -0,0 +1,31 @@
+package hsm_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCVV(t *testing.T) {
+	cvk, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	h := hsm.New()
+	require.NoError(t, h.ImportKey("cvk", hsm.KeyTypeCVK, cvk))
+
+	cvv, err := h.GenerateCVV("cvk", "4123456789012345", "8701", "101")
+	require.NoError(t, err)
+	require.Equal(t, "561", cvv)
+
+	ok, err := h.VerifyCVV("cvk", "4123456789012345", "8701", "101", "561")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = h.VerifyCVV("cvk", "4123456789012345", "8701", "000", "561")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = h.GenerateCVV("cvk", "4123456789012345", "0187", "1")
+	require.Error(t, err)
+}
@@ -0,0 +1,740 @@
+// Package hsm emulates a payment hardware security module. Keys never leave
+// the HSM in clear, they are referenced by their names and used by the HSM
+// commands, e.g. PIN block encryption or PIN verification.
+//
+// Keys are kept encrypted under the local master key (LMK). The HSM opened
+// with Open reads the LMK from the master key file and persists the
+// encrypted keys in the key store file, so they survive the restart.
+package hsm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrInvalidKey      = errors.New("invalid key")
+	ErrInvalidPINBlock = errors.New("invalid PIN block")
+	// ErrKeyCheckValue is returned when the imported key doesn't match its
+	// key check value
+	ErrKeyCheckValue = errors.New("key check value mismatch")
+)
+
+// KeyType defines what the key can be used for. The HSM refuses to use the
+// key for commands of another type.
+type KeyType string
+
+const (
+	// zone PIN key encrypts PIN blocks sent between the acquirer and the
+	// issuer
+	KeyTypeZPK KeyType = "ZPK"
+	// PIN verification key generates and verifies PIN verification values
+	KeyTypePVK KeyType = "PVK"
+	// zone master key is the key encryption key (KEK) the working keys are
+	// exchanged under
+	KeyTypeZMK KeyType = "ZMK"
+	// card verification key generates and verifies CVVs
+	KeyTypeCVK KeyType = "CVK"
+	// zone authentication key generates and verifies MACs of the messages
+	// sent between the acquirer and the issuer
+	KeyTypeZAK KeyType = "ZAK"
+)
+
+// keyVersions is the number of versions of the key kept by the HSM. The
+// previous version is kept after the rotation so the data protected by it
+// can be processed until all parties switch to the new version.
+const keyVersions = 2
+
+// KeyInfo describes the key without revealing it.
+type KeyInfo struct {
+	Name    string
+	Type    KeyType
+	Version int
+	// CheckValue (KCV) is the hex encoded first 3 bytes of the zero block
+	// encrypted with the key. It identifies the key.
+	CheckValue string
+	CreatedAt  time.Time
+}
+
+// storedKey is the key as it's kept by the HSM, its value is encrypted
+// under the LMK.
+type storedKey struct {
+	Name           string    `json:"name"`
+	Type           KeyType   `json:"type"`
+	Version        int       `json:"version"`
+	EncryptedValue []byte    `json:"encrypted_value"`
+	CheckValue     string    `json:"check_value"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (k storedKey) info() KeyInfo {
+	return KeyInfo{
+		Name:       k.Name,
+		Type:       k.Type,
+		Version:    k.Version,
+		CheckValue: k.CheckValue,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+type HSM struct {
+	mu  sync.RWMutex
+	lmk []byte
+	// keys are the versions of the keys by their names, the current
+	// version goes first
+	keys map[string][]storedKey
+
+	// keyStoreFile is the file the keys are persisted in, the keys are
+	// kept in memory only when it's empty
+	keyStoreFile string
+	// masterKeyFile is the file the LMK is read from
+	masterKeyFile string
+
+	// gracePeriod is how long the previous version of the rotated key is
+	// still used to verify the data
+	gracePeriod time.Duration
+}
+
+// New returns the HSM with the random LMK that keeps the keys in memory
+// only.
+func New() *HSM {
+	lmk, err := randomBytes(32)
+	if err != nil {
+		panic(fmt.Sprintf("generating LMK: %v", err))
+	}
+
+	return &HSM{
+		lmk:  lmk,
+		keys: make(map[string][]storedKey),
+	}
+}
+
+// Open returns the HSM with the LMK read from the hex encoded master key
+// file and the keys loaded from the key store file. The files are created
+// when they don't exist, the master key file with the random LMK. Without
+// the master key file it's the same as New, and without the key store file
+// the keys are kept in memory only.
+func Open(masterKeyFile, keyStoreFile string) (*HSM, error) {
+	if masterKeyFile == "" {
+		return New(), nil
+	}
+
+	lmk, err := loadMasterKey(masterKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HSM{
+		lmk:           lmk,
+		keys:          make(map[string][]storedKey),
+		keyStoreFile:  keyStoreFile,
+		masterKeyFile: masterKeyFile,
+	}
+
+	if keyStoreFile == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(keyStoreFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading key store: %w", err)
+	}
+
+	var stored []storedKey
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("decoding key store: %w", err)
+	}
+
+	for _, k := range stored {
+		// the keys encrypted under another LMK are detected early
+		if _, err := h.decrypt(k); err != nil {
+			return nil, err
+		}
+
+		h.keys[k.Name] = append(h.keys[k.Name], k)
+	}
+
+	for name := range h.keys {
+		sort.Slice(h.keys[name], func(i, j int) bool {
+			return h.keys[name][i].Version > h.keys[name][j].Version
+		})
+	}
+
+	return h, nil
+}
+
+// loadMasterKey reads the hex encoded LMK from the file or generates it when
+// the file doesn't exist.
+func loadMasterKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		lmk, err := randomBytes(32)
+		if err != nil {
+			return nil, fmt.Errorf("generating LMK: %w", err)
+		}
+
+		if err := writeFile(path, []byte(strings.ToUpper(hex.EncodeToString(lmk)))); err != nil {
+			return nil, fmt.Errorf("writing master key file: %w", err)
+		}
+
+		return lmk, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading master key file: %w", err)
+	}
+
+	lmk, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(lmk) != 32 {
+		return nil, fmt.Errorf("%w: master key must be 32 hex encoded bytes", ErrInvalidKey)
+	}
+
+	return lmk, nil
+}
+
+// SetGracePeriod sets how long the previous version of the rotated or
+// replaced key is still used to verify MACs and PIN blocks, so the messages
+// protected by it before the other party switched to the new version are
+// not rejected. The previous version is not used by default.
+func (h *HSM) SetGracePeriod(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.gracePeriod = d
+}
+
+// ImportKey stores the clear key under the name. Keys are 16 bytes (double
+// length TDES or AES-128) or 24 bytes (triple length TDES or AES-192) long.
+// Zone PIN keys used with ISO format 4 PIN blocks only, zone authentication
+// keys used with AES-CMAC only and zone master keys may also be 32 bytes
+// long (AES-256). Card verification keys are double length keys only.
+//
+// When the key with the name exists, the imported key becomes its new
+// version. Importing the current version again changes nothing.
+func (h *HSM) ImportKey(name string, keyType KeyType, value []byte) error {
+	_, err := h.importKey(name, keyType, value)
+
+	return err
+}
+
+func (h *HSM) importKey(name string, keyType KeyType, value []byte) (KeyInfo, error) {
+	switch {
+	case len(value) == 16:
+	case len(value) == 24 && keyType != KeyTypeCVK:
+	case len(value) == 32 && (keyType == KeyTypeZPK || keyType == KeyTypeZAK || keyType == KeyTypeZMK):
+	default:
+		return KeyInfo{}, fmt.Errorf("%w: %s can't be %d bytes long", ErrInvalidKey, keyType, len(value))
+	}
+
+	checkValue, err := keyCheckValue(value)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions := h.keys[name]
+	version := 1
+	if len(versions) > 0 {
+		current := versions[0]
+		if current.Type != keyType {
+			return KeyInfo{}, fmt.Errorf("%w: %s is %s, not %s", ErrInvalidKey, name, current.Type, keyType)
+		}
+
+		if current.CheckValue == checkValue {
+			clear, err := h.decrypt(current)
+			if err != nil {
+				return KeyInfo{}, err
+			}
+
+			if bytes.Equal(clear, value) {
+				return current.info(), nil
+			}
+		}
+
+		version = current.Version + 1
+	}
+
+	k := storedKey{
+		Name:       name,
+		Type:       keyType,
+		Version:    version,
+		CheckValue: checkValue,
+		CreatedAt:  time.Now(),
+	}
+
+	k.EncryptedValue, err = h.encrypt(k, value)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	versions = append([]storedKey{k}, versions...)
+	if len(versions) > keyVersions {
+		versions = versions[:keyVersions]
+	}
+
+	h.keys[name] = versions
+
+	if err := h.save(); err != nil {
+		return KeyInfo{}, err
+	}
+
+	return k.info(), nil
+}
+
+// GenerateKey generates the random double length key and stores it under
+// the name.
+func (h *HSM) GenerateKey(name string, keyType KeyType) error {
+	value, err := randomBytes(16)
+	if err != nil {
+		return fmt.Errorf("generating key: %w", err)
+	}
+
+	return h.ImportKey(name, keyType, value)
+}
+
+// RotateKey replaces the key with the random key of the same type and
+// length. The previous version of the key is still used to verify the data
+// protected by it during the grace period.
+func (h *HSM) RotateKey(name string) (KeyInfo, error) {
+	h.mu.RLock()
+	versions, ok := h.keys[name]
+	h.mu.RUnlock()
+
+	if !ok {
+		return KeyInfo{}, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	clear, err := h.decrypt(versions[0])
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	value, err := randomBytes(len(clear))
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("generating key: %w", err)
+	}
+
+	return h.importKey(name, versions[0].Type, value)
+}
+
+// RollbackKey removes the version of the rotated key and makes the previous
+// version current again, e.g. when the new version wasn't delivered to the
+// other party. The version must be the current one.
+func (h *HSM) RollbackKey(name string, version int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	versions, ok := h.keys[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	if versions[0].Version != version || len(versions) < 2 {
+		return fmt.Errorf("%w: version %d of %s can't be rolled back", ErrInvalidKey, version, name)
+	}
+
+	h.keys[name] = versions[1:]
+
+	return h.save()
+}
+
+// ImportOrGenerateKey imports the hex encoded key or, if it's empty,
+// generates a random key unless the key with the name already exists in the
+// key store. Generated keys are not shared with other parties, so they are
+// good for keys used by the local HSM only, or for development.
+func (h *HSM) ImportOrGenerateKey(name string, keyType KeyType, hexValue string) error {
+	if hexValue == "" {
+		if _, err := h.key(name, keyType); err == nil {
+			return nil
+		}
+
+		return h.GenerateKey(name, keyType)
+	}
+
+	value, err := hex.DecodeString(hexValue)
+	if err != nil {
+		return fmt.Errorf("%w: decoding %s: %v", ErrInvalidKey, name, err)
+	}
+
+	return h.ImportKey(name, keyType, value)
+}
+
+// Key returns the information of the current version of the key.
+func (h *HSM) Key(name string) (KeyInfo, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	versions, ok := h.keys[name]
+	if !ok {
+		return KeyInfo{}, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	return versions[0].info(), nil
+}
+
+// Keys returns the information of all versions of all keys sorted by names
+// and versions.
+func (h *HSM) Keys() []KeyInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var keys []KeyInfo
+	for _, versions := range h.keys {
+		for _, k := range versions {
+			keys = append(keys, k.info())
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Name != keys[j].Name {
+			return keys[i].Name < keys[j].Name
+		}
+
+		return keys[i].Version < keys[j].Version
+	})
+
+	return keys
+}
+
+// ExportKey returns the current version of the key encrypted under the zone
+// master key and its key check value, so it can be sent to the other party
+// sharing the zone master key.
+func (h *HSM) ExportKey(name, zmkName string) ([]byte, string, error) {
+	zmk, err := h.key(zmkName, KeyTypeZMK)
+	if err != nil {
+		return nil, "", err
+	}
+
+	h.mu.RLock()
+	versions, ok := h.keys[name]
+	h.mu.RUnlock()
+
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	current := versions[0]
+	if current.Type == KeyTypeZMK {
+		return nil, "", fmt.Errorf("%w: zone master keys can't be exported", ErrInvalidKey)
+	}
+
+	value, err := h.decrypt(current)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encrypted, err := seal(zmk, value, []byte(current.Type))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return encrypted, current.CheckValue, nil
+}
+
+// ImportEncryptedKey decrypts the key encrypted under the zone master key
+// by ExportKey and imports it under the name. The key must match the key
+// check value.
+func (h *HSM) ImportEncryptedKey(name string, keyType KeyType, zmkName string, encrypted []byte, checkValue string) (KeyInfo, error) {
+	if keyType == KeyTypeZMK {
+		return KeyInfo{}, fmt.Errorf("%w: zone master keys can't be imported under zone master keys", ErrInvalidKey)
+	}
+
+	zmk, err := h.key(zmkName, KeyTypeZMK)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	value, err := open(zmk, encrypted, []byte(keyType))
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	kcv, err := keyCheckValue(value)
+	if err != nil {
+		return KeyInfo{}, err
+	}
+
+	if !strings.EqualFold(kcv, checkValue) {
+		return KeyInfo{}, fmt.Errorf("%w: %s", ErrKeyCheckValue, name)
+	}
+
+	return h.importKey(name, keyType, value)
+}
+
+// RotateMasterKey re-encrypts all keys under the new random LMK and writes
+// it to the master key file.
+func (h *HSM) RotateMasterKey() error {
+	lmk, err := randomBytes(32)
+	if err != nil {
+		return fmt.Errorf("generating LMK: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make(map[string][]storedKey, len(h.keys))
+	for name, versions := range h.keys {
+		for _, k := range versions {
+			value, err := h.decrypt(k)
+			if err != nil {
+				return err
+			}
+
+			k.EncryptedValue, err = seal(lmk, value, lmkAdditionalData(k))
+			if err != nil {
+				return err
+			}
+
+			keys[name] = append(keys[name], k)
+		}
+	}
+
+	previousLMK, previousKeys := h.lmk, h.keys
+	h.lmk, h.keys = lmk, keys
+
+	if h.masterKeyFile == "" {
+		return nil
+	}
+
+	// the key store is written first, the keys can't be decrypted if the
+	// master key file isn't written, so the previous LMK and keys are
+	// restored
+	if err := h.save(); err != nil {
+		h.lmk, h.keys = previousLMK, previousKeys
+		return err
+	}
+
+	if err := writeFile(h.masterKeyFile, []byte(strings.ToUpper(hex.EncodeToString(lmk)))); err != nil {
+		h.lmk, h.keys = previousLMK, previousKeys
+		if saveErr := h.save(); saveErr != nil {
+			return fmt.Errorf("writing master key file: %w, restoring key store: %v", err, saveErr)
+		}
+
+		return fmt.Errorf("writing master key file: %w", err)
+	}
+
+	return nil
+}
+
+// key returns the clear value of the current version of the key with the
+// name and type.
+func (h *HSM) key(name string, keyType KeyType) ([]byte, error) {
+	values, err := h.verificationKeys(name, keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	return values[0], nil
+}
+
+// verificationKeys returns the clear values of the versions of the key with
+// the name and type the data is verified with: the current version and,
+// during the grace period after the rotation, the previous one. The current
+// version goes first.
+func (h *HSM) verificationKeys(name string, keyType KeyType) ([][]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	versions, ok := h.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+
+	if versions[0].Type != keyType {
+		return nil, fmt.Errorf("%w: %s is %s, not %s", ErrInvalidKey, name, versions[0].Type, keyType)
+	}
+
+	values := make([][]byte, 0, len(versions))
+	for i, k := range versions {
+		// the version is replaced by the next one when it's created
+		if i > 0 && time.Since(versions[i-1].CreatedAt) > h.gracePeriod {
+			break
+		}
+
+		value, err := h.decrypt(k)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// encrypt encrypts the clear value of the key under the LMK. The name, type
+// and version of the key are authenticated, so the encrypted value can't be
+// used for another key.
+func (h *HSM) encrypt(k storedKey, value []byte) ([]byte, error) {
+	return seal(h.lmk, value, lmkAdditionalData(k))
+}
+
+// decrypt returns the clear value of the key encrypted under the LMK.
+func (h *HSM) decrypt(k storedKey) ([]byte, error) {
+	value, err := open(h.lmk, k.EncryptedValue, lmkAdditionalData(k))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s version %d under LMK: %w", k.Name, k.Version, err)
+	}
+
+	return value, nil
+}
+
+func lmkAdditionalData(k storedKey) []byte {
+	return []byte(fmt.Sprintf("%s/%s/%d", k.Name, k.Type, k.Version))
+}
+
+// save writes the keys to the key store file. It must be called with the
+// lock held.
+func (h *HSM) save() error {
+	if h.keyStoreFile == "" {
+		return nil
+	}
+
+	var stored []storedKey
+	for _, versions := range h.keys {
+		stored = append(stored, versions...)
+	}
+
+	sort.Slice(stored, func(i, j int) bool {
+		if stored[i].Name != stored[j].Name {
+			return stored[i].Name < stored[j].Name
+		}
+
+		return stored[i].Version < stored[j].Version
+	})
+
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding key store: %w", err)
+	}
+
+	if err := writeFile(h.keyStoreFile, data); err != nil {
+		return fmt.Errorf("writing key store: %w", err)
+	}
+
+	return nil
+}
+
+// keyCheckValue returns the KCV of the key: the first 3 bytes of the zero
+// block encrypted with TDES for double and triple length keys or with AES
+// for AES-256 keys.
+func keyCheckValue(value []byte) (string, error) {
+	var block cipher.Block
+	var err error
+
+	if len(value) == 32 {
+		block, err = aes.NewCipher(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+	} else {
+		block, err = tdesCipher(value)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	zeros := make([]byte, block.BlockSize())
+	block.Encrypt(zeros, zeros)
+
+	return strings.ToUpper(hex.EncodeToString(zeros[:3])), nil
+}
+
+// seal encrypts the value with AES-GCM. The nonce is prepended to the
+// ciphertext.
+func seal(key, value, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, value, additionalData), nil
+}
+
+// open decrypts the value encrypted by seal.
+func open(key, encrypted, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encrypted) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: encrypted key is too short", ErrInvalidKey)
+	}
+
+	nonce, ciphertext := encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():]
+
+	value, err := gcm.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return value, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return gcm, nil
+}
+
+// writeFile writes the data to the temporary file readable by the owner
+// only and renames it, so the file is never left half written.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	value := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,235 @@
+package hsm_test
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeys(t *testing.T) {
+	key, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	t.Run("key check value identifies the key", func(t *testing.T) {
+		h := hsm.New()
+		require.NoError(t, h.ImportKey("zpk", hsm.KeyTypeZPK, key))
+
+		info, err := h.Key("zpk")
+		require.NoError(t, err)
+		require.Equal(t, "08D7B4", info.CheckValue)
+		require.Equal(t, 1, info.Version)
+
+		// importing the same key doesn't create the new version
+		require.NoError(t, h.ImportKey("zpk", hsm.KeyTypeZPK, key))
+
+		info, err = h.Key("zpk")
+		require.NoError(t, err)
+		require.Equal(t, 1, info.Version)
+
+		require.ErrorIs(t, h.ImportKey("zpk", hsm.KeyTypePVK, key), hsm.ErrInvalidKey)
+		require.ErrorIs(t, h.ImportKey("cvk", hsm.KeyTypeCVK, make([]byte, 24)), hsm.ErrInvalidKey)
+	})
+
+	t.Run("rotated key keeps the previous version", func(t *testing.T) {
+		h := hsm.New()
+		require.NoError(t, h.ImportKey("zak", hsm.KeyTypeZAK, key))
+
+		mac, err := h.GenerateMAC("zak", hsm.MACAlgorithmX919, []byte("message"))
+		require.NoError(t, err)
+
+		info, err := h.RotateKey("zak")
+		require.NoError(t, err)
+		require.Equal(t, 2, info.Version)
+		require.NotEqual(t, "08D7B4", info.CheckValue)
+
+		ok, err := h.VerifyMAC("zak", hsm.MACAlgorithmX919, []byte("message"), mac)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = h.RotateKey("zak")
+		require.NoError(t, err)
+
+		// only the current and the previous versions are kept
+		keys := h.Keys()
+		require.Len(t, keys, 2)
+		require.Equal(t, 2, keys[0].Version)
+		require.Equal(t, 3, keys[1].Version)
+
+		_, err = h.RotateKey("unknown")
+		require.ErrorIs(t, err, hsm.ErrKeyNotFound)
+	})
+
+	t.Run("rolled back key makes the previous version current", func(t *testing.T) {
+		h := hsm.New()
+		require.NoError(t, h.ImportKey("zak", hsm.KeyTypeZAK, key))
+
+		mac, err := h.GenerateMAC("zak", hsm.MACAlgorithmX919, []byte("message"))
+		require.NoError(t, err)
+
+		info, err := h.RotateKey("zak")
+		require.NoError(t, err)
+
+		// only the current version can be rolled back
+		require.ErrorIs(t, h.RollbackKey("zak", info.Version-1), hsm.ErrInvalidKey)
+		require.NoError(t, h.RollbackKey("zak", info.Version))
+
+		current, err := h.Key("zak")
+		require.NoError(t, err)
+		require.Equal(t, 1, current.Version)
+		require.Equal(t, "08D7B4", current.CheckValue)
+
+		ok, err := h.VerifyMAC("zak", hsm.MACAlgorithmX919, []byte("message"), mac)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// the last version can't be rolled back
+		require.ErrorIs(t, h.RollbackKey("zak", 1), hsm.ErrInvalidKey)
+		require.ErrorIs(t, h.RollbackKey("unknown", 1), hsm.ErrKeyNotFound)
+	})
+
+	t.Run("previous version of the rotated key is used during the grace period", func(t *testing.T) {
+		h := hsm.New()
+		h.SetGracePeriod(time.Hour)
+		require.NoError(t, h.GenerateKey("zak", hsm.KeyTypeZAK))
+		require.NoError(t, h.GenerateKey("zpk", hsm.KeyTypeZPK))
+		require.NoError(t, h.GenerateKey("pvk", hsm.KeyTypePVK))
+
+		const pan = "4111111111111111"
+
+		mac, err := h.GenerateMAC("zak", hsm.MACAlgorithmX919, []byte("message"))
+		require.NoError(t, err)
+
+		pinBlock, err := h.EncryptPINBlock("zpk", hsm.PINBlockFormat0, "1234", pan)
+		require.NoError(t, err)
+
+		pvv, err := h.GeneratePVV("pvk", pan, 1, "1234")
+		require.NoError(t, err)
+
+		_, err = h.RotateKey("zak")
+		require.NoError(t, err)
+		_, err = h.RotateKey("zpk")
+		require.NoError(t, err)
+
+		ok, err := h.VerifyMAC("zak", hsm.MACAlgorithmX919, []byte("message"), mac)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		ok, err = h.VerifyPIN("zpk", hsm.PINBlockFormat0, pinBlock, pan, "pvk", 1, pvv)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// the new version is used to generate MACs
+		newMAC, err := h.GenerateMAC("zak", hsm.MACAlgorithmX919, []byte("message"))
+		require.NoError(t, err)
+		require.NotEqual(t, mac, newMAC)
+
+		// the previous version is not used after the grace period
+		h.SetGracePeriod(0)
+
+		ok, err = h.VerifyMAC("zak", hsm.MACAlgorithmX919, []byte("message"), mac)
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = h.VerifyPIN("zpk", hsm.PINBlockFormat0, pinBlock, pan, "pvk", 1, pvv)
+		require.ErrorIs(t, err, hsm.ErrInvalidPINBlock)
+	})
+
+	t.Run("key is exported and imported under the zone master key", func(t *testing.T) {
+		zmk, _ := hex.DecodeString("89ABCDEF0123456776543210FEDCBA98")
+
+		acquirer := hsm.New()
+		require.NoError(t, acquirer.ImportKey("zmk", hsm.KeyTypeZMK, zmk))
+		require.NoError(t, acquirer.GenerateKey("zpk", hsm.KeyTypeZPK))
+
+		issuer := hsm.New()
+		require.NoError(t, issuer.ImportKey("zmk", hsm.KeyTypeZMK, zmk))
+
+		encrypted, checkValue, err := acquirer.ExportKey("zpk", "zmk")
+		require.NoError(t, err)
+
+		// the key is imported for its type only
+		_, err = issuer.ImportEncryptedKey("zpk", hsm.KeyTypeZAK, "zmk", encrypted, checkValue)
+		require.ErrorIs(t, err, hsm.ErrInvalidKey)
+
+		_, err = issuer.ImportEncryptedKey("zpk", hsm.KeyTypeZPK, "zmk", encrypted, "000000")
+		require.ErrorIs(t, err, hsm.ErrKeyCheckValue)
+
+		info, err := issuer.ImportEncryptedKey("zpk", hsm.KeyTypeZPK, "zmk", encrypted, checkValue)
+		require.NoError(t, err)
+		require.Equal(t, checkValue, info.CheckValue)
+
+		// PIN blocks encrypted by the acquirer are decrypted by the issuer
+		pinBlock, err := acquirer.EncryptPINBlock("zpk", hsm.PINBlockFormat0, "1234", "4111111111111111")
+		require.NoError(t, err)
+
+		require.NoError(t, issuer.GenerateKey("pvk", hsm.KeyTypePVK))
+		pvv, err := issuer.GeneratePVV("pvk", "4111111111111111", 1, "1234")
+		require.NoError(t, err)
+
+		ok, err := issuer.VerifyPIN("zpk", hsm.PINBlockFormat0, pinBlock, "4111111111111111", "pvk", 1, pvv)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		_, _, err = acquirer.ExportKey("zmk", "zmk")
+		require.ErrorIs(t, err, hsm.ErrInvalidKey)
+	})
+
+	t.Run("keys are stored encrypted under the master key", func(t *testing.T) {
+		dir := t.TempDir()
+		masterKeyFile := filepath.Join(dir, "lmk")
+		keyStoreFile := filepath.Join(dir, "keys.json")
+
+		h, err := hsm.Open(masterKeyFile, keyStoreFile)
+		require.NoError(t, err)
+		require.NoError(t, h.ImportKey("zpk", hsm.KeyTypeZPK, key))
+		require.NoError(t, h.GenerateKey("pvk", hsm.KeyTypePVK))
+
+		pvv, err := h.GeneratePVV("pvk", "4111111111111111", 1, "1234")
+		require.NoError(t, err)
+
+		store, err := os.ReadFile(keyStoreFile)
+		require.NoError(t, err)
+		require.NotContains(t, string(store), "0123456789ABCDEFFEDCBA9876543210")
+		require.NotContains(t, string(store), hex.EncodeToString(key))
+
+		// the keys are loaded after the restart and the random key is
+		// not generated again
+		h, err = hsm.Open(masterKeyFile, keyStoreFile)
+		require.NoError(t, err)
+		require.NoError(t, h.ImportOrGenerateKey("pvk", hsm.KeyTypePVK, ""))
+
+		pinBlock, err := h.EncryptPINBlock("zpk", hsm.PINBlockFormat0, "1234", "4111111111111111")
+		require.NoError(t, err)
+
+		ok, err := h.VerifyPIN("zpk", hsm.PINBlockFormat0, pinBlock, "4111111111111111", "pvk", 1, pvv)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		// the keys are re-encrypted under the rotated master key
+		lmk, err := os.ReadFile(masterKeyFile)
+		require.NoError(t, err)
+
+		require.NoError(t, h.RotateMasterKey())
+
+		rotated, err := os.ReadFile(masterKeyFile)
+		require.NoError(t, err)
+		require.NotEqual(t, lmk, rotated)
+
+		h, err = hsm.Open(masterKeyFile, keyStoreFile)
+		require.NoError(t, err)
+
+		info, err := h.Key("zpk")
+		require.NoError(t, err)
+		require.Equal(t, "08D7B4", info.CheckValue)
+
+		// the keys can't be decrypted under another master key
+		require.NoError(t, os.WriteFile(masterKeyFile, lmk, 0o600))
+
+		_, err = hsm.Open(masterKeyFile, keyStoreFile)
+		require.ErrorIs(t, err, hsm.ErrInvalidKey)
+	})
+}
@@ -0,0 +1,174 @@
+package hsm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/subtle"
+	"fmt"
+)
+
+// MACAlgorithm is the algorithm the message authentication code is
+// generated with.
+type MACAlgorithm string
+
+const (
+	// ANSI X9.19 (ISO 9797-1 MAC algorithm 3) retail MAC with the double
+	// length TDES key, the MAC is 8 bytes long
+	MACAlgorithmX919 MACAlgorithm = "X9.19"
+	// AES-CMAC (NIST SP 800-38B), the MAC is 16 bytes long
+	MACAlgorithmAESCMAC MACAlgorithm = "AES-CMAC"
+)
+
+// minMACLength is the shortest truncated MAC VerifyMAC accepts
+const minMACLength = 4
+
+// GenerateMAC generates the MAC of the data with the zone authentication
+// key.
+func (h *HSM) GenerateMAC(zakName string, algorithm MACAlgorithm, data []byte) ([]byte, error) {
+	zak, err := h.key(zakName, KeyTypeZAK)
+	if err != nil {
+		return nil, err
+	}
+
+	return generateMAC(zak, algorithm, data)
+}
+
+// VerifyMAC verifies the MAC of the data generated with the zone
+// authentication key or its previous version during the grace period. The
+// MAC may be truncated to its leftmost bytes, e.g. to 8 bytes of AES-CMAC
+// carried in the ISO 8583 message.
+func (h *HSM) VerifyMAC(zakName string, algorithm MACAlgorithm, data, mac []byte) (bool, error) {
+	zaks, err := h.verificationKeys(zakName, KeyTypeZAK)
+	if err != nil {
+		return false, err
+	}
+
+	for _, zak := range zaks {
+		expected, err := generateMAC(zak, algorithm, data)
+		if err != nil {
+			return false, err
+		}
+
+		if len(mac) < minMACLength || len(mac) > len(expected) {
+			return false, nil
+		}
+
+		if subtle.ConstantTimeCompare(expected[:len(mac)], mac) == 1 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func generateMAC(key []byte, algorithm MACAlgorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case MACAlgorithmX919:
+		return retailMAC(key, data)
+	case MACAlgorithmAESCMAC:
+		return aesCMAC(key, data)
+	}
+
+	return nil, fmt.Errorf("unsupported MAC algorithm %q", algorithm)
+}
+
+// retailMAC returns the ANSI X9.19 MAC: the data padded with zeros is
+// encrypted in the CBC mode with the left half of the key, the last block
+// is decrypted with the right half and encrypted with the left half again.
+func retailMAC(key, data []byte) ([]byte, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("%w: X9.19 key must be 16 bytes", ErrInvalidKey)
+	}
+
+	left, err := des.NewCipher(key[:8])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	right, err := des.NewCipher(key[8:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	padded := append([]byte(nil), data...)
+	if len(padded) == 0 || len(padded)%des.BlockSize != 0 {
+		padded = append(padded, make([]byte, des.BlockSize-len(padded)%des.BlockSize)...)
+	}
+
+	mac := make([]byte, des.BlockSize)
+	for i := 0; i < len(padded); i += des.BlockSize {
+		mac = xor(mac, padded[i:i+des.BlockSize])
+		left.Encrypt(mac, mac)
+	}
+
+	right.Decrypt(mac, mac)
+	left.Encrypt(mac, mac)
+
+	return mac, nil
+}
+
+// aesCMAC returns the AES-CMAC of the data.
+func aesCMAC(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	k1, k2 := cmacSubkeys(block)
+
+	n := (len(data) + aes.BlockSize - 1) / aes.BlockSize
+	complete := n > 0 && len(data)%aes.BlockSize == 0
+	if n == 0 {
+		n = 1
+	}
+
+	// the last block is XORed with K1 when it's complete or padded with
+	// 10...0 and XORed with K2 otherwise
+	last := make([]byte, aes.BlockSize)
+	copy(last, data[(n-1)*aes.BlockSize:])
+	if complete {
+		last = xor(last, k1)
+	} else {
+		last[len(data)-(n-1)*aes.BlockSize] = 0x80
+		last = xor(last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		mac = xor(mac, data[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+
+	mac = xor(mac, last)
+	block.Encrypt(mac, mac)
+
+	return mac, nil
+}
+
+// cmacSubkeys returns the CMAC subkeys K1 and K2 of the cipher.
+func cmacSubkeys(block cipher.Block) ([]byte, []byte) {
+	l := make([]byte, aes.BlockSize)
+	block.Encrypt(l, l)
+
+	k1 := shiftLeft(l)
+	k2 := shiftLeft(k1)
+
+	return k1, k2
+}
+
+// shiftLeft shifts the block left by one bit and XORs its last byte with
+// 0x87 if the most significant bit was set.
+func shiftLeft(b []byte) []byte {
+	shifted := make([]byte, len(b))
+	for i := 0; i < len(b)-1; i++ {
+		shifted[i] = b[i]<<1 | b[i+1]>>7
+	}
+	shifted[len(b)-1] = b[len(b)-1] << 1
+
+	if b[0]&0x80 != 0 {
+		shifted[len(b)-1] ^= 0x87
+	}
+
+	return shifted
+}
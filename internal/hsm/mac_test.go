@@ -0,0 +1,77 @@
+package hsm_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMAC(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm hsm.MACAlgorithm
+		key       string
+		data      string
+		mac       string
+	}{
+		{
+			// ISO 9797-1 MAC algorithm 3 with padding method 1
+			name:      "X9.19",
+			algorithm: hsm.MACAlgorithmX919,
+			key:       "0123456789ABCDEFFEDCBA9876543210",
+			data:      hex.EncodeToString([]byte("Now is the time for all ")),
+			mac:       "a1c72e74ea3fa9b6",
+		},
+		// RFC 4493 examples
+		{
+			name:      "AES-CMAC of empty message",
+			algorithm: hsm.MACAlgorithmAESCMAC,
+			key:       "2b7e151628aed2a6abf7158809cf4f3c",
+			data:      "",
+			mac:       "bb1d6929e95937287fa37d129b756746",
+		},
+		{
+			name:      "AES-CMAC of complete block",
+			algorithm: hsm.MACAlgorithmAESCMAC,
+			key:       "2b7e151628aed2a6abf7158809cf4f3c",
+			data:      "6bc1bee22e409f96e93d7e117393172a",
+			mac:       "070a16b46b4d4144f79bdd9dd04a287c",
+		},
+		{
+			name:      "AES-CMAC of padded block",
+			algorithm: hsm.MACAlgorithmAESCMAC,
+			key:       "2b7e151628aed2a6abf7158809cf4f3c",
+			data:      "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411",
+			mac:       "dfa66747de9ae63030ca32611497c827",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+			data, _ := hex.DecodeString(tt.data)
+
+			h := hsm.New()
+			require.NoError(t, h.ImportKey("zak", hsm.KeyTypeZAK, key))
+
+			mac, err := h.GenerateMAC("zak", tt.algorithm, data)
+			require.NoError(t, err)
+			require.Equal(t, tt.mac, hex.EncodeToString(mac))
+
+			ok, err := h.VerifyMAC("zak", tt.algorithm, data, mac)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			// the truncated MAC is verified too
+			ok, err = h.VerifyMAC("zak", tt.algorithm, data, mac[:8])
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			ok, err = h.VerifyMAC("zak", tt.algorithm, append(data, 0), mac)
+			require.NoError(t, err)
+			require.False(t, ok)
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package hsm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// PINBlockFormat is the ISO 9564-1 format of the PIN block.
+type PINBlockFormat int
+
+const (
+	// format 0 PIN block is 8 bytes long and encrypted with TDES
+	PINBlockFormat0 PINBlockFormat = 0
+	// format 4 PIN block is 16 bytes long and encrypted with AES
+	PINBlockFormat4 PINBlockFormat = 4
+)
+
+var (
+	pinPattern = regexp.MustCompile(`^[0-9]{4,12}$`)
+	panPattern = regexp.MustCompile(`^[0-9]{13,19}$`)
+)
+
+// EncryptPINBlock formats the PIN block of the PIN and the PAN and encrypts
+// it under the zone PIN key.
+func (h *HSM) EncryptPINBlock(zpkName string, format PINBlockFormat, pin, pan string) ([]byte, error) {
+	if !pinPattern.MatchString(pin) {
+		return nil, fmt.Errorf("PIN must be 4 to 12 digits")
+	}
+
+	if !panPattern.MatchString(pan) {
+		return nil, fmt.Errorf("PAN must be 13 to 19 digits")
+	}
+
+	zpk, err := h.key(zpkName, KeyTypeZPK)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case PINBlockFormat0:
+		block, err := tdesCipher(zpk)
+		if err != nil {
+			return nil, err
+		}
+
+		pinBlock := xor(format0PINField(pin), format0PANField(pan))
+		block.Encrypt(pinBlock, pinBlock)
+
+		return pinBlock, nil
+	case PINBlockFormat4:
+		block, err := aes.NewCipher(zpk)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+
+		pinField, err := format4PINField(pin)
+		if err != nil {
+			return nil, err
+		}
+
+		pinBlock := make([]byte, aes.BlockSize)
+		block.Encrypt(pinBlock, pinField)
+		pinBlock = xor(pinBlock, format4PANField(pan))
+		block.Encrypt(pinBlock, pinBlock)
+
+		return pinBlock, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PIN block format %d", format)
+}
+
+// GeneratePVV generates the Visa PIN verification value (PVV) of the PIN
+// with the PIN verification key. Only the PVV is stored by the issuer, the
+// PIN can't be recovered from it. PVV is calculated of the first 4 digits
+// of the PIN.
+func (h *HSM) GeneratePVV(pvkName, pan string, pvki int, pin string) (string, error) {
+	if !pinPattern.MatchString(pin) {
+		return "", fmt.Errorf("PIN must be 4 to 12 digits")
+	}
+
+	if !panPattern.MatchString(pan) {
+		return "", fmt.Errorf("PAN must be 13 to 19 digits")
+	}
+
+	if pvki < 0 || pvki > 9 {
+		return "", fmt.Errorf("PVKI must be a digit")
+	}
+
+	pvk, err := h.key(pvkName, KeyTypePVK)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := tdesCipher(pvk)
+	if err != nil {
+		return "", err
+	}
+
+	// transformed security parameter is the rightmost 11 digits of the PAN
+	// without the check digit, the PVKI and the first 4 digits of the PIN
+	tsp, err := hex.DecodeString(pan[len(pan)-12:len(pan)-1] + fmt.Sprint(pvki) + pin[:4])
+	if err != nil {
+		return "", err
+	}
+
+	block.Encrypt(tsp, tsp)
+
+	return decimalize(strings.ToUpper(hex.EncodeToString(tsp)), 4), nil
+}
+
+// VerifyPIN decrypts the PIN block encrypted under the zone PIN key and
+// verifies its PIN against the PVV. The PIN never leaves the HSM. It returns
+// ErrInvalidPINBlock if the PIN block can't be decrypted, e.g. when it was
+// encrypted under another key.
+func (h *HSM) VerifyPIN(zpkName string, format PINBlockFormat, pinBlock []byte, pan, pvkName string, pvki int, pvv string) (bool, error) {
+	pin, err := h.decryptPINBlock(zpkName, format, pinBlock, pan)
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := h.GeneratePVV(pvkName, pan, pvki, pin)
+	if err != nil {
+		return false, err
+	}
+
+	return expected == pvv, nil
+}
+
+// TranslatePINBlock decrypts the PIN block encrypted under the source zone
+// PIN key and encrypts its PIN under the destination zone PIN key in the
+// destination format, e.g. when the PIN block is forwarded to another zone.
+func (h *HSM) TranslatePINBlock(srcZPKName string, srcFormat PINBlockFormat, pinBlock []byte, pan, dstZPKName string, dstFormat PINBlockFormat) ([]byte, error) {
+	pin, err := h.decryptPINBlock(srcZPKName, srcFormat, pinBlock, pan)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.EncryptPINBlock(dstZPKName, dstFormat, pin, pan)
+}
+
+// decryptPINBlock returns the PIN of the PIN block encrypted under the zone
+// PIN key or its previous version during the grace period.
+func (h *HSM) decryptPINBlock(zpkName string, format PINBlockFormat, pinBlock []byte, pan string) (string, error) {
+	if !panPattern.MatchString(pan) {
+		return "", fmt.Errorf("PAN must be 13 to 19 digits")
+	}
+
+	zpks, err := h.verificationKeys(zpkName, KeyTypeZPK)
+	if err != nil {
+		return "", err
+	}
+
+	for _, zpk := range zpks {
+		pin, err := decryptPINBlock(zpk, format, pinBlock, pan)
+		if !errors.Is(err, ErrInvalidPINBlock) {
+			return pin, err
+		}
+	}
+
+	return "", ErrInvalidPINBlock
+}
+
+// decryptPINBlock returns the PIN of the PIN block encrypted under the zone
+// PIN key value.
+func decryptPINBlock(zpk []byte, format PINBlockFormat, pinBlock []byte, pan string) (string, error) {
+	var pinField []byte
+
+	switch format {
+	case PINBlockFormat0:
+		if len(pinBlock) != des.BlockSize {
+			return "", fmt.Errorf("%w: format 0 PIN block must be %d bytes", ErrInvalidPINBlock, des.BlockSize)
+		}
+
+		block, err := tdesCipher(zpk)
+		if err != nil {
+			return "", err
+		}
+
+		pinField = make([]byte, des.BlockSize)
+		block.Decrypt(pinField, pinBlock)
+		pinField = xor(pinField, format0PANField(pan))
+	case PINBlockFormat4:
+		if len(pinBlock) != aes.BlockSize {
+			return "", fmt.Errorf("%w: format 4 PIN block must be %d bytes", ErrInvalidPINBlock, aes.BlockSize)
+		}
+
+		block, err := aes.NewCipher(zpk)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		}
+
+		pinField = make([]byte, aes.BlockSize)
+		block.Decrypt(pinField, pinBlock)
+		pinField = xor(pinField, format4PANField(pan))
+		block.Decrypt(pinField, pinField)
+	default:
+		return "", fmt.Errorf("unsupported PIN block format %d", format)
+	}
+
+	return parsePINField(format, pinField)
+}
+
+// format0PINField returns the PIN field: the format, the PIN length and the
+// PIN padded with F.
+func format0PINField(pin string) []byte {
+	field := fmt.Sprintf("0%X%s", len(pin), pin)
+	field += strings.Repeat("F", 16-len(field))
+
+	value, _ := hex.DecodeString(field)
+
+	return value
+}
+
+// format0PANField returns the PAN field: the rightmost 12 digits of the PAN
+// without the check digit padded with zeros on the left.
+func format0PANField(pan string) []byte {
+	value, _ := hex.DecodeString("0000" + pan[len(pan)-13:len(pan)-1])
+
+	return value
+}
+
+// format4PINField returns the PIN field: the format, the PIN length and the
+// PIN padded with A followed by 8 random bytes.
+func format4PINField(pin string) ([]byte, error) {
+	field := fmt.Sprintf("4%X%s", len(pin), pin)
+	field += strings.Repeat("A", 16-len(field))
+
+	value, _ := hex.DecodeString(field)
+
+	random := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, fmt.Errorf("generating PIN field: %w", err)
+	}
+
+	return append(value, random...), nil
+}
+
+// format4PANField returns the PAN field: the PAN length minus 12 and the PAN
+// padded with zeros on the right.
+func format4PANField(pan string) []byte {
+	field := fmt.Sprintf("%d%s", len(pan)-12, pan)
+	field += strings.Repeat("0", 32-len(field))
+
+	value, _ := hex.DecodeString(field)
+
+	return value
+}
+
+// parsePINField returns the PIN of the clear PIN field or ErrInvalidPINBlock
+// if the field is malformed.
+func parsePINField(format PINBlockFormat, pinField []byte) (string, error) {
+	field := strings.ToUpper(hex.EncodeToString(pinField))[:16]
+
+	pinLength := int(pinField[0] & 0x0f)
+	if field[0] != byte('0'+format) || pinLength < 4 || pinLength > 12 {
+		return "", ErrInvalidPINBlock
+	}
+
+	pin := field[2 : 2+pinLength]
+	fill := "F"
+	if format == PINBlockFormat4 {
+		fill = "A"
+	}
+
+	if !pinPattern.MatchString(pin) || field[2+pinLength:] != strings.Repeat(fill, 14-pinLength) {
+		return "", ErrInvalidPINBlock
+	}
+
+	return pin, nil
+}
+
+// decimalize returns the first n decimal digits of the hex value. When the
+// value has fewer decimal digits, its hex digits A-F are converted to 0-5.
+func decimalize(value string, n int) string {
+	var digits bytes.Buffer
+
+	for _, c := range value {
+		if c >= '0' && c <= '9' && digits.Len() < n {
+			digits.WriteRune(c)
+		}
+	}
+
+	for _, c := range value {
+		if c >= 'A' && c <= 'F' && digits.Len() < n {
+			digits.WriteRune(c - 'A' + '0')
+		}
+	}
+
+	return digits.String()
+}
+
+// tdesCipher returns the TDES cipher of the double or triple length key.
+func tdesCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16:
+		key = append(append([]byte(nil), key...), key[:8]...)
+	case 24:
+	default:
+		return nil, fmt.Errorf("%w: TDES key must be 16 or 24 bytes", ErrInvalidKey)
+	}
+
+	block, err := des.NewTripleDESCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return block, nil
+}
+
+func xor(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+
+	return result
+}
@@ -0,0 +1,109 @@
+package hsm_test
+
+import (
+	"crypto/des"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPINBlock(t *testing.T) {
+	zpk, _ := hex.DecodeString("0123456789ABCDEFFEDCBA9876543210")
+
+	h := hsm.New()
+	require.NoError(t, h.ImportKey("zpk", hsm.KeyTypeZPK, zpk))
+	require.NoError(t, h.GenerateKey("pvk", hsm.KeyTypePVK))
+
+	const pan = "4111111111111111"
+
+	t.Run("format 0 PIN block is the PIN field XOR the PAN field", func(t *testing.T) {
+		pinBlock, err := h.EncryptPINBlock("zpk", hsm.PINBlockFormat0, "1234", pan)
+		require.NoError(t, err)
+		require.Len(t, pinBlock, 8)
+
+		block, err := des.NewTripleDESCipher(append(zpk, zpk[:8]...))
+		require.NoError(t, err)
+
+		clear := make([]byte, 8)
+		block.Decrypt(clear, pinBlock)
+
+		// 041234FFFFFFFFFF XOR 0000111111111111
+		require.Equal(t, "041225eeeeeeeeee", hex.EncodeToString(clear))
+	})
+
+	for _, format := range []hsm.PINBlockFormat{hsm.PINBlockFormat0, hsm.PINBlockFormat4} {
+		t.Run(fmt.Sprintf("PIN of format %d PIN block is verified against PVV", format), func(t *testing.T) {
+			pvv, err := h.GeneratePVV("pvk", pan, 1, "1234")
+			require.NoError(t, err)
+			require.Regexp(t, `^[0-9]{4}$`, pvv)
+
+			pinBlock, err := h.EncryptPINBlock("zpk", format, "1234", pan)
+			require.NoError(t, err)
+
+			ok, err := h.VerifyPIN("zpk", format, pinBlock, pan, "pvk", 1, pvv)
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			pinBlock, err = h.EncryptPINBlock("zpk", format, "4321", pan)
+			require.NoError(t, err)
+
+			ok, err = h.VerifyPIN("zpk", format, pinBlock, pan, "pvk", 1, pvv)
+			require.NoError(t, err)
+			require.False(t, ok)
+
+			// PIN block of another PAN doesn't decrypt to the valid PIN field
+			pinBlock, err = h.EncryptPINBlock("zpk", format, "1234", "4111111111112222")
+			require.NoError(t, err)
+
+			_, err = h.VerifyPIN("zpk", format, pinBlock, pan, "pvk", 1, pvv)
+			require.ErrorIs(t, err, hsm.ErrInvalidPINBlock)
+		})
+	}
+
+	t.Run("format 4 PIN blocks of the same PIN differ", func(t *testing.T) {
+		first, err := h.EncryptPINBlock("zpk", hsm.PINBlockFormat4, "1234", pan)
+		require.NoError(t, err)
+		require.Len(t, first, 16)
+
+		second, err := h.EncryptPINBlock("zpk", hsm.PINBlockFormat4, "1234", pan)
+		require.NoError(t, err)
+		require.NotEqual(t, first, second)
+	})
+
+	t.Run("keys are used for their type only", func(t *testing.T) {
+		_, err := h.EncryptPINBlock("pvk", hsm.PINBlockFormat0, "1234", pan)
+		require.ErrorIs(t, err, hsm.ErrInvalidKey)
+
+		_, err = h.GeneratePVV("unknown", pan, 1, "1234")
+		require.ErrorIs(t, err, hsm.ErrKeyNotFound)
+	})
+}
+
+func TestTranslatePINBlock(t *testing.T) {
+	h := hsm.New()
+	require.NoError(t, h.GenerateKey("acquirer-zpk", hsm.KeyTypeZPK))
+	require.NoError(t, h.GenerateKey("issuer-zpk", hsm.KeyTypeZPK))
+	require.NoError(t, h.GenerateKey("pvk", hsm.KeyTypePVK))
+
+	const pan = "4111111111111111"
+
+	pvv, err := h.GeneratePVV("pvk", pan, 1, "1234")
+	require.NoError(t, err)
+
+	pinBlock, err := h.EncryptPINBlock("acquirer-zpk", hsm.PINBlockFormat0, "1234", pan)
+	require.NoError(t, err)
+
+	translated, err := h.TranslatePINBlock("acquirer-zpk", hsm.PINBlockFormat0, pinBlock, pan, "issuer-zpk", hsm.PINBlockFormat4)
+	require.NoError(t, err)
+	require.Len(t, translated, 16)
+
+	ok, err := h.VerifyPIN("issuer-zpk", hsm.PINBlockFormat4, translated, pan, "pvk", 1, pvv)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, err = h.TranslatePINBlock("issuer-zpk", hsm.PINBlockFormat0, pinBlock, pan, "acquirer-zpk", hsm.PINBlockFormat0)
+	require.ErrorIs(t, err, hsm.ErrInvalidPINBlock)
+}
@@ -0,0 +1,106 @@
+// Package iso8583mac generates and verifies the message authentication codes
+// (MAC) of ISO 8583 messages. The MAC is sent in the last field of the
+// bitmap: the field 64 or, when the message has the secondary bitmap, the
+// field 128. Both fields must be defined in the spec as 8 bytes long binary
+// fields.
+package iso8583mac
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/moov-io/iso8583"
+)
+
+var (
+	ErrMissingMAC = errors.New("missing MAC")
+	ErrInvalidMAC = errors.New("invalid MAC")
+)
+
+// Length is the length of the MAC in the message, AES-CMAC is truncated to
+// it.
+const Length = 8
+
+// Generate generates the MAC of the message with the zone authentication
+// key and sets it in the MAC field. The message must not be changed after
+// that.
+func Generate(h *hsm.HSM, zakName string, algorithm hsm.MACAlgorithm, message *iso8583.Message) error {
+	id := field(message)
+
+	// the MAC field is set before the message is packed, so it's present
+	// in the bitmap the MAC is generated of
+	if err := message.BinaryField(id, make([]byte, Length)); err != nil {
+		return fmt.Errorf("setting field %d: %w", id, err)
+	}
+
+	data, err := data(message)
+	if err != nil {
+		return err
+	}
+
+	mac, err := h.GenerateMAC(zakName, algorithm, data)
+	if err != nil {
+		return fmt.Errorf("generating MAC: %w", err)
+	}
+
+	if err := message.BinaryField(id, mac[:Length]); err != nil {
+		return fmt.Errorf("setting field %d: %w", id, err)
+	}
+
+	return nil
+}
+
+// Verify verifies the MAC of the received message with the zone
+// authentication key. It returns ErrMissingMAC if the message has no MAC and
+// ErrInvalidMAC if the MAC doesn't match.
+func Verify(h *hsm.HSM, zakName string, algorithm hsm.MACAlgorithm, message *iso8583.Message) error {
+	id := field(message)
+
+	if _, ok := message.GetFields()[id]; !ok {
+		return fmt.Errorf("%w: field %d is not set", ErrMissingMAC, id)
+	}
+
+	mac, err := message.GetBytes(id)
+	if err != nil {
+		return fmt.Errorf("getting field %d: %w", id, err)
+	}
+
+	data, err := data(message)
+	if err != nil {
+		return err
+	}
+
+	ok, err := h.VerifyMAC(zakName, algorithm, data, mac)
+	if err != nil {
+		return fmt.Errorf("verifying MAC: %w", err)
+	}
+
+	if !ok {
+		return ErrInvalidMAC
+	}
+
+	return nil
+}
+
+// field returns the number of the MAC field of the message.
+func field(message *iso8583.Message) int {
+	for id := range message.GetFields() {
+		if id > 64 && id != 128 {
+			return 128
+		}
+	}
+
+	return 64
+}
+
+// data returns the data the MAC is generated of: the packed message from
+// the MTI up to the MAC field. The MAC field is always the last one.
+func data(message *iso8583.Message) ([]byte, error) {
+	packed, err := message.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("packing message: %w", err)
+	}
+
+	return packed[:len(packed)-Length], nil
+}
@@ -0,0 +1,148 @@
+package iso8583mac_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/alovak/cardflow-playground/internal/iso8583mac"
+	"github.com/moov-io/iso8583"
+	"github.com/moov-io/iso8583/encoding"
+	"github.com/moov-io/iso8583/field"
+	"github.com/moov-io/iso8583/prefix"
+	"github.com/stretchr/testify/require"
+)
+
+var spec = &iso8583.MessageSpec{
+	Fields: map[int]field.Field{
+		0: field.NewString(&field.Spec{
+			Length:      4,
+			Description: "Message Type Indicator",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		1: field.NewBitmap(&field.Spec{
+			Length:      8,
+			Description: "Bitmap",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
+		2: field.NewString(&field.Spec{
+			Length:      16,
+			Description: "Primary Account Number (PAN)",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		11: field.NewString(&field.Spec{
+			Length:      6,
+			Description: "Systems Trace Audit Number (STAN)",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		64: field.NewBinary(&field.Spec{
+			Length:      8,
+			Description: "Message Authentication Code (MAC)",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
+		70: field.NewString(&field.Spec{
+			Length:      3,
+			Description: "Network Management Information Code",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		128: field.NewBinary(&field.Spec{
+			Length:      8,
+			Description: "Message Authentication Code (MAC)",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
+	},
+}
+
+func TestMAC(t *testing.T) {
+	newMessage := func(t *testing.T, mti string, fields map[int]string) *iso8583.Message {
+		message := iso8583.NewMessage(spec)
+		message.MTI(mti)
+		for id, value := range fields {
+			require.NoError(t, message.Field(id, value))
+		}
+
+		return message
+	}
+
+	tests := []struct {
+		name      string
+		algorithm hsm.MACAlgorithm
+		key       string
+		mti       string
+		fields    map[int]string
+		macField  int
+		mac       string
+	}{
+		// the expected MACs are calculated with OpenSSL of the packed
+		// messages without the MAC field
+		{
+			name:      "X9.19 in the field 64",
+			algorithm: hsm.MACAlgorithmX919,
+			key:       "0123456789ABCDEFFEDCBA9876543210",
+			mti:       "0100",
+			fields:    map[int]string{2: "4111111111111111", 11: "000001"},
+			macField:  64,
+			mac:       "3ee31cd2b8cfe203",
+		},
+		{
+			name:      "X9.19 in the field 128",
+			algorithm: hsm.MACAlgorithmX919,
+			key:       "0123456789ABCDEFFEDCBA9876543210",
+			mti:       "0800",
+			fields:    map[int]string{11: "000002", 70: "001"},
+			macField:  128,
+			mac:       "193499f8eb787ffc",
+		},
+		{
+			name:      "AES-CMAC in the field 64",
+			algorithm: hsm.MACAlgorithmAESCMAC,
+			key:       "2b7e151628aed2a6abf7158809cf4f3c",
+			mti:       "0100",
+			fields:    map[int]string{2: "4111111111111111", 11: "000001"},
+			macField:  64,
+			mac:       "572c22697ef4a04d",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+
+			h := hsm.New()
+			require.NoError(t, h.ImportKey("zak", hsm.KeyTypeZAK, key))
+
+			message := newMessage(t, tt.mti, tt.fields)
+			require.NoError(t, iso8583mac.Generate(h, "zak", tt.algorithm, message))
+
+			mac, err := message.GetBytes(tt.macField)
+			require.NoError(t, err)
+			require.Equal(t, tt.mac, hex.EncodeToString(mac))
+
+			// the MAC is verified by the receiver of the packed message
+			packed, err := message.Pack()
+			require.NoError(t, err)
+
+			received := iso8583.NewMessage(spec)
+			require.NoError(t, received.Unpack(packed))
+			require.NoError(t, iso8583mac.Verify(h, "zak", tt.algorithm, received))
+
+			require.NoError(t, received.Field(11, "999999"))
+			require.ErrorIs(t, iso8583mac.Verify(h, "zak", tt.algorithm, received), iso8583mac.ErrInvalidMAC)
+		})
+	}
+
+	t.Run("message without MAC is rejected", func(t *testing.T) {
+		h := hsm.New()
+		require.NoError(t, h.GenerateKey("zak", hsm.KeyTypeZAK))
+
+		message := newMessage(t, "0100", map[int]string{2: "4111111111111111", 11: "000001"})
+		require.ErrorIs(t, iso8583mac.Verify(h, "zak", hsm.MACAlgorithmX919, message), iso8583mac.ErrMissingMAC)
+	})
+}
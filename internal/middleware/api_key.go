@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ErrInvalidAPIKey is returned by the authenticator when the API key is
+// unknown or revoked.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// Principal is the caller authenticated by the API key.
+type Principal struct {
+	// Admin can call all routes, including the management ones
+	Admin bool
+	// MerchantID is the merchant whose routes the caller can call
+	MerchantID string
+}
+
+// APIKeyAuthenticator returns the principal the API key was issued to.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(key string) (Principal, error)
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the principal of the authenticated request.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(Principal)
+
+	return principal, ok
+}
+
+// Authenticate authenticates the requests with the API key sent as the
+// bearer token in the Authorization header. Requests without the valid key
+// are rejected with 401 Unauthorized.
+func Authenticate(authenticator APIKeyAuthenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || key == "" {
+				unauthorized(w)
+				return
+			}
+
+			principal, err := authenticator.AuthenticateAPIKey(key)
+			if err != nil {
+				if errors.Is(err, ErrInvalidAPIKey) {
+					unauthorized(w)
+				} else {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalContextKey{}, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireAdmin rejects the requests of the callers other than the admin with
+// 403 Forbidden. It's used after Authenticate.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := PrincipalFromContext(r.Context())
+		if !principal.Admin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMerchant rejects the requests of the callers other than the admin
+// and the merchant with the ID in the URL parameter with 403 Forbidden. It's
+// used after Authenticate within the route with the parameter.
+func RequireMerchant(param string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := PrincipalFromContext(r.Context())
+			if !principal.Admin && (principal.MerchantID == "" || principal.MerchantID != chi.URLParam(r, param)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
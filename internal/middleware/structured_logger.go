@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/alovak/cardflow-playground/log"
 	"github.com/go-chi/chi/v5/middleware"
 	"golang.org/x/exp/slog"
 )
@@ -23,7 +24,9 @@ type StructuredLogger struct {
 func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 	logger := l.Logger.With(
 		slog.String("http_method", r.Method),
-		slog.String("uri", fmt.Sprintf("%s%s", r.Host, r.RequestURI)),
+		// the URI may carry card numbers, e.g. in the query, they are
+		// masked even if the logger doesn't redact the attributes
+		slog.String("uri", log.RedactString(fmt.Sprintf("%s%s", r.Host, r.RequestURI))),
 	)
 
 	entry := StructuredLoggerEntry{Logger: logger}
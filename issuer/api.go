@@ -3,7 +3,12 @@ package issuer
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/alovak/cardflow-playground/issuer/models"
 	"github.com/go-chi/chi/v5"
@@ -21,14 +26,100 @@ func NewAPI(issuer *Service) *API {
 }
 
 func (a *API) AppendRoutes(r chi.Router) {
+	r.Route("/customers", func(r chi.Router) {
+		r.Post("/", a.createCustomer)
+		r.Route("/{customerID}", func(r chi.Router) {
+			r.Get("/", a.getCustomer)
+			r.Post("/kyc", a.verifyCustomer)
+		})
+	})
 	r.Route("/accounts", func(r chi.Router) {
 		r.Post("/", a.createAccount)
 		r.Route("/{accountID}", func(r chi.Router) {
 			r.Get("/", a.getAccount)
 			r.Post("/cards", a.issueCard)
+			r.Get("/cards", a.getAccountCards)
 			r.Get("/transactions", a.getTransactions)
+			r.Post("/deposits", a.deposit)
+			r.Post("/withdrawals", a.withdraw)
+			r.Post("/transfers", a.transfer)
+			r.Get("/operations", a.getAccountOperations)
+			r.Post("/payments", a.postPayment)
+			r.Get("/statements", a.getStatements)
 		})
 	})
+	r.Route("/cards/{cardID}", func(r chi.Router) {
+		r.Get("/", a.getCard)
+		r.Get("/transactions", a.getCardTransactions)
+		r.Put("/account", a.moveCard)
+		r.Put("/pin", a.setPIN)
+		r.Post("/tokens", a.provisionToken)
+		r.Get("/tokens", a.getCardTokens)
+	})
+	r.Route("/tokens/{tokenID}", func(r chi.Router) {
+		r.Get("/", a.getToken)
+		r.Delete("/", a.deleteToken)
+		r.Post("/suspend", a.suspendToken)
+		r.Post("/resume", a.resumeToken)
+	})
+	r.Route("/transactions/{transactionID}", func(r chi.Router) {
+		r.Get("/", a.getTransaction)
+		r.Post("/disputes", a.openDispute)
+	})
+	r.Get("/statements/{statementID}", a.getStatement)
+	r.Get("/unknown-card-attempts", a.getUnknownCardAttempts)
+	r.Route("/disputes/{disputeID}", func(r chi.Router) {
+		r.Get("/", a.getDispute)
+		r.Post("/accept", a.acceptRepresentment)
+		r.Post("/pre-arbitration", a.startPreArbitration)
+	})
+}
+
+func (a *API) createCustomer(w http.ResponseWriter, r *http.Request) {
+	create := models.CreateCustomer{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	customer, err := a.issuer.CreateCustomer(create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(customer)
+}
+
+func (a *API) getCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID := chi.URLParam(r, "customerID")
+
+	customer, err := a.issuer.GetCustomer(customerID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(customer)
+}
+
+func (a *API) verifyCustomer(w http.ResponseWriter, r *http.Request) {
+	customerID := chi.URLParam(r, "customerID")
+
+	customer, err := a.issuer.VerifyCustomer(customerID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(customer)
 }
 
 func (a *API) createAccount(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +132,7 @@ func (a *API) createAccount(w http.ResponseWriter, r *http.Request) {
 
 	account, err := a.issuer.CreateAccount(create)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -71,9 +162,17 @@ func (a *API) getAccount(w http.ResponseWriter, r *http.Request) {
 func (a *API) issueCard(w http.ResponseWriter, r *http.Request) {
 	accountID := chi.URLParam(r, "accountID")
 
-	card, err := a.issuer.IssueCard(accountID)
+	// the request body is optional, without it the primary card is issued
+	create := models.IssueCard{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	card, err := a.issuer.IssueCard(accountID, create)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -82,12 +181,283 @@ func (a *API) issueCard(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(card)
 }
 
+func (a *API) getAccountCards(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	cards, err := a.issuer.ListAccountCards(accountID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(cards)
+}
+
+func (a *API) getCard(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	card, err := a.issuer.GetCard(cardID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(card)
+}
+
+func (a *API) moveCard(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	move := models.MoveCard{}
+	err := json.NewDecoder(r.Body).Decode(&move)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	card, err := a.issuer.MoveCard(cardID, move)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(card)
+}
+
+func (a *API) setPIN(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	set := models.SetPIN{}
+	err := json.NewDecoder(r.Body).Decode(&set)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = a.issuer.SetPIN(cardID, set)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) provisionToken(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	create := models.CreateToken{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, err := a.issuer.ProvisionToken(cardID, create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+func (a *API) getCardTokens(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	tokens, err := a.issuer.ListCardTokens(cardID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+func (a *API) getToken(w http.ResponseWriter, r *http.Request) {
+	a.handleToken(w, r, a.issuer.GetToken)
+}
+
+func (a *API) suspendToken(w http.ResponseWriter, r *http.Request) {
+	a.handleToken(w, r, a.issuer.SuspendToken)
+}
+
+func (a *API) resumeToken(w http.ResponseWriter, r *http.Request) {
+	a.handleToken(w, r, a.issuer.ResumeToken)
+}
+
+func (a *API) deleteToken(w http.ResponseWriter, r *http.Request) {
+	a.handleToken(w, r, a.issuer.DeleteToken)
+}
+
+// handleToken responds with the token returned by the handler for the token
+// ID of the request.
+func (a *API) handleToken(w http.ResponseWriter, r *http.Request, handler func(tokenID string) (*models.Token, error)) {
+	tokenID := chi.URLParam(r, "tokenID")
+
+	token, err := handler(tokenID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(token)
+}
+
+// idempotencyKeyHeader is the header of funding requests that makes them safe
+// to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+func (a *API) deposit(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	create := models.CreateDeposit{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	operation, err := a.issuer.Deposit(accountID, r.Header.Get(idempotencyKeyHeader), create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(operation)
+}
+
+func (a *API) withdraw(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	create := models.CreateWithdrawal{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	operation, err := a.issuer.Withdraw(accountID, r.Header.Get(idempotencyKeyHeader), create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(operation)
+}
+
+func (a *API) transfer(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	create := models.CreateTransfer{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	operation, err := a.issuer.Transfer(accountID, r.Header.Get(idempotencyKeyHeader), create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(operation)
+}
+
+func (a *API) getAccountOperations(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	operations, err := a.issuer.ListAccountOperations(accountID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(operations)
+}
+
+func (a *API) postPayment(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	create := models.CreatePayment{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	operation, err := a.issuer.PostPayment(accountID, r.Header.Get(idempotencyKeyHeader), create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(operation)
+}
+
+func (a *API) getStatements(w http.ResponseWriter, r *http.Request) {
+	accountID := chi.URLParam(r, "accountID")
+
+	statements, err := a.issuer.ListStatements(accountID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statements)
+}
+
+func (a *API) getStatement(w http.ResponseWriter, r *http.Request) {
+	statementID := chi.URLParam(r, "statementID")
+
+	statement, err := a.issuer.GetStatement(statementID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(statement)
+}
+
 func (a *API) getTransactions(w http.ResponseWriter, r *http.Request) {
 	accountID := chi.URLParam(r, "accountID")
 
-	transactions, err := a.issuer.ListTransactions(accountID)
+	filter, err := parseTransactionFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transactions, err := a.issuer.ListTransactions(accountID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -95,3 +465,192 @@ func (a *API) getTransactions(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(transactions)
 }
+
+func (a *API) getCardTransactions(w http.ResponseWriter, r *http.Request) {
+	cardID := chi.URLParam(r, "cardID")
+
+	filter, err := parseTransactionFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transactions, err := a.issuer.ListCardTransactions(cardID, filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transactions)
+}
+
+func (a *API) getTransaction(w http.ResponseWriter, r *http.Request) {
+	transactionID := chi.URLParam(r, "transactionID")
+
+	transaction, err := a.issuer.GetTransaction(transactionID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transaction)
+}
+
+func (a *API) getUnknownCardAttempts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := a.issuer.ListUnknownCardAttempts(r.URL.Query().Get("card_last4"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(attempts)
+}
+
+// parseTransactionFilter parses the transaction filter from the query
+// parameters. Dates are expected in RFC 3339 format.
+func parseTransactionFilter(query url.Values) (models.TransactionFilter, error) {
+	filter := models.TransactionFilter{
+		Status:       models.TransactionStatus(query.Get("status")),
+		CardID:       query.Get("card_id"),
+		MerchantName: query.Get("merchant_name"),
+		MerchantMCC:  query.Get("merchant_mcc"),
+		Cursor:       query.Get("cursor"),
+	}
+
+	var err error
+
+	for param, value := range map[string]*time.Time{
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+	} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %w", param, err)
+		}
+	}
+
+	for param, value := range map[string]*int64{
+		"amount_min": &filter.AmountMin,
+		"amount_max": &filter.AmountMax,
+	} {
+		if query.Get(param) == "" {
+			continue
+		}
+
+		*value, err = strconv.ParseInt(query.Get(param), 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %w", param, err)
+		}
+	}
+
+	if query.Get("limit") != "" {
+		filter.Limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil {
+			return filter, fmt.Errorf("invalid limit: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+func (a *API) openDispute(w http.ResponseWriter, r *http.Request) {
+	transactionID := chi.URLParam(r, "transactionID")
+
+	create := models.CreateDispute{}
+	err := json.NewDecoder(r.Body).Decode(&create)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := a.issuer.OpenDispute(transactionID, create)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+func (a *API) getDispute(w http.ResponseWriter, r *http.Request) {
+	disputeID := chi.URLParam(r, "disputeID")
+
+	dispute, err := a.issuer.GetDispute(disputeID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+func (a *API) acceptRepresentment(w http.ResponseWriter, r *http.Request) {
+	disputeID := chi.URLParam(r, "disputeID")
+
+	dispute, err := a.issuer.AcceptRepresentment(disputeID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+func (a *API) startPreArbitration(w http.ResponseWriter, r *http.Request) {
+	disputeID := chi.URLParam(r, "disputeID")
+
+	escalate := models.EscalateDispute{}
+	err := json.NewDecoder(r.Body).Decode(&escalate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := a.issuer.StartPreArbitration(disputeID, escalate)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dispute)
+}
+
+// errorStatus returns the HTTP status code for the service error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrValidation),
+		errors.Is(err, models.ErrInvalidReasonCode):
+		return http.StatusBadRequest
+	case errors.Is(err, models.ErrInvalidDisputeStatus),
+		errors.Is(err, models.ErrTransactionNotDisputable),
+		errors.Is(err, models.ErrIdempotencyKeyReused),
+		errors.Is(err, models.ErrCardNotMovable),
+		errors.Is(err, models.ErrInvalidTokenStatus):
+		return http.StatusConflict
+	case errors.Is(err, models.ErrCurrencyMismatch),
+		errors.Is(err, models.ErrInsufficientFunds):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -20,10 +20,57 @@ func TestAPI(t *testing.T) {
 	api := issuer.NewAPI(issuer.NewService(issuer.NewRepository()))
 	api.AppendRoutes(router)
 
+	var customerID string
+
+	t.Run("create customer", func(t *testing.T) {
+		create := models.CreateCustomer{
+			FirstName:   "John",
+			LastName:    "Doe",
+			DateOfBirth: "1990-01-01",
+			Address: models.Address{
+				Line1:      "1 Main St",
+				City:       "Springfield",
+				PostalCode: "12345",
+				Country:    "US",
+			},
+		}
+
+		jsonReq, _ := json.Marshal(create)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/customers", bytes.NewBuffer(jsonReq))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+
+		customer := models.Customer{}
+		err := json.Unmarshal(w.Body.Bytes(), &customer)
+		require.NoError(t, err)
+
+		require.Equal(t, models.KYCStatusVerified, customer.KYCStatus)
+		require.NotEmpty(t, customer.ID)
+
+		customerID = customer.ID
+	})
+
+	t.Run("create invalid customer", func(t *testing.T) {
+		jsonReq, _ := json.Marshal(models.CreateCustomer{
+			FirstName:   "John",
+			DateOfBirth: "01/01/1990",
+		})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/customers", bytes.NewBuffer(jsonReq))
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
 	t.Run("create account", func(t *testing.T) {
 		create := models.CreateAccount{
-			Balance:  10_00,
-			Currency: "USD",
+			CustomerID: customerID,
+			Balance:    10_00,
+			Currency:   "USD",
 		}
 
 		jsonReq, _ := json.Marshal(create)
@@ -2,15 +2,19 @@ package issuer
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/alovak/cardflow-playground/internal/hsm"
 	"github.com/alovak/cardflow-playground/internal/middleware"
 	// "github.com/alovak/cardflow-playground/issuer"
 	issuer8583 "github.com/alovak/cardflow-playground/issuer/iso8583"
+	"github.com/alovak/cardflow-playground/issuer/models"
 	"github.com/go-chi/chi/v5"
 	"golang.org/x/exp/slog"
 )
@@ -25,6 +29,7 @@ type App struct {
 	logger            *slog.Logger
 	iso8583Server     io.Closer
 	config            *Config
+	done              chan struct{}
 }
 
 func NewApp(logger *slog.Logger, config *Config) *App {
@@ -38,6 +43,7 @@ func NewApp(logger *slog.Logger, config *Config) *App {
 		wg:     &sync.WaitGroup{},
 		logger: logger,
 		config: config,
+		done:   make(chan struct{}),
 	}
 }
 
@@ -49,15 +55,99 @@ func (a *App) Start() error {
 	router.Use(middleware.NewStructuredLogger(a.logger))
 	repository := NewRepository()
 	iss := NewService(repository)
+	if a.config.Clock != nil {
+		iss.SetClock(a.config.Clock)
+	}
+	if a.config.HoldExpiry.Default != 0 {
+		iss.SetHoldExpiry(a.config.HoldExpiry)
+	}
+
+	if a.config.BillingPolicy != (models.BillingPolicy{}) {
+		iss.SetBillingPolicy(a.config.BillingPolicy)
+	}
+
+	if a.config.PINTryLimit != 0 {
+		iss.SetPINTryLimit(a.config.PINTryLimit)
+	}
+
+	paymentHSM, err := hsm.Open(a.config.HSMMasterKeyFile, a.config.HSMKeyStoreFile)
+	if err != nil {
+		return fmt.Errorf("opening HSM: %w", err)
+	}
+
+	keyGracePeriod := a.config.KeyGracePeriod
+	if keyGracePeriod == 0 {
+		keyGracePeriod = defaultKeyGracePeriod
+	}
+
+	paymentHSM.SetGracePeriod(keyGracePeriod)
 
-	iso8583Server := issuer8583.NewServer(a.logger, a.config.ISO8583Addr, iss)
-	err := iso8583Server.Start()
+	err = paymentHSM.ImportOrGenerateKey(ZonePINKeyName, hsm.KeyTypeZPK, a.config.ZonePINKey)
+	if err != nil {
+		return fmt.Errorf("loading zone PIN key: %w", err)
+	}
+
+	err = paymentHSM.ImportOrGenerateKey(PINVerificationKeyName, hsm.KeyTypePVK, a.config.PINVerificationKey)
+	if err != nil {
+		return fmt.Errorf("loading PIN verification key: %w", err)
+	}
+
+	err = paymentHSM.ImportOrGenerateKey(CardVerificationKeyName, hsm.KeyTypeCVK, a.config.CardVerificationKey)
+	if err != nil {
+		return fmt.Errorf("loading card verification key: %w", err)
+	}
+
+	iss.SetHSM(paymentHSM)
+
+	cardVault, err := a.newCardVault()
+	if err != nil {
+		return fmt.Errorf("creating card vault: %w", err)
+	}
+
+	iss.SetCardVault(cardVault)
+
+	iso8583Server := issuer8583.NewServer(a.logger, a.config.ISO8583Addr, iss, iss, iss)
+
+	if a.config.ISO8583TLS != nil {
+		err = iso8583Server.SetTLS(*a.config.ISO8583TLS)
+		if err != nil {
+			return fmt.Errorf("configuring iso8583 server TLS: %w", err)
+		}
+	}
+
+	if a.config.MACKey != "" {
+		err = paymentHSM.ImportOrGenerateKey(issuer8583.MACKeyName, hsm.KeyTypeZAK, a.config.MACKey)
+		if err != nil {
+			return fmt.Errorf("loading MAC key: %w", err)
+		}
+
+		algorithm := a.config.MACAlgorithm
+		if algorithm == "" {
+			algorithm = hsm.MACAlgorithmX919
+		}
+
+		iso8583Server.SetMAC(paymentHSM, algorithm)
+	}
+
+	if a.config.ZoneMasterKey != "" {
+		err = paymentHSM.ImportOrGenerateKey(issuer8583.ZoneMasterKeyName, hsm.KeyTypeZMK, a.config.ZoneMasterKey)
+		if err != nil {
+			return fmt.Errorf("loading zone master key: %w", err)
+		}
+
+		iso8583Server.SetKeyExchange(paymentHSM)
+	}
+
+	err = iso8583Server.Start()
 	if err != nil {
 		return fmt.Errorf("starting iso8583 server: %w", err)
 	}
 	a.ISO8583ServerAddr = iso8583Server.Addr
 	a.iso8583Server = iso8583Server
 
+	// disputes are sent to the acquirer over the ISO 8583 connection
+	iss.SetDisputeNotifier(iso8583Server)
+
 	api := NewAPI(iss)
 	api.AppendRoutes(router)
 
@@ -72,6 +162,10 @@ func (a *App) Start() error {
 		Handler: router,
 	}
 
+	a.startHoldSweeper(iss)
+	a.startBillingCycle(iss)
+	a.startKeyRotation(iso8583Server)
+
 	a.wg.Add(1)
 	go func() {
 		a.logger.Info("http server started", slog.String("addr", a.Addr))
@@ -90,9 +184,136 @@ func (a *App) Start() error {
 	return nil
 }
 
+// newCardVault returns the card vault with the local key provider and the
+// configured index key or the random one.
+func (a *App) newCardVault() (*CardVault, error) {
+	keyProvider, err := NewLocalKeyProvider(a.config.CardKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("creating key provider: %w", err)
+	}
+
+	var indexKey []byte
+	if a.config.CardIndexKey != "" {
+		indexKey, err = hex.DecodeString(a.config.CardIndexKey)
+		if err != nil {
+			return nil, fmt.Errorf("decoding card index key: %w", err)
+		}
+	} else {
+		indexKey, err = randomKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewCardVault(keyProvider, indexKey)
+}
+
+// startHoldSweeper periodically releases expired holds until the app is shut
+// down.
+func (a *App) startHoldSweeper(iss *Service) {
+	interval := a.config.HoldSweepInterval
+	if interval == 0 {
+		interval = defaultHoldSweepInterval
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+				released, err := iss.ReleaseExpiredHolds()
+				if err != nil {
+					a.logger.Error("releasing expired holds", "err", err)
+				}
+
+				if released > 0 {
+					a.logger.Info("released expired holds", slog.Int("count", released))
+				}
+			}
+		}
+	}()
+}
+
+// startBillingCycle periodically closes the statements of credit accounts
+// and charges late fees until the app is shut down.
+func (a *App) startBillingCycle(iss *Service) {
+	interval := a.config.BillingInterval
+	if interval == 0 {
+		interval = defaultBillingInterval
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+				created, err := iss.RunBillingCycle()
+				if err != nil {
+					a.logger.Error("running billing cycle", "err", err)
+				}
+
+				if created > 0 {
+					a.logger.Info("created statements", slog.Int("count", created))
+				}
+			}
+		}
+	}()
+}
+
+// startKeyRotation periodically rotates the working keys and sends them to
+// the acquirer until the app is shut down.
+func (a *App) startKeyRotation(iso8583Server *issuer8583.Server) {
+	if a.config.ZoneMasterKey == "" || a.config.KeyRotationInterval == 0 {
+		return
+	}
+
+	keyTypes := []hsm.KeyType{hsm.KeyTypeZPK}
+	if a.config.MACKey != "" {
+		keyTypes = append(keyTypes, hsm.KeyTypeZAK)
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(a.config.KeyRotationInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-a.done:
+				return
+			case <-ticker.C:
+				for _, keyType := range keyTypes {
+					err := iso8583Server.ChangeKey(keyType)
+					if err != nil {
+						a.logger.Error("changing key", slog.String("key_type", string(keyType)), "err", err)
+					}
+				}
+			}
+		}
+	}()
+}
+
 func (a *App) Shutdown() {
 	a.logger.Info("shutting down app...")
 
+	close(a.done)
+
 	a.srv.Shutdown(context.Background())
 
 	err := a.iso8583Server.Close()
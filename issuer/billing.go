@@ -0,0 +1,266 @@
+package issuer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/google/uuid"
+)
+
+// PostPayment posts the payment to the credit account, it increases the
+// open-to-buy by the paid amount. A request repeated with the same
+// idempotency key returns the original operation.
+func (i *Service) PostPayment(accountID, idempotencyKey string, create models.CreatePayment) (*models.AccountOperation, error) {
+	i.fundingMu.Lock()
+	defer i.fundingMu.Unlock()
+
+	operation := &models.AccountOperation{
+		AccountID:      accountID,
+		Type:           models.AccountOperationTypePayment,
+		Amount:         create.Amount,
+		Currency:       create.Currency,
+		IdempotencyKey: idempotencyKey,
+	}
+
+	account, replayed, err := i.prepareAccountOperation(operation)
+	if err != nil || replayed != nil {
+		return replayed, err
+	}
+
+	if account.Type != models.AccountTypeCredit {
+		return nil, fmt.Errorf("%w: payments are posted to credit accounts only", models.ErrValidation)
+	}
+
+	operation.AvailableBalance = account.Deposit(operation.Amount)
+
+	err = i.repo.CreateAccountOperations(operation)
+	if err != nil {
+		return nil, fmt.Errorf("creating account operation: %w", err)
+	}
+
+	return operation, nil
+}
+
+// RunBillingCycle charges late fees for the statements which minimum
+// payment wasn't paid by the due date and closes the statements of credit
+// accounts which billing period is over. Billing periods are one month long
+// and start when the account is opened. It returns the number of created
+// statements.
+func (i *Service) RunBillingCycle() (int, error) {
+	i.fundingMu.Lock()
+	defer i.fundingMu.Unlock()
+
+	accounts, err := i.repo.ListCreditAccounts()
+	if err != nil {
+		return 0, fmt.Errorf("listing credit accounts: %w", err)
+	}
+
+	now := i.clock.Now()
+
+	var created int
+	for _, account := range accounts {
+		// the clock may have moved by more than one period since the last
+		// run, so the periods are billed one by one
+		for {
+			last, err := i.repo.FindLastStatement(account.ID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return created, fmt.Errorf("finding last statement: %w", err)
+			}
+
+			if last != nil {
+				err = i.chargeLateFee(account, last, now)
+				if err != nil {
+					return created, err
+				}
+			}
+
+			periodStart := account.CreatedAt
+			if last != nil {
+				periodStart = last.PeriodEnd
+			}
+
+			periodEnd := periodStart.AddDate(0, 1, 0)
+			if now.Before(periodEnd) {
+				break
+			}
+
+			err = i.closeStatement(account, last, periodStart, periodEnd)
+			if err != nil {
+				return created, err
+			}
+
+			created++
+		}
+	}
+
+	return created, nil
+}
+
+// chargeLateFee charges the late fee if the minimum payment of the
+// statement wasn't paid by its due date.
+func (i *Service) chargeLateFee(account *models.Account, statement *models.Statement, now time.Time) error {
+	if statement.LateFeeCharged || now.Before(statement.DueDate) || i.billingPolicy.LateFee == 0 {
+		return nil
+	}
+
+	paid, err := i.paidInPeriod(account.ID, statement.PeriodEnd, statement.DueDate)
+	if err != nil {
+		return err
+	}
+
+	if paid >= statement.MinimumPayment {
+		return nil
+	}
+
+	err = i.charge(account, models.AccountOperationTypeLateFee, i.billingPolicy.LateFee, statement.DueDate)
+	if err != nil {
+		return err
+	}
+
+	err = i.repo.MarkLateFeeCharged(statement.ID)
+	if err != nil {
+		return fmt.Errorf("updating statement: %w", err)
+	}
+
+	return nil
+}
+
+// closeStatement accrues the interest if the previous statement wasn't paid
+// in full by its due date and creates the statement of the period. Balances
+// are computed as of the end of the period, so the activity after it goes to
+// the next statement even when the period is closed late.
+func (i *Service) closeStatement(account *models.Account, previous *models.Statement, periodStart, periodEnd time.Time) error {
+	statement := &models.Statement{
+		ID:          uuid.New().String(),
+		AccountID:   account.ID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		Currency:    account.Currency,
+	}
+
+	posted, held, err := i.repo.AccountBalancesAt(account.ID, periodEnd)
+	if err != nil {
+		return fmt.Errorf("computing balances: %w", err)
+	}
+
+	if previous != nil {
+		statement.OpeningBalance = previous.ClosingBalance
+
+		paid, err := i.paidInPeriod(account.ID, previous.PeriodEnd, previous.DueDate)
+		if err != nil {
+			return err
+		}
+
+		// the grace period is lost when the statement isn't paid in full
+		if paid < previous.ClosingBalance {
+			// interest is posted at the end of the period, so it's listed
+			// on the statement. It's not charged on the pending
+			// authorizations.
+			interest := owed(posted, 0) * account.InterestRate / 12 / 10_000
+			if interest > 0 {
+				err = i.charge(account, models.AccountOperationTypeInterest, interest, periodEnd.Add(-time.Nanosecond))
+				if err != nil {
+					return err
+				}
+
+				posted -= interest
+			}
+		}
+	}
+
+	statement.Transactions, err = i.repo.ListAuthorizedTransactionsInPeriod(account.ID, periodStart, periodEnd)
+	if err != nil {
+		return fmt.Errorf("listing transactions: %w", err)
+	}
+
+	statement.Operations, err = i.repo.ListAccountOperationsInPeriod(account.ID, periodStart, periodEnd)
+	if err != nil {
+		return fmt.Errorf("listing account operations: %w", err)
+	}
+
+	statement.ClosingBalance = owed(posted, held)
+	statement.MinimumPayment = i.billingPolicy.MinimumPaymentFor(statement.ClosingBalance)
+	statement.DueDate = periodEnd.Add(i.billingPolicy.PaymentDuePeriod)
+
+	err = i.repo.CreateStatement(statement)
+	if err != nil {
+		return fmt.Errorf("creating statement: %w", err)
+	}
+
+	return nil
+}
+
+// owed returns the amount the customer owes on the credit account with the
+// posted balance and the funds on hold.
+func owed(posted, held int64) int64 {
+	if posted-held >= 0 {
+		return 0
+	}
+
+	return held - posted
+}
+
+// charge posts the issuer's charge to the account.
+func (i *Service) charge(account *models.Account, operationType models.AccountOperationType, amount int64, createdAt time.Time) error {
+	operation := &models.AccountOperation{
+		ID:        uuid.New().String(),
+		AccountID: account.ID,
+		Type:      operationType,
+		Amount:    amount,
+		Currency:  account.Currency,
+		CreatedAt: createdAt,
+	}
+
+	operation.AvailableBalance = account.Charge(amount)
+
+	err := i.repo.CreateAccountOperations(operation)
+	if err != nil {
+		return fmt.Errorf("creating account operation: %w", err)
+	}
+
+	return nil
+}
+
+// paidInPeriod returns the amount of payments posted to the account in the
+// [from, to) period. Transfers into the credit account pay it off too.
+func (i *Service) paidInPeriod(accountID string, from, to time.Time) (int64, error) {
+	operations, err := i.repo.ListAccountOperationsInPeriod(accountID, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("listing account operations: %w", err)
+	}
+
+	var paid int64
+	for _, operation := range operations {
+		if operation.Type == models.AccountOperationTypePayment || operation.Type == models.AccountOperationTypeTransferIn {
+			paid += operation.Amount
+		}
+	}
+
+	return paid, nil
+}
+
+// ListStatements returns statements of the account, newest first.
+func (i *Service) ListStatements(accountID string) ([]*models.Statement, error) {
+	_, err := i.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	statements, err := i.repo.ListStatements(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("listing statements: %w", err)
+	}
+
+	return statements, nil
+}
+
+func (i *Service) GetStatement(statementID string) (*models.Statement, error) {
+	statement, err := i.repo.GetStatement(statementID)
+	if err != nil {
+		return nil, fmt.Errorf("finding statement: %w", err)
+	}
+
+	return statement, nil
+}
@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/alovak/cardflow-playground/issuer/models"
@@ -28,8 +31,56 @@ func New(baseURL string) *client {
 	}
 }
 
-// CreateAccount creates a new account with the given balance and currency and
-// returns the account ID or an error.
+// CreateCustomer creates a new customer and returns it with the KYC status
+// or an error.
+func (i *client) CreateCustomer(req models.CreateCustomer) (models.Customer, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Customer{}, err
+	}
+
+	res, err := i.httpClient.Post(i.baseURL+"/customers", "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.Customer{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return models.Customer{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	}
+
+	var customer models.Customer
+	err = json.NewDecoder(res.Body).Decode(&customer)
+	if err != nil {
+		return models.Customer{}, err
+	}
+
+	return customer, nil
+}
+
+// GetCustomer returns the customer for the given customer ID or an error.
+func (i *client) GetCustomer(customerID string) (models.Customer, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/customers/" + customerID)
+	if err != nil {
+		return models.Customer{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return models.Customer{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var customer models.Customer
+	err = json.NewDecoder(res.Body).Decode(&customer)
+	if err != nil {
+		return models.Customer{}, err
+	}
+
+	return customer, nil
+}
+
+// CreateAccount creates a new account for the customer with the given balance
+// and currency and returns the account ID or an error.
 func (i *client) CreateAccount(req models.CreateAccount) (string, error) {
 	reqJSON, err := json.Marshal(req)
 	if err != nil {
@@ -55,33 +106,167 @@ func (i *client) CreateAccount(req models.CreateAccount) (string, error) {
 }
 
 // GetAccount returns the account for the given account ID or an error.
-func (i *client) GetAccount(accountID string) (models.Account, error) {
+func (i *client) GetAccount(accountID string) (*models.Account, error) {
 	res, err := i.httpClient.Get(i.baseURL + "/accounts/" + accountID)
 	if err != nil {
-		return models.Account{}, err
+		return nil, err
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return models.Account{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+		return nil, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
 	}
 
-	var account models.Account
-	err = json.NewDecoder(res.Body).Decode(&account)
+	account := &models.Account{}
+	err = json.NewDecoder(res.Body).Decode(account)
 	if err != nil {
-		return models.Account{}, err
+		return nil, err
 	}
 
 	return account, nil
 }
 
-// IssueCard issues a new card for the given account ID and returns the card or
-// an error.
+// Deposit adds funds to the account. Requests with the same idempotency key
+// are made only once.
+func (i *client) Deposit(accountID, idempotencyKey string, req models.CreateDeposit) (models.AccountOperation, error) {
+	return i.createAccountOperation(accountID, "deposits", idempotencyKey, req)
+}
+
+// Withdraw takes funds from the account. Requests with the same idempotency
+// key are made only once.
+func (i *client) Withdraw(accountID, idempotencyKey string, req models.CreateWithdrawal) (models.AccountOperation, error) {
+	return i.createAccountOperation(accountID, "withdrawals", idempotencyKey, req)
+}
+
+// Transfer moves funds from the account to another account. Requests with
+// the same idempotency key are made only once.
+func (i *client) Transfer(accountID, idempotencyKey string, req models.CreateTransfer) (models.AccountOperation, error) {
+	return i.createAccountOperation(accountID, "transfers", idempotencyKey, req)
+}
+
+// PostPayment posts the payment to the credit account. Requests with the
+// same idempotency key are made only once.
+func (i *client) PostPayment(accountID, idempotencyKey string, req models.CreatePayment) (models.AccountOperation, error) {
+	return i.createAccountOperation(accountID, "payments", idempotencyKey, req)
+}
+
+func (i *client) createAccountOperation(accountID, operation, idempotencyKey string, req any) (models.AccountOperation, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.AccountOperation{}, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, i.baseURL+"/accounts/"+accountID+"/"+operation, bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.AccountOperation{}, err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	res, err := i.httpClient.Do(httpReq)
+	if err != nil {
+		return models.AccountOperation{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return models.AccountOperation{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	}
+
+	var accountOperation models.AccountOperation
+	err = json.NewDecoder(res.Body).Decode(&accountOperation)
+	if err != nil {
+		return models.AccountOperation{}, err
+	}
+
+	return accountOperation, nil
+}
+
+// ListAccountOperations returns the funding history of the account or an
+// error.
+func (i *client) ListAccountOperations(accountID string) ([]models.AccountOperation, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/accounts/" + accountID + "/operations")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var operations []models.AccountOperation
+	err = json.NewDecoder(res.Body).Decode(&operations)
+	if err != nil {
+		return nil, err
+	}
+
+	return operations, nil
+}
+
+// ListStatements returns the statements of the credit account, newest
+// first, or an error.
+func (i *client) ListStatements(accountID string) ([]models.Statement, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/accounts/" + accountID + "/statements")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var statements []models.Statement
+	err = json.NewDecoder(res.Body).Decode(&statements)
+	if err != nil {
+		return nil, err
+	}
+
+	return statements, nil
+}
+
+// IssueCard issues a new primary card for the given account ID and returns
+// the card or an error.
 func (i *client) IssueCard(accountID string) (models.Card, error) {
-	res, err := i.httpClient.Post(i.baseURL+"/accounts/"+accountID+"/cards", "application/json", nil)
+	return i.issueCard(accountID, nil)
+}
+
+// IssueAuthorizedUserCard issues a secondary card of the account to the
+// authorized user and returns the card or an error.
+func (i *client) IssueAuthorizedUserCard(accountID string, req models.IssueCard) (models.Card, error) {
+	req.Type = models.CardTypeSecondary
+
+	reqJSON, err := json.Marshal(req)
 	if err != nil {
 		return models.Card{}, err
 	}
 
+	return i.issueCard(accountID, bytes.NewReader(reqJSON))
+}
+
+// IssueVirtualCard issues a virtual card of the account with the usage
+// controls of the request and returns the card or an error.
+func (i *client) IssueVirtualCard(accountID string, req models.IssueCard) (models.Card, error) {
+	req.Virtual = true
+
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Card{}, err
+	}
+
+	return i.issueCard(accountID, bytes.NewReader(reqJSON))
+}
+
+func (i *client) issueCard(accountID string, body io.Reader) (models.Card, error) {
+	res, err := i.httpClient.Post(i.baseURL+"/accounts/"+accountID+"/cards", "application/json", body)
+	if err != nil {
+		return models.Card{}, err
+	}
+	defer res.Body.Close()
+
 	if res.StatusCode != http.StatusCreated {
 		return models.Card{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
 	}
@@ -95,23 +280,376 @@ func (i *client) IssueCard(accountID string) (models.Card, error) {
 	return card, nil
 }
 
-// GetTransactions returns the list of transactions for the given card ID
-// and account ID or an error.
-func (i *client) GetTransactions(accountID string) ([]models.Transaction, error) {
-	res, err := i.httpClient.Get(i.baseURL + "/accounts/" + accountID + "/transactions")
+// ProvisionToken provisions the token of the card and returns the token or
+// an error.
+func (i *client) ProvisionToken(cardID string, req models.CreateToken) (models.Token, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Token{}, err
+	}
+
+	res, err := i.httpClient.Post(i.baseURL+"/cards/"+cardID+"/tokens", "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.Token{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		return models.Token{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	}
+
+	var token models.Token
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return models.Token{}, err
+	}
+
+	return token, nil
+}
+
+// SuspendToken suspends the token and returns it or an error.
+func (i *client) SuspendToken(tokenID string) (models.Token, error) {
+	return i.updateToken(http.MethodPost, "/tokens/"+tokenID+"/suspend")
+}
+
+// ResumeToken resumes the suspended token and returns it or an error.
+func (i *client) ResumeToken(tokenID string) (models.Token, error) {
+	return i.updateToken(http.MethodPost, "/tokens/"+tokenID+"/resume")
+}
+
+// DeleteToken deletes the token and returns it or an error.
+func (i *client) DeleteToken(tokenID string) (models.Token, error) {
+	return i.updateToken(http.MethodDelete, "/tokens/"+tokenID)
+}
+
+func (i *client) updateToken(method, path string) (models.Token, error) {
+	httpReq, err := http.NewRequest(method, i.baseURL+path, nil)
+	if err != nil {
+		return models.Token{}, err
+	}
+
+	res, err := i.httpClient.Do(httpReq)
+	if err != nil {
+		return models.Token{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return models.Token{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var token models.Token
+	err = json.NewDecoder(res.Body).Decode(&token)
+	if err != nil {
+		return models.Token{}, err
+	}
+
+	return token, nil
+}
+
+// ListAccountCards returns the cards of the given account ID or an error.
+func (i *client) ListAccountCards(accountID string) ([]models.Card, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/accounts/" + accountID + "/cards")
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
 	}
 
+	var cards []models.Card
+	err = json.NewDecoder(res.Body).Decode(&cards)
+	if err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// SetPIN sets the PIN of the card.
+func (i *client) SetPIN(cardID, pin string) error {
+	reqJSON, err := json.Marshal(models.SetPIN{PIN: pin})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPut, i.baseURL+"/cards/"+cardID+"/pin", bytes.NewReader(reqJSON))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := i.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusNoContent)
+	}
+
+	return nil
+}
+
+// MoveCard moves the card to another account of the same owner and returns
+// the moved card or an error.
+func (i *client) MoveCard(cardID string, req models.MoveCard) (models.Card, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Card{}, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPut, i.baseURL+"/cards/"+cardID+"/account", bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.Card{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	res, err := i.httpClient.Do(httpReq)
+	if err != nil {
+		return models.Card{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return models.Card{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var card models.Card
+	err = json.NewDecoder(res.Body).Decode(&card)
+	if err != nil {
+		return models.Card{}, err
+	}
+
+	return card, nil
+}
+
+// GetTransactions returns all transactions of the given account ID or an
+// error. It follows the pagination cursor until the last page.
+func (i *client) GetTransactions(accountID string) ([]models.Transaction, error) {
 	var transactions []models.Transaction
-	err = json.NewDecoder(res.Body).Decode(&transactions)
+
+	filter := models.TransactionFilter{}
+	for {
+		list, err := i.ListTransactions(accountID, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, transaction := range list.Transactions {
+			transactions = append(transactions, *transaction)
+		}
+
+		if list.NextCursor == "" {
+			return transactions, nil
+		}
+
+		filter.Cursor = list.NextCursor
+	}
+}
+
+// ListTransactions returns a page of transactions of the given account ID
+// matching the filter or an error.
+func (i *client) ListTransactions(accountID string, filter models.TransactionFilter) (models.TransactionList, error) {
+	return i.listTransactions("/accounts/"+accountID+"/transactions", filter)
+}
+
+// ListCardTransactions returns a page of transactions made with the given
+// card ID matching the filter or an error.
+func (i *client) ListCardTransactions(cardID string, filter models.TransactionFilter) (models.TransactionList, error) {
+	return i.listTransactions("/cards/"+cardID+"/transactions", filter)
+}
+
+func (i *client) listTransactions(path string, filter models.TransactionFilter) (models.TransactionList, error) {
+	query := url.Values{}
+
+	for param, value := range map[string]string{
+		"status":        string(filter.Status),
+		"card_id":       filter.CardID,
+		"merchant_name": filter.MerchantName,
+		"merchant_mcc":  filter.MerchantMCC,
+		"cursor":        filter.Cursor,
+	} {
+		if value != "" {
+			query.Set(param, value)
+		}
+	}
+
+	if !filter.CreatedFrom.IsZero() {
+		query.Set("created_from", filter.CreatedFrom.Format(time.RFC3339Nano))
+	}
+	if !filter.CreatedTo.IsZero() {
+		query.Set("created_to", filter.CreatedTo.Format(time.RFC3339Nano))
+	}
+	if filter.AmountMin != 0 {
+		query.Set("amount_min", strconv.FormatInt(filter.AmountMin, 10))
+	}
+	if filter.AmountMax != 0 {
+		query.Set("amount_max", strconv.FormatInt(filter.AmountMax, 10))
+	}
+	if filter.Limit != 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	res, err := i.httpClient.Get(i.baseURL + path + "?" + query.Encode())
+	if err != nil {
+		return models.TransactionList{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return models.TransactionList{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var list models.TransactionList
+	err = json.NewDecoder(res.Body).Decode(&list)
+	if err != nil {
+		return models.TransactionList{}, err
+	}
+
+	return list, nil
+}
+
+// GetTransaction returns the transaction with the given ID or an error.
+func (i *client) GetTransaction(transactionID string) (models.Transaction, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/transactions/" + transactionID)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return models.Transaction{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var transaction models.Transaction
+	err = json.NewDecoder(res.Body).Decode(&transaction)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// ListUnknownCardAttempts returns authorization attempts with unknown cards
+// ending with the given last 4 digits or an error.
+func (i *client) ListUnknownCardAttempts(last4 string) ([]models.UnknownCardAttempt, error) {
+	query := url.Values{}
+	if last4 != "" {
+		query.Set("card_last4", last4)
+	}
+
+	res, err := i.httpClient.Get(i.baseURL + "/unknown-card-attempts?" + query.Encode())
 	if err != nil {
 		return nil, err
 	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var attempts []models.UnknownCardAttempt
+	err = json.NewDecoder(res.Body).Decode(&attempts)
+	if err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// OpenDispute opens a dispute for the given transaction ID and returns the
+// dispute or an error.
+func (i *client) OpenDispute(transactionID string, req models.CreateDispute) (models.Dispute, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	res, err := i.httpClient.Post(i.baseURL+"/transactions/"+transactionID+"/disputes", "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	if res.StatusCode != http.StatusCreated {
+		return models.Dispute{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusCreated)
+	}
+
+	var dispute models.Dispute
+	err = json.NewDecoder(res.Body).Decode(&dispute)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	return dispute, nil
+}
+
+// GetDispute returns the dispute for the given dispute ID or an error.
+func (i *client) GetDispute(disputeID string) (models.Dispute, error) {
+	res, err := i.httpClient.Get(i.baseURL + "/disputes/" + disputeID)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return models.Dispute{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var dispute models.Dispute
+	err = json.NewDecoder(res.Body).Decode(&dispute)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	return dispute, nil
+}
+
+// AcceptRepresentment accepts the merchant's representment for the given
+// dispute ID and returns the dispute or an error.
+func (i *client) AcceptRepresentment(disputeID string) (models.Dispute, error) {
+	res, err := i.httpClient.Post(i.baseURL+"/disputes/"+disputeID+"/accept", "application/json", nil)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return models.Dispute{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var dispute models.Dispute
+	err = json.NewDecoder(res.Body).Decode(&dispute)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	return dispute, nil
+}
+
+// StartPreArbitration escalates the dispute with the given dispute ID to
+// pre-arbitration and returns the dispute or an error.
+func (i *client) StartPreArbitration(disputeID string, req models.EscalateDispute) (models.Dispute, error) {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	res, err := i.httpClient.Post(i.baseURL+"/disputes/"+disputeID+"/pre-arbitration", "application/json", bytes.NewReader(reqJSON))
+	if err != nil {
+		return models.Dispute{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return models.Dispute{}, fmt.Errorf("unexpected status code: %d; expected: %d", res.StatusCode, http.StatusOK)
+	}
+
+	var dispute models.Dispute
+	err = json.NewDecoder(res.Body).Decode(&dispute)
+	if err != nil {
+		return models.Dispute{}, err
+	}
 
-	return transactions, nil
+	return dispute, nil
 }
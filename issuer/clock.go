@@ -0,0 +1,15 @@
+package issuer
+
+import "time"
+
+// Clock provides the current time. It's injected into the service so tests
+// can move the time forward.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
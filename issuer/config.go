@@ -1,14 +1,114 @@
 package issuer
 
+import (
+	"time"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	issuer8583 "github.com/alovak/cardflow-playground/issuer/iso8583"
+	"github.com/alovak/cardflow-playground/issuer/models"
+)
+
 // Config is a configuration for the issuer application
 type Config struct {
 	HTTPAddr    string
 	ISO8583Addr string
+	// ISO8583TLS configures TLS of the ISO 8583 server and the client
+	// certificates of the known acquirers. Plain TCP is used when it's nil.
+	ISO8583TLS *issuer8583.TLSConfig
+
+	// HoldExpiry defines how long the funds of authorized transactions stay
+	// on hold. models.DefaultHoldExpiry is used if it's not set.
+	HoldExpiry models.HoldExpiry
+	// HoldSweepInterval is how often expired holds are released
+	HoldSweepInterval time.Duration
+	// BillingPolicy defines how the credit accounts are billed.
+	// models.DefaultBillingPolicy is used if it's not set.
+	BillingPolicy models.BillingPolicy
+	// BillingInterval is how often the billing cycle of credit accounts is
+	// run
+	BillingInterval time.Duration
+	// Clock is used by the issuer service, the system clock is used if it's
+	// not set
+	Clock Clock
+	// HSMMasterKeyFile is the file with the hex encoded local master key
+	// the HSM keys are encrypted under. It's created with the random key
+	// if it doesn't exist. The HSM keys are kept in memory only when it's
+	// empty.
+	HSMMasterKeyFile string
+	// HSMKeyStoreFile is the file the encrypted HSM keys are stored in
+	HSMKeyStoreFile string
+	// ZonePINKey is the hex encoded key PIN blocks are encrypted under, it's
+	// shared with the acquirer
+	ZonePINKey string
+	// PINVerificationKey is the hex encoded key PVVs of PINs are generated
+	// with. A random key is used when it's empty, PINs have to be set
+	// again after the restart then.
+	PINVerificationKey string
+	// CardVerificationKey is the hex encoded key CVVs of the cards are
+	// generated and verified with. A random key is used when it's empty,
+	// CVVs of the cards issued before the restart are invalid then.
+	CardVerificationKey string
+	// CardKeyFile is the file with the hex encoded key encryption key of
+	// the local key provider the card numbers are encrypted with. It's
+	// created with the random key if it doesn't exist. The random key is
+	// kept in memory only when it's empty.
+	CardKeyFile string
+	// CardIndexKey is the hex encoded key, at least 32 bytes, of the keyed
+	// hashes the cards are found by their numbers with. A random key is
+	// used when it's empty.
+	CardIndexKey string
+	// PINTryLimit is the number of failed PIN tries after which the card is
+	// blocked
+	PINTryLimit int
+	// MACKey is the hex encoded zone authentication key the MACs of ISO
+	// 8583 requests are verified with, it's shared with the acquirer. The
+	// requests are not verified when it's empty.
+	MACKey string
+	// MACAlgorithm is the algorithm of the MACs, hsm.MACAlgorithmX919
+	// (double length key) or hsm.MACAlgorithmAESCMAC
+	MACAlgorithm hsm.MACAlgorithm
+	// ZoneMasterKey is the hex encoded key the working keys (the zone PIN
+	// key and the zone authentication key) are exchanged under, it's
+	// shared with the acquirer. The keys are not exchanged when it's empty.
+	ZoneMasterKey string
+	// KeyRotationInterval is how often the working keys are rotated and
+	// sent to the acquirer. The keys are rotated only when the acquirer
+	// requests them if it's not set.
+	KeyRotationInterval time.Duration
+	// KeyGracePeriod is how long the previous version of the rotated key
+	// is still accepted
+	KeyGracePeriod time.Duration
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		HTTPAddr:    "localhost:9090",
-		ISO8583Addr: "localhost:8583",
+		HTTPAddr:            "localhost:9090",
+		ISO8583Addr:         "localhost:8583",
+		HoldExpiry:          models.DefaultHoldExpiry(),
+		HoldSweepInterval:   defaultHoldSweepInterval,
+		BillingPolicy:       models.DefaultBillingPolicy(),
+		BillingInterval:     defaultBillingInterval,
+		ZonePINKey:          developmentZonePINKey,
+		PINTryLimit:         defaultPINTryLimit,
+		MACAlgorithm:        hsm.MACAlgorithmX919,
+		ZoneMasterKey:       developmentZoneMasterKey,
+		KeyRotationInterval: defaultKeyRotationInterval,
+		KeyGracePeriod:      defaultKeyGracePeriod,
 	}
 }
+
+// developmentZonePINKey is the zone PIN key of the default configs of the
+// issuer and the acquirer, it must not be used outside of the playground
+const developmentZonePINKey = "0123456789ABCDEFFEDCBA9876543210"
+
+// developmentZoneMasterKey is the zone master key of the default configs of
+// the issuer and the acquirer, it must not be used outside of the playground
+const developmentZoneMasterKey = "FEDCBA98765432100123456789ABCDEF"
+
+const (
+	defaultHoldSweepInterval = time.Minute
+	defaultBillingInterval   = time.Hour
+
+	defaultKeyRotationInterval = 24 * time.Hour
+	defaultKeyGracePeriod      = 5 * time.Minute
+)
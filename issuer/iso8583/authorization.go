@@ -1,19 +1,33 @@
 package iso8583
 
 type AuthorizationRequest struct {
-	MTI                   string               `index:"0"`
-	PrimaryAccountNumber  string               `index:"2"`
-	Amount                int64                `index:"3"`
-	TransmissionDateTime  string               `index:"4"`
-	Currency              string               `index:"7"`
-	CardVerificationValue string               `index:"8"`
-	ExpirationDate        string               `index:"9"`
-	AcceptorInformation   *AcceptorInformation `index:"10"`
-	STAN                  string               `index:"11"`
+	MTI                      string               `index:"0"`
+	PrimaryAccountNumber     string               `index:"2"`
+	Amount                   int64                `index:"3"`
+	TransmissionDateTime     string               `index:"4"`
+	Currency                 string               `index:"7"`
+	CardVerificationValue    string               `index:"8"`
+	ExpirationDate           string               `index:"9"`
+	AcceptorInformation      *AcceptorInformation `index:"10"`
+	STAN                     string               `index:"11"`
+	RetrievalReferenceNumber string               `index:"37"`
+	TerminalID               string               `index:"41"`
+	AdditionalData           *AdditionalData      `index:"48"`
+	// PINData is the hex encoded PIN block encrypted under the zone PIN key,
+	// it's sent in binary. Its ISO 9564-1 format is in PINBlockFormat, e.g.
+	// "00" or "04".
+	PINData        string `index:"52"`
+	PINBlockFormat string `index:"53"`
+	// OriginalData is set for incremental authorizations and references
+	// the authorization being incremented
+	OriginalData *OriginalData `index:"90"`
 }
 
 type AuthorizationResponse struct {
-	MTI               string `index:"0"`
+	MTI string `index:"0"`
+	// ApprovedAmount is less than the requested amount when the
+	// authorization is partially approved
+	ApprovedAmount    int64  `index:"3"`
 	ApprovalCode      string `index:"5"`
 	AuthorizationCode string `index:"6"`
 	STAN              string `index:"11"`
@@ -25,3 +39,23 @@ type AcceptorInformation struct {
 	PostalCode string `index:"03"`
 	WebSite    string `index:"04"`
 }
+
+// PartialApprovalSupported is the value of the partial approval indicator
+// when the merchant accepts partially approved authorizations.
+const PartialApprovalSupported = "1"
+
+type AdditionalData struct {
+	PartialApprovalIndicator string `index:"05"`
+	// TokenRequestorID identifies the wallet or the merchant the token of
+	// the request is provisioned for
+	TokenRequestorID string `index:"06"`
+	// StoredCredentialIndicator is set when the card stored by the merchant
+	// is used: "C" when the customer initiated the payment, "M" when the
+	// merchant did and "R" for the recurring payment
+	StoredCredentialIndicator string `index:"07"`
+}
+
+type OriginalData struct {
+	RetrievalReferenceNumber string `index:"01"`
+	AuthorizationCode        string `index:"02"`
+}
@@ -0,0 +1,65 @@
+package iso8583
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProcessingCodeBalanceInquiry is the processing code of the 0100 balance
+// inquiry request. Authorization requests are sent without the processing
+// code.
+const ProcessingCodeBalanceInquiry = "310000"
+
+type BalanceInquiryRequest struct {
+	MTI                      string               `index:"0"`
+	PrimaryAccountNumber     string               `index:"2"`
+	TransmissionDateTime     string               `index:"4"`
+	CardVerificationValue    string               `index:"8"`
+	ExpirationDate           string               `index:"9"`
+	AcceptorInformation      *AcceptorInformation `index:"10"`
+	STAN                     string               `index:"11"`
+	ProcessingCode           string               `index:"12"`
+	RetrievalReferenceNumber string               `index:"37"`
+	TerminalID               string               `index:"41"`
+}
+
+type BalanceInquiryResponse struct {
+	MTI               string `index:"0"`
+	ApprovalCode      string `index:"5"`
+	STAN              string `index:"11"`
+	ProcessingCode    string `index:"12"`
+	AdditionalAmounts string `index:"54"`
+}
+
+// Amount types of the additional amounts
+const (
+	AmountTypeLedgerBalance    = "01"
+	AmountTypeAvailableBalance = "02"
+)
+
+// AdditionalAmount is a single amount of the Additional Amounts field. Each
+// amount is encoded as 20 characters: account type (2), amount type (2),
+// currency (3), sign C or D (1) and amount (12).
+type AdditionalAmount struct {
+	AccountType string
+	AmountType  string
+	Currency    string
+	Amount      int64
+}
+
+// encodeAdditionalAmounts encodes amounts into the Additional Amounts field
+// value.
+func encodeAdditionalAmounts(amounts []AdditionalAmount) string {
+	var sb strings.Builder
+
+	for _, amount := range amounts {
+		sign, value := "C", amount.Amount
+		if value < 0 {
+			sign, value = "D", -value
+		}
+
+		fmt.Fprintf(&sb, "%2s%2s%3s%s%012d", amount.AccountType, amount.AmountType, amount.Currency, sign, value)
+	}
+
+	return sb.String()
+}
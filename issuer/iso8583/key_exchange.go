@@ -0,0 +1,147 @@
+package iso8583
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/moov-io/iso8583"
+	"golang.org/x/exp/slog"
+)
+
+// names of the HSM keys shared with the acquirer
+const (
+	// ZonePINKeyName is the key the acquirer encrypts PIN blocks under
+	ZonePINKeyName = "zpk"
+	// ZoneMasterKeyName is the key the working keys are exchanged under
+	ZoneMasterKeyName = "zmk"
+)
+
+// SetKeyExchange sets the HSM with the zone master key named
+// ZoneMasterKeyName the working keys are exchanged under. The acquirer can't
+// request the new keys until it's set.
+func (s *Server) SetKeyExchange(h *hsm.HSM) {
+	s.keyHSM = h
+}
+
+// ChangeKey rotates the working key of the type, the zone PIN key or the
+// zone authentication key, and sends the new key to the signed on
+// acquirer. The previous version of the key is still used to verify the
+// requests during the grace period of the HSM. The rotation is rolled back
+// when the acquirer doesn't acknowledge the new key.
+func (s *Server) ChangeKey(keyType hsm.KeyType) error {
+	s.mu.Lock()
+	conn := s.acquirerConn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return errors.New("acquirer is not signed on")
+	}
+
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	keyData, info, err := s.newKey(keyType)
+	if err != nil {
+		return err
+	}
+
+	requestData := &NetworkManagementRequest{
+		MTI:                   "0800",
+		TransmissionDateTime:  time.Now().UTC().Format(time.RFC3339),
+		STAN:                  s.stanGenerator.Next(),
+		NetworkManagementCode: NetworkManagementCodeKeyChange,
+		KeyExchangeData:       keyData,
+	}
+
+	requestMessage := iso8583.NewMessage(spec)
+	if err := requestMessage.Marshal(requestData); err != nil {
+		return s.rollbackKey(info, fmt.Errorf("marshaling request data: %w", err))
+	}
+
+	responseMessage, err := sendMessage(s.logger, conn, requestMessage)
+	if err != nil {
+		return s.rollbackKey(info, fmt.Errorf("sending ISO 8583 message to acquirer: %w", err))
+	}
+
+	responseData := &NetworkManagementResponse{}
+	if err := responseMessage.Unmarshal(responseData); err != nil {
+		return s.rollbackKey(info, fmt.Errorf("unmarshaling response data: %w", err))
+	}
+
+	if responseData.ApprovalCode != models.ApprovalCodeApproved {
+		return s.rollbackKey(info, fmt.Errorf("key change declined by acquirer with code: %s", responseData.ApprovalCode))
+	}
+
+	return nil
+}
+
+// handleKeyRequest returns the new working key of the type requested by the
+// acquirer and the rotated version of it. The caller must hold keyMu until
+// the key is sent to the acquirer.
+func (s *Server) handleKeyRequest(keyData *KeyExchangeData) (*KeyExchangeData, hsm.KeyInfo, error) {
+	if keyData == nil {
+		return nil, hsm.KeyInfo{}, errors.New("key type is not set")
+	}
+
+	return s.newKey(hsm.KeyType(keyData.KeyType))
+}
+
+// newKey rotates the working key of the type and returns it encrypted under
+// the zone master key along with the new version of it.
+func (s *Server) newKey(keyType hsm.KeyType) (*KeyExchangeData, hsm.KeyInfo, error) {
+	if s.keyHSM == nil {
+		return nil, hsm.KeyInfo{}, errors.New("key exchange is not configured")
+	}
+
+	var name string
+	switch keyType {
+	case hsm.KeyTypeZPK:
+		name = ZonePINKeyName
+	case hsm.KeyTypeZAK:
+		name = MACKeyName
+	default:
+		return nil, hsm.KeyInfo{}, fmt.Errorf("unsupported key type %q", keyType)
+	}
+
+	info, err := s.keyHSM.RotateKey(name)
+	if err != nil {
+		return nil, hsm.KeyInfo{}, fmt.Errorf("rotating %s: %w", name, err)
+	}
+
+	encrypted, checkValue, err := s.keyHSM.ExportKey(name, ZoneMasterKeyName)
+	if err != nil {
+		return nil, hsm.KeyInfo{}, s.rollbackKey(info, fmt.Errorf("exporting %s: %w", name, err))
+	}
+
+	s.logger.With(
+		slog.String("key_type", string(keyType)),
+		slog.Int("version", info.Version),
+		slog.String("check_value", checkValue),
+	).Info("working key rotated")
+
+	return &KeyExchangeData{
+		KeyType:      string(keyType),
+		EncryptedKey: hex.EncodeToString(encrypted),
+		CheckValue:   checkValue,
+	}, info, nil
+}
+
+// rollbackKey makes the previous version of the rotated key current again
+// as the new version wasn't delivered to the acquirer. It returns the error
+// the key wasn't delivered with.
+func (s *Server) rollbackKey(info hsm.KeyInfo, err error) error {
+	if rollbackErr := s.keyHSM.RollbackKey(info.Name, info.Version); rollbackErr != nil {
+		return fmt.Errorf("%w (rolling back %s: %v)", err, info.Name, rollbackErr)
+	}
+
+	s.logger.With(
+		slog.String("key_name", info.Name),
+		slog.Int("version", info.Version),
+	).Warn("working key rotation rolled back")
+
+	return err
+}
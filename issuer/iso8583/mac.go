@@ -0,0 +1,73 @@
+package iso8583
+
+import (
+	"fmt"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/alovak/cardflow-playground/internal/iso8583mac"
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/moov-io/iso8583"
+	iso8583Connection "github.com/moov-io/iso8583-connection"
+)
+
+// MACKeyName is the name of the HSM key the MACs of the requests are
+// verified with, the key is shared with the acquirer.
+const MACKeyName = "zak"
+
+// RejectionResponse is the response to the request rejected before it's
+// handled, e.g. because of the invalid MAC.
+type RejectionResponse struct {
+	MTI          string `index:"0"`
+	ApprovalCode string `index:"5"`
+	STAN         string `index:"11"`
+}
+
+// SetMAC sets the HSM with the zone authentication key named MACKeyName and
+// the algorithm the MACs of the requests are verified with. Requests
+// without the valid MAC are rejected once it's set.
+func (s *Server) SetMAC(h *hsm.HSM, algorithm hsm.MACAlgorithm) {
+	s.macHSM = h
+	s.macAlgorithm = algorithm
+}
+
+// verifyMAC verifies the MAC of the request message if MACs are configured.
+func (s *Server) verifyMAC(message *iso8583.Message) error {
+	if s.macHSM == nil {
+		return nil
+	}
+
+	return iso8583mac.Verify(s.macHSM, MACKeyName, s.macAlgorithm, message)
+}
+
+// rejectRequest replies to the request with the response code of the
+// security violation.
+func (s *Server) rejectRequest(c *iso8583Connection.Connection, mti string, message *iso8583.Message) error {
+	stan, _ := message.GetString(11)
+
+	responseData := &RejectionResponse{
+		MTI:          responseMTI(mti),
+		ApprovalCode: models.ApprovalCodeSecurityViolation,
+		STAN:         stan,
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+
+	if err := replyMessage(s.logger, c, responseMessage); err != nil {
+		return fmt.Errorf("sending response: %w", err)
+	}
+
+	return nil
+}
+
+// responseMTI returns the MTI of the response to the request, e.g. 0110 for
+// 0100.
+func responseMTI(mti string) string {
+	if len(mti) != 4 {
+		return mti
+	}
+
+	return mti[:2] + string(mti[2]+1) + mti[3:]
+}
@@ -1,56 +1,94 @@
 package iso8583
 
 import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/alovak/cardflow-playground/internal/hsm"
 	"github.com/alovak/cardflow-playground/issuer/models"
 	"github.com/moov-io/iso8583"
 	iso8583Connection "github.com/moov-io/iso8583-connection"
-	iso8583Server "github.com/moov-io/iso8583-connection/server"
 	"golang.org/x/exp/slog"
 )
 
-// Server is a wrapper around the moov-io/iso8583-connection server.
+// Server accepts the connections of the acquirers over plain TCP or TLS and
+// handles their messages with moov-io/iso8583-connection.
 type Server struct {
 	Addr string
 
-	server     *iso8583Server.Server
-	logger     *slog.Logger
-	authorizer Authorizer
+	listener net.Listener
+	// closeCh is closed when the server is closed, wg waits for the
+	// connections to be closed
+	closeCh chan struct{}
+	wg      sync.WaitGroup
+
+	// tlsConfig is set when the server accepts TLS connections only,
+	// knownAcquirers are the common names of the client certificates of
+	// the acquirers allowed to connect
+	tlsConfig      *tls.Config
+	knownAcquirers map[string]bool
+
+	logger         *slog.Logger
+	authorizer     Authorizer
+	detokenizer    Detokenizer
+	disputeHandler DisputeHandler
+	stanGenerator  *stanGenerator
+
+	// macHSM verifies MACs of the requests with the zone authentication
+	// key, the MACs are not verified when it's not set
+	macHSM       *hsm.HSM
+	macAlgorithm hsm.MACAlgorithm
+
+	// keyHSM exchanges the working keys with the acquirer under the zone
+	// master key, keyMu serializes the key rotations
+	keyHSM *hsm.HSM
+	keyMu  sync.Mutex
+
+	// acquirerConn is the connection of the signed on acquirer. It's used
+	// to send messages initiated by the issuer.
+	mu           sync.Mutex
+	acquirerConn *iso8583Connection.Connection
 }
 
 // Authorizer is an interface that defines the authorization logic.
 type Authorizer interface {
 	AuthorizeRequest(req models.AuthorizationRequest) (models.AuthorizationResponse, error)
+	InquireBalance(req models.BalanceInquiryRequest) (models.BalanceInquiryResponse, error)
 }
 
-// NewServer creates a new Server instance with the given logger, address and authorizer.
-func NewServer(logger *slog.Logger, addr string, authorizer Authorizer) *Server {
+// Detokenizer is an interface that defines the logic of replacing the token
+// of the request with the card.
+type Detokenizer interface {
+	Detokenize(req models.DetokenizationRequest) (models.DetokenizationResponse, error)
+}
+
+// DisputeHandler is an interface that defines the logic of handling dispute
+// notifications received from the acquirer.
+type DisputeHandler interface {
+	HandleDisputeNotification(notification models.DisputeNotification) error
+}
+
+// NewServer creates a new Server instance with the given logger, address,
+// authorizer, detokenizer and dispute handler.
+func NewServer(logger *slog.Logger, addr string, authorizer Authorizer, detokenizer Detokenizer, disputeHandler DisputeHandler) *Server {
 	logger = logger.With(slog.String("type", "iso8583-server"), slog.String("addr", addr))
 
 	s := &Server{
-		logger:     logger,
-		Addr:       addr,
-		authorizer: authorizer,
+		logger:         logger,
+		Addr:           addr,
+		authorizer:     authorizer,
+		detokenizer:    detokenizer,
+		disputeHandler: disputeHandler,
+		stanGenerator:  NewStanGenerator(),
+		closeCh:        make(chan struct{}),
 	}
 
-	// here we create an instance of the ISO 8583 server
-	iso8583Server := iso8583Server.New(
-		// this is the ISO 8583 spec we defined in spec.go
-		spec,
-
-		// part of binary framing, it reads the message length from the connection
-		readMessageLength,
-
-		// part of binary framing, it writes the message length to the connection
-		writeMessageLength,
-
-		// here we define a function that will be called when a new message is received`
-		iso8583Connection.InboundMessageHandler(s.handleRequest),
-	)
-
-	s.server = iso8583Server
-
 	return s
 }
 
@@ -58,16 +96,26 @@ func NewServer(logger *slog.Logger, addr string, authorizer Authorizer) *Server
 func (s *Server) Start() error {
 	s.logger.Info("starting ISO 8583 server...")
 
-	if err := s.server.Start(s.Addr); err != nil {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
 		return fmt.Errorf("starting ISO 8583 server: %w", err)
 	}
 
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+
+	s.listener = listener
+
 	// if the server is started successfully, update the address as it might be
 	// different from the one we passed to the Start() method (e.g. if we passed
 	// ":0" to let the OS choose a free port)
-	s.Addr = s.server.Addr
+	s.Addr = listener.Addr().String()
+
+	s.wg.Add(1)
+	go s.acceptConnections()
 
-	s.logger.Info("ISO 8583 server started", slog.String("addr", s.Addr))
+	s.logger.Info("ISO 8583 server started", slog.String("addr", s.Addr), slog.Bool("tls", s.tlsConfig != nil))
 
 	return nil
 }
@@ -75,28 +123,128 @@ func (s *Server) Start() error {
 func (s *Server) Close() error {
 	s.logger.Info("shutting down ISO 8583 server...")
 
-	s.server.Close()
+	close(s.closeCh)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
 
 	s.logger.Info("ISO 8583 server shut down")
 
 	return nil
 }
 
-// handleRequest is called when a new message is received.
-func (s *Server) handleRequest(c *iso8583Connection.Connection, message *iso8583.Message) {
+// acceptConnections accepts the connections until the server is closed.
+func (s *Server) acceptConnections() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Error("failed to accept connection", "err", err)
+			}
+
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+// handleConnection identifies the acquirer of the connection and handles
+// its messages until the connection or the server is closed.
+func (s *Server) handleConnection(conn net.Conn) {
+	logger := s.logger.With(slog.String("remote_addr", conn.RemoteAddr().String()))
+
+	acquirer, err := s.identifyAcquirer(conn)
+	if err != nil {
+		logger.Error("rejecting connection", "err", err)
+		conn.Close()
+
+		return
+	}
+
+	if acquirer != "" {
+		logger = logger.With(slog.String("acquirer", acquirer))
+	}
+
+	logger.Info("acquirer connected")
+
+	c, err := iso8583Connection.NewFrom(
+		conn,
+		// this is the ISO 8583 spec we defined in spec.go
+		spec,
+
+		// part of binary framing, it reads the message length from the connection
+		readMessageLength,
+
+		// part of binary framing, it writes the message length to the connection
+		writeMessageLength,
+
+		// here we define a function that will be called when a new message is received
+		iso8583Connection.InboundMessageHandler(func(c *iso8583Connection.Connection, message *iso8583.Message) {
+			s.handleRequest(logger, c, message)
+		}),
+	)
+	if err != nil {
+		logger.Error("failed to create connection", "err", err)
+		conn.Close()
+
+		return
+	}
+
+	select {
+	case <-s.closeCh:
+		c.Close()
+	case <-c.Done():
+	}
+
+	logger.Info("acquirer disconnected")
+}
+
+// handleRequest is called when a new message is received from the acquirer
+// of the connection.
+func (s *Server) handleRequest(logger *slog.Logger, c *iso8583Connection.Connection, message *iso8583.Message) {
 	mti, err := message.GetMTI()
 	if err != nil {
-		s.logger.Error("failed to get MTI from message", "err", err)
+		logger.Error("failed to get MTI from message", "err", err)
 	}
 
-	logger := s.logger.With(slog.String("mti", mti))
+	logger = logger.With(slog.String("mti", mti))
 
 	logger.Info("handling request")
+	dumpMessage(logger, "received", message)
+
+	if err := s.verifyMAC(message); err != nil {
+		stan, _ := message.GetString(11)
+		logger.Error("rejecting request", slog.String("stan", stan), "err", err)
+
+		if err := s.rejectRequest(c, mti, message); err != nil {
+			logger.Error("failed to reject request", "err", err)
+		}
+
+		return
+	}
 
 	// here we handle different MTIs
 	switch mti {
 	case "0100":
-		err = s.handleAuthorizationRequest(c, message)
+		// balance inquiries are 0100 messages with the processing code
+		if processingCode, _ := message.GetString(12); processingCode == ProcessingCodeBalanceInquiry {
+			err = s.handleBalanceInquiry(c, message)
+		} else {
+			err = s.handleAuthorizationRequest(c, message)
+		}
+	case "0422":
+		err = s.handleDisputeAdvice(c, message)
+	case "0800":
+		err = s.handleNetworkManagementRequest(c, message)
 	default:
 		err = fmt.Errorf("unknown MTI: %s", mti)
 	}
@@ -106,6 +254,71 @@ func (s *Server) handleRequest(c *iso8583Connection.Connection, message *iso8583
 	}
 }
 
+// handleBalanceInquiry handles balance inquiry requests. The balances are
+// returned in the additional amounts field.
+func (s *Server) handleBalanceInquiry(c *iso8583Connection.Connection, message *iso8583.Message) error {
+	requestData := &BalanceInquiryRequest{}
+	if err := message.Unmarshal(requestData); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+
+	s.logger.With(
+		slog.String("mti", requestData.MTI),
+		slog.String("stan", requestData.STAN),
+	).Info("handling balance inquiry")
+
+	inquiry := models.BalanceInquiryRequest{
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
+		Card: models.Card{
+			Number:                requestData.PrimaryAccountNumber,
+			ExpirationDate:        requestData.ExpirationDate,
+			CardVerificationValue: requestData.CardVerificationValue,
+		},
+	}
+
+	if requestData.AcceptorInformation != nil {
+		inquiry.Merchant = models.Merchant{
+			Name:       requestData.AcceptorInformation.Name,
+			MCC:        requestData.AcceptorInformation.MCC,
+			PostalCode: requestData.AcceptorInformation.PostalCode,
+			WebSite:    requestData.AcceptorInformation.WebSite,
+			TerminalID: requestData.TerminalID,
+		}
+	}
+
+	responseData := &BalanceInquiryResponse{
+		MTI:            "0110",
+		STAN:           requestData.STAN,
+		ProcessingCode: requestData.ProcessingCode,
+	}
+
+	balance, err := s.authorizer.InquireBalance(inquiry)
+	if err != nil {
+		s.logger.Error("failed to inquire balance", "err", err)
+		responseData.ApprovalCode = models.ApprovalCodeSystemError
+	} else {
+		responseData.ApprovalCode = balance.ApprovalCode
+	}
+
+	if err == nil && balance.ApprovalCode == models.ApprovalCodeApproved {
+		responseData.AdditionalAmounts = encodeAdditionalAmounts([]AdditionalAmount{
+			{AccountType: "00", AmountType: AmountTypeLedgerBalance, Currency: balance.Currency, Amount: balance.LedgerBalance},
+			{AccountType: "00", AmountType: AmountTypeAvailableBalance, Currency: balance.Currency, Amount: balance.AvailableBalance},
+		})
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+
+	if err := replyMessage(s.logger, c, responseMessage); err != nil {
+		return fmt.Errorf("sending response: %w", err)
+	}
+
+	return nil
+}
+
 // handleAuthorizationRequest handles authorization requests.
 func (s *Server) handleAuthorizationRequest(c *iso8583Connection.Connection, message *iso8583.Message) error {
 	// here we unmarshal the message into our AuthorizationRequest struct
@@ -124,8 +337,9 @@ func (s *Server) handleAuthorizationRequest(c *iso8583Connection.Connection, mes
 	// here we create an instance of our authorization request
 	// and pass it to the authorizer
 	authRequest := models.AuthorizationRequest{
-		Amount:   requestData.Amount,
-		Currency: requestData.Currency,
+		Amount:                   requestData.Amount,
+		Currency:                 requestData.Currency,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
 		Card: models.Card{
 			Number:                requestData.PrimaryAccountNumber,
 			ExpirationDate:        requestData.ExpirationDate,
@@ -136,26 +350,82 @@ func (s *Server) handleAuthorizationRequest(c *iso8583Connection.Connection, mes
 			MCC:        requestData.AcceptorInformation.MCC,
 			PostalCode: requestData.AcceptorInformation.PostalCode,
 			WebSite:    requestData.AcceptorInformation.WebSite,
+			TerminalID: requestData.TerminalID,
 		},
 	}
 
+	if requestData.OriginalData != nil {
+		authRequest.OriginalRetrievalReferenceNumber = requestData.OriginalData.RetrievalReferenceNumber
+		authRequest.OriginalAuthorizationCode = requestData.OriginalData.AuthorizationCode
+	}
+
+	if requestData.PINData != "" {
+		// the binary field is always unmarshaled as valid hex
+		authRequest.PINBlock, _ = hex.DecodeString(requestData.PINData)
+
+		format, err := strconv.Atoi(requestData.PINBlockFormat)
+		if err != nil {
+			// the unknown format is rejected when the PIN is verified
+			format = -1
+		}
+
+		authRequest.PINBlockFormat = format
+	}
+
+	var tokenRequestorID string
+	if requestData.AdditionalData != nil {
+		authRequest.PartialApprovalSupported = requestData.AdditionalData.PartialApprovalIndicator == PartialApprovalSupported
+		tokenRequestorID = requestData.AdditionalData.TokenRequestorID
+		authRequest.StoredCredential = models.StoredCredential(requestData.AdditionalData.StoredCredentialIndicator)
+	}
+
 	// we define a variable that will hold the response data
 	// we need to define it here so we can set its value in the if/else block
 	var responseData *AuthorizationResponse
 
+	// the token is replaced with the card before the request is authorized,
+	// so the authorizer never sees the token number
+	var detokenized models.DetokenizationResponse
+	var err error
+	if models.IsTokenNumber(authRequest.Card.Number) {
+		detokenized, err = s.detokenizer.Detokenize(models.DetokenizationRequest{
+			TokenNumber:      authRequest.Card.Number,
+			ExpirationDate:   authRequest.Card.ExpirationDate,
+			TokenRequestorID: tokenRequestorID,
+			Merchant:         authRequest.Merchant,
+		})
+		if err == nil && detokenized.ApprovalCode == "" {
+			authRequest.TokenID = detokenized.TokenID
+			authRequest.Card = detokenized.Card
+		}
+	}
+
 	// pass the request to the authorizer and get the response with the
 	// approval code and authorization code
-	authResponse, err := s.authorizer.AuthorizeRequest(authRequest)
+	var authResponse models.AuthorizationResponse
+	if err == nil && detokenized.ApprovalCode == "" {
+		authResponse, err = s.authorizer.AuthorizeRequest(authRequest)
+	}
+
 	if err != nil {
+		s.logger.Error("failed to authorize request", "err", err)
+
 		responseData = &AuthorizationResponse{
 			MTI:          "0110",
 			STAN:         requestData.STAN,
 			ApprovalCode: models.ApprovalCodeSystemError,
 		}
+	} else if detokenized.ApprovalCode != "" {
+		responseData = &AuthorizationResponse{
+			MTI:          "0110",
+			STAN:         requestData.STAN,
+			ApprovalCode: detokenized.ApprovalCode,
+		}
 	} else {
 		responseData = &AuthorizationResponse{
 			MTI:               "0110",
 			STAN:              requestData.STAN,
+			ApprovedAmount:    authResponse.ApprovedAmount,
 			ApprovalCode:      authResponse.ApprovalCode,
 			AuthorizationCode: authResponse.AuthorizationCode,
 		}
@@ -168,7 +438,7 @@ func (s *Server) handleAuthorizationRequest(c *iso8583Connection.Connection, mes
 	}
 
 	// send the response message back to the client
-	if err := c.Reply(responseMessage); err != nil {
+	if err := replyMessage(s.logger, c, responseMessage); err != nil {
 		return fmt.Errorf("sending response: %w", err)
 	}
 
@@ -181,3 +451,166 @@ func (s *Server) handleAuthorizationRequest(c *iso8583Connection.Connection, mes
 
 	return nil
 }
+
+// handleNetworkManagementRequest handles network management requests. When
+// the acquirer signs on, we remember its connection to send the messages
+// initiated by the issuer.
+func (s *Server) handleNetworkManagementRequest(c *iso8583Connection.Connection, message *iso8583.Message) error {
+	requestData := &NetworkManagementRequest{}
+	if err := message.Unmarshal(requestData); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+
+	responseData := &NetworkManagementResponse{
+		MTI:                   "0810",
+		STAN:                  requestData.STAN,
+		NetworkManagementCode: requestData.NetworkManagementCode,
+		ApprovalCode:          models.ApprovalCodeApproved,
+	}
+
+	// rotated is the version of the working key sent in the response, it's
+	// rolled back if the response can't be sent
+	var rotated *hsm.KeyInfo
+
+	switch requestData.NetworkManagementCode {
+	case NetworkManagementCodeSignOn:
+		s.mu.Lock()
+		s.acquirerConn = c
+		s.mu.Unlock()
+
+		s.logger.Info("acquirer signed on")
+	case NetworkManagementCodeKeyRequest:
+		// the rotation is held until the new key is sent to the acquirer
+		s.keyMu.Lock()
+		defer s.keyMu.Unlock()
+
+		keyData, info, err := s.handleKeyRequest(requestData.KeyExchangeData)
+		if err != nil {
+			s.logger.Error("failed to handle key request", "err", err)
+			responseData.ApprovalCode = models.ApprovalCodeInvalidTransaction
+		} else {
+			responseData.KeyExchangeData = keyData
+			rotated = &info
+		}
+	default:
+		responseData.ApprovalCode = models.ApprovalCodeInvalidTransaction
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		err = fmt.Errorf("marshaling response: %w", err)
+		if rotated != nil {
+			return s.rollbackKey(*rotated, err)
+		}
+
+		return err
+	}
+
+	if err := replyMessage(s.logger, c, responseMessage); err != nil {
+		err = fmt.Errorf("sending response: %w", err)
+		if rotated != nil {
+			return s.rollbackKey(*rotated, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// handleDisputeAdvice handles dispute notifications sent by the acquirer.
+func (s *Server) handleDisputeAdvice(c *iso8583Connection.Connection, message *iso8583.Message) error {
+	requestData := &DisputeAdvice{}
+	if err := message.Unmarshal(requestData); err != nil {
+		return fmt.Errorf("unmarshaling message: %w", err)
+	}
+
+	if requestData.DisputeInformation == nil {
+		requestData.DisputeInformation = &DisputeInformation{}
+	}
+
+	s.logger.With(
+		slog.String("stan", requestData.STAN),
+		slog.String("dispute_id", requestData.DisputeInformation.DisputeID),
+		slog.String("stage", requestData.DisputeInformation.Stage),
+	).Info("handling dispute advice")
+
+	responseData := &DisputeAdviceResponse{
+		MTI:          "0432",
+		STAN:         requestData.STAN,
+		ApprovalCode: models.ApprovalCodeApproved,
+	}
+
+	err := s.disputeHandler.HandleDisputeNotification(models.DisputeNotification{
+		DisputeID:                requestData.DisputeInformation.DisputeID,
+		RetrievalReferenceNumber: requestData.RetrievalReferenceNumber,
+		Stage:                    models.DisputeStage(requestData.DisputeInformation.Stage),
+		ReasonCode:               requestData.DisputeInformation.ReasonCode,
+		Amount:                   requestData.Amount,
+		Currency:                 requestData.Currency,
+		Message:                  requestData.DisputeInformation.Message,
+	})
+	if err != nil {
+		s.logger.Error("failed to handle dispute advice", "err", err)
+
+		responseData.ApprovalCode = models.ApprovalCodeDeclined
+	}
+
+	responseMessage := iso8583.NewMessage(spec)
+	if err := responseMessage.Marshal(responseData); err != nil {
+		return fmt.Errorf("marshaling response: %w", err)
+	}
+
+	if err := replyMessage(s.logger, c, responseMessage); err != nil {
+		return fmt.Errorf("sending response: %w", err)
+	}
+
+	return nil
+}
+
+// NotifyDispute sends the dispute notification to the signed on acquirer.
+func (s *Server) NotifyDispute(notification models.DisputeNotification) error {
+	s.mu.Lock()
+	conn := s.acquirerConn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return errors.New("acquirer is not signed on")
+	}
+
+	requestData := &DisputeAdvice{
+		MTI:                      "0422",
+		Amount:                   notification.Amount,
+		Currency:                 notification.Currency,
+		TransmissionDateTime:     time.Now().UTC().Format(time.RFC3339),
+		STAN:                     s.stanGenerator.Next(),
+		RetrievalReferenceNumber: notification.RetrievalReferenceNumber,
+		DisputeInformation: &DisputeInformation{
+			DisputeID:  notification.DisputeID,
+			ReasonCode: notification.ReasonCode,
+			Stage:      string(notification.Stage),
+			Message:    notification.Message,
+		},
+	}
+
+	requestMessage := iso8583.NewMessage(spec)
+	if err := requestMessage.Marshal(requestData); err != nil {
+		return fmt.Errorf("marshaling request data: %w", err)
+	}
+
+	responseMessage, err := sendMessage(s.logger, conn, requestMessage)
+	if err != nil {
+		return fmt.Errorf("sending ISO 8583 message to acquirer: %w", err)
+	}
+
+	responseData := &DisputeAdviceResponse{}
+	if err := responseMessage.Unmarshal(responseData); err != nil {
+		return fmt.Errorf("unmarshaling response data: %w", err)
+	}
+
+	if responseData.ApprovalCode != models.ApprovalCodeApproved {
+		return fmt.Errorf("dispute advice declined by acquirer with code: %s", responseData.ApprovalCode)
+	}
+
+	return nil
+}
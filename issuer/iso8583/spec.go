@@ -52,7 +52,6 @@ var spec *iso8583.MessageSpec = &iso8583.MessageSpec{
 			Description: "Approval Code",
 			Enc:         encoding.ASCII,
 			Pref:        prefix.ASCII.Fixed,
-			Pad:         padding.Left('0'),
 		}),
 		6: field.NewString(&field.Spec{
 			Length:      6,
@@ -71,6 +70,9 @@ var spec *iso8583.MessageSpec = &iso8583.MessageSpec{
 			Description: "Card Verification Value (CVV)",
 			Enc:         encoding.ASCII,
 			Pref:        prefix.ASCII.Fixed,
+			// the 3 digit CVV2 is padded with the space, it's removed when
+			// the field is unpacked
+			Pad: padding.Left(' '),
 		}),
 		9: field.NewString(&field.Spec{
 			Length:      4,
@@ -120,6 +122,168 @@ var spec *iso8583.MessageSpec = &iso8583.MessageSpec{
 			Enc:         encoding.ASCII,
 			Pref:        prefix.ASCII.Fixed,
 		}),
+		12: field.NewString(&field.Spec{
+			Length:      6,
+			Description: "Processing Code",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		37: field.NewString(&field.Spec{
+			Length:      12,
+			Description: "Retrieval Reference Number (RRN)",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		41: field.NewString(&field.Spec{
+			Length:      8,
+			Description: "Card Acceptor Terminal Identification (TID)",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		48: field.NewComposite(&field.Spec{
+			Length:      999,
+			Description: "Additional Data - Private",
+			Pref:        prefix.ASCII.LLL,
+			Tag: &field.TagSpec{
+				Length: 2,
+				Enc:    encoding.ASCII,
+				Sort:   sort.StringsByInt,
+			},
+			Subfields: map[string]field.Field{
+				"01": field.NewString(&field.Spec{
+					Length:      36,
+					Description: "Dispute ID",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LL,
+				}),
+				"02": field.NewString(&field.Spec{
+					Length:      4,
+					Description: "Dispute Reason Code",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+				"03": field.NewString(&field.Spec{
+					Length:      20,
+					Description: "Dispute Stage",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LL,
+				}),
+				"04": field.NewString(&field.Spec{
+					Length:      900,
+					Description: "Dispute Message",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LLL,
+				}),
+				"05": field.NewString(&field.Spec{
+					Length:      1,
+					Description: "Partial Approval Indicator",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+				"06": field.NewString(&field.Spec{
+					Length:      11,
+					Description: "Token Requestor ID",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LL,
+				}),
+				"07": field.NewString(&field.Spec{
+					Length:      1,
+					Description: "Stored Credential Indicator",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+			},
+		}),
+		52: field.NewBinary(&field.Spec{
+			Length:      16,
+			Description: "PIN Data",
+			Enc:         encoding.Binary,
+			Pref:        prefix.ASCII.LL,
+		}),
+		53: field.NewString(&field.Spec{
+			Length:      2,
+			Description: "PIN Block Format",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		54: field.NewString(&field.Spec{
+			Length:      120,
+			Description: "Additional Amounts",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.LLL,
+		}),
+		64: field.NewBinary(&field.Spec{
+			Length:      8,
+			Description: "Message Authentication Code (MAC)",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
+		70: field.NewString(&field.Spec{
+			Length:      3,
+			Description: "Network Management Information Code",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		90: field.NewComposite(&field.Spec{
+			Length:      99,
+			Description: "Original Data Elements",
+			Pref:        prefix.ASCII.LL,
+			Tag: &field.TagSpec{
+				Length: 2,
+				Enc:    encoding.ASCII,
+				Sort:   sort.StringsByInt,
+			},
+			Subfields: map[string]field.Field{
+				"01": field.NewString(&field.Spec{
+					Length:      12,
+					Description: "Original Retrieval Reference Number",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+				"02": field.NewString(&field.Spec{
+					Length:      6,
+					Description: "Original Authorization Code",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+			},
+		}),
+		96: field.NewComposite(&field.Spec{
+			Length:      999,
+			Description: "Key Exchange Data",
+			Pref:        prefix.ASCII.LLL,
+			Tag: &field.TagSpec{
+				Length: 2,
+				Enc:    encoding.ASCII,
+				Sort:   sort.StringsByInt,
+			},
+			Subfields: map[string]field.Field{
+				"01": field.NewString(&field.Spec{
+					Length:      3,
+					Description: "Key Type",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+				"02": field.NewString(&field.Spec{
+					Length:      256,
+					Description: "Encrypted Key",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LLL,
+				}),
+				"03": field.NewString(&field.Spec{
+					Length:      6,
+					Description: "Key Check Value",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+			},
+		}),
+		128: field.NewBinary(&field.Spec{
+			Length:      8,
+			Description: "Message Authentication Code (MAC)",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
 	},
 }
 
@@ -0,0 +1,119 @@
+package iso8583
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// TLSConfig configures TLS of the ISO 8583 server.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM files with the certificate of the
+	// server and its key
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM file with the CA certificates the client
+	// certificates of the acquirers are verified with. When it's set, the
+	// acquirers must authenticate with the client certificate (mutual TLS).
+	ClientCAFile string
+	// KnownAcquirers are the common names of the client certificates of
+	// the acquirers allowed to connect. It's required with ClientCAFile.
+	KnownAcquirers []string
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS13. TLS 1.2
+	// is used if it's not set.
+	MinVersion uint16
+}
+
+// handshakeTimeout is how long the TLS handshake of the accepted connection
+// may take
+const handshakeTimeout = 10 * time.Second
+
+// SetTLS configures the server to accept TLS connections only. It should be
+// set before the server is started.
+func (s *Server) SetTLS(config TLSConfig) error {
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   config.MinVersion,
+	}
+
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+
+	if config.ClientCAFile != "" {
+		if len(config.KnownAcquirers) == 0 {
+			return errors.New("known acquirers must be set to verify client certificates")
+		}
+
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("reading client CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("parsing client CA file %s", config.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
+		s.knownAcquirers = make(map[string]bool, len(config.KnownAcquirers))
+		for _, acquirer := range config.KnownAcquirers {
+			s.knownAcquirers[acquirer] = true
+		}
+	}
+
+	s.tlsConfig = tlsConfig
+
+	return nil
+}
+
+// identifyAcquirer completes the TLS handshake of the connection and returns
+// the common name of the client certificate of the acquirer. It returns an
+// error if the acquirer is not known. Acquirers connected over plain TCP or
+// without client certificates are not identified.
+func (s *Server) identifyAcquirer(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return "", err
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("TLS handshake: %w", err)
+	}
+
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		return "", err
+	}
+
+	if s.knownAcquirers == nil {
+		return "", nil
+	}
+
+	// the certificate is already verified against the client CAs
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return "", errors.New("client certificate is required")
+	}
+
+	acquirer := certificates[0].Subject.CommonName
+	if !s.knownAcquirers[acquirer] {
+		return "", fmt.Errorf("unknown acquirer %q", acquirer)
+	}
+
+	return acquirer, nil
+}
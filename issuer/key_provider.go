@@ -0,0 +1,139 @@
+package issuer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeyProvider protects the data encryption keys of the card vault with its
+// key encryption key, e.g. the key kept by the cloud KMS. The key
+// encryption key itself never leaves the provider.
+type KeyProvider interface {
+	// EncryptKey encrypts the data encryption key and returns the ID of
+	// the key encryption key it's encrypted with
+	EncryptKey(dataKey []byte) (string, []byte, error)
+	// DecryptKey decrypts the data encryption key encrypted with the key
+	// encryption key with the ID
+	DecryptKey(keyID string, encryptedKey []byte) ([]byte, error)
+}
+
+// LocalKeyProvider keeps the AES-256 key encryption key in the local file.
+// It's meant for the development, the production provider keeps the key in
+// the KMS or the HSM.
+type LocalKeyProvider struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// NewLocalKeyProvider returns the provider with the hex encoded key read
+// from the file. The file is created with the random key if it doesn't
+// exist. Without the file the random key is kept in memory only, so the card
+// numbers can't be decrypted after the restart.
+func NewLocalKeyProvider(keyFile string) (*LocalKeyProvider, error) {
+	var key []byte
+	var err error
+
+	if keyFile == "" {
+		key, err = randomKey()
+	} else {
+		key, err = loadLocalKey(keyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	// the ID identifies the key without revealing it, so the data keys
+	// encrypted with another key are detected
+	fingerprint := sha256.Sum256(key)
+
+	return &LocalKeyProvider{
+		keyID: "local:" + hex.EncodeToString(fingerprint[:4]),
+		aead:  aead,
+	}, nil
+}
+
+// loadLocalKey reads the hex encoded key from the file or generates it when
+// the file doesn't exist.
+func loadLocalKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := randomKey()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(path, []byte(strings.ToUpper(hex.EncodeToString(key))), 0o600); err != nil {
+			return nil, fmt.Errorf("writing key file: %w", err)
+		}
+
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key file must contain 32 hex encoded bytes")
+	}
+
+	return key, nil
+}
+
+func randomKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+
+	return key, nil
+}
+
+// EncryptKey encrypts the data encryption key with AES-GCM, the ID of the
+// key encryption key is authenticated with it.
+func (p *LocalKeyProvider) EncryptKey(dataKey []byte) (string, []byte, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	return p.keyID, p.aead.Seal(nonce, nonce, dataKey, []byte(p.keyID)), nil
+}
+
+// DecryptKey decrypts the data encryption key encrypted by EncryptKey.
+func (p *LocalKeyProvider) DecryptKey(keyID string, encryptedKey []byte) ([]byte, error) {
+	if keyID != p.keyID {
+		return nil, fmt.Errorf("unknown key encryption key %q", keyID)
+	}
+
+	if len(encryptedKey) < p.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted key is too short")
+	}
+
+	nonce, ciphertext := encryptedKey[:p.aead.NonceSize()], encryptedKey[p.aead.NonceSize():]
+
+	dataKey, err := p.aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting key: %w", err)
+	}
+
+	return dataKey, nil
+}
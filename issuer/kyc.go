@@ -0,0 +1,37 @@
+package issuer
+
+import (
+	"time"
+
+	"github.com/alovak/cardflow-playground/issuer/models"
+)
+
+// KYCVerifier verifies the identity of the customer. It returns the KYC
+// status with the reason if the customer is not verified.
+type KYCVerifier interface {
+	Verify(customer models.Customer) (models.KYCStatus, string, error)
+}
+
+// StubKYCVerifier is a local KYC verifier with deterministic rules:
+//   - customers under 18 are rejected
+//   - customers without the street, city or postal code stay pending
+//   - everyone else is verified
+type StubKYCVerifier struct{}
+
+func (v StubKYCVerifier) Verify(customer models.Customer) (models.KYCStatus, string, error) {
+	dateOfBirth, err := time.Parse(models.DateOfBirthLayout, customer.DateOfBirth)
+	if err != nil {
+		return models.KYCStatusRejected, "invalid date of birth", nil
+	}
+
+	if dateOfBirth.AddDate(18, 0, 0).After(time.Now()) {
+		return models.KYCStatusRejected, "customer is under 18", nil
+	}
+
+	address := customer.Address
+	if address.Line1 == "" || address.City == "" || address.PostalCode == "" {
+		return models.KYCStatusPending, "address can't be verified", nil
+	}
+
+	return models.KYCStatusVerified, "", nil
+}
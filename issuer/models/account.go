@@ -2,30 +2,111 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 )
 
-var ErrInsufficientFunds = errors.New("insufficient funds")
+var (
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrInsufficientHoldFunds = errors.New("insufficient hold funds")
+)
+
+type AccountType string
+
+const (
+	// debit account spends its own funds, it's the default type
+	AccountTypeDebit AccountType = "debit"
+	// credit account spends the funds borrowed up to the credit limit and
+	// is billed monthly
+	AccountTypeCredit AccountType = "credit"
+)
 
 type CreateAccount struct {
-	Balance  int64
-	Currency string
+	CustomerID string
+	Type       AccountType
+	Balance    int64
+	Currency   string
+	// CreditLimit and InterestRate are set for credit accounts only
+	CreditLimit int64
+	// InterestRate is the annual percentage rate in basis points, e.g. 2000
+	// is 20%
+	InterestRate int64
+}
+
+func (c CreateAccount) Validate() error {
+	switch c.Type {
+	case AccountTypeDebit:
+		if c.CreditLimit != 0 || c.InterestRate != 0 {
+			return fmt.Errorf("%w: credit limit and interest rate are set for credit accounts only", ErrValidation)
+		}
+	case AccountTypeCredit:
+		if c.CreditLimit <= 0 {
+			return fmt.Errorf("%w: credit limit must be positive", ErrValidation)
+		}
+		if c.InterestRate < 0 {
+			return fmt.Errorf("%w: interest rate must not be negative", ErrValidation)
+		}
+		if c.Balance != 0 {
+			return fmt.Errorf("%w: credit account is opened with zero balance", ErrValidation)
+		}
+	default:
+		return fmt.Errorf("%w: unknown account type %q", ErrValidation, c.Type)
+	}
+
+	return nil
 }
 
+// Account holds the funds of the customer. The available balance of the
+// credit account is negative when the customer owes the issuer, it can go
+// down to the negative credit limit.
 type Account struct {
 	ID               string
+	CustomerID       string
+	Type             AccountType
 	AvailableBalance int64
 	HoldBalance      int64
 	Currency         string
+	CreditLimit      int64
+	InterestRate     int64
+	CreatedAt        time.Time
 
 	mu sync.Mutex
 }
 
+// openToBuy returns the amount that can be spent. For debit accounts it's
+// the available balance, for credit accounts it also includes the unused
+// credit.
+func (a *Account) openToBuy() int64 {
+	return a.AvailableBalance + a.CreditLimit
+}
+
+// OpenToBuy returns the amount that can be spent from the account.
+func (a *Account) OpenToBuy() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.openToBuy()
+}
+
+// Owed returns the amount the customer owes on the credit account, the
+// funds on hold are owed too.
+func (a *Account) Owed() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.AvailableBalance >= 0 {
+		return 0
+	}
+
+	return -a.AvailableBalance
+}
+
 func (a *Account) Hold(amount int64) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.AvailableBalance < amount {
+	if a.openToBuy() < amount {
 		return ErrInsufficientFunds
 	}
 
@@ -34,3 +115,127 @@ func (a *Account) Hold(amount int64) error {
 
 	return nil
 }
+
+// HoldUpTo puts the amount on hold or, if the available balance is not
+// enough, all of the available balance. It returns the held amount and
+// ErrInsufficientFunds if nothing is available.
+func (a *Account) HoldUpTo(amount int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	openToBuy := a.openToBuy()
+	if openToBuy <= 0 {
+		return 0, ErrInsufficientFunds
+	}
+
+	if openToBuy < amount {
+		amount = openToBuy
+	}
+
+	a.AvailableBalance -= amount
+	a.HoldBalance += amount
+
+	return amount, nil
+}
+
+// Balances returns the available balance and the ledger balance, which
+// includes the funds on hold. The available balance of the credit account
+// is the open-to-buy amount.
+func (a *Account) Balances() (int64, int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.openToBuy(), a.AvailableBalance + a.HoldBalance
+}
+
+// Deposit adds the amount to the available balance and returns the new
+// available balance.
+func (a *Account) Deposit(amount int64) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.AvailableBalance += amount
+
+	return a.AvailableBalance
+}
+
+// Charge takes the fee or the interest from the available balance and
+// returns the new available balance. Unlike Withdraw, it doesn't check the
+// balance as the issuer's charges are posted even over the credit limit.
+func (a *Account) Charge(amount int64) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.AvailableBalance -= amount
+
+	return a.AvailableBalance
+}
+
+// Withdraw takes the amount from the available balance and returns the new
+// available balance. Funds on hold can't be withdrawn.
+func (a *Account) Withdraw(amount int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.AvailableBalance < amount {
+		return a.AvailableBalance, ErrInsufficientFunds
+	}
+
+	a.AvailableBalance -= amount
+
+	return a.AvailableBalance, nil
+}
+
+// Transfer moves the amount from the available balance of one account to
+// another and returns their new available balances. Accounts are locked in
+// the order of their IDs, so concurrent transfers between the same accounts
+// don't deadlock.
+func Transfer(from, to *Account, amount int64) (int64, int64, error) {
+	first, second := from, to
+	if second.ID < first.ID {
+		first, second = second, first
+	}
+
+	first.mu.Lock()
+	defer first.mu.Unlock()
+
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	if from.AvailableBalance < amount {
+		return from.AvailableBalance, to.AvailableBalance, ErrInsufficientFunds
+	}
+
+	from.AvailableBalance -= amount
+	to.AvailableBalance += amount
+
+	return from.AvailableBalance, to.AvailableBalance, nil
+}
+
+// Release returns the amount from the hold balance back to the available
+// balance.
+func (a *Account) Release(amount int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.HoldBalance < amount {
+		return ErrInsufficientHoldFunds
+	}
+
+	a.HoldBalance -= amount
+	a.AvailableBalance += amount
+
+	return nil
+}
+
+// Rehold puts previously released amount on hold again. Unlike Hold, it
+// doesn't check the available balance as the funds were already given back to
+// the cardholder and have to be recovered even if it makes the available
+// balance negative.
+func (a *Account) Rehold(amount int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.AvailableBalance -= amount
+	a.HoldBalance += amount
+}
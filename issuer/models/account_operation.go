@@ -0,0 +1,70 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrCurrencyMismatch     = errors.New("currency doesn't match the account currency")
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for another request")
+)
+
+type CreateDeposit struct {
+	Amount   int64
+	Currency string
+}
+
+type CreateWithdrawal struct {
+	Amount   int64
+	Currency string
+}
+
+type CreateTransfer struct {
+	ToAccountID string
+	Amount      int64
+	Currency    string
+}
+
+type AccountOperationType string
+
+const (
+	AccountOperationTypeDeposit     AccountOperationType = "deposit"
+	AccountOperationTypeWithdrawal  AccountOperationType = "withdrawal"
+	AccountOperationTypeTransferIn  AccountOperationType = "transfer_in"
+	AccountOperationTypeTransferOut AccountOperationType = "transfer_out"
+	// payment, interest and late fee are posted to credit accounts only
+	AccountOperationTypePayment  AccountOperationType = "payment"
+	AccountOperationTypeInterest AccountOperationType = "interest"
+	AccountOperationTypeLateFee  AccountOperationType = "late_fee"
+)
+
+// AccountOperation is an entry of the account's funding history. A transfer
+// creates two operations, one for each account, with the same TransferID.
+type AccountOperation struct {
+	ID        string
+	AccountID string
+	Type      AccountOperationType
+	Amount    int64
+	Currency  string
+	// CounterpartyAccountID is the other account of the transfer
+	CounterpartyAccountID string
+	TransferID            string
+	// IdempotencyKey is the key the operation was requested with. Requests
+	// repeated with the same key return the original operation.
+	IdempotencyKey string
+	// AvailableBalance is the available balance after the operation
+	AvailableBalance int64
+	CreatedAt        time.Time
+}
+
+// BalanceChange returns the amount the operation changed the balance of the
+// account by, it's negative for the operations taking the funds.
+func (o *AccountOperation) BalanceChange() int64 {
+	switch o.Type {
+	case AccountOperationTypeDeposit, AccountOperationTypeTransferIn, AccountOperationTypePayment:
+		return o.Amount
+	default:
+		return -o.Amount
+	}
+}
@@ -1,10 +1,19 @@
 package models
 
 var (
-	ApprovalCodeApproved          = "00"
-	ApprovalCodeDeclined          = "05"
-	ApprovalCodeInvalidRequest    = "10"
-	ApprovalCodeInvalidCard       = "14"
-	ApprovalCodeInsufficientFunds = "51"
-	ApprovalCodeSystemError       = "99"
+	ApprovalCodeApproved           = "00"
+	ApprovalCodeDeclined           = "05"
+	ApprovalCodePartiallyApproved  = "10"
+	ApprovalCodeInvalidTransaction = "12"
+	ApprovalCodeInvalidAmount      = "13"
+	ApprovalCodeInvalidCard        = "14"
+	ApprovalCodeInsufficientFunds  = "51"
+	ApprovalCodeExpiredCard        = "54"
+	ApprovalCodeInvalidPIN         = "55"
+	ApprovalCodeNotPermitted       = "57"
+	ApprovalCodeExceedsLimit       = "61"
+	ApprovalCodeRestrictedCard     = "62"
+	ApprovalCodeSecurityViolation  = "63"
+	ApprovalCodePINTriesExceeded   = "75"
+	ApprovalCodeSystemError        = "99"
 )
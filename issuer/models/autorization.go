@@ -1,13 +1,53 @@
 package models
 
 type AuthorizationRequest struct {
-	Amount   int64
-	Currency string
-	Card     Card
-	Merchant Merchant
+	Amount                   int64
+	Currency                 string
+	Card                     Card
+	Merchant                 Merchant
+	RetrievalReferenceNumber string
+	// OriginalRetrievalReferenceNumber is set for incremental authorizations,
+	// it references the transaction which hold should be extended
+	OriginalRetrievalReferenceNumber string
+	// OriginalAuthorizationCode is the authorization code of the transaction
+	// referenced by the incremental authorization
+	OriginalAuthorizationCode string
+	// PartialApprovalSupported is set when the merchant accepts approval of
+	// the amount less than requested
+	PartialApprovalSupported bool
+	// TokenID is set when the request was made with the token, the card of
+	// the request is the detokenized card
+	TokenID string
+	// StoredCredential is set when the merchant made the request with the
+	// card it stored, such requests carry no CVV
+	StoredCredential StoredCredential
+	// PINBlock is the PIN block encrypted under the zone PIN key, it's set
+	// when the cardholder entered the PIN
+	PINBlock []byte
+	// PINBlockFormat is the ISO 9564-1 format of the PIN block, 0 or 4
+	PINBlockFormat int
+}
+
+// StoredCredential indicates that the card stored by the merchant was used
+// and who initiated the payment.
+type StoredCredential string
+
+const (
+	StoredCredentialCustomerInitiated StoredCredential = "C"
+	StoredCredentialMerchantInitiated StoredCredential = "M"
+	StoredCredentialRecurring         StoredCredential = "R"
+)
+
+// IsMerchantInitiated returns true if the merchant made the payment without
+// the customer, so the CVV can't be entered.
+func (s StoredCredential) IsMerchantInitiated() bool {
+	return s == StoredCredentialMerchantInitiated || s == StoredCredentialRecurring
 }
 
 type AuthorizationResponse struct {
 	AuthorizationCode string
 	ApprovalCode      string
+	// ApprovedAmount is the amount put on hold, it's less than the requested
+	// amount for partial approvals
+	ApprovedAmount int64
 }
@@ -0,0 +1,15 @@
+package models
+
+type BalanceInquiryRequest struct {
+	Card                     Card
+	Merchant                 Merchant
+	RetrievalReferenceNumber string
+}
+
+type BalanceInquiryResponse struct {
+	ApprovalCode     string
+	AvailableBalance int64
+	// LedgerBalance includes the funds on hold
+	LedgerBalance int64
+	Currency      string
+}
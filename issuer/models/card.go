@@ -1,9 +1,153 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var ErrCardNotMovable = errors.New("card can't be moved to the account")
+
+var pinPattern = regexp.MustCompile(`^[0-9]{4}$`)
+
+type CardType string
+
+const (
+	// primary card is issued to the owner of the account
+	CardTypePrimary CardType = "primary"
+	// secondary card is issued to the authorized user of the account
+	CardTypeSecondary CardType = "secondary"
+)
+
+// IssueCard is the request to issue a card. Empty request issues a primary
+// card to the owner of the account.
+type IssueCard struct {
+	Type CardType
+	// CustomerID is the authorized user of the secondary card
+	CustomerID string
+	// SpendingLimit is the limit of the card's authorizations per calendar
+	// month (UTC), zero means no limit. The limit is checked in addition to
+	// the account balance.
+	SpendingLimit int64
+
+	// Virtual card exists only as card details, it can have usage controls
+	Virtual bool
+	// SingleUse card is closed after the first approved authorization
+	SingleUse bool
+	// MerchantLocked card approves authorizations only of the merchant of
+	// the first approved authorization
+	MerchantLocked bool
+	// LockedAmount is the only amount the card approves authorizations for,
+	// zero means any amount
+	LockedAmount int64
+	// ExpiresAt is the custom expiry of the virtual card. The card expiration
+	// date is set to its month.
+	ExpiresAt time.Time
+}
+
+func (c IssueCard) Validate() error {
+	switch c.Type {
+	case CardTypePrimary:
+		if c.CustomerID != "" {
+			return fmt.Errorf("%w: primary card is issued to the account owner", ErrValidation)
+		}
+	case CardTypeSecondary:
+		if c.CustomerID == "" {
+			return fmt.Errorf("%w: customer ID of the authorized user is required", ErrValidation)
+		}
+	default:
+		return fmt.Errorf("%w: unknown card type %q", ErrValidation, c.Type)
+	}
+
+	if c.SpendingLimit < 0 {
+		return fmt.Errorf("%w: spending limit must not be negative", ErrValidation)
+	}
+
+	if c.LockedAmount < 0 {
+		return fmt.Errorf("%w: locked amount must not be negative", ErrValidation)
+	}
+
+	hasControls := c.SingleUse || c.MerchantLocked || c.LockedAmount != 0 || !c.ExpiresAt.IsZero()
+	if hasControls && !c.Virtual {
+		return fmt.Errorf("%w: usage controls and custom expiry are set for virtual cards only", ErrValidation)
+	}
+
+	return nil
+}
+
+// SetPIN is the request to set the PIN of the card. The PIN itself is never
+// stored.
+type SetPIN struct {
+	PIN string
+}
+
+// Validate returns an error wrapping ErrValidation if the PIN is not 4
+// digits. Only 4 digits are covered by the PIN verification value.
+func (s SetPIN) Validate() error {
+	if !pinPattern.MatchString(s.PIN) {
+		return fmt.Errorf("%w: PIN must be 4 digits", ErrValidation)
+	}
+
+	return nil
+}
+
+// MoveCard is the request to move the card to another account of the same
+// owner.
+type MoveCard struct {
+	AccountID string
+}
+
+type CardStatus string
+
+const (
+	CardStatusActive CardStatus = "active"
+	// closed card declines all authorizations
+	CardStatusClosed CardStatus = "closed"
+	// blocked card declines all authorizations until the new PIN is set, the
+	// card is blocked when the PIN tries limit is reached
+	CardStatusBlocked CardStatus = "blocked"
+)
+
 type Card struct {
-	ID                    string
-	AccountID             string
+	ID        string
+	AccountID string
+	// CustomerID is the cardholder, it's the account owner for primary cards
+	CustomerID     string
+	Type           CardType
+	Status         CardStatus
+	CardholderName string
+	SpendingLimit  int64
+	// Number and CardVerificationValue are shown only in the response of
+	// the card issuing. The number is stored encrypted, the CVV is not
+	// stored at all, it's verified by the HSM.
 	Number                string
-	ExpirationDate        string
 	CardVerificationValue string
+	// First6 and Last4 are the digits of the number safe to show
+	First6         string
+	Last4          string
+	ExpirationDate string
+
+	// usage controls of the virtual card
+	Virtual        bool
+	SingleUse      bool
+	MerchantLocked bool
+	LockedAmount   int64
+	// ExpiresAt is set for virtual cards with the custom expiry
+	ExpiresAt time.Time
+	// LockedMerchant is the merchant of the first approved authorization of
+	// the merchant-locked card
+	LockedMerchant *Merchant
+
+	// PINVerificationValue (PVV) is stored instead of the PIN, it's empty
+	// when the PIN is not set
+	PINVerificationValue string
+	// PINTries is the number of failed PIN tries since the last correct PIN
+	PINTries int
+}
+
+// HasUsageControls returns true if the authorizations of the card depend on
+// its previous authorizations.
+func (c *Card) HasUsageControls() bool {
+	return c.SpendingLimit > 0 || c.SingleUse || c.MerchantLocked
 }
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateOfBirthLayout is the layout of the customer's date of birth
+const DateOfBirthLayout = "2006-01-02"
+
+type Address struct {
+	Line1      string
+	Line2      string
+	City       string
+	PostalCode string
+	Country    string
+}
+
+type CreateCustomer struct {
+	FirstName string
+	LastName  string
+	Address   Address
+	// DateOfBirth is in the YYYY-MM-DD format
+	DateOfBirth string
+}
+
+// Validate returns an error if the customer can't be created.
+func (c CreateCustomer) Validate() error {
+	var errs []string
+
+	if strings.TrimSpace(c.FirstName) == "" {
+		errs = append(errs, "first name is required")
+	}
+
+	if strings.TrimSpace(c.LastName) == "" {
+		errs = append(errs, "last name is required")
+	}
+
+	if len(c.Address.Country) != 2 {
+		errs = append(errs, "country must be a 2-letter code")
+	}
+
+	dateOfBirth, err := time.Parse(DateOfBirthLayout, c.DateOfBirth)
+	switch {
+	case err != nil:
+		errs = append(errs, "date of birth must be in the YYYY-MM-DD format")
+	case dateOfBirth.After(time.Now()):
+		errs = append(errs, "date of birth is in the future")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrValidation, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+type KYCStatus string
+
+const (
+	KYCStatusPending  KYCStatus = "pending"
+	KYCStatusVerified KYCStatus = "verified"
+	KYCStatusRejected KYCStatus = "rejected"
+)
+
+type Customer struct {
+	ID          string
+	FirstName   string
+	LastName    string
+	Address     Address
+	DateOfBirth string
+	KYCStatus   KYCStatus
+	// KYCReason explains why the customer is not verified
+	KYCReason string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Name returns the full name of the customer.
+func (c *Customer) Name() string {
+	return c.FirstName + " " + c.LastName
+}
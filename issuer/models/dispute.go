@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidReasonCode        = errors.New("invalid dispute reason code")
+	ErrInvalidDisputeStatus     = errors.New("invalid dispute status")
+	ErrTransactionNotDisputable = errors.New("transaction can't be disputed")
+)
+
+// DisputeReasonCodes lists the chargeback reason codes the issuer accepts.
+var DisputeReasonCodes = map[string]string{
+	"4834": "Duplicate processing",
+	"4837": "No cardholder authorization",
+	"4853": "Cardholder dispute",
+	"4855": "Goods or services not provided",
+	"4863": "Cardholder does not recognize",
+}
+
+type CreateDispute struct {
+	ReasonCode string
+	Message    string
+}
+
+type EscalateDispute struct {
+	Message string
+}
+
+type DisputeStatus string
+
+const (
+	// chargeback was sent to the acquirer, cardholder got provisional credit
+	DisputeStatusChargeback DisputeStatus = "chargeback"
+	// merchant challenged the chargeback and provided evidence
+	DisputeStatusRepresentment DisputeStatus = "representment"
+	// issuer rejected the representment and escalated the dispute
+	DisputeStatusPreArbitration DisputeStatus = "pre_arbitration"
+	// dispute was resolved in favor of the cardholder
+	DisputeStatusWon DisputeStatus = "won"
+	// dispute was resolved in favor of the merchant
+	DisputeStatusLost DisputeStatus = "lost"
+)
+
+// disputeTransitions defines which statuses the dispute can move to from its
+// current status.
+var disputeTransitions = map[DisputeStatus][]DisputeStatus{
+	DisputeStatusChargeback:     {DisputeStatusRepresentment, DisputeStatusWon},
+	DisputeStatusRepresentment:  {DisputeStatusPreArbitration, DisputeStatusLost},
+	DisputeStatusPreArbitration: {DisputeStatusWon, DisputeStatusLost},
+}
+
+// DisputeStage is the stage of the dispute communicated between the issuer
+// and the acquirer.
+type DisputeStage string
+
+const (
+	DisputeStageChargeback     DisputeStage = "chargeback"
+	DisputeStageRepresentment  DisputeStage = "representment"
+	DisputeStagePreArbitration DisputeStage = "pre_arbitration"
+	// the sender accepts liability, the dispute is resolved in favor of the
+	// receiver
+	DisputeStageAccepted DisputeStage = "accepted"
+	// the acquirer rejects the pre-arbitration. As we don't model arbitration
+	// by the card network, the dispute is resolved in favor of the merchant
+	DisputeStageRejected DisputeStage = "rejected"
+)
+
+type Dispute struct {
+	ID                       string
+	TransactionID            string
+	AccountID                string
+	RetrievalReferenceNumber string
+	Amount                   int64
+	Currency                 string
+	ReasonCode               string
+	Status                   DisputeStatus
+	// Message is the cardholder's description of the dispute
+	Message string
+	// Evidence is provided by the merchant with the representment
+	Evidence  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CanTransition returns an error if the dispute can't be moved to the given
+// status from the current one.
+func (d *Dispute) CanTransition(status DisputeStatus) error {
+	for _, allowed := range disputeTransitions[d.Status] {
+		if allowed == status {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: can't move dispute from %s to %s", ErrInvalidDisputeStatus, d.Status, status)
+}
+
+// Transition moves the dispute to the given status if it's allowed from the
+// current one.
+func (d *Dispute) Transition(status DisputeStatus) error {
+	if err := d.CanTransition(status); err != nil {
+		return err
+	}
+
+	d.Status = status
+	d.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// DisputeNotification is a dispute message exchanged with the acquirer.
+type DisputeNotification struct {
+	DisputeID                string
+	RetrievalReferenceNumber string
+	Stage                    DisputeStage
+	ReasonCode               string
+	Amount                   int64
+	Currency                 string
+	Message                  string
+}
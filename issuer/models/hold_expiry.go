@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHoldExpiry  = 7 * 24 * time.Hour
+	extendedHoldExpiry = 30 * 24 * time.Hour
+)
+
+// HoldExpiry defines how long the funds of the authorized transaction stay on
+// hold before they are returned to the cardholder. Merchants like hotels and
+// car rentals charge the final amount long after the authorization, so their
+// holds live longer.
+type HoldExpiry struct {
+	Default time.Duration
+	// ByMCC overrides the default for the merchant category code
+	ByMCC map[string]time.Duration
+	// ByMCCRange overrides the default for the merchant category code
+	// ranges, e.g. hotel chains (3501-3999)
+	ByMCCRange []MCCRangeHoldExpiry
+}
+
+type MCCRangeHoldExpiry struct {
+	From   int
+	To     int
+	Expiry time.Duration
+}
+
+func DefaultHoldExpiry() HoldExpiry {
+	return HoldExpiry{
+		Default: defaultHoldExpiry,
+		ByMCC: map[string]time.Duration{
+			"7011": extendedHoldExpiry, // hotels and motels
+			"7512": extendedHoldExpiry, // car rental agencies
+			"7513": extendedHoldExpiry, // truck and utility trailer rentals
+		},
+		ByMCCRange: []MCCRangeHoldExpiry{
+			{From: 3351, To: 3441, Expiry: extendedHoldExpiry}, // car rental agencies
+			{From: 3501, To: 3999, Expiry: extendedHoldExpiry}, // hotels and motels
+		},
+	}
+}
+
+// For returns the hold expiry for the merchant category code.
+func (h HoldExpiry) For(mcc string) time.Duration {
+	if expiry, ok := h.ByMCC[mcc]; ok {
+		return expiry
+	}
+
+	if code, err := strconv.Atoi(mcc); err == nil {
+		for _, r := range h.ByMCCRange {
+			if code >= r.From && code <= r.To {
+				return r.Expiry
+			}
+		}
+	}
+
+	return h.Default
+}
@@ -5,4 +5,5 @@ type Merchant struct {
 	MCC        string // Merchant Category Code
 	PostalCode string
 	WebSite    string
+	TerminalID string
 }
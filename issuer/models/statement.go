@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// BillingPolicy defines how the credit accounts are billed.
+type BillingPolicy struct {
+	// MinimumPaymentPercent is the percent of the closing balance the
+	// customer has to pay by the due date
+	MinimumPaymentPercent int64
+	// MinimumPayment is the lowest minimum payment, unless the closing
+	// balance is lower
+	MinimumPayment int64
+	// PaymentDuePeriod is the time between the statement closing and the due
+	// date
+	PaymentDuePeriod time.Duration
+	// LateFee is charged when the minimum payment isn't paid by the due date
+	LateFee int64
+}
+
+// DefaultBillingPolicy returns the policy with 1% (at least $25) minimum
+// payment due in 25 days and $35 late fee.
+func DefaultBillingPolicy() BillingPolicy {
+	return BillingPolicy{
+		MinimumPaymentPercent: 1,
+		MinimumPayment:        25_00,
+		PaymentDuePeriod:      25 * 24 * time.Hour,
+		LateFee:               35_00,
+	}
+}
+
+// MinimumPaymentFor returns the minimum payment of the statement with the
+// closing balance.
+func (p BillingPolicy) MinimumPaymentFor(closingBalance int64) int64 {
+	payment := closingBalance * p.MinimumPaymentPercent / 100
+	if payment < p.MinimumPayment {
+		payment = p.MinimumPayment
+	}
+
+	if payment > closingBalance {
+		payment = closingBalance
+	}
+
+	return payment
+}
+
+type CreatePayment struct {
+	Amount   int64
+	Currency string
+}
+
+// Statement is the monthly statement of the credit account. Balances are
+// the amounts owed by the customer, authorized transactions are owed as
+// there is no clearing in the playground.
+type Statement struct {
+	ID          string
+	AccountID   string
+	PeriodStart time.Time
+	// PeriodEnd is the closing date of the statement, it's excluded from
+	// the period
+	PeriodEnd      time.Time
+	OpeningBalance int64
+	ClosingBalance int64
+	// Transactions are authorized during the period
+	Transactions []*Transaction
+	// Operations are payments, interest and fees posted during the period
+	Operations     []*AccountOperation
+	MinimumPayment int64
+	DueDate        time.Time
+	// LateFeeCharged is set when the minimum payment wasn't paid by the due
+	// date
+	LateFeeCharged bool
+	Currency       string
+}
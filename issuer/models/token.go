@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTokenStatus = errors.New("invalid token status")
+
+// TokenBIN is the BIN range of token numbers, card numbers are never issued
+// from it.
+const TokenBIN = "899000"
+
+// IsTokenNumber returns true if the number is from the token BIN range.
+func IsTokenNumber(number string) bool {
+	return strings.HasPrefix(number, TokenBIN)
+}
+
+// TokenDomain restricts where the token can be used. Empty fields don't
+// restrict the token.
+type TokenDomain struct {
+	// TokenRequestorID identifies the wallet or the card-on-file merchant
+	// the token is provisioned for
+	TokenRequestorID string
+	// MerchantName is the only merchant the token can be used with
+	MerchantName string
+}
+
+type CreateToken struct {
+	Domain TokenDomain
+}
+
+type TokenStatus string
+
+const (
+	TokenStatusActive    TokenStatus = "active"
+	TokenStatusSuspended TokenStatus = "suspended"
+	// deleted token can't be resumed
+	TokenStatusDeleted TokenStatus = "deleted"
+)
+
+// Token is the token PAN (DPAN) of the card. Authorizations with the token
+// are detokenized to the card before they are authorized.
+type Token struct {
+	ID             string
+	CardID         string
+	Number         string
+	ExpirationDate string
+	Domain         TokenDomain
+	Status         TokenStatus
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type DetokenizationRequest struct {
+	TokenNumber      string
+	ExpirationDate   string
+	TokenRequestorID string
+	Merchant         Merchant
+}
+
+// DetokenizationResponse contains the card of the token or the approval
+// code of the declined request.
+type DetokenizationResponse struct {
+	ApprovalCode string
+	TokenID      string
+	Card         Card
+}
@@ -1,15 +1,55 @@
 package models
 
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrValidation = errors.New("validation failed")
+
 type Transaction struct {
-	ID                string
-	AccountID         string
-	CardID            string
-	Amount            int64
-	Currency          string
-	AuthorizationCode string
-	ApprovalCode      string
-	Status            TransactionStatus
-	Merchant          Merchant
+	ID        string
+	AccountID string
+	CardID    string
+	// TokenID is set when the transaction was made with the token of the
+	// card
+	TokenID string
+	// StoredCredential is set when the transaction was made with the card
+	// stored by the merchant
+	StoredCredential StoredCredential
+	// Amount is less than the RequestedAmount when the authorization was
+	// partially approved
+	Amount                   int64
+	RequestedAmount          int64
+	Currency                 string
+	AuthorizationCode        string
+	ApprovalCode             string
+	RetrievalReferenceNumber string
+	Status                   TransactionStatus
+	// DeclineReason explains why the authorization was declined, it's empty
+	// for authorized transactions
+	DeclineReason DeclineReason
+	Merchant      Merchant
+	CreatedAt     time.Time
+	// HoldExpiresAt is when the held funds of the authorized transaction are
+	// returned to the cardholder
+	HoldExpiresAt time.Time
+	// ExpiredAt is when the hold of the expired transaction was released
+	ExpiredAt time.Time
+	// Increments are incremental authorizations of the transaction. Amount
+	// of the transaction includes the approved increments.
+	Increments []AuthorizationIncrement
+}
+
+// AuthorizationIncrement is an incremental authorization that extends the
+// hold of the authorized transaction.
+type AuthorizationIncrement struct {
+	Amount                   int64
+	ApprovalCode             string
+	DeclineReason            DeclineReason
+	RetrievalReferenceNumber string
+	CreatedAt                time.Time
 }
 
 type TransactionStatus string
@@ -17,4 +57,127 @@ type TransactionStatus string
 const (
 	TransactionStatusAuthorized TransactionStatus = "authorized"
 	TransactionStatusDeclined   TransactionStatus = "declined"
+	// the hold expired and the funds were returned to the cardholder
+	TransactionStatusExpired TransactionStatus = "expired"
 )
+
+// DeclineReason is the structured reason of the declined authorization. The
+// approval code sent to the acquirer is less specific, e.g. the acquirer
+// doesn't learn which card detail was wrong.
+type DeclineReason string
+
+const (
+	DeclineReasonCardNotFound          DeclineReason = "card_not_found"
+	DeclineReasonInvalidExpirationDate DeclineReason = "invalid_expiration_date"
+	DeclineReasonInvalidCVV            DeclineReason = "invalid_cvv"
+	DeclineReasonCardExpired           DeclineReason = "card_expired"
+	DeclineReasonInsufficientFunds     DeclineReason = "insufficient_funds"
+	DeclineReasonCustomerNotVerified   DeclineReason = "customer_not_verified"
+	// incremental authorization references a transaction that can't be
+	// incremented
+	DeclineReasonInvalidOriginalTransaction DeclineReason = "invalid_original_transaction"
+	// the card's monthly spending limit would be exceeded by the transaction
+	DeclineReasonSpendingLimitExceeded DeclineReason = "spending_limit_exceeded"
+	// usage controls of the virtual card
+	DeclineReasonCardClosed         DeclineReason = "card_closed"
+	DeclineReasonAmountNotAllowed   DeclineReason = "amount_not_allowed"
+	DeclineReasonMerchantNotAllowed DeclineReason = "merchant_not_allowed"
+	// PIN of the request
+	DeclineReasonPINNotSet        DeclineReason = "pin_not_set"
+	DeclineReasonInvalidPIN       DeclineReason = "invalid_pin"
+	DeclineReasonPINTriesExceeded DeclineReason = "pin_tries_exceeded"
+	DeclineReasonCardBlocked      DeclineReason = "card_blocked"
+)
+
+// Decline marks the transaction as declined with the given approval code and
+// reason.
+func (t *Transaction) Decline(approvalCode string, reason DeclineReason) {
+	t.Status = TransactionStatusDeclined
+	t.ApprovalCode = approvalCode
+	t.DeclineReason = reason
+}
+
+// HeldAmountAt returns the amount the transaction held on the account at
+// the given time. The hold is released when the transaction expires or is
+// disputed, and is put back when the cardholder loses the dispute. Approved
+// increments hold their amount from the time they were made.
+func (t *Transaction) HeldAmountAt(at time.Time, dispute *Dispute) int64 {
+	if !t.CreatedAt.Before(at) {
+		return 0
+	}
+
+	switch t.Status {
+	case TransactionStatusAuthorized:
+	case TransactionStatusExpired:
+		if !t.ExpiredAt.After(at) {
+			return 0
+		}
+	default:
+		return 0
+	}
+
+	if dispute != nil && dispute.CreatedAt.Before(at) {
+		lost := dispute.Status == DisputeStatusLost && dispute.UpdatedAt.Before(at)
+		if !lost {
+			return 0
+		}
+	}
+
+	amount := t.Amount
+	for _, increment := range t.Increments {
+		if increment.ApprovalCode == ApprovalCodeApproved && !increment.CreatedAt.Before(at) {
+			amount -= increment.Amount
+		}
+	}
+
+	return amount
+}
+
+// TransactionFilter defines the criteria for listing transactions. Zero
+// values are ignored.
+type TransactionFilter struct {
+	Status TransactionStatus
+	CardID string
+	// CreatedFrom is inclusive, CreatedTo is exclusive
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// MerchantName matches merchants with names containing it, ignoring
+	// case
+	MerchantName string
+	MerchantMCC  string
+	// AmountMin and AmountMax are inclusive
+	AmountMin int64
+	AmountMax int64
+
+	// Cursor is the NextCursor of the previous page
+	Cursor string
+	Limit  int
+}
+
+// Match returns true if the transaction matches the filter. Date range and
+// cursor are not checked here as they are handled by the repository index.
+func (f TransactionFilter) Match(transaction *Transaction) bool {
+	switch {
+	case f.Status != "" && transaction.Status != f.Status:
+		return false
+	case f.CardID != "" && transaction.CardID != f.CardID:
+		return false
+	case f.MerchantName != "" && !strings.Contains(strings.ToLower(transaction.Merchant.Name), strings.ToLower(f.MerchantName)):
+		return false
+	case f.MerchantMCC != "" && transaction.Merchant.MCC != f.MerchantMCC:
+		return false
+	case f.AmountMin != 0 && transaction.Amount < f.AmountMin:
+		return false
+	case f.AmountMax != 0 && transaction.Amount > f.AmountMax:
+		return false
+	}
+
+	return true
+}
+
+// TransactionList is a page of transactions sorted by creation time, newest
+// first. NextCursor is empty when there are no more transactions.
+type TransactionList struct {
+	Transactions []*Transaction
+	NextCursor   string
+}
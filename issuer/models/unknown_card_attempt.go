@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// UnknownCardAttempt is an authorization attempt with a card number the
+// issuer doesn't know. As there is no card or account to attach the attempt
+// to, it's kept in the audit store instead of the transactions. Only the
+// first 6 and the last 4 digits of the card number are stored.
+type UnknownCardAttempt struct {
+	ID                       string
+	CardFirst6               string
+	CardLast4                string
+	Amount                   int64
+	Currency                 string
+	ApprovalCode             string
+	DeclineReason            DeclineReason
+	RetrievalReferenceNumber string
+	Merchant                 Merchant
+	CreatedAt                time.Time
+}
@@ -0,0 +1,152 @@
+package issuer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	issuer8583 "github.com/alovak/cardflow-playground/issuer/iso8583"
+	"github.com/alovak/cardflow-playground/issuer/models"
+)
+
+// names of the HSM keys used by the issuer
+const (
+	// ZonePINKeyName is the key the acquirer encrypts PIN blocks under
+	ZonePINKeyName = issuer8583.ZonePINKeyName
+	// PINVerificationKeyName is the key PVVs are generated with
+	PINVerificationKeyName = "pvk"
+	// CardVerificationKeyName is the key CVVs are generated with
+	CardVerificationKeyName = "cvk"
+)
+
+const (
+	// pinVerificationKeyIndex (PVKI) identifies the PIN verification key
+	// the PVV was generated with
+	pinVerificationKeyIndex = 1
+
+	defaultPINTryLimit = 3
+)
+
+// newDefaultHSM returns the HSM with random PIN and card verification keys.
+// PIN blocks can't be verified until the zone PIN key shared with the
+// acquirer is set.
+func newDefaultHSM() *hsm.HSM {
+	h := hsm.New()
+
+	for name, keyType := range map[string]hsm.KeyType{
+		ZonePINKeyName:          hsm.KeyTypeZPK,
+		PINVerificationKeyName:  hsm.KeyTypePVK,
+		CardVerificationKeyName: hsm.KeyTypeCVK,
+	} {
+		if err := h.GenerateKey(name, keyType); err != nil {
+			panic(fmt.Sprintf("generating %s: %v", name, err))
+		}
+	}
+
+	return h
+}
+
+// SetHSM sets the HSM with the zone PIN key, the PIN verification key and
+// the card verification key named ZonePINKeyName, PINVerificationKeyName
+// and CardVerificationKeyName.
+func (i *Service) SetHSM(h *hsm.HSM) {
+	i.hsm = h
+}
+
+// SetPINTryLimit sets the number of failed PIN tries after which the card is
+// blocked.
+func (i *Service) SetPINTryLimit(limit int) {
+	i.pinTryLimit = limit
+}
+
+// SetPIN sets the new PIN of the card. Only the PVV of the PIN is stored.
+// The failed PIN tries are reset and the blocked card is unblocked.
+func (i *Service) SetPIN(cardID string, set models.SetPIN) error {
+	err := set.Validate()
+	if err != nil {
+		return err
+	}
+
+	card, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return fmt.Errorf("finding card: %w", err)
+	}
+
+	if card.Status == models.CardStatusClosed {
+		return fmt.Errorf("%w: card is closed", models.ErrValidation)
+	}
+
+	number, err := i.cardNumber(card)
+	if err != nil {
+		return err
+	}
+
+	pvv, err := i.hsm.GeneratePVV(PINVerificationKeyName, number, pinVerificationKeyIndex, set.PIN)
+	if err != nil {
+		return fmt.Errorf("generating PVV: %w", err)
+	}
+
+	i.pinMu.Lock()
+	defer i.pinMu.Unlock()
+
+	err = i.repo.SetCardPIN(card, pvv)
+	if err != nil {
+		return fmt.Errorf("setting card PIN: %w", err)
+	}
+
+	return nil
+}
+
+// verifyPIN verifies the PIN block of the request against the PVV of the
+// card and returns the approval code and the reason if the request should
+// be declined. Failed tries are counted and the card is blocked when their
+// limit is reached. Requests without the PIN block are not verified.
+func (i *Service) verifyPIN(card *models.Card, req models.AuthorizationRequest) (string, models.DeclineReason, error) {
+	if len(req.PINBlock) == 0 {
+		return "", "", nil
+	}
+
+	if card.PINVerificationValue == "" {
+		return models.ApprovalCodeInvalidPIN, models.DeclineReasonPINNotSet, nil
+	}
+
+	i.pinMu.Lock()
+	defer i.pinMu.Unlock()
+
+	valid, err := i.hsm.VerifyPIN(
+		ZonePINKeyName,
+		hsm.PINBlockFormat(req.PINBlockFormat),
+		req.PINBlock,
+		req.Card.Number,
+		PINVerificationKeyName,
+		pinVerificationKeyIndex,
+		card.PINVerificationValue,
+	)
+	// the PIN block which can't be decrypted is counted as the wrong PIN
+	if err != nil && !errors.Is(err, hsm.ErrInvalidPINBlock) {
+		return "", "", fmt.Errorf("verifying PIN: %w", err)
+	}
+
+	if valid {
+		err = i.repo.UpdateCardPINTries(card, 0, false)
+		if err != nil {
+			return "", "", fmt.Errorf("updating PIN tries: %w", err)
+		}
+
+		return "", "", nil
+	}
+
+	tries := card.PINTries + 1
+	blocked := tries >= i.pinTryLimit
+
+	err = i.repo.UpdateCardPINTries(card, tries, blocked)
+	if err != nil {
+		return "", "", fmt.Errorf("updating PIN tries: %w", err)
+	}
+
+	if blocked {
+		return models.ApprovalCodePINTriesExceeded, models.DeclineReasonPINTriesExceeded, nil
+	}
+
+	return models.ApprovalCodeInvalidPIN, models.DeclineReasonInvalidPIN, nil
+}
@@ -1,28 +1,102 @@
 package issuer
 
 import (
+	"encoding/base64"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alovak/cardflow-playground/issuer/models"
 )
 
-var ErrNotFound = fmt.Errorf("not found")
+var (
+	ErrNotFound      = fmt.Errorf("not found")
+	ErrAlreadyExists = fmt.Errorf("already exists")
+)
 
 type Repository struct {
+	Customers    []*models.Customer
 	Cards        []*models.Card
 	Accounts     []*models.Account
 	Transactions []*models.Transaction
+	Disputes     []*models.Dispute
+	Statements   []*models.Statement
+	Tokens       []*models.Token
+
+	// operations are the funding history of the accounts, operations by
+	// idempotency key are indexed by account ID and the key
+	operations                 map[string][]*models.AccountOperation
+	operationsByIdempotencyKey map[string]*models.AccountOperation
+
+	// cards are stored with their numbers encrypted by the vault and found
+	// by the keyed hashes of the numbers
+	encryptedCardNumbers map[string]EncryptedCardNumber
+	cardsByNumberIndex   map[string]*models.Card
+
+	// tokensByNumber keeps token numbers unique
+	tokensByNumber map[string]*models.Token
+
+	// UnknownCardAttempts is the audit store of authorization attempts with
+	// unknown cards
+	UnknownCardAttempts []*models.UnknownCardAttempt
+
+	// indexes of transactions, account and card transactions are sorted by
+	// CreatedAt and ID
+	transactionsByID    map[string]*models.Transaction
+	transactionsByRRN   map[string]*models.Transaction
+	accountTransactions map[string][]*models.Transaction
+	cardTransactions    map[string][]*models.Transaction
+	// heldTransactions are authorized transactions with funds on hold
+	heldTransactions map[string]*models.Transaction
 
 	mu sync.RWMutex
 }
 
 func NewRepository() *Repository {
 	return &Repository{
-		Cards:        make([]*models.Card, 0),
-		Accounts:     make([]*models.Account, 0),
-		Transactions: make([]*models.Transaction, 0),
+		Cards:                      make([]*models.Card, 0),
+		Accounts:                   make([]*models.Account, 0),
+		Transactions:               make([]*models.Transaction, 0),
+		Disputes:                   make([]*models.Dispute, 0),
+		Statements:                 make([]*models.Statement, 0),
+		Tokens:                     make([]*models.Token, 0),
+		UnknownCardAttempts:        make([]*models.UnknownCardAttempt, 0),
+		encryptedCardNumbers:       make(map[string]EncryptedCardNumber),
+		cardsByNumberIndex:         make(map[string]*models.Card),
+		tokensByNumber:             make(map[string]*models.Token),
+		operations:                 make(map[string][]*models.AccountOperation),
+		operationsByIdempotencyKey: make(map[string]*models.AccountOperation),
+		transactionsByID:           make(map[string]*models.Transaction),
+		transactionsByRRN:          make(map[string]*models.Transaction),
+		accountTransactions:        make(map[string][]*models.Transaction),
+		cardTransactions:           make(map[string][]*models.Transaction),
+		heldTransactions:           make(map[string]*models.Transaction),
+	}
+}
+
+func (r *Repository) CreateCustomer(customer *models.Customer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Customers = append(r.Customers, customer)
+
+	return nil
+}
+
+func (r *Repository) GetCustomer(customerID string) (*models.Customer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, customer := range r.Customers {
+		if customer.ID == customerID {
+			return customer, nil
+		}
 	}
+
+	return nil, ErrNotFound
 }
 
 func (r *Repository) CreateAccount(account *models.Account) error {
@@ -47,26 +121,406 @@ func (r *Repository) GetAccount(accountID string) (*models.Account, error) {
 	return nil, ErrNotFound
 }
 
-func (r *Repository) CreateCard(card *models.Card) error {
+// CreateAccountOperations stores the operations, operations of the transfer
+// are stored together.
+func (r *Repository) CreateAccountOperations(operations ...*models.AccountOperation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, operation := range operations {
+		r.operations[operation.AccountID] = append(r.operations[operation.AccountID], operation)
+
+		if operation.IdempotencyKey != "" {
+			r.operationsByIdempotencyKey[operation.AccountID+"|"+operation.IdempotencyKey] = operation
+		}
+	}
+
+	return nil
+}
+
+// FindAccountOperationByIdempotencyKey returns the operation of the account
+// created with the given idempotency key.
+func (r *Repository) FindAccountOperationByIdempotencyKey(accountID, key string) (*models.AccountOperation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operation, ok := r.operationsByIdempotencyKey[accountID+"|"+key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return operation, nil
+}
+
+// ListAccountOperations returns the funding history of the account, newest
+// first.
+func (r *Repository) ListAccountOperations(accountID string) ([]*models.AccountOperation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operations := make([]*models.AccountOperation, 0, len(r.operations[accountID]))
+	for i := len(r.operations[accountID]) - 1; i >= 0; i-- {
+		operations = append(operations, r.operations[accountID][i])
+	}
+
+	return operations, nil
+}
+
+// ListAccountOperationsInPeriod returns operations of the account created
+// in the [from, to) period, oldest first.
+func (r *Repository) ListAccountOperationsInPeriod(accountID string, from, to time.Time) ([]*models.AccountOperation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operations := make([]*models.AccountOperation, 0)
+	for _, operation := range r.operations[accountID] {
+		if !operation.CreatedAt.Before(from) && operation.CreatedAt.Before(to) {
+			operations = append(operations, operation)
+		}
+	}
+
+	return operations, nil
+}
+
+// AccountBalancesAt returns the posted balance of the account and the amount
+// held by its transactions at the given time. The posted balance is the sum
+// of the operations created before the time.
+func (r *Repository) AccountBalancesAt(accountID string, at time.Time) (int64, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var posted int64
+	for _, operation := range r.operations[accountID] {
+		if operation.CreatedAt.Before(at) {
+			posted += operation.BalanceChange()
+		}
+	}
+
+	disputes := make(map[string]*models.Dispute)
+	for _, dispute := range r.Disputes {
+		if dispute.AccountID == accountID {
+			disputes[dispute.TransactionID] = dispute
+		}
+	}
+
+	var held int64
+	for _, transaction := range r.accountTransactions[accountID] {
+		if !transaction.CreatedAt.Before(at) {
+			break
+		}
+
+		held += transaction.HeldAmountAt(at, disputes[transaction.ID])
+	}
+
+	return posted, held, nil
+}
+
+// ListCreditAccounts returns accounts of the credit type.
+func (r *Repository) ListCreditAccounts() ([]*models.Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := make([]*models.Account, 0)
+	for _, account := range r.Accounts {
+		if account.Type == models.AccountTypeCredit {
+			accounts = append(accounts, account)
+		}
+	}
+
+	return accounts, nil
+}
+
+func (r *Repository) CreateStatement(statement *models.Statement) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Statements = append(r.Statements, statement)
+
+	return nil
+}
+
+func (r *Repository) GetStatement(statementID string) (*models.Statement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, statement := range r.Statements {
+		if statement.ID == statementID {
+			copied := *statement
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// MarkLateFeeCharged marks that the late fee was charged for the statement.
+func (r *Repository) MarkLateFeeCharged(statementID string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	for _, statement := range r.Statements {
+		if statement.ID == statementID {
+			statement.LateFeeCharged = true
+
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// ListStatements returns statements of the account, newest first.
+func (r *Repository) ListStatements(accountID string) ([]*models.Statement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statements := make([]*models.Statement, 0)
+	for i := len(r.Statements) - 1; i >= 0; i-- {
+		if r.Statements[i].AccountID == accountID {
+			copied := *r.Statements[i]
+			statements = append(statements, &copied)
+		}
+	}
+
+	return statements, nil
+}
+
+// FindLastStatement returns the latest statement of the account.
+func (r *Repository) FindLastStatement(accountID string) (*models.Statement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.Statements) - 1; i >= 0; i-- {
+		if r.Statements[i].AccountID == accountID {
+			copied := *r.Statements[i]
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// CreateCard stores the card with its encrypted number and the keyed hash
+// of the number it's found by.
+func (r *Repository) CreateCard(card *models.Card, encryptedNumber EncryptedCardNumber, numberIndex string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cardsByNumberIndex[numberIndex]; ok {
+		return fmt.Errorf("card number %w", ErrAlreadyExists)
+	}
+
 	r.Cards = append(r.Cards, card)
+	r.encryptedCardNumbers[card.ID] = encryptedNumber
+	r.cardsByNumberIndex[numberIndex] = card
 
 	return nil
 }
 
-func (r *Repository) FindCardForAuthorization(card models.Card) (*models.Card, error) {
+// FindCardByNumberIndex returns the card with the keyed hash of the number.
+// Card details are verified by the service, so it can record why the
+// authorization was declined.
+func (r *Repository) FindCardByNumberIndex(numberIndex string) (*models.Card, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	for _, c := range r.Cards {
-		match := c.Number == card.Number &&
-			c.ExpirationDate == card.ExpirationDate &&
-			c.CardVerificationValue == card.CardVerificationValue
+	card, ok := r.cardsByNumberIndex[numberIndex]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return card, nil
+}
 
-		if match {
-			return c, nil
+// GetCardNumber returns the encrypted number of the card.
+func (r *Repository) GetCardNumber(cardID string) (EncryptedCardNumber, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	encryptedNumber, ok := r.encryptedCardNumbers[cardID]
+	if !ok {
+		return EncryptedCardNumber{}, ErrNotFound
+	}
+
+	return encryptedNumber, nil
+}
+
+// CreateToken stores the token. Token numbers are unique, the token with the
+// number already in use is not stored.
+func (r *Repository) CreateToken(token *models.Token) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokensByNumber[token.Number]; ok {
+		return fmt.Errorf("token number %w", ErrAlreadyExists)
+	}
+
+	// the copy is stored, so the token returned to the caller isn't changed
+	// by UpdateToken
+	stored := *token
+	r.Tokens = append(r.Tokens, &stored)
+	r.tokensByNumber[stored.Number] = &stored
+
+	return nil
+}
+
+// GetToken returns the copy of the token made under the lock, so it can be
+// read while the token is updated with UpdateToken.
+func (r *Repository) GetToken(tokenID string) (*models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, token := range r.Tokens {
+		if token.ID == tokenID {
+			copied := *token
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// UpdateToken applies the update to the token under the repository lock and
+// returns the copy of the updated token.
+func (r *Repository) UpdateToken(tokenID string, update func(token *models.Token) error) (*models.Token, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.Tokens {
+		if token.ID == tokenID {
+			if err := update(token); err != nil {
+				return nil, err
+			}
+
+			copied := *token
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// FindTokenByNumber returns the copy of the token with the number.
+func (r *Repository) FindTokenByNumber(number string) (*models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	token, ok := r.tokensByNumber[number]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	copied := *token
+
+	return &copied, nil
+}
+
+// ListCardTokens returns copies of the tokens of the card in the order they
+// were provisioned.
+func (r *Repository) ListCardTokens(cardID string) ([]*models.Token, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tokens := make([]*models.Token, 0)
+	for _, token := range r.Tokens {
+		if token.CardID == cardID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+
+	return tokens, nil
+}
+
+// ListAccountCards returns cards of the account in the order they were
+// issued.
+func (r *Repository) ListAccountCards(accountID string) ([]*models.Card, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cards := make([]*models.Card, 0)
+	for _, card := range r.Cards {
+		if card.AccountID == accountID {
+			cards = append(cards, card)
+		}
+	}
+
+	return cards, nil
+}
+
+// MoveCard moves the card to another account.
+func (r *Repository) MoveCard(card *models.Card, accountID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	card.AccountID = accountID
+
+	return nil
+}
+
+// SetCardPIN stores the PVV of the card's new PIN, resets the failed PIN
+// tries and unblocks the card.
+func (r *Repository) SetCardPIN(card *models.Card, pvv string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	card.PINVerificationValue = pvv
+	card.PINTries = 0
+
+	if card.Status == models.CardStatusBlocked {
+		card.Status = models.CardStatusActive
+	}
+
+	return nil
+}
+
+// UpdateCardPINTries sets the number of the failed PIN tries of the card and
+// blocks the card if blocked is true.
+func (r *Repository) UpdateCardPINTries(card *models.Card, tries int, blocked bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	card.PINTries = tries
+
+	if blocked {
+		card.Status = models.CardStatusBlocked
+	}
+
+	return nil
+}
+
+// UseCard records the approved authorization of the card with the merchant.
+// The single-use card is closed and the merchant-locked card is locked to
+// the merchant of its first authorization.
+func (r *Repository) UseCard(card *models.Card, merchant models.Merchant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if card.SingleUse {
+		card.Status = models.CardStatusClosed
+	}
+
+	if card.MerchantLocked && card.LockedMerchant == nil {
+		card.LockedMerchant = &models.Merchant{
+			Name: merchant.Name,
+			MCC:  merchant.MCC,
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) GetCard(cardID string) (*models.Card, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, card := range r.Cards {
+		if card.ID == cardID {
+			return card, nil
 		}
 	}
 
@@ -78,22 +532,403 @@ func (r *Repository) CreateTransaction(transaction *models.Transaction) error {
 	defer r.mu.Unlock()
 
 	r.Transactions = append(r.Transactions, transaction)
+	r.transactionsByID[transaction.ID] = transaction
+	if transaction.RetrievalReferenceNumber != "" {
+		r.transactionsByRRN[transaction.RetrievalReferenceNumber] = transaction
+	}
+	r.accountTransactions[transaction.AccountID] = insertTransaction(r.accountTransactions[transaction.AccountID], transaction)
+	r.cardTransactions[transaction.CardID] = insertTransaction(r.cardTransactions[transaction.CardID], transaction)
+
+	if transaction.Status == models.TransactionStatusAuthorized && !transaction.HoldExpiresAt.IsZero() {
+		r.heldTransactions[transaction.ID] = transaction
+	}
 
 	return nil
 }
 
-// ListTransactions returns all transactions for a given account ID.
-func (r *Repository) ListTransactions(accountID string) ([]*models.Transaction, error) {
+// SumCardAuthorizedAmount returns the amount of the card's authorized
+// transactions created since the given time.
+func (r *Repository) SumCardAuthorizedAmount(cardID string, since time.Time) (int64, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	var transactions []*models.Transaction
+	transactions := r.cardTransactions[cardID]
+	start := sort.Search(len(transactions), func(i int) bool {
+		return !transactions[i].CreatedAt.Before(since)
+	})
+
+	var sum int64
+	for _, transaction := range transactions[start:] {
+		if transaction.Status == models.TransactionStatusAuthorized {
+			sum += transaction.Amount
+		}
+	}
+
+	return sum, nil
+}
+
+// ListAuthorizedTransactionsInPeriod returns copies of authorized
+// transactions of the account created in the [from, to) period, oldest
+// first.
+func (r *Repository) ListAuthorizedTransactionsInPeriod(accountID string, from, to time.Time) ([]*models.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transactions := r.accountTransactions[accountID]
+	start := sort.Search(len(transactions), func(i int) bool {
+		return !transactions[i].CreatedAt.Before(from)
+	})
+
+	result := make([]*models.Transaction, 0)
+	for _, transaction := range transactions[start:] {
+		if !transaction.CreatedAt.Before(to) {
+			break
+		}
 
-	for _, transaction := range r.Transactions {
-		if transaction.AccountID == accountID {
-			transactions = append(transactions, transaction)
+		if transaction.Status == models.TransactionStatusAuthorized {
+			result = append(result, copyTransaction(transaction))
+		}
+	}
+
+	return result, nil
+}
+
+// FindTransactionByRRN returns the copy of the transaction with the given
+// retrieval reference number.
+func (r *Repository) FindTransactionByRRN(rrn string) (*models.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transaction, ok := r.transactionsByRRN[rrn]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyTransaction(transaction), nil
+}
+
+// ListExpiredHolds returns copies of transactions with funds on hold that
+// expire before or at the given time.
+func (r *Repository) ListExpiredHolds(now time.Time) ([]*models.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var transactions []*models.Transaction
+	for _, transaction := range r.heldTransactions {
+		if !transaction.HoldExpiresAt.After(now) {
+			transactions = append(transactions, copyTransaction(transaction))
 		}
 	}
 
 	return transactions, nil
 }
+
+// AddHold adds the transaction to the transactions with funds on hold, e.g.
+// when the released hold was put back.
+func (r *Repository) AddHold(transactionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transaction, ok := r.transactionsByID[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	r.heldTransactions[transaction.ID] = transaction
+
+	return nil
+}
+
+// ExpireHold marks the transaction as expired at the given time and removes
+// it from the transactions with funds on hold.
+func (r *Repository) ExpireHold(transactionID string, expiredAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transaction, ok := r.transactionsByID[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	transaction.Status = models.TransactionStatusExpired
+	transaction.ExpiredAt = expiredAt
+	delete(r.heldTransactions, transactionID)
+
+	return nil
+}
+
+// RemoveHold removes the transaction from the transactions with funds on
+// hold, e.g. when the hold was released.
+func (r *Repository) RemoveHold(transactionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.heldTransactions, transactionID)
+
+	return nil
+}
+
+// IncrementTransaction records the incremental authorization of the
+// transaction. The approved amount is added to the amount of the transaction
+// and its hold is extended until holdExpiresAt.
+func (r *Repository) IncrementTransaction(transactionID string, increment models.AuthorizationIncrement, holdExpiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transaction, ok := r.transactionsByID[transactionID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	transaction.Increments = append(transaction.Increments, increment)
+
+	if increment.ApprovalCode == models.ApprovalCodeApproved {
+		transaction.Amount += increment.Amount
+		transaction.RequestedAmount += increment.Amount
+		transaction.HoldExpiresAt = holdExpiresAt
+	}
+
+	return nil
+}
+
+// GetTransaction returns the copy of the transaction made under the lock,
+// so it can be read while the transaction is updated.
+func (r *Repository) GetTransaction(transactionID string) (*models.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transaction, ok := r.transactionsByID[transactionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyTransaction(transaction), nil
+}
+
+// ListTransactions returns a page of account's transactions matching the
+// filter, newest first.
+func (r *Repository) ListTransactions(accountID string, filter models.TransactionFilter) (*models.TransactionList, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return listTransactions(r.accountTransactions[accountID], filter)
+}
+
+// ListCardTransactions returns a page of card's transactions matching the
+// filter, newest first.
+func (r *Repository) ListCardTransactions(cardID string, filter models.TransactionFilter) (*models.TransactionList, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return listTransactions(r.cardTransactions[cardID], filter)
+}
+
+func (r *Repository) CreateDispute(dispute *models.Dispute) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Disputes = append(r.Disputes, dispute)
+
+	return nil
+}
+
+// GetDispute returns the copy of the dispute made under the lock, so it can
+// be read while the dispute is updated with UpdateDispute.
+func (r *Repository) GetDispute(disputeID string) (*models.Dispute, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, dispute := range r.Disputes {
+		if dispute.ID == disputeID {
+			copied := *dispute
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// UpdateDispute applies the update to the dispute under the repository lock
+// and returns the copy of the updated dispute.
+func (r *Repository) UpdateDispute(disputeID string, update func(dispute *models.Dispute) error) (*models.Dispute, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, dispute := range r.Disputes {
+		if dispute.ID == disputeID {
+			if err := update(dispute); err != nil {
+				return nil, err
+			}
+
+			copied := *dispute
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+// FindDisputeForTransaction returns the copy of the dispute opened for the
+// given transaction ID.
+func (r *Repository) FindDisputeForTransaction(transactionID string) (*models.Dispute, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, dispute := range r.Disputes {
+		if dispute.TransactionID == transactionID {
+			copied := *dispute
+
+			return &copied, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (r *Repository) CreateUnknownCardAttempt(attempt *models.UnknownCardAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.UnknownCardAttempts = append(r.UnknownCardAttempts, attempt)
+
+	return nil
+}
+
+// ListUnknownCardAttempts returns attempts with cards ending with the given
+// last 4 digits, newest first. All attempts are returned if last4 is empty.
+func (r *Repository) ListUnknownCardAttempts(last4 string) ([]*models.UnknownCardAttempt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	attempts := make([]*models.UnknownCardAttempt, 0)
+	for i := len(r.UnknownCardAttempts) - 1; i >= 0; i-- {
+		attempt := r.UnknownCardAttempts[i]
+		if last4 == "" || attempt.CardLast4 == last4 {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	return attempts, nil
+}
+
+// insertTransaction inserts the transaction into the sorted slice. As
+// transactions are created in order most of the time, we are usually
+// appending to the end of the slice.
+func insertTransaction(transactions []*models.Transaction, transaction *models.Transaction) []*models.Transaction {
+	i := sort.Search(len(transactions), func(i int) bool {
+		return transactionLess(transaction, transactions[i])
+	})
+
+	transactions = append(transactions, nil)
+	copy(transactions[i+1:], transactions[i:])
+	transactions[i] = transaction
+
+	return transactions
+}
+
+// listTransactions returns a page of sorted transactions matching the filter,
+// newest first. The date range and the cursor are found with binary search
+// and only the transactions within the range are checked against the rest of
+// the filter.
+func listTransactions(transactions []*models.Transaction, filter models.TransactionFilter) (*models.TransactionList, error) {
+	start := 0
+	if !filter.CreatedFrom.IsZero() {
+		start = sort.Search(len(transactions), func(i int) bool {
+			return !transactions[i].CreatedAt.Before(filter.CreatedFrom)
+		})
+	}
+
+	end := len(transactions)
+	if !filter.CreatedTo.IsZero() {
+		end = sort.Search(len(transactions), func(i int) bool {
+			return !transactions[i].CreatedAt.Before(filter.CreatedTo)
+		})
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeTransactionCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		// only transactions created before the cursor are returned
+		cursorEnd := sort.Search(len(transactions), func(i int) bool {
+			return !transactionLess(transactions[i], cursor)
+		})
+		if cursorEnd < end {
+			end = cursorEnd
+		}
+	}
+
+	list := &models.TransactionList{
+		Transactions: make([]*models.Transaction, 0, filter.Limit),
+	}
+
+	for i := end - 1; i >= start; i-- {
+		if !filter.Match(transactions[i]) {
+			continue
+		}
+
+		if len(list.Transactions) == filter.Limit {
+			list.NextCursor = encodeTransactionCursor(list.Transactions[len(list.Transactions)-1])
+			break
+		}
+
+		list.Transactions = append(list.Transactions, copyTransaction(transactions[i]))
+	}
+
+	return list, nil
+}
+
+// copyTransaction returns the copy of the transaction with its own
+// increments.
+func copyTransaction(transaction *models.Transaction) *models.Transaction {
+	copied := *transaction
+	copied.Increments = append([]models.AuthorizationIncrement(nil), transaction.Increments...)
+
+	return &copied
+}
+
+// transactionLess defines the order of transactions in the indexes: by
+// creation time and by ID for transactions created at the same time.
+func transactionLess(a, b *models.Transaction) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+
+	return a.ID < b.ID
+}
+
+// encodeTransactionCursor encodes the position of the transaction in the
+// index as an opaque string.
+func encodeTransactionCursor(transaction *models.Transaction) string {
+	cursor := fmt.Sprintf("%d|%s", transaction.CreatedAt.UnixNano(), transaction.ID)
+
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeTransactionCursor returns a transaction with the creation time and
+// ID encoded in the cursor.
+func decodeTransactionCursor(cursor string) (*models.Transaction, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	nanos, id, found := strings.Cut(string(decoded), "|")
+	if !found {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", models.ErrValidation)
+	}
+
+	return &models.Transaction{
+		ID:        id,
+		CreatedAt: time.Unix(0, n),
+	}, nil
+}
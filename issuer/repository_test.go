@@ -0,0 +1,151 @@
+package issuer_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alovak/cardflow-playground/issuer"
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/stretchr/testify/require"
+)
+
+// createTransactions creates n transactions for the account, one per minute
+// starting from the given time, alternating between two cards and two
+// merchants.
+func createTransactions(t testing.TB, repo *issuer.Repository, accountID string, start time.Time, n int) {
+	merchants := []models.Merchant{
+		{Name: "Coffee Shop", MCC: "5814"},
+		{Name: "Grand Hotel", MCC: "7011"},
+	}
+
+	for i := 0; i < n; i++ {
+		err := repo.CreateTransaction(&models.Transaction{
+			ID:        fmt.Sprintf("%s-%06d", accountID, i),
+			AccountID: accountID,
+			CardID:    fmt.Sprintf("%s-card-%d", accountID, i%2),
+			Amount:    int64(i+1) * 100,
+			Currency:  "USD",
+			Status:    models.TransactionStatusAuthorized,
+			Merchant:  merchants[i%2],
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestRepositoryListTransactions(t *testing.T) {
+	repo := issuer.NewRepository()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	createTransactions(t, repo, "account-1", start, 50)
+	createTransactions(t, repo, "account-2", start, 10)
+
+	t.Run("pages are sorted newest first", func(t *testing.T) {
+		var ids []string
+		filter := models.TransactionFilter{Limit: 20}
+
+		for {
+			list, err := repo.ListTransactions("account-1", filter)
+			require.NoError(t, err)
+
+			for _, transaction := range list.Transactions {
+				ids = append(ids, transaction.ID)
+			}
+
+			if list.NextCursor == "" {
+				break
+			}
+
+			filter.Cursor = list.NextCursor
+		}
+
+		require.Len(t, ids, 50)
+		require.Equal(t, "account-1-000049", ids[0])
+		require.Equal(t, "account-1-000000", ids[49])
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		list, err := repo.ListTransactions("account-1", models.TransactionFilter{
+			MerchantName: "hotel",
+			CreatedFrom:  start.Add(10 * time.Minute),
+			CreatedTo:    start.Add(20 * time.Minute),
+			Limit:        100,
+		})
+		require.NoError(t, err)
+		require.Len(t, list.Transactions, 5) // 11, 13, 15, 17, 19
+		require.Equal(t, "account-1-000019", list.Transactions[0].ID)
+		require.Empty(t, list.NextCursor)
+
+		list, err = repo.ListTransactions("account-1", models.TransactionFilter{
+			MerchantMCC: "5814",
+			AmountMin:   1000,
+			AmountMax:   2000,
+			Limit:       100,
+		})
+		require.NoError(t, err)
+		require.Len(t, list.Transactions, 5) // 10, 12, 14, 16, 18
+	})
+
+	t.Run("card transactions", func(t *testing.T) {
+		list, err := repo.ListCardTransactions("account-2-card-1", models.TransactionFilter{Limit: 100})
+		require.NoError(t, err)
+		require.Len(t, list.Transactions, 5)
+
+		for _, transaction := range list.Transactions {
+			require.Equal(t, "account-2-card-1", transaction.CardID)
+		}
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		_, err := repo.ListTransactions("account-1", models.TransactionFilter{
+			Cursor: "invalid",
+			Limit:  10,
+		})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+}
+
+func TestRepositoryTokenNumbersAreUnique(t *testing.T) {
+	repo := issuer.NewRepository()
+
+	err := repo.CreateToken(&models.Token{ID: "token-1", CardID: "card-1", Number: "8990001234567897"})
+	require.NoError(t, err)
+
+	err = repo.CreateToken(&models.Token{ID: "token-2", CardID: "card-2", Number: "8990001234567897"})
+	require.ErrorIs(t, err, issuer.ErrAlreadyExists)
+
+	token, err := repo.FindTokenByNumber("8990001234567897")
+	require.NoError(t, err)
+	require.Equal(t, "token-1", token.ID)
+
+	tokens, err := repo.ListCardTokens("card-2")
+	require.NoError(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestRepositoryUpdateToken(t *testing.T) {
+	repo := issuer.NewRepository()
+
+	token := &models.Token{ID: "token-1", CardID: "card-1", Number: "8990001234567897", Status: models.TokenStatusActive}
+	require.NoError(t, repo.CreateToken(token))
+
+	updated, err := repo.UpdateToken("token-1", func(token *models.Token) error {
+		token.Status = models.TokenStatusSuspended
+
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.TokenStatusSuspended, updated.Status)
+
+	// the tokens returned by the repository are copies
+	require.Equal(t, models.TokenStatusActive, token.Status)
+	updated.Status = models.TokenStatusDeleted
+
+	found, err := repo.GetToken("token-1")
+	require.NoError(t, err)
+	require.Equal(t, models.TokenStatusSuspended, found.Status)
+
+	_, err = repo.UpdateToken("unknown", func(token *models.Token) error { return nil })
+	require.ErrorIs(t, err, issuer.ErrNotFound)
+}
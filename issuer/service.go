@@ -4,30 +4,204 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/alovak/cardflow-playground/internal/hsm"
 	"github.com/alovak/cardflow-playground/issuer/models"
 	"github.com/google/uuid"
 )
 
 type Service struct {
-	repo *Repository
+	repo            *Repository
+	disputeNotifier DisputeNotifier
+	kycVerifier     KYCVerifier
+	clock           Clock
+	holdExpiry      models.HoldExpiry
+	billingPolicy   models.BillingPolicy
+	hsm             *hsm.HSM
+	pinTryLimit     int
+	cardVault       *CardVault
+
+	// fundingMu serializes funding operations, so the request repeated with
+	// the same idempotency key is never executed twice
+	fundingMu sync.Mutex
+
+	// holdsMu serializes releasing and extending of the held funds, so the
+	// hold of the transaction can't be released by the dispute and by the
+	// expiry at the same time. It guards disputesInProgress, the
+	// transactions reserved for the dispute actions being sent to the
+	// acquirer.
+	holdsMu            sync.Mutex
+	disputesInProgress map[string]bool
+
+	// limitsMu serializes authorizations of cards with the spending limit or
+	// the usage controls
+	limitsMu sync.Mutex
+
+	// pinMu serializes PIN verifications with setting the PIN, so the
+	// failed PIN tries are counted correctly
+	pinMu sync.Mutex
+}
+
+// DisputeNotifier sends dispute notifications to the acquirer.
+type DisputeNotifier interface {
+	NotifyDispute(notification models.DisputeNotification) error
 }
 
 func NewService(repo *Repository) *Service {
 	return &Service{
-		repo: repo,
+		repo:          repo,
+		kycVerifier:   StubKYCVerifier{},
+		clock:         systemClock{},
+		holdExpiry:    models.DefaultHoldExpiry(),
+		billingPolicy: models.DefaultBillingPolicy(),
+		hsm:           newDefaultHSM(),
+		pinTryLimit:   defaultPINTryLimit,
+		cardVault:     newRandomCardVault(),
+
+		disputesInProgress: make(map[string]bool),
+	}
+}
+
+// SetCardVault sets the vault encrypting and indexing card numbers. By
+// default, the vault with random keys is used.
+func (i *Service) SetCardVault(vault *CardVault) {
+	i.cardVault = vault
+}
+
+// SetClock sets the clock used by the service.
+func (i *Service) SetClock(clock Clock) {
+	i.clock = clock
+}
+
+// SetHoldExpiry sets how long the funds of authorized transactions stay on
+// hold.
+func (i *Service) SetHoldExpiry(holdExpiry models.HoldExpiry) {
+	i.holdExpiry = holdExpiry
+}
+
+// SetBillingPolicy sets how the credit accounts are billed.
+func (i *Service) SetBillingPolicy(policy models.BillingPolicy) {
+	i.billingPolicy = policy
+}
+
+// SetKYCVerifier sets the verifier of the customers' identity.
+func (i *Service) SetKYCVerifier(verifier KYCVerifier) {
+	i.kycVerifier = verifier
+}
+
+// SetDisputeNotifier sets the notifier used to send dispute notifications to
+// the acquirer.
+func (i *Service) SetDisputeNotifier(notifier DisputeNotifier) {
+	i.disputeNotifier = notifier
+}
+
+// CreateCustomer creates the customer and verifies their identity. The
+// customer can use their cards only when verified.
+func (i *Service) CreateCustomer(create models.CreateCustomer) (*models.Customer, error) {
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	now := i.clock.Now()
+	customer := &models.Customer{
+		ID:          uuid.New().String(),
+		FirstName:   create.FirstName,
+		LastName:    create.LastName,
+		Address:     create.Address,
+		DateOfBirth: create.DateOfBirth,
+		KYCStatus:   models.KYCStatusPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	err = i.verifyCustomer(customer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.repo.CreateCustomer(customer)
+	if err != nil {
+		return nil, fmt.Errorf("creating customer: %w", err)
+	}
+
+	return customer, nil
+}
+
+func (i *Service) GetCustomer(customerID string) (*models.Customer, error) {
+	customer, err := i.repo.GetCustomer(customerID)
+	if err != nil {
+		return nil, fmt.Errorf("finding customer: %w", err)
+	}
+
+	return customer, nil
+}
+
+// VerifyCustomer runs the identity verification of the pending customer
+// again, e.g. after the KYC provider got more documents.
+func (i *Service) VerifyCustomer(customerID string) (*models.Customer, error) {
+	customer, err := i.repo.GetCustomer(customerID)
+	if err != nil {
+		return nil, fmt.Errorf("finding customer: %w", err)
+	}
+
+	if customer.KYCStatus == models.KYCStatusPending {
+		err = i.verifyCustomer(customer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return customer, nil
+}
+
+func (i *Service) verifyCustomer(customer *models.Customer) error {
+	status, reason, err := i.kycVerifier.Verify(*customer)
+	if err != nil {
+		return fmt.Errorf("verifying customer: %w", err)
 	}
+
+	customer.KYCStatus = status
+	customer.KYCReason = reason
+	customer.UpdatedAt = i.clock.Now()
+
+	return nil
 }
 
 func (i *Service) CreateAccount(req models.CreateAccount) (*models.Account, error) {
+	if req.Type == "" {
+		req.Type = models.AccountTypeDebit
+	}
+
+	err := req.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = i.repo.GetCustomer(req.CustomerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: customer %q not found", models.ErrValidation, req.CustomerID)
+		}
+
+		return nil, fmt.Errorf("finding customer: %w", err)
+	}
+
 	account := &models.Account{
 		ID:               uuid.New().String(),
+		CustomerID:       req.CustomerID,
+		Type:             req.Type,
 		AvailableBalance: req.Balance,
 		Currency:         req.Currency,
+		CreditLimit:      req.CreditLimit,
+		InterestRate:     req.InterestRate,
+		CreatedAt:        i.clock.Now(),
 	}
 
-	err := i.repo.CreateAccount(account)
+	err = i.repo.CreateAccount(account)
 	if err != nil {
 		return nil, fmt.Errorf("creating account: %w", err)
 	}
@@ -44,85 +218,1343 @@ func (i *Service) GetAccount(accountID string) (*models.Account, error) {
 	return account, nil
 }
 
-func (i *Service) IssueCard(accountID string) (*models.Card, error) {
-	card := &models.Card{
-		ID:                    uuid.New().String(),
+// Deposit adds funds to the account. A request repeated with the same
+// idempotency key returns the original operation.
+func (i *Service) Deposit(accountID, idempotencyKey string, create models.CreateDeposit) (*models.AccountOperation, error) {
+	i.fundingMu.Lock()
+	defer i.fundingMu.Unlock()
+
+	operation := &models.AccountOperation{
+		AccountID:      accountID,
+		Type:           models.AccountOperationTypeDeposit,
+		Amount:         create.Amount,
+		Currency:       create.Currency,
+		IdempotencyKey: idempotencyKey,
+	}
+
+	account, replayed, err := i.prepareAccountOperation(operation)
+	if err != nil || replayed != nil {
+		return replayed, err
+	}
+
+	if account.Type == models.AccountTypeCredit {
+		return nil, fmt.Errorf("%w: credit accounts accept payments only", models.ErrValidation)
+	}
+
+	operation.AvailableBalance = account.Deposit(operation.Amount)
+
+	err = i.repo.CreateAccountOperations(operation)
+	if err != nil {
+		return nil, fmt.Errorf("creating account operation: %w", err)
+	}
+
+	return operation, nil
+}
+
+// Withdraw takes funds from the available balance of the account. A request
+// repeated with the same idempotency key returns the original operation.
+func (i *Service) Withdraw(accountID, idempotencyKey string, create models.CreateWithdrawal) (*models.AccountOperation, error) {
+	i.fundingMu.Lock()
+	defer i.fundingMu.Unlock()
+
+	operation := &models.AccountOperation{
+		AccountID:      accountID,
+		Type:           models.AccountOperationTypeWithdrawal,
+		Amount:         create.Amount,
+		Currency:       create.Currency,
+		IdempotencyKey: idempotencyKey,
+	}
+
+	account, replayed, err := i.prepareAccountOperation(operation)
+	if err != nil || replayed != nil {
+		return replayed, err
+	}
+
+	operation.AvailableBalance, err = account.Withdraw(operation.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("withdrawing funds: %w", err)
+	}
+
+	err = i.repo.CreateAccountOperations(operation)
+	if err != nil {
+		return nil, fmt.Errorf("creating account operation: %w", err)
+	}
+
+	return operation, nil
+}
+
+// Transfer moves funds from the available balance of the account to another
+// account with the same currency. It returns the operation of the source
+// account. A request repeated with the same idempotency key returns the
+// original operation.
+func (i *Service) Transfer(accountID, idempotencyKey string, create models.CreateTransfer) (*models.AccountOperation, error) {
+	i.fundingMu.Lock()
+	defer i.fundingMu.Unlock()
+
+	out := &models.AccountOperation{
 		AccountID:             accountID,
-		Number:                generateFakeCardNumber(),
-		ExpirationDate:        time.Now().AddDate(3, 1, 0).Format("0106"), // 3 years, 1 month from now
-		CardVerificationValue: "1234",
+		Type:                  models.AccountOperationTypeTransferOut,
+		Amount:                create.Amount,
+		Currency:              create.Currency,
+		CounterpartyAccountID: create.ToAccountID,
+		IdempotencyKey:        idempotencyKey,
+	}
+
+	from, replayed, err := i.prepareAccountOperation(out)
+	if err != nil || replayed != nil {
+		return replayed, err
+	}
+
+	if create.ToAccountID == accountID {
+		return nil, fmt.Errorf("%w: can't transfer to the same account", models.ErrValidation)
+	}
+
+	to, err := i.repo.GetAccount(create.ToAccountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding destination account: %w", err)
+	}
+
+	if to.Currency != create.Currency {
+		return nil, fmt.Errorf("%w: destination account currency is %s", models.ErrCurrencyMismatch, to.Currency)
+	}
+
+	in := &models.AccountOperation{
+		ID:                    uuid.New().String(),
+		AccountID:             to.ID,
+		Type:                  models.AccountOperationTypeTransferIn,
+		Amount:                create.Amount,
+		Currency:              create.Currency,
+		CounterpartyAccountID: accountID,
+		TransferID:            uuid.New().String(),
+		CreatedAt:             out.CreatedAt,
+	}
+	out.TransferID = in.TransferID
+
+	out.AvailableBalance, in.AvailableBalance, err = models.Transfer(from, to, create.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("transferring funds: %w", err)
+	}
+
+	err = i.repo.CreateAccountOperations(out, in)
+	if err != nil {
+		return nil, fmt.Errorf("creating account operations: %w", err)
+	}
+
+	return out, nil
+}
+
+// prepareAccountOperation finds the account of the operation and validates
+// the operation against it. If the operation was already made with the same
+// idempotency key, the original operation is returned instead. Otherwise the
+// operation gets its ID and creation time.
+func (i *Service) prepareAccountOperation(operation *models.AccountOperation) (*models.Account, *models.AccountOperation, error) {
+	account, err := i.repo.GetAccount(operation.AccountID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	if operation.IdempotencyKey != "" {
+		original, err := i.repo.FindAccountOperationByIdempotencyKey(operation.AccountID, operation.IdempotencyKey)
+		switch {
+		case err == nil:
+			if original.Type != operation.Type ||
+				original.Amount != operation.Amount ||
+				original.Currency != operation.Currency ||
+				original.CounterpartyAccountID != operation.CounterpartyAccountID {
+				return nil, nil, models.ErrIdempotencyKeyReused
+			}
+
+			return account, original, nil
+		case !errors.Is(err, ErrNotFound):
+			return nil, nil, fmt.Errorf("finding operation by idempotency key: %w", err)
+		}
+	}
+
+	if operation.Amount <= 0 {
+		return nil, nil, fmt.Errorf("%w: amount must be positive", models.ErrValidation)
+	}
+
+	if operation.Currency != account.Currency {
+		return nil, nil, fmt.Errorf("%w: account currency is %s", models.ErrCurrencyMismatch, account.Currency)
+	}
+
+	operation.ID = uuid.New().String()
+	operation.CreatedAt = i.clock.Now()
+
+	return account, nil, nil
+}
+
+// ListAccountOperations returns the funding history of the account, newest
+// first.
+func (i *Service) ListAccountOperations(accountID string) ([]*models.AccountOperation, error) {
+	_, err := i.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	operations, err := i.repo.ListAccountOperations(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("listing account operations: %w", err)
+	}
+
+	return operations, nil
+}
+
+// IssueCard issues a new card for the account. The primary card is issued
+// to the account's owner, the secondary card to the authorized user. The
+// name of the cardholder is embossed on the card.
+func (i *Service) IssueCard(accountID string, create models.IssueCard) (*models.Card, error) {
+	if create.Type == "" {
+		create.Type = models.CardTypePrimary
+	}
+
+	err := create.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := i.repo.GetAccount(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	customerID := account.CustomerID
+	if create.Type == models.CardTypeSecondary {
+		customerID = create.CustomerID
+	}
+
+	customer, err := i.repo.GetCustomer(customerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("%w: customer %q not found", models.ErrValidation, customerID)
+		}
+
+		return nil, fmt.Errorf("finding customer: %w", err)
+	}
+
+	now := i.clock.Now()
+	expiresAt := now.AddDate(3, 1, 0) // 3 years, 1 month from now
+	if !create.ExpiresAt.IsZero() {
+		if !create.ExpiresAt.After(now) || create.ExpiresAt.After(expiresAt) {
+			return nil, fmt.Errorf("%w: virtual card must expire within %s", models.ErrValidation, expiresAt.Format(time.DateOnly))
+		}
+
+		expiresAt = create.ExpiresAt
+	}
+
+	number := generateFakeCardNumber()
+
+	card := &models.Card{
+		ID:             uuid.New().String(),
+		AccountID:      accountID,
+		CustomerID:     customer.ID,
+		Type:           create.Type,
+		Status:         models.CardStatusActive,
+		CardholderName: embossedName(customer.Name()),
+		SpendingLimit:  create.SpendingLimit,
+		First6:         number[:6],
+		Last4:          number[len(number)-4:],
+		ExpirationDate: expiresAt.Format("0106"),
+		Virtual:        create.Virtual,
+		SingleUse:      create.SingleUse,
+		MerchantLocked: create.MerchantLocked,
+		LockedAmount:   create.LockedAmount,
+		ExpiresAt:      create.ExpiresAt,
 	}
 
-	err := i.repo.CreateCard(card)
+	cvv, err := i.hsm.GenerateCVV(CardVerificationKeyName, number, cvvExpiry(card.ExpirationDate), cvv2ServiceCode)
+	if err != nil {
+		return nil, fmt.Errorf("generating CVV: %w", err)
+	}
+
+	encryptedNumber, err := i.cardVault.Encrypt(card.ID, number)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting card number: %w", err)
+	}
+
+	err = i.repo.CreateCard(card, encryptedNumber, i.cardVault.Index(number))
 	if err != nil {
 		return nil, fmt.Errorf("creating card: %w", err)
 	}
 
+	// the only time the card number and the CVV are shown
+	issued := *card
+	issued.Number = number
+	issued.CardVerificationValue = cvv
+
+	return &issued, nil
+}
+
+// findCardByNumber returns the card with the number found by its keyed hash.
+func (i *Service) findCardByNumber(number string) (*models.Card, error) {
+	return i.repo.FindCardByNumberIndex(i.cardVault.Index(number))
+}
+
+// cardNumber returns the decrypted number of the card.
+func (i *Service) cardNumber(card *models.Card) (string, error) {
+	encryptedNumber, err := i.repo.GetCardNumber(card.ID)
+	if err != nil {
+		return "", fmt.Errorf("finding card number: %w", err)
+	}
+
+	number, err := i.cardVault.Decrypt(card.ID, encryptedNumber)
+	if err != nil {
+		return "", fmt.Errorf("decrypting card number: %w", err)
+	}
+
+	return number, nil
+}
+
+func (i *Service) GetCard(cardID string) (*models.Card, error) {
+	card, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("finding card: %w", err)
+	}
+
 	return card, nil
 }
 
-// ListTransactions returns a list of transactions for the given account ID.
-func (i *Service) ListTransactions(accountID string) ([]*models.Transaction, error) {
-	transactions, err := i.repo.ListTransactions(accountID)
+// ListAccountCards returns primary and secondary cards of the account.
+func (i *Service) ListAccountCards(accountID string) ([]*models.Card, error) {
+	_, err := i.repo.GetAccount(accountID)
 	if err != nil {
-		return nil, fmt.Errorf("listing transactions: %w", err)
+		return nil, fmt.Errorf("finding account: %w", err)
 	}
 
-	return transactions, nil
+	cards, err := i.repo.ListAccountCards(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("listing cards: %w", err)
+	}
+
+	return cards, nil
 }
 
-func (i *Service) AuthorizeRequest(req models.AuthorizationRequest) (models.AuthorizationResponse, error) {
-	card, err := i.repo.FindCardForAuthorization(req.Card)
+// MoveCard moves the card to another account of the same owner and
+// currency. Authorizations of the card made before the move keep holding
+// the funds of the previous account.
+func (i *Service) MoveCard(cardID string, move models.MoveCard) (*models.Card, error) {
+	card, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("finding card: %w", err)
+	}
+
+	from, err := i.repo.GetAccount(card.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	to, err := i.repo.GetAccount(move.AccountID)
 	if err != nil {
 		if errors.Is(err, ErrNotFound) {
-			return models.AuthorizationResponse{
-				ApprovalCode: models.ApprovalCodeInvalidCard,
-			}, nil
+			return nil, fmt.Errorf("%w: account %q not found", models.ErrValidation, move.AccountID)
 		}
 
-		return models.AuthorizationResponse{}, fmt.Errorf("finding card: %w", err)
+		return nil, fmt.Errorf("finding account: %w", err)
 	}
 
-	account, err := i.repo.GetAccount(card.AccountID)
+	if to.CustomerID != from.CustomerID {
+		return nil, fmt.Errorf("%w: account %q has another owner", models.ErrCardNotMovable, to.ID)
+	}
+
+	if to.Currency != from.Currency {
+		return nil, fmt.Errorf("%w: account %q currency is %s", models.ErrCurrencyMismatch, to.ID, to.Currency)
+	}
+
+	err = i.repo.MoveCard(card, to.ID)
 	if err != nil {
-		return models.AuthorizationResponse{}, fmt.Errorf("finding account: %w", err)
+		return nil, fmt.Errorf("moving card: %w", err)
 	}
 
-	transaction := &models.Transaction{
-		ID:        uuid.New().String(),
-		AccountID: card.AccountID,
-		CardID:    card.ID,
-		Amount:    req.Amount,
-		Currency:  req.Currency,
-		Merchant:  req.Merchant,
+	return card, nil
+}
+
+// maxEmbossedNameLength is the number of characters that fit on the card
+const maxEmbossedNameLength = 26
+
+// embossedName returns the cardholder name as it's embossed on the card.
+func embossedName(name string) string {
+	runes := []rune(strings.ToUpper(name))
+	if len(runes) > maxEmbossedNameLength {
+		runes = runes[:maxEmbossedNameLength]
 	}
 
-	err = i.repo.CreateTransaction(transaction)
+	return string(runes)
+}
+
+const (
+	defaultTransactionsLimit = 20
+	maxTransactionsLimit     = 100
+)
+
+// ListTransactions returns a page of transactions for the given account ID
+// matching the filter, newest first.
+func (i *Service) ListTransactions(accountID string, filter models.TransactionFilter) (*models.TransactionList, error) {
+	_, err := i.repo.GetAccount(accountID)
 	if err != nil {
-		return models.AuthorizationResponse{}, fmt.Errorf("creating transaction: %w", err)
+		return nil, fmt.Errorf("finding account: %w", err)
 	}
 
-	// hold the funds on the account
-	err = account.Hold(req.Amount)
+	filter, err = validateTransactionFilter(filter)
 	if err != nil {
-		// handle insufficient funds
-		if !errors.Is(err, models.ErrInsufficientFunds) {
-			return models.AuthorizationResponse{}, fmt.Errorf("holding funds: %w", err)
-		}
+		return nil, err
+	}
 
-		return models.AuthorizationResponse{
-			ApprovalCode: models.ApprovalCodeInsufficientFunds,
-		}, nil
+	transactions, err := i.repo.ListTransactions(accountID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("listing transactions: %w", err)
 	}
 
-	transaction.ApprovalCode = models.ApprovalCodeApproved
-	transaction.AuthorizationCode = generateAuthorizationCode()
-	transaction.Status = models.TransactionStatusAuthorized
+	return transactions, nil
+}
 
-	return models.AuthorizationResponse{
-		AuthorizationCode: transaction.AuthorizationCode,
-		ApprovalCode:      transaction.ApprovalCode,
-	}, nil
+// ListCardTransactions returns a page of transactions for the given card ID
+// matching the filter, newest first.
+func (i *Service) ListCardTransactions(cardID string, filter models.TransactionFilter) (*models.TransactionList, error) {
+	_, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("finding card: %w", err)
+	}
+
+	filter, err = validateTransactionFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := i.repo.ListCardTransactions(cardID, filter)
+	if err != nil {
+		return nil, fmt.Errorf("listing transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+func (i *Service) GetTransaction(transactionID string) (*models.Transaction, error) {
+	transaction, err := i.repo.GetTransaction(transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("finding transaction: %w", err)
+	}
+
+	return transaction, nil
+}
+
+// validateTransactionFilter validates the filter and sets the default limit.
+func validateTransactionFilter(filter models.TransactionFilter) (models.TransactionFilter, error) {
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = defaultTransactionsLimit
+	case filter.Limit < 0 || filter.Limit > maxTransactionsLimit:
+		return filter, fmt.Errorf("%w: limit must be between 1 and %d", models.ErrValidation, maxTransactionsLimit)
+	}
+
+	if filter.AmountMax != 0 && filter.AmountMin > filter.AmountMax {
+		return filter, fmt.Errorf("%w: minimum amount is greater than maximum", models.ErrValidation)
+	}
+
+	if !filter.CreatedTo.IsZero() && filter.CreatedFrom.After(filter.CreatedTo) {
+		return filter, fmt.Errorf("%w: created from is after created to", models.ErrValidation)
+	}
+
+	return filter, nil
+}
+
+// AuthorizeRequest authorizes the request and holds the funds on the
+// account. Every attempt is recorded: as a transaction when the card is
+// known, or in the audit store of unknown card attempts otherwise.
+func (i *Service) AuthorizeRequest(req models.AuthorizationRequest) (models.AuthorizationResponse, error) {
+	if req.OriginalRetrievalReferenceNumber != "" {
+		return i.incrementAuthorization(req)
+	}
+
+	card, err := i.findCardByNumber(req.Card.Number)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return i.recordUnknownCardAttempt(req)
+		}
+
+		return models.AuthorizationResponse{}, fmt.Errorf("finding card: %w", err)
+	}
+
+	account, err := i.repo.GetAccount(card.AccountID)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("finding account: %w", err)
+	}
+
+	transaction := &models.Transaction{
+		ID:        uuid.New().String(),
+		AccountID: card.AccountID,
+		CardID:    card.ID,
+		TokenID:   req.TokenID,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Merchant:  req.Merchant,
+
+		StoredCredential:         req.StoredCredential,
+		RequestedAmount:          req.Amount,
+		RetrievalReferenceNumber: req.RetrievalReferenceNumber,
+		CreatedAt:                i.clock.Now(),
+	}
+
+	cardholderVerified, err := i.isCardholderVerified(account, card)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	// authorizations of the card with usage controls are serialized, so
+	// concurrent authorizations can't exceed the limit together or use the
+	// single-use card twice
+	if card.HasUsageControls() {
+		i.limitsMu.Lock()
+		defer i.limitsMu.Unlock()
+	}
+
+	withinLimit, err := i.isWithinSpendingLimit(card, transaction.RequestedAmount, transaction.CreatedAt)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	// tokenized requests are authenticated by the token, the cardholder of
+	// the PIN request by the PIN, and the merchant doesn't keep the CVV of
+	// the stored card, so the requests it makes without the customer carry
+	// no CVV. The customer enters the CVV of the stored card.
+	requireCVV := req.TokenID == "" && !req.StoredCredential.IsMerchantInitiated() && len(req.PINBlock) == 0
+	approvalCode, reason, err := i.verifyCard(card, req.Card, transaction.CreatedAt, requireCVV)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	if reason != "" {
+		transaction.Decline(approvalCode, reason)
+	} else if approvalCode, reason := checkUsageControls(card, req); reason != "" {
+		transaction.Decline(approvalCode, reason)
+	} else if !cardholderVerified {
+		transaction.Decline(models.ApprovalCodeNotPermitted, models.DeclineReasonCustomerNotVerified)
+	} else if approvalCode, reason, err := i.verifyPIN(card, req); err != nil || reason != "" {
+		if err != nil {
+			return models.AuthorizationResponse{}, err
+		}
+
+		transaction.Decline(approvalCode, reason)
+	} else if !withinLimit {
+		transaction.Decline(models.ApprovalCodeExceedsLimit, models.DeclineReasonSpendingLimitExceeded)
+	} else {
+		// the amount-locked card doesn't approve less than the locked amount
+		partialApprovalSupported := req.PartialApprovalSupported && card.LockedAmount == 0
+
+		err = i.holdFunds(account, transaction, partialApprovalSupported)
+		if err != nil {
+			return models.AuthorizationResponse{}, err
+		}
+	}
+
+	if transaction.Status == models.TransactionStatusAuthorized {
+		err = i.repo.UseCard(card, req.Merchant)
+		if err != nil {
+			return models.AuthorizationResponse{}, fmt.Errorf("using card: %w", err)
+		}
+	}
+
+	// the transaction is stored when it's complete, so it's never listed
+	// without the status
+	err = i.repo.CreateTransaction(transaction)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("creating transaction: %w", err)
+	}
+
+	response := models.AuthorizationResponse{
+		AuthorizationCode: transaction.AuthorizationCode,
+		ApprovalCode:      transaction.ApprovalCode,
+	}
+
+	if transaction.Status == models.TransactionStatusAuthorized {
+		response.ApprovedAmount = transaction.Amount
+	}
+
+	return response, nil
+}
+
+// InquireBalance returns the balances of the card's account. Unlike the
+// authorization, it doesn't hold any funds.
+func (i *Service) InquireBalance(req models.BalanceInquiryRequest) (models.BalanceInquiryResponse, error) {
+	card, err := i.findCardByNumber(req.Card.Number)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return models.BalanceInquiryResponse{
+				ApprovalCode: models.ApprovalCodeInvalidCard,
+			}, nil
+		}
+
+		return models.BalanceInquiryResponse{}, fmt.Errorf("finding card: %w", err)
+	}
+
+	approvalCode, reason, err := i.verifyCard(card, req.Card, i.clock.Now(), true)
+	if err != nil {
+		return models.BalanceInquiryResponse{}, err
+	}
+
+	if reason != "" {
+		return models.BalanceInquiryResponse{
+			ApprovalCode: approvalCode,
+		}, nil
+	}
+
+	account, err := i.repo.GetAccount(card.AccountID)
+	if err != nil {
+		return models.BalanceInquiryResponse{}, fmt.Errorf("finding account: %w", err)
+	}
+
+	ownerVerified, err := i.isOwnerVerified(account)
+	if err != nil {
+		return models.BalanceInquiryResponse{}, err
+	}
+
+	if !ownerVerified {
+		return models.BalanceInquiryResponse{
+			ApprovalCode: models.ApprovalCodeNotPermitted,
+		}, nil
+	}
+
+	available, ledger := account.Balances()
+
+	return models.BalanceInquiryResponse{
+		ApprovalCode:     models.ApprovalCodeApproved,
+		AvailableBalance: available,
+		LedgerBalance:    ledger,
+		Currency:         account.Currency,
+	}, nil
+}
+
+// holdFunds puts the transaction amount on hold and authorizes the
+// transaction. If the merchant supports partial approvals and the available
+// balance is less than the amount, the available balance is held and the
+// transaction is partially approved.
+func (i *Service) holdFunds(account *models.Account, transaction *models.Transaction, partialApprovalSupported bool) error {
+	var err error
+	if partialApprovalSupported {
+		transaction.Amount, err = account.HoldUpTo(transaction.RequestedAmount)
+	} else {
+		err = account.Hold(transaction.RequestedAmount)
+	}
+
+	if err != nil {
+		// handle insufficient funds
+		if !errors.Is(err, models.ErrInsufficientFunds) {
+			return fmt.Errorf("holding funds: %w", err)
+		}
+
+		transaction.Amount = transaction.RequestedAmount
+		transaction.Decline(models.ApprovalCodeInsufficientFunds, models.DeclineReasonInsufficientFunds)
+
+		return nil
+	}
+
+	transaction.ApprovalCode = models.ApprovalCodeApproved
+	if transaction.Amount < transaction.RequestedAmount {
+		transaction.ApprovalCode = models.ApprovalCodePartiallyApproved
+	}
+
+	transaction.AuthorizationCode = generateAuthorizationCode()
+	transaction.Status = models.TransactionStatusAuthorized
+	transaction.HoldExpiresAt = transaction.CreatedAt.Add(i.holdExpiry.For(transaction.Merchant.MCC))
+
+	return nil
+}
+
+// incrementAuthorization extends the hold of the authorized transaction
+// referenced by the request. The approved amount is added to the transaction
+// amount, so the transaction holds the combined amount. Card details are
+// optional for incremental authorizations, the card of the original
+// transaction is used.
+func (i *Service) incrementAuthorization(req models.AuthorizationRequest) (models.AuthorizationResponse, error) {
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	transaction, err := i.repo.FindTransactionByRRN(req.OriginalRetrievalReferenceNumber)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return models.AuthorizationResponse{
+				ApprovalCode: models.ApprovalCodeInvalidTransaction,
+			}, nil
+		}
+
+		return models.AuthorizationResponse{}, fmt.Errorf("finding transaction: %w", err)
+	}
+
+	// the RRN can be guessed, so the request must also carry the
+	// authorization code and the merchant of the original authorization.
+	// The request of another merchant is handled as the one referencing an
+	// unknown transaction and isn't recorded with the transaction.
+	if !isOriginalAuthorization(transaction, req) {
+		return models.AuthorizationResponse{
+			ApprovalCode: models.ApprovalCodeInvalidTransaction,
+		}, nil
+	}
+
+	increment := models.AuthorizationIncrement{
+		Amount:                   req.Amount,
+		RetrievalReferenceNumber: req.RetrievalReferenceNumber,
+		CreatedAt:                i.clock.Now(),
+	}
+
+	incrementable, err := i.isIncrementable(transaction, req)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	if !incrementable {
+		return i.declineIncrement(transaction.ID, increment, models.ApprovalCodeInvalidTransaction, models.DeclineReasonInvalidOriginalTransaction)
+	}
+
+	account, err := i.repo.GetAccount(transaction.AccountID)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("finding account: %w", err)
+	}
+
+	card, err := i.repo.GetCard(transaction.CardID)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("finding card: %w", err)
+	}
+
+	// the card may have been blocked, closed (e.g. the single-use card
+	// after the original authorization) or expired since the original
+	// authorization
+	approvalCode, reason := checkCardStatus(card)
+	if reason == "" {
+		approvalCode, reason = checkCardExpiry(card, increment.CreatedAt)
+	}
+
+	if reason == "" {
+		approvalCode, reason = checkUsageControls(card, req)
+	}
+
+	if reason != "" {
+		return i.declineIncrement(transaction.ID, increment, approvalCode, reason)
+	}
+
+	cardholderVerified, err := i.isCardholderVerified(account, card)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	if !cardholderVerified {
+		return i.declineIncrement(transaction.ID, increment, models.ApprovalCodeNotPermitted, models.DeclineReasonCustomerNotVerified)
+	}
+
+	// the amount-locked card approves only the locked amount, so it can't
+	// be incremented
+	if card.LockedAmount != 0 {
+		return i.declineIncrement(transaction.ID, increment, models.ApprovalCodeInvalidAmount, models.DeclineReasonAmountNotAllowed)
+	}
+
+	if card.HasUsageControls() {
+		i.limitsMu.Lock()
+		defer i.limitsMu.Unlock()
+	}
+
+	withinLimit, err := i.isWithinSpendingLimit(card, req.Amount, increment.CreatedAt)
+	if err != nil {
+		return models.AuthorizationResponse{}, err
+	}
+
+	if !withinLimit {
+		return i.declineIncrement(transaction.ID, increment, models.ApprovalCodeExceedsLimit, models.DeclineReasonSpendingLimitExceeded)
+	}
+
+	err = account.Hold(req.Amount)
+	if err != nil {
+		if !errors.Is(err, models.ErrInsufficientFunds) {
+			return models.AuthorizationResponse{}, fmt.Errorf("holding funds: %w", err)
+		}
+
+		return i.declineIncrement(transaction.ID, increment, models.ApprovalCodeInsufficientFunds, models.DeclineReasonInsufficientFunds)
+	}
+
+	increment.ApprovalCode = models.ApprovalCodeApproved
+
+	// the hold lives as long as for the new authorization
+	holdExpiresAt := increment.CreatedAt.Add(i.holdExpiry.For(transaction.Merchant.MCC))
+
+	err = i.repo.IncrementTransaction(transaction.ID, increment, holdExpiresAt)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("incrementing transaction: %w", err)
+	}
+
+	return models.AuthorizationResponse{
+		AuthorizationCode: transaction.AuthorizationCode,
+		ApprovalCode:      increment.ApprovalCode,
+		ApprovedAmount:    increment.Amount,
+	}, nil
+}
+
+// declineIncrement records the declined incremental authorization of the
+// transaction.
+func (i *Service) declineIncrement(transactionID string, increment models.AuthorizationIncrement, approvalCode string, reason models.DeclineReason) (models.AuthorizationResponse, error) {
+	increment.ApprovalCode = approvalCode
+	increment.DeclineReason = reason
+
+	err := i.repo.IncrementTransaction(transactionID, increment, time.Time{})
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("incrementing transaction: %w", err)
+	}
+
+	return models.AuthorizationResponse{
+		ApprovalCode: approvalCode,
+	}, nil
+}
+
+// isOriginalAuthorization returns true if the incremental authorization
+// request references the authorization of the transaction made by the same
+// merchant.
+func isOriginalAuthorization(transaction *models.Transaction, req models.AuthorizationRequest) bool {
+	return req.OriginalAuthorizationCode != "" &&
+		req.OriginalAuthorizationCode == transaction.AuthorizationCode &&
+		req.Merchant.Name == transaction.Merchant.Name &&
+		req.Merchant.MCC == transaction.Merchant.MCC
+}
+
+// isIncrementable returns true if the hold of the transaction can be extended
+// by the incremental authorization request.
+func (i *Service) isIncrementable(transaction *models.Transaction, req models.AuthorizationRequest) (bool, error) {
+	if transaction.Status != models.TransactionStatusAuthorized || transaction.Currency != req.Currency {
+		return false, nil
+	}
+
+	if req.Card.Number != "" {
+		card, err := i.findCardByNumber(req.Card.Number)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return false, fmt.Errorf("finding card: %w", err)
+		}
+
+		if card == nil || card.ID != transaction.CardID {
+			return false, nil
+		}
+	}
+
+	// the hold of the disputed transaction was already released, or is
+	// released when the chargeback being sent is delivered
+	if i.disputesInProgress[transaction.ID] {
+		return false, nil
+	}
+
+	_, err := i.repo.FindDisputeForTransaction(transaction.ID)
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("finding dispute: %w", err)
+	}
+
+	return true, nil
+}
+
+// isOwnerVerified returns true if the KYC of the account's owner is
+// verified.
+func (i *Service) isOwnerVerified(account *models.Account) (bool, error) {
+	customer, err := i.repo.GetCustomer(account.CustomerID)
+	if err != nil {
+		return false, fmt.Errorf("finding customer: %w", err)
+	}
+
+	return customer.KYCStatus == models.KYCStatusVerified, nil
+}
+
+// isCardholderVerified returns true if the account's owner and, for
+// secondary cards, the authorized user are verified.
+func (i *Service) isCardholderVerified(account *models.Account, card *models.Card) (bool, error) {
+	ownerVerified, err := i.isOwnerVerified(account)
+	if err != nil || !ownerVerified {
+		return false, err
+	}
+
+	if card.CustomerID == "" || card.CustomerID == account.CustomerID {
+		return true, nil
+	}
+
+	customer, err := i.repo.GetCustomer(card.CustomerID)
+	if err != nil {
+		return false, fmt.Errorf("finding customer: %w", err)
+	}
+
+	return customer.KYCStatus == models.KYCStatusVerified, nil
+}
+
+// isWithinSpendingLimit returns true if the amount authorized with the card
+// during the calendar month stays within the card's spending limit.
+func (i *Service) isWithinSpendingLimit(card *models.Card, amount int64, now time.Time) (bool, error) {
+	if card.SpendingLimit == 0 {
+		return true, nil
+	}
+
+	now = now.UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	spent, err := i.repo.SumCardAuthorizedAmount(card.ID, monthStart)
+	if err != nil {
+		return false, fmt.Errorf("summing card authorizations: %w", err)
+	}
+
+	return spent+amount <= card.SpendingLimit, nil
+}
+
+// verifyCard checks the card details of the request against the issued card
+// and returns the approval code and the reason if the request should be
+// declined. The CVV is verified by the HSM if it's required or present in
+// the request.
+func (i *Service) verifyCard(card *models.Card, reqCard models.Card, now time.Time, requireCVV bool) (string, models.DeclineReason, error) {
+	if approvalCode, reason := checkCardStatus(card); reason != "" {
+		return approvalCode, reason, nil
+	}
+
+	if card.ExpirationDate != reqCard.ExpirationDate {
+		return models.ApprovalCodeInvalidCard, models.DeclineReasonInvalidExpirationDate, nil
+	}
+
+	if requireCVV || reqCard.CardVerificationValue != "" {
+		valid, err := i.hsm.VerifyCVV(CardVerificationKeyName, reqCard.Number, cvvExpiry(card.ExpirationDate), cvv2ServiceCode, reqCard.CardVerificationValue)
+		if err != nil {
+			return "", "", fmt.Errorf("verifying CVV: %w", err)
+		}
+
+		if !valid {
+			return models.ApprovalCodeInvalidCard, models.DeclineReasonInvalidCVV, nil
+		}
+	}
+
+	approvalCode, reason := checkCardExpiry(card, now)
+
+	return approvalCode, reason, nil
+}
+
+// checkCardStatus returns the approval code and the reason if the card is
+// closed or blocked.
+func checkCardStatus(card *models.Card) (string, models.DeclineReason) {
+	switch card.Status {
+	case models.CardStatusClosed:
+		return models.ApprovalCodeInvalidCard, models.DeclineReasonCardClosed
+	case models.CardStatusBlocked:
+		return models.ApprovalCodeRestrictedCard, models.DeclineReasonCardBlocked
+	}
+
+	return "", ""
+}
+
+// checkCardExpiry returns the approval code and the reason if the card or
+// its custom expiry is expired.
+func checkCardExpiry(card *models.Card, now time.Time) (string, models.DeclineReason) {
+	if isCardExpired(card.ExpirationDate, now) || !card.ExpiresAt.IsZero() && !now.Before(card.ExpiresAt) {
+		return models.ApprovalCodeExpiredCard, models.DeclineReasonCardExpired
+	}
+
+	return "", ""
+}
+
+// cvv2ServiceCode is the service code the CVV2 printed on the card is
+// generated with
+const cvv2ServiceCode = "000"
+
+// cvvExpiry returns the MMYY expiration date of the card in the YYMM format
+// the CVV is generated with.
+func cvvExpiry(expirationDate string) string {
+	return expirationDate[2:] + expirationDate[:2]
+}
+
+// checkUsageControls checks the request against the usage controls of the
+// virtual card and returns the approval code and the reason if the request
+// should be declined.
+func checkUsageControls(card *models.Card, req models.AuthorizationRequest) (string, models.DeclineReason) {
+	switch {
+	case card.LockedAmount != 0 && req.Amount != card.LockedAmount:
+		return models.ApprovalCodeInvalidAmount, models.DeclineReasonAmountNotAllowed
+	case card.LockedMerchant != nil && (card.LockedMerchant.Name != req.Merchant.Name || card.LockedMerchant.MCC != req.Merchant.MCC):
+		return models.ApprovalCodeNotPermitted, models.DeclineReasonMerchantNotAllowed
+	}
+
+	return "", ""
+}
+
+// isCardExpired returns true if the card with the given MMYY expiration date
+// is expired. Card is valid until the end of the expiration month.
+func isCardExpired(expirationDate string, now time.Time) bool {
+	expiresAt, err := time.Parse("0106", expirationDate)
+	if err != nil {
+		return true
+	}
+
+	return !now.Before(expiresAt.AddDate(0, 1, 0))
+}
+
+func (i *Service) recordUnknownCardAttempt(req models.AuthorizationRequest) (models.AuthorizationResponse, error) {
+	attempt := &models.UnknownCardAttempt{
+		ID:                       uuid.New().String(),
+		Amount:                   req.Amount,
+		Currency:                 req.Currency,
+		ApprovalCode:             models.ApprovalCodeInvalidCard,
+		DeclineReason:            models.DeclineReasonCardNotFound,
+		RetrievalReferenceNumber: req.RetrievalReferenceNumber,
+		Merchant:                 req.Merchant,
+		CreatedAt:                i.clock.Now(),
+	}
+
+	if number := req.Card.Number; len(number) >= 10 {
+		attempt.CardFirst6 = number[:6]
+		attempt.CardLast4 = number[len(number)-4:]
+	}
+
+	err := i.repo.CreateUnknownCardAttempt(attempt)
+	if err != nil {
+		return models.AuthorizationResponse{}, fmt.Errorf("creating unknown card attempt: %w", err)
+	}
+
+	return models.AuthorizationResponse{
+		ApprovalCode: attempt.ApprovalCode,
+	}, nil
+}
+
+// ListUnknownCardAttempts returns authorization attempts with unknown cards
+// ending with the given last 4 digits, newest first.
+func (i *Service) ListUnknownCardAttempts(last4 string) ([]*models.UnknownCardAttempt, error) {
+	attempts, err := i.repo.ListUnknownCardAttempts(last4)
+	if err != nil {
+		return nil, fmt.Errorf("listing unknown card attempts: %w", err)
+	}
+
+	return attempts, nil
+}
+
+// ReleaseExpiredHolds returns the held funds of the transactions with
+// expired holds to the cardholders and marks the transactions as expired. It
+// returns the number of released holds.
+func (i *Service) ReleaseExpiredHolds() (int, error) {
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	now := i.clock.Now()
+
+	transactions, err := i.repo.ListExpiredHolds(now)
+	if err != nil {
+		return 0, fmt.Errorf("listing expired holds: %w", err)
+	}
+
+	var released int
+	for _, transaction := range transactions {
+		// the chargeback of the transaction is being sent, the hold is
+		// released by the dispute or expires on the next run
+		if i.disputesInProgress[transaction.ID] {
+			continue
+		}
+
+		account, err := i.repo.GetAccount(transaction.AccountID)
+		if err != nil {
+			return released, fmt.Errorf("finding account: %w", err)
+		}
+
+		err = account.Release(transaction.Amount)
+		if err != nil {
+			return released, fmt.Errorf("releasing funds of transaction %s: %w", transaction.ID, err)
+		}
+
+		err = i.repo.ExpireHold(transaction.ID, now)
+		if err != nil {
+			return released, fmt.Errorf("expiring hold: %w", err)
+		}
+
+		released++
+	}
+
+	return released, nil
+}
+
+// OpenDispute opens a dispute for the transaction and sends a chargeback to the
+// acquirer. The disputed amount is released from hold and returned to the
+// cardholder as a provisional credit until the dispute is resolved.
+func (i *Service) OpenDispute(transactionID string, create models.CreateDispute) (*models.Dispute, error) {
+	if _, ok := models.DisputeReasonCodes[create.ReasonCode]; !ok {
+		return nil, fmt.Errorf("%w: %q", models.ErrInvalidReasonCode, create.ReasonCode)
+	}
+
+	var transaction *models.Transaction
+	release, err := i.reserveDispute(transactionID, func() error {
+		var err error
+		transaction, err = i.repo.GetTransaction(transactionID)
+		if err != nil {
+			return fmt.Errorf("finding transaction: %w", err)
+		}
+
+		if transaction.Status != models.TransactionStatusAuthorized {
+			return fmt.Errorf("%w: transaction status is %s", models.ErrTransactionNotDisputable, transaction.Status)
+		}
+
+		_, err = i.repo.FindDisputeForTransaction(transactionID)
+		if err == nil {
+			return fmt.Errorf("%w: transaction is already disputed", models.ErrTransactionNotDisputable)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("finding dispute: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	account, err := i.repo.GetAccount(transaction.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	now := i.clock.Now()
+	dispute := &models.Dispute{
+		ID:                       uuid.New().String(),
+		TransactionID:            transaction.ID,
+		AccountID:                transaction.AccountID,
+		RetrievalReferenceNumber: transaction.RetrievalReferenceNumber,
+		Amount:                   transaction.Amount,
+		Currency:                 transaction.Currency,
+		ReasonCode:               create.ReasonCode,
+		Status:                   models.DisputeStatusChargeback,
+		Message:                  create.Message,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+	}
+
+	err = i.notifyDispute(dispute, models.DisputeStageChargeback, create.Message)
+	if err != nil {
+		return nil, fmt.Errorf("sending chargeback: %w", err)
+	}
+
+	// the transaction is reserved, so its hold wasn't released or extended
+	// while the chargeback was sent
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	// give the cardholder provisional credit
+	err = account.Release(dispute.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("releasing funds: %w", err)
+	}
+
+	// the hold was released, it can't expire anymore
+	err = i.repo.RemoveHold(transaction.ID)
+	if err != nil {
+		return nil, fmt.Errorf("removing hold: %w", err)
+	}
+
+	err = i.repo.CreateDispute(dispute)
+	if err != nil {
+		return nil, fmt.Errorf("creating dispute: %w", err)
+	}
+
+	copied := *dispute
+
+	return &copied, nil
+}
+
+func (i *Service) GetDispute(disputeID string) (*models.Dispute, error) {
+	dispute, err := i.repo.GetDispute(disputeID)
+	if err != nil {
+		return nil, fmt.Errorf("finding dispute: %w", err)
+	}
+
+	return dispute, nil
+}
+
+// AcceptRepresentment accepts the evidence provided by the merchant. The
+// dispute is resolved in favor of the merchant and the provisional credit is
+// taken back from the cardholder.
+func (i *Service) AcceptRepresentment(disputeID string) (*models.Dispute, error) {
+	dispute, release, err := i.reserveDisputeAction(disputeID, func(dispute *models.Dispute) error {
+		return checkDisputeStatus(dispute, models.DisputeStatusRepresentment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = i.notifyDispute(dispute, models.DisputeStageAccepted, "")
+	if err != nil {
+		return nil, fmt.Errorf("sending dispute acceptance: %w", err)
+	}
+
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	return i.resolveInFavorOfMerchant(disputeID, models.DisputeStatusRepresentment)
+}
+
+// StartPreArbitration rejects the representment and escalates the dispute to
+// the pre-arbitration stage.
+func (i *Service) StartPreArbitration(disputeID string, escalate models.EscalateDispute) (*models.Dispute, error) {
+	dispute, release, err := i.reserveDisputeAction(disputeID, func(dispute *models.Dispute) error {
+		return dispute.CanTransition(models.DisputeStatusPreArbitration)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	err = i.notifyDispute(dispute, models.DisputeStagePreArbitration, escalate.Message)
+	if err != nil {
+		return nil, fmt.Errorf("sending pre-arbitration: %w", err)
+	}
+
+	dispute, err = i.repo.UpdateDispute(disputeID, func(dispute *models.Dispute) error {
+		return dispute.Transition(models.DisputeStatusPreArbitration)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return dispute, nil
+}
+
+// HandleDisputeNotification handles dispute notifications received from the
+// acquirer.
+func (i *Service) HandleDisputeNotification(notification models.DisputeNotification) error {
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	var err error
+
+	switch notification.Stage {
+	case models.DisputeStageRepresentment:
+		_, err = i.repo.UpdateDispute(notification.DisputeID, func(dispute *models.Dispute) error {
+			err := dispute.Transition(models.DisputeStatusRepresentment)
+			if err != nil {
+				return err
+			}
+
+			dispute.Evidence = notification.Message
+
+			return nil
+		})
+	case models.DisputeStageAccepted:
+		// merchant accepted liability, the provisional credit becomes final
+		_, err = i.repo.UpdateDispute(notification.DisputeID, func(dispute *models.Dispute) error {
+			return dispute.Transition(models.DisputeStatusWon)
+		})
+	case models.DisputeStageRejected:
+		_, err = i.resolveInFavorOfMerchant(notification.DisputeID, models.DisputeStatusPreArbitration)
+	default:
+		return fmt.Errorf("unexpected dispute stage: %s", notification.Stage)
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("finding dispute: %w", err)
+	}
+
+	return err
+}
+
+// resolveInFavorOfMerchant closes the dispute in the given status as lost by
+// the cardholder and puts the disputed amount back on hold. The hold expires
+// as the hold of any authorized transaction. It's called with holdsMu held.
+func (i *Service) resolveInFavorOfMerchant(disputeID string, status models.DisputeStatus) (*models.Dispute, error) {
+	dispute, err := i.repo.UpdateDispute(disputeID, func(dispute *models.Dispute) error {
+		err := checkDisputeStatus(dispute, status)
+		if err != nil {
+			return err
+		}
+
+		return dispute.Transition(models.DisputeStatusLost)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := i.repo.GetAccount(dispute.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("finding account: %w", err)
+	}
+
+	account.Rehold(dispute.Amount)
+
+	err = i.repo.AddHold(dispute.TransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("adding hold: %w", err)
+	}
+
+	return dispute, nil
+}
+
+func checkDisputeStatus(dispute *models.Dispute, status models.DisputeStatus) error {
+	if dispute.Status != status {
+		return fmt.Errorf("%w: dispute status is %s", models.ErrInvalidDisputeStatus, dispute.Status)
+	}
+
+	return nil
+}
+
+// reserveDisputeAction reserves the transaction of the dispute for the
+// cardholder's action if the check of the dispute passes. It returns the
+// checked dispute.
+func (i *Service) reserveDisputeAction(disputeID string, check func(dispute *models.Dispute) error) (*models.Dispute, func(), error) {
+	dispute, err := i.repo.GetDispute(disputeID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("finding dispute: %w", err)
+	}
+
+	release, err := i.reserveDispute(dispute.TransactionID, func() error {
+		// the dispute may have moved before the transaction was reserved
+		dispute, err = i.repo.GetDispute(disputeID)
+		if err != nil {
+			return fmt.Errorf("finding dispute: %w", err)
+		}
+
+		return check(dispute)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dispute, release, nil
+}
+
+// reserveDispute runs the check under holdsMu and reserves the transaction
+// for the dispute action until release is called. The action is sent to the
+// acquirer without holding the lock. While the transaction is reserved,
+// another dispute action is rejected and the hold of the transaction is
+// neither released by the expiry nor extended. The action is applied after
+// the dispute is checked again, as the acquirer may move it in the meantime.
+func (i *Service) reserveDispute(transactionID string, check func() error) (func(), error) {
+	i.holdsMu.Lock()
+	defer i.holdsMu.Unlock()
+
+	if i.disputesInProgress[transactionID] {
+		return nil, fmt.Errorf("%w: another action on the dispute is in progress", models.ErrInvalidDisputeStatus)
+	}
+
+	err := check()
+	if err != nil {
+		return nil, err
+	}
+
+	i.disputesInProgress[transactionID] = true
+
+	release := func() {
+		i.holdsMu.Lock()
+		defer i.holdsMu.Unlock()
+
+		delete(i.disputesInProgress, transactionID)
+	}
+
+	return release, nil
+}
+
+func (i *Service) notifyDispute(dispute *models.Dispute, stage models.DisputeStage, message string) error {
+	if i.disputeNotifier == nil {
+		return errors.New("dispute notifier is not set")
+	}
+
+	return i.disputeNotifier.NotifyDispute(models.DisputeNotification{
+		DisputeID:                dispute.ID,
+		RetrievalReferenceNumber: dispute.RetrievalReferenceNumber,
+		Stage:                    stage,
+		ReasonCode:               dispute.ReasonCode,
+		Amount:                   dispute.Amount,
+		Currency:                 dispute.Currency,
+		Message:                  message,
+	})
 }
 
 // generateFakeCardNumber generates a fake card number starting with 9
@@ -0,0 +1,1120 @@
+package issuer_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alovak/cardflow-playground/internal/hsm"
+	"github.com/alovak/cardflow-playground/issuer"
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/stretchr/testify/require"
+)
+
+// verifiedCustomer passes the stub KYC verification
+var verifiedCustomer = models.CreateCustomer{
+	FirstName:   "John",
+	LastName:    "Doe",
+	DateOfBirth: "1990-01-01",
+	Address: models.Address{
+		Line1:      "1 Main St",
+		City:       "Springfield",
+		PostalCode: "12345",
+		Country:    "US",
+	},
+}
+
+func createCustomer(t *testing.T, service *issuer.Service, create models.CreateCustomer) string {
+	customer, err := service.CreateCustomer(create)
+	require.NoError(t, err)
+
+	return customer.ID
+}
+
+func TestServiceAuthorizeRequestDeclines(t *testing.T) {
+	repo := issuer.NewRepository()
+	service := issuer.NewService(repo)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    10_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		card          models.Card
+		amount        int64
+		approvalCode  string
+		declineReason models.DeclineReason
+	}{
+		{
+			name:          "invalid expiration date",
+			card:          models.Card{Number: card.Number, ExpirationDate: "0199", CardVerificationValue: card.CardVerificationValue},
+			amount:        1_00,
+			approvalCode:  models.ApprovalCodeInvalidCard,
+			declineReason: models.DeclineReasonInvalidExpirationDate,
+		},
+		{
+			name:          "invalid cvv",
+			card:          models.Card{Number: card.Number, ExpirationDate: card.ExpirationDate, CardVerificationValue: "0000"},
+			amount:        1_00,
+			approvalCode:  models.ApprovalCodeInvalidCard,
+			declineReason: models.DeclineReasonInvalidCVV,
+		},
+		{
+			name:          "insufficient funds",
+			card:          *card,
+			amount:        20_00,
+			approvalCode:  models.ApprovalCodeInsufficientFunds,
+			declineReason: models.DeclineReasonInsufficientFunds,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+				Amount:   tt.amount,
+				Currency: "USD",
+				Card:     tt.card,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tt.approvalCode, res.ApprovalCode)
+			require.Empty(t, res.AuthorizationCode)
+
+			list, err := service.ListCardTransactions(card.ID, models.TransactionFilter{})
+			require.NoError(t, err)
+			require.NotEmpty(t, list.Transactions)
+
+			transaction := list.Transactions[0]
+			require.Equal(t, models.TransactionStatusDeclined, transaction.Status)
+			require.Equal(t, tt.approvalCode, transaction.ApprovalCode)
+			require.Equal(t, tt.declineReason, transaction.DeclineReason)
+		})
+	}
+
+	t.Run("customer initiated payment with stored card without CVV", func(t *testing.T) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:           1_00,
+			Currency:         "USD",
+			Card:             models.Card{Number: card.Number, ExpirationDate: card.ExpirationDate},
+			StoredCredential: models.StoredCredentialCustomerInitiated,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeInvalidCard, res.ApprovalCode)
+	})
+
+	t.Run("declines don't hold funds", func(t *testing.T) {
+		account, err := service.GetAccount(account.ID)
+		require.NoError(t, err)
+		require.Equal(t, int64(10_00), account.AvailableBalance)
+		require.Equal(t, int64(0), account.HoldBalance)
+	})
+
+	t.Run("unknown card", func(t *testing.T) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   1_00,
+			Currency: "USD",
+			Card:     models.Card{Number: "9000001234567890", ExpirationDate: "1299", CardVerificationValue: "1234"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeInvalidCard, res.ApprovalCode)
+
+		attempts, err := service.ListUnknownCardAttempts("7890")
+		require.NoError(t, err)
+		require.Len(t, attempts, 1)
+		require.Equal(t, "900000", attempts[0].CardFirst6)
+		require.Equal(t, models.DeclineReasonCardNotFound, attempts[0].DeclineReason)
+	})
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestServiceReleaseExpiredHolds(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	authorize := func(mcc string) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   10_00,
+			Currency: "USD",
+			Card:     *card,
+			Merchant: models.Merchant{Name: "Merchant " + mcc, MCC: mcc},
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+	}
+
+	authorize("5411") // grocery store, default expiry
+	authorize("7011") // hotel, extended expiry
+
+	statuses := func() map[string]models.TransactionStatus {
+		list, err := service.ListTransactions(account.ID, models.TransactionFilter{})
+		require.NoError(t, err)
+
+		statuses := map[string]models.TransactionStatus{}
+		for _, transaction := range list.Transactions {
+			statuses[transaction.Merchant.MCC] = transaction.Status
+		}
+
+		return statuses
+	}
+
+	// nothing is expired yet
+	released, err := service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Zero(t, released)
+
+	// the default hold expires in 7 days
+	clock.Advance(7 * 24 * time.Hour)
+
+	released, err = service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Equal(t, 1, released)
+	require.Equal(t, models.TransactionStatusExpired, statuses()["5411"])
+	require.Equal(t, models.TransactionStatusAuthorized, statuses()["7011"])
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(90_00), account.AvailableBalance)
+	require.Equal(t, int64(10_00), account.HoldBalance)
+
+	// the hotel hold expires in 30 days
+	clock.Advance(23 * 24 * time.Hour)
+
+	released, err = service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Equal(t, 1, released)
+	require.Equal(t, models.TransactionStatusExpired, statuses()["7011"])
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(100_00), account.AvailableBalance)
+	require.Equal(t, int64(0), account.HoldBalance)
+
+	// released holds are not released again
+	released, err = service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Zero(t, released)
+}
+
+// disputeNotifier accepts all dispute notifications without sending them to
+// the acquirer
+type disputeNotifier struct{}
+
+func (n *disputeNotifier) NotifyDispute(notification models.DisputeNotification) error {
+	return nil
+}
+
+func TestServiceLostDisputeHoldExpires(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+	service.SetDisputeNotifier(&disputeNotifier{})
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+		Amount:   10_00,
+		Currency: "USD",
+		Card:     *card,
+		Merchant: models.Merchant{Name: "Demo Merchant", MCC: "5411"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+
+	list, err := service.ListTransactions(account.ID, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 1)
+
+	dispute, err := service.OpenDispute(list.Transactions[0].ID, models.CreateDispute{ReasonCode: "4837"})
+	require.NoError(t, err)
+
+	err = service.HandleDisputeNotification(models.DisputeNotification{
+		DisputeID: dispute.ID,
+		Stage:     models.DisputeStageRepresentment,
+		Message:   "signed receipt",
+	})
+	require.NoError(t, err)
+
+	// the cardholder loses the dispute, the funds are held again
+	dispute, err = service.AcceptRepresentment(dispute.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.DisputeStatusLost, dispute.Status)
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(90_00), account.AvailableBalance)
+	require.Equal(t, int64(10_00), account.HoldBalance)
+
+	// and the hold expires as any other
+	clock.Advance(7 * 24 * time.Hour)
+
+	released, err := service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Equal(t, 1, released)
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(100_00), account.AvailableBalance)
+	require.Equal(t, int64(0), account.HoldBalance)
+}
+
+// blockingDisputeNotifier blocks the notifications until unblocked
+type blockingDisputeNotifier struct {
+	sending chan struct{}
+	unblock chan struct{}
+}
+
+func (n *blockingDisputeNotifier) NotifyDispute(notification models.DisputeNotification) error {
+	n.sending <- struct{}{}
+	<-n.unblock
+
+	return nil
+}
+
+func TestServiceDisputeNotificationIsSentWithoutLock(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	notifier := &blockingDisputeNotifier{
+		sending: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+	service.SetDisputeNotifier(notifier)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+		Amount:   10_00,
+		Currency: "USD",
+		Card:     *card,
+		Merchant: models.Merchant{Name: "Demo Merchant", MCC: "5411"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+
+	list, err := service.ListTransactions(account.ID, models.TransactionFilter{})
+	require.NoError(t, err)
+	transactionID := list.Transactions[0].ID
+
+	opened := make(chan error)
+	go func() {
+		_, err := service.OpenDispute(transactionID, models.CreateDispute{ReasonCode: "4837"})
+		opened <- err
+	}()
+
+	<-notifier.sending
+
+	// the hold expiry isn't blocked by the chargeback being sent, but the
+	// hold of the transaction isn't released as the dispute releases it
+	clock.Advance(7 * 24 * time.Hour)
+
+	released, err := service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Zero(t, released)
+
+	// the transaction is disputed once
+	_, err = service.OpenDispute(transactionID, models.CreateDispute{ReasonCode: "4837"})
+	require.ErrorIs(t, err, models.ErrInvalidDisputeStatus)
+
+	close(notifier.unblock)
+	require.NoError(t, <-opened)
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(100_00), account.AvailableBalance)
+	require.Equal(t, int64(0), account.HoldBalance)
+
+	released, err = service.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	require.Zero(t, released)
+}
+
+func TestServiceAccountFunding(t *testing.T) {
+	service := issuer.NewService(issuer.NewRepository())
+
+	createAccount := func(currency string) *models.Account {
+		account, err := service.CreateAccount(models.CreateAccount{
+			CustomerID: createCustomer(t, service, verifiedCustomer),
+			Currency:   currency,
+		})
+		require.NoError(t, err)
+
+		return account
+	}
+
+	account := createAccount("USD")
+	other := createAccount("USD")
+	euroAccount := createAccount("EUR")
+
+	t.Run("deposit is idempotent", func(t *testing.T) {
+		deposit := models.CreateDeposit{Amount: 100_00, Currency: "USD"}
+
+		operation, err := service.Deposit(account.ID, "deposit-1", deposit)
+		require.NoError(t, err)
+		require.Equal(t, int64(100_00), operation.AvailableBalance)
+
+		replayed, err := service.Deposit(account.ID, "deposit-1", deposit)
+		require.NoError(t, err)
+		require.Equal(t, operation.ID, replayed.ID)
+		require.Equal(t, int64(100_00), account.AvailableBalance)
+
+		// the key can't be used for another request
+		_, err = service.Deposit(account.ID, "deposit-1", models.CreateDeposit{Amount: 1_00, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrIdempotencyKeyReused)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		_, err := service.Deposit(account.ID, "", models.CreateDeposit{Amount: 0, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrValidation)
+
+		_, err = service.Deposit(account.ID, "", models.CreateDeposit{Amount: 1_00, Currency: "EUR"})
+		require.ErrorIs(t, err, models.ErrCurrencyMismatch)
+
+		_, err = service.Withdraw(account.ID, "", models.CreateWithdrawal{Amount: 1000_00, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrInsufficientFunds)
+
+		_, err = service.Transfer(account.ID, "", models.CreateTransfer{ToAccountID: euroAccount.ID, Amount: 1_00, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrCurrencyMismatch)
+
+		_, err = service.Transfer(account.ID, "", models.CreateTransfer{ToAccountID: account.ID, Amount: 1_00, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+
+	t.Run("withdrawal and transfer", func(t *testing.T) {
+		_, err := service.Withdraw(account.ID, "withdrawal-1", models.CreateWithdrawal{Amount: 20_00, Currency: "USD"})
+		require.NoError(t, err)
+
+		out, err := service.Transfer(account.ID, "transfer-1", models.CreateTransfer{ToAccountID: other.ID, Amount: 30_00, Currency: "USD"})
+		require.NoError(t, err)
+		require.Equal(t, int64(50_00), out.AvailableBalance)
+		require.Equal(t, int64(30_00), other.AvailableBalance)
+
+		operations, err := service.ListAccountOperations(account.ID)
+		require.NoError(t, err)
+		require.Len(t, operations, 3)
+		require.Equal(t, models.AccountOperationTypeTransferOut, operations[0].Type)
+		require.Equal(t, models.AccountOperationTypeWithdrawal, operations[1].Type)
+		require.Equal(t, models.AccountOperationTypeDeposit, operations[2].Type)
+
+		operations, err = service.ListAccountOperations(other.ID)
+		require.NoError(t, err)
+		require.Len(t, operations, 1)
+		require.Equal(t, models.AccountOperationTypeTransferIn, operations[0].Type)
+		require.Equal(t, out.TransferID, operations[0].TransferID)
+	})
+
+	t.Run("concurrent transfers and holds keep balances consistent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for n := 0; n < 50; n++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				service.Transfer(account.ID, "", models.CreateTransfer{ToAccountID: other.ID, Amount: 1, Currency: "USD"})
+			}()
+			go func() {
+				defer wg.Done()
+				service.Transfer(other.ID, "", models.CreateTransfer{ToAccountID: account.ID, Amount: 1, Currency: "USD"})
+			}()
+			go func() {
+				defer wg.Done()
+				account.Hold(1)
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int64(50_00+30_00), account.AvailableBalance+account.HoldBalance+other.AvailableBalance)
+	})
+}
+
+func TestServiceAuthorizeRequestRequiresVerifiedOwner(t *testing.T) {
+	service := issuer.NewService(issuer.NewRepository())
+
+	// the address can't be verified, so the customer stays pending
+	pending := verifiedCustomer
+	pending.Address.Line1 = ""
+
+	customerID := createCustomer(t, service, pending)
+
+	customer, err := service.GetCustomer(customerID)
+	require.NoError(t, err)
+	require.Equal(t, models.KYCStatusPending, customer.KYCStatus)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: customerID,
+		Balance:    10_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+		Amount:   1_00,
+		Currency: "USD",
+		Card:     *card,
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ApprovalCodeNotPermitted, res.ApprovalCode)
+
+	list, err := service.ListCardTransactions(card.ID, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, list.Transactions, 1)
+	require.Equal(t, models.DeclineReasonCustomerNotVerified, list.Transactions[0].DeclineReason)
+
+	t.Run("minors are rejected", func(t *testing.T) {
+		minor := verifiedCustomer
+		minor.DateOfBirth = time.Now().AddDate(-17, 0, 0).Format(models.DateOfBirthLayout)
+
+		customer, err := service.GetCustomer(createCustomer(t, service, minor))
+		require.NoError(t, err)
+		require.Equal(t, models.KYCStatusRejected, customer.KYCStatus)
+		require.NotEmpty(t, customer.KYCReason)
+	})
+
+	t.Run("accounts are owned by customers", func(t *testing.T) {
+		_, err := service.CreateAccount(models.CreateAccount{
+			CustomerID: "unknown",
+			Currency:   "USD",
+		})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+}
+
+func TestServiceAccountCards(t *testing.T) {
+	service := issuer.NewService(issuer.NewRepository())
+
+	ownerID := createCustomer(t, service, verifiedCustomer)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: ownerID,
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	t.Run("cards are issued for existing accounts", func(t *testing.T) {
+		_, err := service.IssueCard("unknown", models.IssueCard{})
+		require.ErrorIs(t, err, issuer.ErrNotFound)
+	})
+
+	primary, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+	require.Equal(t, models.CardTypePrimary, primary.Type)
+	require.Equal(t, ownerID, primary.CustomerID)
+
+	authorizedUser := verifiedCustomer
+	authorizedUser.FirstName = "Jane"
+
+	secondary, err := service.IssueCard(account.ID, models.IssueCard{
+		Type:          models.CardTypeSecondary,
+		CustomerID:    createCustomer(t, service, authorizedUser),
+		SpendingLimit: 30_00,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "JANE DOE", secondary.CardholderName)
+
+	cards, err := service.ListAccountCards(account.ID)
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+
+	t.Run("secondary card is declined over its spending limit", func(t *testing.T) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   20_00,
+			Currency: "USD",
+			Card:     *secondary,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+
+		res, err = service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   20_00,
+			Currency: "USD",
+			Card:     *secondary,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeExceedsLimit, res.ApprovalCode)
+
+		// the limit of the secondary card doesn't apply to the primary card
+		res, err = service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   50_00,
+			Currency: "USD",
+			Card:     *primary,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+	})
+
+	t.Run("card is moved to another account of the owner", func(t *testing.T) {
+		other, err := service.CreateAccount(models.CreateAccount{
+			CustomerID: ownerID,
+			Currency:   "USD",
+		})
+		require.NoError(t, err)
+
+		card, err := service.MoveCard(primary.ID, models.MoveCard{AccountID: other.ID})
+		require.NoError(t, err)
+		require.Equal(t, other.ID, card.AccountID)
+
+		cards, err := service.ListAccountCards(other.ID)
+		require.NoError(t, err)
+		require.Len(t, cards, 1)
+
+		foreign, err := service.CreateAccount(models.CreateAccount{
+			CustomerID: createCustomer(t, service, authorizedUser),
+			Currency:   "USD",
+		})
+		require.NoError(t, err)
+
+		_, err = service.MoveCard(primary.ID, models.MoveCard{AccountID: foreign.ID})
+		require.ErrorIs(t, err, models.ErrCardNotMovable)
+	})
+}
+
+func TestServiceCreditAccountBilling(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID:   createCustomer(t, service, verifiedCustomer),
+		Type:         models.AccountTypeCredit,
+		Currency:     "USD",
+		CreditLimit:  1000_00,
+		InterestRate: 2400, // 2% per month
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	t.Run("authorization is limited by the open-to-buy", func(t *testing.T) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   1500_00,
+			Currency: "USD",
+			Card:     *card,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeInsufficientFunds, res.ApprovalCode)
+
+		clock.now = time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+		res, err = service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   200_00,
+			Currency: "USD",
+			Card:     *card,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+		require.Equal(t, int64(800_00), account.OpenToBuy())
+	})
+
+	t.Run("statement is closed after a month", func(t *testing.T) {
+		clock.now = time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+		created, err := service.RunBillingCycle()
+		require.NoError(t, err)
+		require.Equal(t, 1, created)
+
+		statements, err := service.ListStatements(account.ID)
+		require.NoError(t, err)
+		require.Len(t, statements, 1)
+
+		statement := statements[0]
+		require.Equal(t, int64(0), statement.OpeningBalance)
+		require.Equal(t, int64(200_00), statement.ClosingBalance)
+		require.Equal(t, int64(25_00), statement.MinimumPayment)
+		require.Equal(t, time.Date(2026, time.February, 26, 0, 0, 0, 0, time.UTC), statement.DueDate)
+		require.Len(t, statement.Transactions, 1)
+	})
+
+	t.Run("minimum payment avoids the late fee", func(t *testing.T) {
+		clock.now = time.Date(2026, time.February, 10, 0, 0, 0, 0, time.UTC)
+
+		_, err := service.PostPayment(account.ID, "", models.CreatePayment{Amount: 25_00, Currency: "USD"})
+		require.NoError(t, err)
+
+		clock.now = time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+		created, err := service.RunBillingCycle()
+		require.NoError(t, err)
+		require.Equal(t, 1, created)
+
+		statements, err := service.ListStatements(account.ID)
+		require.NoError(t, err)
+		require.Len(t, statements, 2)
+
+		statement := statements[0]
+		require.Equal(t, int64(200_00), statement.OpeningBalance)
+		// the whole 175.00 owed is on hold, so no interest is charged
+		require.Equal(t, int64(175_00), statement.ClosingBalance)
+		require.False(t, statements[1].LateFeeCharged)
+		require.Len(t, statement.Operations, 1)
+		require.Equal(t, models.AccountOperationTypePayment, statement.Operations[0].Type)
+	})
+
+	t.Run("late fee is charged when the minimum payment is missed", func(t *testing.T) {
+		clock.now = time.Date(2026, time.March, 27, 0, 0, 0, 0, time.UTC)
+
+		created, err := service.RunBillingCycle()
+		require.NoError(t, err)
+		require.Equal(t, 0, created)
+
+		statements, err := service.ListStatements(account.ID)
+		require.NoError(t, err)
+		require.True(t, statements[0].LateFeeCharged)
+		require.Equal(t, int64(175_00+35_00), account.Owed())
+	})
+
+	t.Run("interest is charged on the posted balance", func(t *testing.T) {
+		clock.now = time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+		created, err := service.RunBillingCycle()
+		require.NoError(t, err)
+		require.Equal(t, 1, created)
+
+		statements, err := service.ListStatements(account.ID)
+		require.NoError(t, err)
+
+		// 200.00 on hold and 25.00 paid, so only 10.00 of the late fee is
+		// posted and 2% interest is charged on it
+		statement := statements[0]
+		require.Equal(t, int64(210_20), statement.ClosingBalance)
+		require.Len(t, statement.Operations, 2)
+		require.Equal(t, models.AccountOperationTypeLateFee, statement.Operations[0].Type)
+		require.Equal(t, models.AccountOperationTypeInterest, statement.Operations[1].Type)
+		require.Equal(t, int64(20), statement.Operations[1].Amount)
+	})
+
+	t.Run("payments are posted to credit accounts only", func(t *testing.T) {
+		_, err := service.Deposit(account.ID, "", models.CreateDeposit{Amount: 10_00, Currency: "USD"})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+}
+
+func TestServiceCreditAccountBillingCatchUp(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID:   createCustomer(t, service, verifiedCustomer),
+		Type:         models.AccountTypeCredit,
+		Currency:     "USD",
+		CreditLimit:  1000_00,
+		InterestRate: 2400,
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	authorize := func(amount int64) {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   amount,
+			Currency: "USD",
+			Card:     *card,
+		})
+		require.NoError(t, err)
+		require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+	}
+
+	clock.now = time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)
+	authorize(200_00)
+
+	clock.now = time.Date(2026, time.February, 20, 0, 0, 0, 0, time.UTC)
+	_, err = service.PostPayment(account.ID, "", models.CreatePayment{Amount: 50_00, Currency: "USD"})
+	require.NoError(t, err)
+
+	clock.now = time.Date(2026, time.March, 10, 0, 0, 0, 0, time.UTC)
+	authorize(100_00)
+
+	// the billing job didn't run for two periods
+	clock.now = time.Date(2026, time.March, 15, 0, 0, 0, 0, time.UTC)
+
+	created, err := service.RunBillingCycle()
+	require.NoError(t, err)
+	require.Equal(t, 2, created)
+
+	statements, err := service.ListStatements(account.ID)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+
+	// every statement has the balance as of the end of its period
+	january, february := statements[1], statements[0]
+	require.Equal(t, int64(200_00), january.ClosingBalance)
+	require.Equal(t, int64(200_00), february.OpeningBalance)
+	require.Equal(t, int64(150_00), february.ClosingBalance)
+	require.Len(t, february.Transactions, 0)
+	require.Len(t, february.Operations, 1)
+	require.Equal(t, models.AccountOperationTypePayment, february.Operations[0].Type)
+}
+
+func TestServiceCreditAccountPaidByTransfer(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+
+	customerID := createCustomer(t, service, verifiedCustomer)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID:   customerID,
+		Type:         models.AccountTypeCredit,
+		Currency:     "USD",
+		CreditLimit:  1000_00,
+		InterestRate: 2400,
+	})
+	require.NoError(t, err)
+
+	savings, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: customerID,
+		Balance:    500_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	clock.now = time.Date(2026, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+		Amount:   200_00,
+		Currency: "USD",
+		Card:     *card,
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+
+	clock.now = time.Date(2026, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err = service.RunBillingCycle()
+	require.NoError(t, err)
+
+	// the cardholder pays the statement off from another account
+	clock.now = time.Date(2026, time.February, 10, 0, 0, 0, 0, time.UTC)
+
+	_, err = service.Transfer(savings.ID, "", models.CreateTransfer{
+		ToAccountID: account.ID,
+		Amount:      200_00,
+		Currency:    "USD",
+	})
+	require.NoError(t, err)
+
+	clock.now = time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err = service.RunBillingCycle()
+	require.NoError(t, err)
+
+	statements, err := service.ListStatements(account.ID)
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+
+	// neither the late fee nor the interest is charged
+	require.False(t, statements[1].LateFeeCharged)
+	require.Len(t, statements[0].Operations, 1)
+	require.Equal(t, models.AccountOperationTypeTransferIn, statements[0].Operations[0].Type)
+	require.Equal(t, int64(0), statements[0].ClosingBalance)
+}
+
+func TestServiceVirtualCards(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetClock(clock)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	merchant := models.Merchant{Name: "Shop", MCC: "5411"}
+
+	authorize := func(card *models.Card, amount int64, merchant models.Merchant) string {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   amount,
+			Currency: "USD",
+			Card:     *card,
+			Merchant: merchant,
+		})
+		require.NoError(t, err)
+
+		return res.ApprovalCode
+	}
+
+	t.Run("usage controls are set for virtual cards only", func(t *testing.T) {
+		_, err := service.IssueCard(account.ID, models.IssueCard{SingleUse: true})
+		require.ErrorIs(t, err, models.ErrValidation)
+	})
+
+	t.Run("single-use card is closed after the first approval", func(t *testing.T) {
+		card, err := service.IssueCard(account.ID, models.IssueCard{Virtual: true, SingleUse: true})
+		require.NoError(t, err)
+
+		require.Equal(t, models.ApprovalCodeApproved, authorize(card, 1_00, merchant))
+		require.Equal(t, models.ApprovalCodeInvalidCard, authorize(card, 1_00, merchant))
+
+		card, err = service.GetCard(card.ID)
+		require.NoError(t, err)
+		require.Equal(t, models.CardStatusClosed, card.Status)
+	})
+
+	t.Run("merchant-locked card approves the first merchant only", func(t *testing.T) {
+		card, err := service.IssueCard(account.ID, models.IssueCard{Virtual: true, MerchantLocked: true})
+		require.NoError(t, err)
+
+		require.Equal(t, models.ApprovalCodeApproved, authorize(card, 1_00, merchant))
+		require.Equal(t, models.ApprovalCodeApproved, authorize(card, 1_00, merchant))
+		require.Equal(t, models.ApprovalCodeNotPermitted, authorize(card, 1_00, models.Merchant{Name: "Other", MCC: "5411"}))
+	})
+
+	t.Run("amount-locked card approves the locked amount only", func(t *testing.T) {
+		card, err := service.IssueCard(account.ID, models.IssueCard{Virtual: true, LockedAmount: 5_00})
+		require.NoError(t, err)
+
+		require.Equal(t, models.ApprovalCodeInvalidAmount, authorize(card, 4_00, merchant))
+		require.Equal(t, models.ApprovalCodeApproved, authorize(card, 5_00, merchant))
+	})
+
+	t.Run("card with the custom expiry", func(t *testing.T) {
+		card, err := service.IssueCard(account.ID, models.IssueCard{
+			Virtual:   true,
+			ExpiresAt: clock.now.Add(48 * time.Hour),
+		})
+		require.NoError(t, err)
+		require.Equal(t, "0126", card.ExpirationDate)
+
+		require.Equal(t, models.ApprovalCodeApproved, authorize(card, 1_00, merchant))
+
+		clock.Advance(48 * time.Hour)
+		require.Equal(t, models.ApprovalCodeExpiredCard, authorize(card, 1_00, merchant))
+	})
+}
+
+func TestServicePIN(t *testing.T) {
+	pinHSM := hsm.New()
+	require.NoError(t, pinHSM.GenerateKey(issuer.ZonePINKeyName, hsm.KeyTypeZPK))
+	require.NoError(t, pinHSM.GenerateKey(issuer.PINVerificationKeyName, hsm.KeyTypePVK))
+	require.NoError(t, pinHSM.GenerateKey(issuer.CardVerificationKeyName, hsm.KeyTypeCVK))
+
+	service := issuer.NewService(issuer.NewRepository())
+	service.SetHSM(pinHSM)
+	service.SetPINTryLimit(2)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+
+	// the acquirer encrypts the PIN block under the same zone PIN key
+	authorize := func(pin string) string {
+		pinBlock, err := pinHSM.EncryptPINBlock(issuer.ZonePINKeyName, hsm.PINBlockFormat4, pin, card.Number)
+		require.NoError(t, err)
+
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   1_00,
+			Currency: "USD",
+			Card: models.Card{
+				Number:         card.Number,
+				ExpirationDate: card.ExpirationDate,
+			},
+			PINBlock:       pinBlock,
+			PINBlockFormat: int(hsm.PINBlockFormat4),
+		})
+		require.NoError(t, err)
+
+		return res.ApprovalCode
+	}
+
+	// increment of the authorization with the given authorization code made
+	// by the merchant
+	increment := func(authorizationCode string, merchant models.Merchant) string {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:                           1_00,
+			Currency:                         "USD",
+			Merchant:                         merchant,
+			RetrievalReferenceNumber:         "000000000002",
+			OriginalRetrievalReferenceNumber: "000000000001",
+			OriginalAuthorizationCode:        authorizationCode,
+		})
+		require.NoError(t, err)
+
+		return res.ApprovalCode
+	}
+
+	err = service.SetPIN(card.ID, models.SetPIN{PIN: "12345"})
+	require.ErrorIs(t, err, models.ErrValidation)
+
+	err = service.SetPIN(card.ID, models.SetPIN{PIN: "2580"})
+	require.NoError(t, err)
+
+	// only the PVV is stored with the card
+	stored, err := service.GetCard(card.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.PINVerificationValue, 4)
+
+	require.Equal(t, models.ApprovalCodeApproved, authorize("2580"))
+
+	// the authorization which is incremented after the card is blocked
+	merchant := models.Merchant{Name: "Hotel", MCC: "7011"}
+	res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+		Amount:                   1_00,
+		Currency:                 "USD",
+		Card:                     *card,
+		Merchant:                 merchant,
+		RetrievalReferenceNumber: "000000000001",
+	})
+	require.NoError(t, err)
+	require.Equal(t, models.ApprovalCodeApproved, res.ApprovalCode)
+	require.Equal(t, models.ApprovalCodeApproved, increment(res.AuthorizationCode, merchant))
+
+	// the RRN alone doesn't allow to extend the hold, the authorization code
+	// and the merchant of the original authorization must match
+	require.Equal(t, models.ApprovalCodeInvalidTransaction, increment("", merchant))
+	require.Equal(t, models.ApprovalCodeInvalidTransaction, increment("000000", merchant))
+	require.Equal(t, models.ApprovalCodeInvalidTransaction, increment(res.AuthorizationCode, models.Merchant{Name: "Other", MCC: "7011"}))
+	require.Equal(t, models.ApprovalCodeInvalidTransaction, increment(res.AuthorizationCode, models.Merchant{Name: "Hotel", MCC: "5411"}))
+
+	list, err := service.ListTransactions(account.ID, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Equal(t, "000000000001", list.Transactions[0].RetrievalReferenceNumber)
+	require.Equal(t, int64(2_00), list.Transactions[0].Amount)
+	require.Len(t, list.Transactions[0].Increments, 1)
+
+	// the correct PIN resets the failed tries
+	require.Equal(t, models.ApprovalCodeInvalidPIN, authorize("1111"))
+	require.Equal(t, models.ApprovalCodeApproved, authorize("2580"))
+	require.Equal(t, 0, stored.PINTries)
+
+	require.Equal(t, models.ApprovalCodeInvalidPIN, authorize("1111"))
+	require.Equal(t, models.ApprovalCodePINTriesExceeded, authorize("1111"))
+	require.Equal(t, models.CardStatusBlocked, stored.Status)
+	require.Equal(t, models.ApprovalCodeRestrictedCard, authorize("2580"))
+
+	// the hold of the blocked card can't be extended
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	held := account.HoldBalance
+
+	require.Equal(t, models.ApprovalCodeRestrictedCard, increment(res.AuthorizationCode, merchant))
+
+	account, err = service.GetAccount(account.ID)
+	require.NoError(t, err)
+	require.Equal(t, held, account.HoldBalance)
+}
+
+func TestServiceCardDataAtRest(t *testing.T) {
+	repo := issuer.NewRepository()
+	service := issuer.NewService(repo)
+
+	keyProvider, err := issuer.NewLocalKeyProvider(filepath.Join(t.TempDir(), "card.key"))
+	require.NoError(t, err)
+
+	vault, err := issuer.NewCardVault(keyProvider, []byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	service.SetCardVault(vault)
+
+	account, err := service.CreateAccount(models.CreateAccount{
+		CustomerID: createCustomer(t, service, verifiedCustomer),
+		Balance:    100_00,
+		Currency:   "USD",
+	})
+	require.NoError(t, err)
+
+	// the number and the CVV are shown when the card is issued only
+	card, err := service.IssueCard(account.ID, models.IssueCard{})
+	require.NoError(t, err)
+	require.Len(t, card.Number, 16)
+	require.Len(t, card.CardVerificationValue, 3)
+	require.Equal(t, card.Number[:6], card.First6)
+	require.Equal(t, card.Number[12:], card.Last4)
+
+	stored, err := service.GetCard(card.ID)
+	require.NoError(t, err)
+	require.Empty(t, stored.Number)
+	require.Empty(t, stored.CardVerificationValue)
+	require.Equal(t, card.Last4, stored.Last4)
+
+	for _, c := range repo.Cards {
+		require.NotContains(t, fmt.Sprintf("%+v", *c), card.Number)
+	}
+
+	// the card is found by the keyed hash of its number and the CVV is
+	// verified by the HSM
+	authorize := func(cvv string) string {
+		res, err := service.AuthorizeRequest(models.AuthorizationRequest{
+			Amount:   1_00,
+			Currency: "USD",
+			Card: models.Card{
+				Number:                card.Number,
+				ExpirationDate:        card.ExpirationDate,
+				CardVerificationValue: cvv,
+			},
+		})
+		require.NoError(t, err)
+
+		return res.ApprovalCode
+	}
+
+	require.Equal(t, models.ApprovalCodeApproved, authorize(card.CardVerificationValue))
+	require.Equal(t, models.ApprovalCodeInvalidCard, authorize("000"))
+}
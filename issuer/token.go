@@ -0,0 +1,223 @@
+package issuer
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/alovak/cardflow-playground/issuer/models"
+	"github.com/google/uuid"
+)
+
+// tokenNumberAttempts is how many token numbers are generated before the
+// provisioning fails.
+const tokenNumberAttempts = 10
+
+// ProvisionToken provisions the token (DPAN) of the card restricted to the
+// domain of the request. The token expires with the card.
+func (i *Service) ProvisionToken(cardID string, create models.CreateToken) (*models.Token, error) {
+	card, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("finding card: %w", err)
+	}
+
+	if card.Status == models.CardStatusClosed {
+		return nil, fmt.Errorf("%w: card is closed", models.ErrValidation)
+	}
+
+	now := i.clock.Now()
+	token := &models.Token{
+		ID:             uuid.New().String(),
+		CardID:         card.ID,
+		ExpirationDate: card.ExpirationDate,
+		Domain:         create.Domain,
+		Status:         models.TokenStatusActive,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	// the number is generated again if it's already used by another token
+	for attempt := 1; ; attempt++ {
+		token.Number, err = generateTokenNumber()
+		if err != nil {
+			return nil, fmt.Errorf("generating token number: %w", err)
+		}
+
+		err = i.repo.CreateToken(token)
+		if errors.Is(err, ErrAlreadyExists) && attempt < tokenNumberAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("creating token: %w", err)
+		}
+
+		return token, nil
+	}
+}
+
+func (i *Service) GetToken(tokenID string) (*models.Token, error) {
+	token, err := i.repo.GetToken(tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("finding token: %w", err)
+	}
+
+	return token, nil
+}
+
+// ListCardTokens returns tokens of the card including the deleted ones.
+func (i *Service) ListCardTokens(cardID string) ([]*models.Token, error) {
+	_, err := i.repo.GetCard(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("finding card: %w", err)
+	}
+
+	tokens, err := i.repo.ListCardTokens(cardID)
+	if err != nil {
+		return nil, fmt.Errorf("listing tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// SuspendToken suspends the active token, e.g. when the device with the
+// wallet is lost. Authorizations with the suspended token are declined.
+func (i *Service) SuspendToken(tokenID string) (*models.Token, error) {
+	return i.updateTokenStatus(tokenID, models.TokenStatusSuspended, models.TokenStatusActive)
+}
+
+// ResumeToken makes the suspended token active again.
+func (i *Service) ResumeToken(tokenID string) (*models.Token, error) {
+	return i.updateTokenStatus(tokenID, models.TokenStatusActive, models.TokenStatusSuspended)
+}
+
+// DeleteToken deletes the token for good, it can't be resumed.
+func (i *Service) DeleteToken(tokenID string) (*models.Token, error) {
+	return i.updateTokenStatus(tokenID, models.TokenStatusDeleted, models.TokenStatusActive, models.TokenStatusSuspended)
+}
+
+// updateTokenStatus moves the token to the new status if its current status
+// is one of the given ones. The status is checked and changed under the
+// repository lock, so detokenization sees either the old or the new status.
+func (i *Service) updateTokenStatus(tokenID string, status models.TokenStatus, from ...models.TokenStatus) (*models.Token, error) {
+	token, err := i.repo.UpdateToken(tokenID, func(token *models.Token) error {
+		allowed := false
+		for _, s := range from {
+			if token.Status == s {
+				allowed = true
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("%w: token is %s", models.ErrInvalidTokenStatus, token.Status)
+		}
+
+		token.Status = status
+		token.UpdatedAt = i.clock.Now()
+
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("finding token: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Detokenize returns the card of the token if the token can be used for
+// the request, or the approval code of the decline otherwise.
+func (i *Service) Detokenize(req models.DetokenizationRequest) (models.DetokenizationResponse, error) {
+	token, err := i.repo.FindTokenByNumber(req.TokenNumber)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return models.DetokenizationResponse{ApprovalCode: models.ApprovalCodeInvalidCard}, nil
+		}
+
+		return models.DetokenizationResponse{}, fmt.Errorf("finding token: %w", err)
+	}
+
+	switch {
+	case token.Status == models.TokenStatusDeleted,
+		token.ExpirationDate != req.ExpirationDate:
+		return models.DetokenizationResponse{ApprovalCode: models.ApprovalCodeInvalidCard}, nil
+	case token.Status == models.TokenStatusSuspended:
+		return models.DetokenizationResponse{ApprovalCode: models.ApprovalCodeRestrictedCard}, nil
+	case !isInTokenDomain(token.Domain, req):
+		return models.DetokenizationResponse{ApprovalCode: models.ApprovalCodeNotPermitted}, nil
+	}
+
+	card, err := i.repo.GetCard(token.CardID)
+	if err != nil {
+		return models.DetokenizationResponse{}, fmt.Errorf("finding card: %w", err)
+	}
+
+	number, err := i.cardNumber(card)
+	if err != nil {
+		return models.DetokenizationResponse{}, err
+	}
+
+	return models.DetokenizationResponse{
+		TokenID: token.ID,
+		Card: models.Card{
+			Number:         number,
+			ExpirationDate: card.ExpirationDate,
+		},
+	}, nil
+}
+
+// isInTokenDomain returns true if the request is made within the domain
+// the token is restricted to.
+func isInTokenDomain(domain models.TokenDomain, req models.DetokenizationRequest) bool {
+	if domain.TokenRequestorID != "" && domain.TokenRequestorID != req.TokenRequestorID {
+		return false
+	}
+
+	if domain.MerchantName != "" && domain.MerchantName != req.Merchant.Name {
+		return false
+	}
+
+	return true
+}
+
+// generateTokenNumber generates a token number from the token BIN range with
+// a valid Luhn check digit. The digits come from crypto/rand, so token
+// numbers can't be predicted.
+func generateTokenNumber() (string, error) {
+	length := 15 - len(models.TokenBIN)
+
+	n, err := rand.Int(rand.Reader, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil))
+	if err != nil {
+		return "", err
+	}
+
+	number := fmt.Sprintf("%s%0*d", models.TokenBIN, length, n.Int64())
+
+	return number + luhnCheckDigit(number), nil
+}
+
+// luhnCheckDigit returns the check digit that makes the number with the
+// digit appended pass the Luhn check.
+func luhnCheckDigit(number string) string {
+	sum := 0
+	// digits are doubled from the rightmost one, as the check digit will be
+	// appended after it
+	double := true
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return strconv.Itoa((10 - sum%10) % 10)
+}
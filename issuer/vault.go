@@ -0,0 +1,148 @@
+package issuer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// EncryptedCardNumber is the card number encrypted with its own data
+// encryption key (envelope encryption). The data key is stored encrypted
+// by the key provider.
+type EncryptedCardNumber struct {
+	// KeyID is the key encryption key of the provider the data key is
+	// encrypted with
+	KeyID        string
+	EncryptedKey []byte
+	Ciphertext   []byte
+}
+
+// CardVault encrypts card numbers, so they are never stored in plain text,
+// and indexes them with the keyed hash, so the card of the authorization
+// request is found without decrypting the numbers of all cards.
+type CardVault struct {
+	keyProvider KeyProvider
+	indexKey    []byte
+}
+
+// NewCardVault creates the vault with the key provider and the key of the
+// card number index, at least 32 bytes long. The index key must not change,
+// otherwise the stored cards can't be found.
+func NewCardVault(keyProvider KeyProvider, indexKey []byte) (*CardVault, error) {
+	if len(indexKey) < 32 {
+		return nil, fmt.Errorf("index key must be at least 32 bytes, got %d", len(indexKey))
+	}
+
+	return &CardVault{
+		keyProvider: keyProvider,
+		indexKey:    indexKey,
+	}, nil
+}
+
+// newRandomCardVault creates the vault with random keys kept in memory.
+// Cards encrypted by it can't be decrypted or found after the restart,
+// which is fine for the in memory repository.
+func newRandomCardVault() *CardVault {
+	keyProvider, err := NewLocalKeyProvider("")
+	if err != nil {
+		panic(fmt.Sprintf("creating key provider: %v", err))
+	}
+
+	indexKey, err := randomKey()
+	if err != nil {
+		panic(fmt.Sprintf("generating index key: %v", err))
+	}
+
+	vault, err := NewCardVault(keyProvider, indexKey)
+	if err != nil {
+		panic(err)
+	}
+
+	return vault
+}
+
+// Encrypt encrypts the card number with the new data key, the ID of the
+// card is authenticated with it, so the encrypted number can't be used for
+// another card.
+func (v *CardVault) Encrypt(cardID, number string) (EncryptedCardNumber, error) {
+	dataKey, err := randomKey()
+	if err != nil {
+		return EncryptedCardNumber{}, err
+	}
+
+	aead, err := newCardNumberAEAD(dataKey)
+	if err != nil {
+		return EncryptedCardNumber{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return EncryptedCardNumber{}, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	keyID, encryptedKey, err := v.keyProvider.EncryptKey(dataKey)
+	if err != nil {
+		return EncryptedCardNumber{}, fmt.Errorf("encrypting data key: %w", err)
+	}
+
+	return EncryptedCardNumber{
+		KeyID:        keyID,
+		EncryptedKey: encryptedKey,
+		Ciphertext:   aead.Seal(nonce, nonce, []byte(number), []byte(cardID)),
+	}, nil
+}
+
+// Decrypt decrypts the number of the card.
+func (v *CardVault) Decrypt(cardID string, encrypted EncryptedCardNumber) (string, error) {
+	dataKey, err := v.keyProvider.DecryptKey(encrypted.KeyID, encrypted.EncryptedKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypting data key: %w", err)
+	}
+
+	aead, err := newCardNumberAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	if len(encrypted.Ciphertext) < aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext is too short")
+	}
+
+	nonce, ciphertext := encrypted.Ciphertext[:aead.NonceSize()], encrypted.Ciphertext[aead.NonceSize():]
+
+	number, err := aead.Open(nil, nonce, ciphertext, []byte(cardID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting card number: %w", err)
+	}
+
+	return string(number), nil
+}
+
+// Index returns the keyed hash (HMAC-SHA256) the card is found by its number
+// with. Unlike the plain hash, it can't be reversed by hashing all possible
+// card numbers without the key.
+func (v *CardVault) Index(number string) string {
+	mac := hmac.New(sha256.New, v.indexKey)
+	mac.Write([]byte(number))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newCardNumberAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("creating GCM: %w", err)
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,66 @@
+package issuer_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/issuer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardVault(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "card.key")
+	indexKey := []byte("0123456789abcdef0123456789abcdef")
+
+	keyProvider, err := issuer.NewLocalKeyProvider(keyFile)
+	require.NoError(t, err)
+
+	vault, err := issuer.NewCardVault(keyProvider, indexKey)
+	require.NoError(t, err)
+
+	encrypted, err := vault.Encrypt("card-1", "9123456789012345")
+	require.NoError(t, err)
+	require.NotContains(t, string(encrypted.Ciphertext), "9123456789012345")
+
+	t.Run("number is decrypted with the key from the same file", func(t *testing.T) {
+		keyProvider, err := issuer.NewLocalKeyProvider(keyFile)
+		require.NoError(t, err)
+
+		vault, err := issuer.NewCardVault(keyProvider, indexKey)
+		require.NoError(t, err)
+
+		number, err := vault.Decrypt("card-1", encrypted)
+		require.NoError(t, err)
+		require.Equal(t, "9123456789012345", number)
+	})
+
+	t.Run("number of another card is not decrypted", func(t *testing.T) {
+		_, err := vault.Decrypt("card-2", encrypted)
+		require.Error(t, err)
+	})
+
+	t.Run("number is not decrypted with another key", func(t *testing.T) {
+		keyProvider, err := issuer.NewLocalKeyProvider(filepath.Join(t.TempDir(), "card.key"))
+		require.NoError(t, err)
+
+		vault, err := issuer.NewCardVault(keyProvider, indexKey)
+		require.NoError(t, err)
+
+		_, err = vault.Decrypt("card-1", encrypted)
+		require.Error(t, err)
+	})
+
+	t.Run("index depends on the number and the key", func(t *testing.T) {
+		require.Equal(t, vault.Index("9123456789012345"), vault.Index("9123456789012345"))
+		require.NotEqual(t, vault.Index("9123456789012345"), vault.Index("9123456789012346"))
+
+		other, err := issuer.NewCardVault(keyProvider, []byte("fedcba9876543210fedcba9876543210"))
+		require.NoError(t, err)
+		require.NotEqual(t, vault.Index("9123456789012345"), other.Index("9123456789012345"))
+	})
+
+	t.Run("index key must be at least 32 bytes", func(t *testing.T) {
+		_, err := issuer.NewCardVault(keyProvider, []byte("short"))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,109 @@
+package log
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/moov-io/iso8583"
+	"github.com/moov-io/iso8583/field"
+	"golang.org/x/exp/slog"
+)
+
+// fields of the playground ISO 8583 spec which are never described
+var removedISO8583Fields = map[string]bool{
+	"8":     true, // CVV
+	"9":     true, // expiration date
+	"35":    true, // track 2 data
+	"45":    true, // track 1 data
+	"52":    true, // PIN block
+	"96.02": true, // encrypted key
+}
+
+// panISO8583Field is the field with the primary account number, it's masked
+const panISO8583Field = "2"
+
+// DescribeISO8583Message returns the single line description of the message
+// fields safe to be logged: the card number is masked to its first 6 and
+// last 4 digits, the CVV, the expiration date, the PIN block and the
+// encrypted keys are removed.
+func DescribeISO8583Message(message *iso8583.Message) string {
+	var b strings.Builder
+
+	mti, err := message.GetMTI()
+	if err != nil {
+		mti = "unknown"
+	}
+
+	fmt.Fprintf(&b, "MTI=%s", mti)
+
+	fields := make(map[string]field.Field)
+	for id, f := range message.GetFields() {
+		// the MTI is already described and the bitmap is derived from
+		// the fields
+		if id > 1 {
+			fields[fmt.Sprint(id)] = f
+		}
+	}
+
+	describeISO8583Fields(&b, "", fields)
+
+	return b.String()
+}
+
+// describeISO8583Fields describes the fields sorted by their IDs, the
+// subfields of composite fields are described with the IDs prefixed with
+// the ID of the composite field.
+func describeISO8583Fields(b *strings.Builder, prefix string, fields map[string]field.Field) {
+	ids := make([]string, 0, len(fields))
+	for id := range fields {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+
+		return ids[i] < ids[j]
+	})
+
+	for _, id := range ids {
+		path := prefix + id
+		if removedISO8583Fields[path] {
+			continue
+		}
+
+		if composite, ok := fields[id].(*field.Composite); ok {
+			describeISO8583Fields(b, path+".", composite.GetSubfields())
+			continue
+		}
+
+		value, err := fields[id].String()
+		if err != nil {
+			value = "[" + err.Error() + "]"
+		}
+
+		if path == panISO8583Field {
+			value = MaskPAN(value)
+		}
+
+		fmt.Fprintf(b, " F%s=%s", path, value)
+	}
+}
+
+// iso8583MessageValue describes the message only when it's logged, so the
+// disabled debug messages cost nothing.
+type iso8583MessageValue struct {
+	message *iso8583.Message
+}
+
+func (v iso8583MessageValue) LogValue() slog.Value {
+	return slog.StringValue(DescribeISO8583Message(v.message))
+}
+
+// ISO8583Message returns the attribute with the description of the message
+// safe to be logged.
+func ISO8583Message(key string, message *iso8583.Message) slog.Attr {
+	return slog.Any(key, iso8583MessageValue{message: message})
+}
@@ -7,14 +7,23 @@ import (
 	"golang.org/x/exp/slog"
 )
 
+// New returns the logger of the info level.
 func New() *slog.Logger {
+	return NewWithLevel(slog.LevelInfo)
+}
+
+// NewWithLevel returns the logger of the level, e.g. slog.LevelDebug logs
+// the dumps of the ISO 8583 messages. All attributes are redacted by
+// Redact.
+func NewWithLevel(level slog.Leveler) *slog.Logger {
 	th := slog.HandlerOptions{
+		Level: level,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 			// Remove time from the output for predictable test output.
 			if a.Key == slog.TimeKey {
 				return slog.String("ts", time.Now().Format("15:04:05.000"))
 			}
-			return a
+			return Redact(groups, a)
 		},
 	}.NewTextHandler(os.Stderr)
 
@@ -0,0 +1,149 @@
+package log_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/alovak/cardflow-playground/log"
+	"github.com/moov-io/iso8583"
+	"github.com/moov-io/iso8583/encoding"
+	"github.com/moov-io/iso8583/field"
+	"github.com/moov-io/iso8583/prefix"
+	"github.com/moov-io/iso8583/sort"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+func TestMaskPAN(t *testing.T) {
+	require.Equal(t, "912345******2345", log.MaskPAN("9123456789012345"))
+	require.Equal(t, "412345*********1234", log.MaskPAN("4123456789012341234"))
+	require.Equal(t, "********", log.MaskPAN("12345678"))
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.HandlerOptions{ReplaceAttr: log.Redact}.NewTextHandler(&buf))
+
+	logger.With(slog.String("uri", "localhost/tokens?number=9123456789012345")).Info(
+		"authorizing card 9123456789012345",
+		slog.String("pan", "9123456789012345"),
+		slog.String("cvv", "123"),
+		slog.String("pin_block", "0412AC89FFFFFFFF"),
+		slog.String("stan", "000001"),
+		"err", errors.New("card 9123456789012345 not found"),
+	)
+
+	output := buf.String()
+	require.NotContains(t, output, "9123456789012345")
+	require.NotContains(t, output, "0412AC89FFFFFFFF")
+	require.Contains(t, output, `msg="authorizing card 912345******2345"`)
+	require.Contains(t, output, `uri="localhost/tokens?number=912345******2345"`)
+	require.Contains(t, output, "pan=912345******2345")
+	require.Contains(t, output, "cvv=[REDACTED]")
+	require.Contains(t, output, "pin_block=[REDACTED]")
+	require.Contains(t, output, "stan=000001")
+	require.Contains(t, output, `err="card 912345******2345 not found"`)
+}
+
+var testSpec = &iso8583.MessageSpec{
+	Fields: map[int]field.Field{
+		0: field.NewString(&field.Spec{
+			Length:      4,
+			Description: "Message Type Indicator",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		1: field.NewBitmap(&field.Spec{
+			Description: "Bitmap",
+			Enc:         encoding.BytesToASCIIHex,
+			Pref:        prefix.Hex.Fixed,
+		}),
+		2: field.NewString(&field.Spec{
+			Length:      19,
+			Description: "Primary Account Number",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.LL,
+		}),
+		4: field.NewString(&field.Spec{
+			Length:      12,
+			Description: "Amount",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		8: field.NewString(&field.Spec{
+			Length:      4,
+			Description: "Card Verification Value (CVV)",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.LL,
+		}),
+		9: field.NewString(&field.Spec{
+			Length:      4,
+			Description: "Card Expiration Date",
+			Enc:         encoding.ASCII,
+			Pref:        prefix.ASCII.Fixed,
+		}),
+		52: field.NewBinary(&field.Spec{
+			Length:      8,
+			Description: "PIN Data",
+			Enc:         encoding.Binary,
+			Pref:        prefix.Binary.Fixed,
+		}),
+		96: field.NewComposite(&field.Spec{
+			Length:      999,
+			Description: "Key Exchange Data",
+			Pref:        prefix.ASCII.LLL,
+			Tag: &field.TagSpec{
+				Length: 2,
+				Enc:    encoding.ASCII,
+				Sort:   sort.StringsByInt,
+			},
+			Subfields: map[string]field.Field{
+				"01": field.NewString(&field.Spec{
+					Length:      3,
+					Description: "Key Type",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.Fixed,
+				}),
+				"02": field.NewString(&field.Spec{
+					Length:      256,
+					Description: "Encrypted Key",
+					Enc:         encoding.ASCII,
+					Pref:        prefix.ASCII.LLL,
+				}),
+			},
+		}),
+	},
+}
+
+func TestDescribeISO8583Message(t *testing.T) {
+	type keyExchangeData struct {
+		KeyType      string `index:"01"`
+		EncryptedKey string `index:"02"`
+	}
+
+	message := iso8583.NewMessage(testSpec)
+	err := message.Marshal(&struct {
+		MTI            string           `index:"0"`
+		PAN            string           `index:"2"`
+		Amount         string           `index:"4"`
+		CVV            string           `index:"8"`
+		ExpirationDate string           `index:"9"`
+		PINBlock       []byte           `index:"52"`
+		KeyExchange    *keyExchangeData `index:"96"`
+	}{
+		MTI:            "0100",
+		PAN:            "9123456789012345",
+		Amount:         "000000001000",
+		CVV:            "123",
+		ExpirationDate: "1230",
+		PINBlock:       []byte{0x04, 0x12, 0xAC, 0x89, 0xFF, 0xFF, 0xFF, 0xFF},
+		KeyExchange: &keyExchangeData{
+			KeyType:      "ZPK",
+			EncryptedKey: "A1B2C3D4",
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, "MTI=0100 F2=912345******2345 F4=000000001000 F96.01=ZPK", log.DescribeISO8583Message(message))
+}
@@ -0,0 +1,80 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// panPattern matches the card numbers within the text, e.g. in the URI or
+// in the error message
+var panPattern = regexp.MustCompile(`\b[0-9]{13,19}\b`)
+
+// redacted replaces the values of the attributes which must not be logged
+const redacted = "[REDACTED]"
+
+// secretKeys are the keys of the attributes whose values are never logged
+var secretKeys = map[string]bool{
+	"cvv":                     true,
+	"card_verification_value": true,
+	"expiration_date":         true,
+	"pin":                     true,
+	"pin_block":               true,
+	"track2":                  true,
+}
+
+// panKeys are the keys of the attributes with card numbers, their values
+// are masked
+var panKeys = map[string]bool{
+	"pan":         true,
+	"card_number": true,
+}
+
+// MaskPAN returns the card number with all digits but the first 6 and the
+// last 4 replaced with asterisks. The numbers shorter than 13 digits are
+// masked completely.
+func MaskPAN(pan string) string {
+	if len(pan) < 13 {
+		return strings.Repeat("*", len(pan))
+	}
+
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
+
+// RedactString masks the card numbers found in the text.
+func RedactString(s string) string {
+	return panPattern.ReplaceAllStringFunc(s, MaskPAN)
+}
+
+// Redact returns the attribute safe to be logged: the secret values like
+// CVVs and PIN blocks are removed, and the card numbers are masked. It has
+// the signature of slog.HandlerOptions.ReplaceAttr, so it can be applied
+// to all attributes of the handler.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, redacted)
+	case panKeys[key]:
+		return slog.String(a.Key, MaskPAN(a.Value.String()))
+	}
+
+	a.Value = a.Value.Resolve()
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(RedactString(a.Value.String()))
+	case slog.KindAny:
+		// errors and other values are logged as text, they are replaced
+		// only when they contain card numbers
+		text := fmt.Sprint(a.Value.Any())
+		if masked := RedactString(text); masked != text {
+			a.Value = slog.StringValue(masked)
+		}
+	}
+
+	return a
+}